@@ -0,0 +1,64 @@
+package main
+
+import (
+	"backend/internal/tracker"
+	"context"
+	"time"
+)
+
+type backoffPolicy struct {
+	initial time.Duration
+	max     time.Duration
+	// maxAttempts limits consecutive failures of the class, zero means unlimited
+	maxAttempts int
+}
+
+var backoffPolicies = map[tracker.ErrorClass]backoffPolicy{
+	tracker.RateLimitErrorClass: {initial: 5 * time.Second, max: 2 * time.Minute},
+	tracker.TransientErrorClass: {initial: 2 * time.Second, max: time.Minute},
+	tracker.MalformedErrorClass: {initial: 30 * time.Second, max: 5 * time.Minute},
+	tracker.StorageErrorClass:   {initial: time.Second, max: 30 * time.Second, maxAttempts: 5},
+}
+
+// backoff counts the consecutive failures of one retried step per error class
+type backoff struct {
+	attempts map[tracker.ErrorClass]int
+}
+
+func newBackoff() *backoff {
+	return &backoff{attempts: make(map[tracker.ErrorClass]int)}
+}
+
+// next returns the delay before the next attempt, false means the error is unrecoverable
+func (b *backoff) next(class tracker.ErrorClass) (time.Duration, bool) {
+	policy, ok := backoffPolicies[class]
+	if !ok {
+		return 0, false
+	}
+
+	b.attempts[class]++
+	attempts := b.attempts[class]
+	if policy.maxAttempts > 0 && attempts > policy.maxAttempts {
+		return 0, false
+	}
+
+	delay := policy.initial
+	for i := 1; i < attempts && delay < policy.max; i++ {
+		delay *= 2
+	}
+
+	return min(delay, policy.max), true
+}
+
+// sleep waits for the delay, false means the context was canceled meanwhile
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
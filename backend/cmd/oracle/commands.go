@@ -0,0 +1,731 @@
+package main
+
+import (
+	"backend/internal/config"
+	"backend/internal/logger"
+	"backend/internal/storage"
+	"backend/internal/tracker"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// commandFlags are the flags shared by the operation commands
+type commandFlags struct {
+	flags         *flag.FlagSet
+	configPath    *string
+	raffleAddress *string
+	jsonOutput    *bool
+}
+
+func newCommandFlags(name string) *commandFlags {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &commandFlags{
+		flags:         flags,
+		configPath:    flags.String("config", "", "path to the YAML configuration file, environment variables override it"),
+		raffleAddress: flags.String("raffle", "", "address of the raffle, required when several raffles are configured"),
+		jsonOutput:    flags.Bool("json", false, "print the result as JSON"),
+	}
+}
+
+// command is a parsed operation command with its configuration and raffle
+type command struct {
+	*commandFlags
+	configuration *config.Configuration
+	raffle        config.RaffleConfiguration
+	// address is the user address argument in the form the tracker stores users under
+	address string
+}
+
+// parseCommand parses the flags and the user address argument when withAddress is set, it
+// reports the failure and returns the exit code when the command cannot run
+func parseCommand(name string, args []string, withAddress bool) (*command, int) {
+	c := &command{commandFlags: newCommandFlags(name)}
+	if err := c.flags.Parse(args); err != nil {
+		return nil, 2
+	}
+
+	if withAddress {
+		if c.flags.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "usage: oracle %s [flags] <address>\n", name)
+			return nil, 2
+		}
+
+		accountID, err := ton.ParseAccountID(c.flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid address %s: %v\n", c.flags.Arg(0), err)
+			return nil, 2
+		}
+		c.address = accountID.ToHuman(true, false)
+	}
+
+	configuration, err := config.Load(*c.configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 1
+	}
+	c.configuration = configuration
+
+	logger.Initialize(logger.Configuration{
+		LogFile: configuration.Logger.File,
+		Level:   configuration.LogLevel(),
+	})
+
+	c.raffle, err = selectRaffle(configuration.RaffleConfigurations(), *c.raffleAddress)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2
+	}
+
+	return c, 0
+}
+
+func selectRaffle(raffles []config.RaffleConfiguration, address string) (config.RaffleConfiguration, error) {
+	if address == "" {
+		if len(raffles) != 1 {
+			return config.RaffleConfiguration{}, fmt.Errorf("-raffle is required, %d raffles are configured", len(raffles))
+		}
+		return raffles[0], nil
+	}
+
+	accountID, err := ton.ParseAccountID(address)
+	if err != nil {
+		return config.RaffleConfiguration{}, fmt.Errorf("invalid raffle address %s: %w", address, err)
+	}
+
+	for _, raffle := range raffles {
+		if raffleAccountID, err := ton.ParseAccountID(raffle.Address); err == nil && raffleAccountID == accountID {
+			return raffle, nil
+		}
+	}
+
+	return config.RaffleConfiguration{}, fmt.Errorf("raffle %s is not configured", address)
+}
+
+// tracker builds the tracker of the selected raffle, the chain source and the oracle wallet
+// are set up like for the loop
+func (c *command) tracker(ctx context.Context) (*tracker.Tracker, error) {
+	trackers, err := tracker.NewTrackers(ctx, c.configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	raffleAccountID := ton.MustParseAccountID(c.raffle.Address)
+	for _, trackerInstance := range trackers {
+		if ton.MustParseAccountID(trackerInstance.RaffleAddress()) == raffleAccountID {
+			return trackerInstance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("raffle %s has no tracker", c.raffle.Address)
+}
+
+// storage opens the storage of the selected raffle without touching the chain
+func (c *command) storage() (storage.Storage, error) {
+	forRaffle, err := tracker.OpenStorage(c.configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return forRaffle(ton.MustParseAccountID(c.raffle.Address).ToRaw()), nil
+}
+
+// print writes value as indented JSON with -json, through human otherwise
+func (c *command) print(value any, human func(w io.Writer)) {
+	if *c.jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(value)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	human(w)
+	_ = w.Flush()
+}
+
+// fail reports a failed command, as an error object with -json
+func (c *command) fail(operation string, err error) int {
+	if *c.jsonOutput {
+		c.print(&errorOutput{Error: operation + ": " + err.Error(), Class: tracker.Classify(err)}, nil)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", operation, err)
+	}
+
+	return 1
+}
+
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+type errorOutput struct {
+	Error string `json:"error"`
+	Class string `json:"class"`
+}
+
+type verifyOutput struct {
+	Raffle   string `json:"raffle"`
+	Verified bool   `json:"verified"`
+}
+
+// runVerify implements `oracle verify`: it checks the raffle contract answers the get-methods
+// the oracle relies on
+func runVerify(args []string) int {
+	c, code := parseCommand("verify", args, false)
+	if c == nil {
+		return code
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	trackerInstance, err := c.tracker(ctx)
+	if err != nil {
+		return c.fail("tracker initialization", err)
+	}
+	defer trackerInstance.Finalize()
+
+	if err := trackerInstance.VerifyRaffleAccount(); err != nil {
+		return c.fail("verify", err)
+	}
+
+	c.print(&verifyOutput{Raffle: c.raffle.Address, Verified: true}, func(w io.Writer) {
+		fmt.Fprintf(w, "raffle %s: verified\n", c.raffle.Address)
+	})
+	return 0
+}
+
+type raffleInfoOutput struct {
+	Raffle                      string          `json:"raffle"`
+	MinCandidateQuantity        uint32          `json:"min_candidate_quantity"`
+	ConditionsDuration          uint32          `json:"conditions_duration"`
+	Targets                     []uint64        `json:"targets"`
+	MinCandidateReachedLt       uint64          `json:"min_candidate_reached_lt"`
+	MinCandidateReachedUnixTime int64           `json:"min_candidate_reached_unix_time"`
+	CandidatesQuantity          uint64          `json:"candidates_quantity"`
+	ParticipantsQuantity        uint64          `json:"participants_quantity"`
+	WinnersQuantity             uint8           `json:"winners_quantity"`
+	Winners                     []*winnerOutput `json:"winners"`
+}
+
+type winnerOutput struct {
+	ParticipantIndex   uint64 `json:"participant_index"`
+	ParticipantAddress string `json:"participant_address"`
+	UserAddress        string `json:"user_address"`
+	// WinnerIndex is null until the participant contract was notified
+	WinnerIndex *int `json:"winner_index"`
+}
+
+// runRaffleInfo implements `oracle raffle-info`: it prints the raffleData of the contract
+func runRaffleInfo(args []string) int {
+	c, code := parseCommand("raffle-info", args, false)
+	if c == nil {
+		return code
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	trackerInstance, err := c.tracker(ctx)
+	if err != nil {
+		return c.fail("tracker initialization", err)
+	}
+	defer trackerInstance.Finalize()
+
+	data, err := trackerInstance.GetRaffleAccountData()
+	if err != nil {
+		return c.fail("raffle info", err)
+	}
+
+	output := &raffleInfoOutput{
+		Raffle:                      c.raffle.Address,
+		MinCandidateQuantity:        data.MinCandidateQuantity,
+		ConditionsDuration:          data.ConditionsDuration,
+		Targets:                     data.Conditions.Targets,
+		MinCandidateReachedLt:       data.MinCandidateReachedLt,
+		MinCandidateReachedUnixTime: data.MinCandidateReachedUnixTime,
+		CandidatesQuantity:          data.CandidatesQuantity,
+		ParticipantsQuantity:        data.ParticipantsQuantity,
+		WinnersQuantity:             data.WinnersQuantity,
+		Winners:                     make([]*winnerOutput, len(data.Winners)),
+	}
+
+	for i, winner := range data.Winners {
+		output.Winners[i] = &winnerOutput{
+			ParticipantIndex:   winner.ParticipantIndex,
+			ParticipantAddress: winner.ParticipantAddress,
+			UserAddress:        winner.UserAddress,
+			WinnerIndex:        winner.WinnerIndex,
+		}
+	}
+
+	c.print(output, func(w io.Writer) {
+		fmt.Fprintf(w, "raffle\t%s\n", output.Raffle)
+		fmt.Fprintf(w, "min candidates\t%d\n", output.MinCandidateQuantity)
+		fmt.Fprintf(w, "conditions duration\t%d s\n", output.ConditionsDuration)
+		fmt.Fprintf(w, "targets\t%v\n", output.Targets)
+		fmt.Fprintf(w, "min candidates reached lt\t%d\n", output.MinCandidateReachedLt)
+		fmt.Fprintf(w, "min candidates reached at\t%d\n", output.MinCandidateReachedUnixTime)
+		fmt.Fprintf(w, "candidates\t%d\n", output.CandidatesQuantity)
+		fmt.Fprintf(w, "participants\t%d\n", output.ParticipantsQuantity)
+		fmt.Fprintf(w, "winners\t%d\n", output.WinnersQuantity)
+		for _, winner := range output.Winners {
+			winnerIndex := "-"
+			if winner.WinnerIndex != nil {
+				winnerIndex = strconv.Itoa(*winner.WinnerIndex)
+			}
+			fmt.Fprintf(w, "winner %s\tparticipant %d %s, user %s\n", winnerIndex, winner.ParticipantIndex, winner.ParticipantAddress, winner.UserAddress)
+		}
+	})
+	return 0
+}
+
+type statusOutput struct {
+	Raffle string `json:"raffle"`
+	User   string `json:"user"`
+	// Candidate is false when the user has no status in the raffle
+	Candidate                 bool               `json:"candidate"`
+	ConditionsReached         bool               `json:"conditions_reached"`
+	CandidateRegistrationLt   int64              `json:"candidate_registration_lt"`
+	ParticipantRegistrationLt int64              `json:"participant_registration_lt"`
+	Conditions                []*conditionOutput `json:"conditions"`
+	Actions                   []*actionOutput    `json:"actions"`
+	Outbox                    []*outboxOutput    `json:"outbox"`
+}
+
+type conditionOutput struct {
+	Slot        string `json:"slot"`
+	Kind        string `json:"kind"`
+	Value       uint64 `json:"value"`
+	Target      uint64 `json:"target"`
+	ProcessedLt int64  `json:"processed_lt"`
+}
+
+type actionOutput struct {
+	ActionType      string `json:"action_type"`
+	Address         string `json:"address"`
+	TransactionHash string `json:"transaction_hash"`
+	TransactionLt   int64  `json:"transaction_lt"`
+	Counterparty    string `json:"counterparty,omitempty"`
+	FlagReason      string `json:"flag_reason,omitempty"`
+	Verified        bool   `json:"verified"`
+}
+
+type outboxOutput struct {
+	ID         int64    `json:"id"`
+	State      string   `json:"state"`
+	Conditions []uint64 `json:"conditions"`
+	Attempts   int      `json:"attempts"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// runStatus implements `oracle status <address>`: it prints what the storage holds about a user,
+// the chain is not queried
+func runStatus(args []string) int {
+	c, code := parseCommand("status", args, true)
+	if c == nil {
+		return code
+	}
+
+	raffleStorage, err := c.storage()
+	if err != nil {
+		return c.fail("open storage", err)
+	}
+
+	output, err := userStatus(raffleStorage, ton.MustParseAccountID(c.raffle.Address).ToRaw(), c.address)
+	if err != nil {
+		return c.fail("status", err)
+	}
+	output.Raffle = c.raffle.Address
+
+	c.print(output, func(w io.Writer) { printStatus(w, output) })
+	return 0
+}
+
+func userStatus(raffleStorage storage.Storage, raffleAddress string, userAddress string) (*statusOutput, error) {
+	output := &statusOutput{
+		User:       userAddress,
+		Conditions: make([]*conditionOutput, 0),
+		Actions:    make([]*actionOutput, 0),
+		Outbox:     make([]*outboxOutput, 0),
+	}
+
+	userStatuses, err := raffleStorage.GetUserStatusesByAddresses([]string{userAddress})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userStatuses) > 0 {
+		output.Candidate = true
+		output.ConditionsReached = userStatuses[0].ConditionsReached
+		output.CandidateRegistrationLt = userStatuses[0].CandidateRegistrationLt
+		output.ParticipantRegistrationLt = userStatuses[0].ParticipantRegistrationLt
+	}
+
+	raffleConditions, err := raffleStorage.GetRaffleConditions(raffleAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	userConditions, err := raffleStorage.GetUserConditions([]string{userAddress})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, raffleCondition := range raffleConditions {
+		condition := &conditionOutput{Slot: raffleCondition.Slot, Kind: raffleCondition.Kind, Target: raffleCondition.Target}
+		for _, userCondition := range userConditions {
+			if userCondition.Slot == raffleCondition.Slot {
+				condition.Value = userCondition.Value
+				condition.ProcessedLt = userCondition.ProcessedLt
+			}
+		}
+		output.Conditions = append(output.Conditions, condition)
+	}
+
+	actions, err := raffleStorage.GetUserActionsByUser(userAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, action := range actions {
+		output.Actions = append(output.Actions, &actionOutput{
+			ActionType:      action.ActionType,
+			Address:         action.Address,
+			TransactionHash: action.TransactionHash,
+			TransactionLt:   action.TransactionLt,
+			Counterparty:    action.Counterparty,
+			FlagReason:      action.FlagReason,
+			Verified:        action.Verified,
+		})
+	}
+
+	messages, err := raffleStorage.GetOutboxMessagesByUser(userAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range messages {
+		values := make([]uint64, len(message.Conditions))
+		for i, condition := range message.Conditions {
+			values[i] = condition.Value
+		}
+
+		output.Outbox = append(output.Outbox, &outboxOutput{
+			ID:         message.ID,
+			State:      message.State,
+			Conditions: values,
+			Attempts:   message.Attempts,
+			Error:      message.Error,
+		})
+	}
+
+	return output, nil
+}
+
+func printStatus(w io.Writer, output *statusOutput) {
+	fmt.Fprintf(w, "raffle\t%s\n", output.Raffle)
+	fmt.Fprintf(w, "user\t%s\n", output.User)
+	if !output.Candidate {
+		fmt.Fprintf(w, "candidate\tno\n")
+	} else {
+		fmt.Fprintf(w, "candidate registration lt\t%d\n", output.CandidateRegistrationLt)
+		fmt.Fprintf(w, "participant registration lt\t%d\n", output.ParticipantRegistrationLt)
+		fmt.Fprintf(w, "conditions reached\t%v\n", output.ConditionsReached)
+	}
+
+	fmt.Fprintf(w, "\nSLOT\tKIND\tVALUE\tTARGET\tPROCESSED LT\n")
+	for _, condition := range output.Conditions {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", condition.Slot, condition.Kind, condition.Value, condition.Target, condition.ProcessedLt)
+	}
+
+	fmt.Fprintf(w, "\nACTION\tADDRESS\tLT\tHASH\tVERIFIED\tFLAG\n")
+	for _, action := range output.Actions {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%v\t%s\n", action.ActionType, action.Address, action.TransactionLt, action.TransactionHash, action.Verified, action.FlagReason)
+	}
+
+	fmt.Fprintf(w, "\nOUTBOX\tSTATE\tCONDITIONS\tATTEMPTS\tERROR\n")
+	for _, message := range output.Outbox {
+		fmt.Fprintf(w, "%d\t%s\t%v\t%d\t%s\n", message.ID, message.State, message.Conditions, message.Attempts, message.Error)
+	}
+}
+
+type resyncOutput struct {
+	Raffle  string          `json:"raffle"`
+	User    string          `json:"user"`
+	Cursors []*cursorOutput `json:"cursors_reset"`
+	Status  *statusOutput   `json:"status"`
+}
+
+type cursorOutput struct {
+	ActionType    string `json:"action_type"`
+	TransactionLt int64  `json:"transaction_lt"`
+}
+
+// runResync implements `oracle resync <address>`: it resets the cursors of a user and runs one
+// tracker cycle, which collects their traces again
+func runResync(args []string) int {
+	c, code := parseCommand("resync", args, true)
+	if c == nil {
+		return code
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	trackerInstance, err := c.tracker(ctx)
+	if err != nil {
+		return c.fail("tracker initialization", err)
+	}
+	defer trackerInstance.Finalize()
+
+	touches, err := trackerInstance.ResetUserCursors(c.address)
+	if err != nil {
+		return c.fail("resync", err)
+	}
+
+	if err := runCycle(trackerInstance, c.raffle.StartLt); err != nil {
+		return c.fail("resync", err)
+	}
+
+	status, err := userStatus(trackerInstance.Storage(), ton.MustParseAccountID(c.raffle.Address).ToRaw(), c.address)
+	if err != nil {
+		return c.fail("resync", err)
+	}
+	status.Raffle = c.raffle.Address
+
+	output := &resyncOutput{Raffle: c.raffle.Address, User: c.address, Cursors: make([]*cursorOutput, len(touches)), Status: status}
+	for i, touch := range touches {
+		output.Cursors[i] = &cursorOutput{ActionType: touch.ActionType, TransactionLt: touch.TransactionLt}
+	}
+
+	c.print(output, func(w io.Writer) {
+		for _, cursor := range output.Cursors {
+			fmt.Fprintf(w, "cursor reset\t%s\t%d\n", cursor.ActionType, cursor.TransactionLt)
+		}
+		if len(output.Cursors) == 0 {
+			fmt.Fprintf(w, "no cursor to reset\n")
+		}
+		fmt.Fprintln(w)
+		printStatus(w, status)
+	})
+	return 0
+}
+
+// runCycle runs a single tracker cycle the way the supervisor starts a pipeline
+func runCycle(trackerInstance *tracker.Tracker, startLt int64) error {
+	raffleDeployedLt, err := trackerInstance.ResolveRaffleDeployedLt(startLt)
+	if err != nil {
+		return err
+	}
+
+	raffleAccountData, err := trackerInstance.GetRaffleAccountData()
+	if err != nil {
+		return err
+	}
+
+	if err := trackerInstance.StoreRaffleConditions(raffleAccountData.Conditions); err != nil {
+		return err
+	}
+
+	return trackerInstance.Run(raffleDeployedLt, raffleAccountData.Conditions)
+}
+
+type pushConditionsOutput struct {
+	Raffle     string          `json:"raffle"`
+	User       string          `json:"user"`
+	Conditions []uint64        `json:"conditions"`
+	Outbox     []*outboxOutput `json:"outbox"`
+}
+
+// runPushConditions implements `oracle push-conditions <address>`: it enqueues the confirmed
+// conditions of a user again and sends the outbox
+func runPushConditions(args []string) int {
+	c, code := parseCommand("push-conditions", args, true)
+	if c == nil {
+		return code
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	trackerInstance, err := c.tracker(ctx)
+	if err != nil {
+		return c.fail("tracker initialization", err)
+	}
+	defer trackerInstance.Finalize()
+
+	values, err := trackerInstance.PushConditions(c.address)
+	if err != nil {
+		return c.fail("push conditions", err)
+	}
+
+	status, err := userStatus(trackerInstance.Storage(), ton.MustParseAccountID(c.raffle.Address).ToRaw(), c.address)
+	if err != nil {
+		return c.fail("push conditions", err)
+	}
+
+	output := &pushConditionsOutput{Raffle: c.raffle.Address, User: c.address, Conditions: make([]uint64, len(values)), Outbox: status.Outbox}
+	for i, value := range values {
+		output.Conditions[i] = value.Value
+	}
+
+	c.print(output, func(w io.Writer) {
+		fmt.Fprintf(w, "pushed conditions %v to %s\n", output.Conditions, output.User)
+		fmt.Fprintf(w, "\nOUTBOX\tSTATE\tCONDITIONS\tATTEMPTS\tERROR\n")
+		for _, message := range output.Outbox {
+			fmt.Fprintf(w, "%d\t%s\t%v\t%d\t%s\n", message.ID, message.State, message.Conditions, message.Attempts, message.Error)
+		}
+	})
+	return 0
+}
+
+type dryRunOutput struct {
+	Raffle string               `json:"raffle"`
+	Users  []*plannedUserOutput `json:"users"`
+}
+
+type plannedUserOutput struct {
+	User string `json:"user"`
+	// OldStatus is null for a user the cycle registers, NewStatus for a user a verification removes
+	OldStatus         *plannedStatusOutput      `json:"old_status"`
+	NewStatus         *plannedStatusOutput      `json:"new_status"`
+	Conditions        []*plannedConditionOutput `json:"conditions"`
+	ConditionsReached bool                      `json:"conditions_reached"`
+	Body              string                    `json:"body,omitempty"`
+	Error             string                    `json:"error,omitempty"`
+}
+
+type plannedStatusOutput struct {
+	CandidateRegistrationLt   int64 `json:"candidate_registration_lt"`
+	ParticipantRegistrationLt int64 `json:"participant_registration_lt"`
+	ConditionsReached         bool  `json:"conditions_reached"`
+}
+
+type plannedConditionOutput struct {
+	Slot string `json:"slot"`
+	Old  uint64 `json:"old"`
+	New  uint64 `json:"new"`
+}
+
+// runDryRun implements `oracle dry-run`: it runs the collectors and the synchronization of one
+// cycle on a snapshot of the database, or in a transaction that is rolled back, and prints the
+// status transitions and the set conditions messages instead of sending them
+func runDryRun(args []string) int {
+	c, code := parseCommand("dry-run", args, false)
+	if c == nil {
+		return code
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	trackerInstance, err := c.tracker(ctx)
+	if err != nil {
+		return c.fail("tracker initialization", err)
+	}
+	defer trackerInstance.Finalize()
+
+	raffleDeployedLt, err := dryRunDeployedLt(trackerInstance, c.raffle.StartLt)
+	if err != nil {
+		return c.fail("dry run", err)
+	}
+
+	raffleAccountData, err := trackerInstance.GetRaffleAccountData()
+	if err != nil {
+		return c.fail("dry run", err)
+	}
+
+	plan, err := trackerInstance.Plan(raffleDeployedLt, raffleAccountData.Conditions)
+	if err != nil {
+		return c.fail("dry run", err)
+	}
+
+	output := &dryRunOutput{Raffle: c.raffle.Address, Users: make([]*plannedUserOutput, len(plan))}
+	for i, user := range plan {
+		output.Users[i] = &plannedUserOutput{
+			User:              user.UserAddress,
+			OldStatus:         plannedStatus(user.Old),
+			NewStatus:         plannedStatus(user.New),
+			Conditions:        make([]*plannedConditionOutput, len(user.Conditions)),
+			ConditionsReached: user.ConditionsReached,
+			Body:              user.Body,
+			Error:             user.Error,
+		}
+		for j, condition := range user.Conditions {
+			output.Users[i].Conditions[j] = &plannedConditionOutput{Slot: condition.Slot, Old: condition.Old, New: condition.New}
+		}
+	}
+
+	c.print(output, func(w io.Writer) {
+		if len(output.Users) == 0 {
+			fmt.Fprintf(w, "raffle %s: nothing to change\n", output.Raffle)
+			return
+		}
+
+		fmt.Fprintf(w, "USER\tSTATUS\tCONDITIONS\tREACHED\tBODY\n")
+		for _, user := range output.Users {
+			conditions := make([]string, len(user.Conditions))
+			for i, condition := range user.Conditions {
+				conditions[i] = fmt.Sprintf("%s %d -> %d", condition.Slot, condition.Old, condition.New)
+			}
+
+			body := user.Body
+			if user.Error != "" {
+				body = "error: " + user.Error
+			}
+
+			fmt.Fprintf(w, "%s\t%s -> %s\t%s\t%v\t%s\n", user.User, statusName(user.OldStatus), statusName(user.NewStatus),
+				strings.Join(conditions, ", "), user.ConditionsReached, body)
+		}
+	})
+	return 0
+}
+
+// dryRunDeployedLt resolves the deployed lt like runCycle without storing it
+func dryRunDeployedLt(trackerInstance *tracker.Tracker, startLt int64) (int64, error) {
+	if startLt > 0 {
+		return startLt, nil
+	}
+
+	deployedLt, err := trackerInstance.Storage().GetRaffleDeployedLt(ton.MustParseAccountID(trackerInstance.RaffleAddress()).ToRaw())
+	if err != nil || deployedLt > 0 {
+		return deployedLt, err
+	}
+
+	return trackerInstance.GetRaffleAccountDeployedLt()
+}
+
+func plannedStatus(userStatus *storage.UserStatus) *plannedStatusOutput {
+	if userStatus == nil {
+		return nil
+	}
+
+	return &plannedStatusOutput{
+		CandidateRegistrationLt:   userStatus.CandidateRegistrationLt,
+		ParticipantRegistrationLt: userStatus.ParticipantRegistrationLt,
+		ConditionsReached:         userStatus.ConditionsReached,
+	}
+}
+
+func statusName(status *plannedStatusOutput) string {
+	switch {
+	case status == nil:
+		return "none"
+	case status.ParticipantRegistrationLt != 0:
+		return "participant"
+	default:
+		return "candidate"
+	}
+}
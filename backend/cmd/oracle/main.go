@@ -1,63 +1,149 @@
 package main
 
 import (
+	"backend/internal/api"
+	"backend/internal/config"
 	"backend/internal/logger"
+	"backend/internal/storage"
 	"backend/internal/tracker"
 	"context"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
+// commands are the subcommands of the oracle, each parses its own flags and returns the exit code
+var commands = map[string]func(args []string) int{
+	"run":             runLoop,
+	"migrate":         runMigrate,
+	"verify":          runVerify,
+	"raffle-info":     runRaffleInfo,
+	"status":          runStatus,
+	"resync":          runResync,
+	"push-conditions": runPushConditions,
+	"dry-run":         runDryRun,
+}
+
+const usage = `usage: oracle <command> [flags] [address]
+
+commands:
+  run                        track the configured raffles until interrupted, the default
+  migrate                    report and apply the database migrations
+  verify                     check the raffle contract answers the oracle get-methods
+  raffle-info                print the raffle contract data
+  status <address>           print the stored status, conditions, actions and outbox of a user
+  resync <address>           reset the cursors of a user and collect their traces again
+  push-conditions <address>  send the confirmed conditions of a user to their candidate again
+  dry-run                    print what a cycle would change and send, nothing is stored or sent
+
+Run "oracle <command> -h" for the flags of a command.
+`
+
 func main() {
+	args := os.Args[1:]
+
+	// flags alone start the loop, like before the subcommands
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(runLoop(args))
+	}
+
+	if args[0] == "help" {
+		fmt.Print(usage)
+		os.Exit(0)
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	os.Exit(command(args[1:]))
+}
+
+// runLoop implements `oracle run`: it runs one tracker pipeline per configured raffle and the
+// API until interrupted or an unrecoverable error.
+func runLoop(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the YAML configuration file, environment variables override it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	configuration, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	logger.Initialize(logger.Configuration{
+		LogFile: configuration.Logger.File,
+		Level:   configuration.LogLevel(),
+		Console: configuration.Logger.Console,
+	})
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Канал для ошибок
-	errCh := make(chan error, 1)
-
-	// Запускаем горутину с основным циклом
-	go func() {
-		logger.Initialize(logger.Configuration{
-			LogFile: "tracker.log",
-			Level:   zapcore.InfoLevel,
-			Console: true,
-		})
-		trackerInstance := tracker.NewTracker(ctx)
-
-		raffleAccountData, err := trackerInstance.GetRaffleAccountData()
+	errCh := make(chan error, len(configuration.RaffleConfigurations())+1)
+
+	trackers, err := tracker.NewTrackers(ctx, configuration)
+	if err != nil {
+		logger.Error("tracker initialization failed", zap.Error(err))
+		return 1
+	}
+
+	startLts := make(map[string]int64)
+	for _, raffle := range configuration.RaffleConfigurations() {
+		startLts[raffle.Address] = raffle.StartLt
+	}
+
+	if configuration.API.Address != "" {
+		storages := make(map[string]storage.Storage)
+		for _, trackerInstance := range trackers {
+			storages[trackerInstance.RaffleAddress()] = trackerInstance.Storage()
+		}
+
+		server, err := api.NewServer(configuration.API.Address, storages)
 		if err != nil {
-			panic(err)
+			logger.Error("api initialization failed", zap.Error(err))
+			return 1
 		}
 
-		for {
-			select {
-			case <-ctx.Done():
-				trackerInstance.Finalize()
-				return
-			default:
-				trackerInstance.Run( // 62867758000006
-					// 61948102000007
-					61946738000007, // ХАРДКОД LT от 26 сентября приблизительно с 20:00 по Мск
-					raffleAccountData.Conditions.WhiteTicketMinted,
-					raffleAccountData.Conditions.BlackTicketPurchased,
-				)
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				errCh <- err
 			}
-		}
-	}()
+		}()
+		defer server.Shutdown(context.Background())
+	}
+
+	// Запускаем по конвейеру на каждый розыгрыш
+	raffleSupervisor := newSupervisor(trackers, startLts)
+	raffleSupervisor.start(ctx, errCh)
 
 	// Ожидаем ошибку или сигнал завершения
+	exitCode := 0
 	select {
 	case err := <-errCh:
-		logger.Fatal("fatal error", zap.Error(err))
+		logger.Error("unrecoverable error, shutting down...", zap.Error(err))
 		cancel()
+		raffleSupervisor.wait()
+		exitCode = 1
 	case <-waitForInterrupt():
 		logger.Info("gracefully shutting down...")
 		cancel()
+		raffleSupervisor.wait()
 	}
+
+	return exitCode
 }
 
 func waitForInterrupt() <-chan os.Signal {
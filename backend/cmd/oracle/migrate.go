@@ -0,0 +1,95 @@
+package main
+
+import (
+	"backend/internal/config"
+	"backend/internal/logger"
+	"backend/internal/storage"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// runMigrate implements `oracle migrate`: it reports the applied and pending migrations of
+// the configured database and applies the pending ones unless -status is given.
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the YAML configuration file, environment variables override it")
+	statusOnly := flags.Bool("status", false, "only report the applied and pending migrations")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	configuration, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	logger.Initialize(logger.Configuration{
+		LogFile: configuration.Logger.File,
+		Level:   configuration.LogLevel(),
+	})
+
+	database, err := openDatabase(configuration.Storage)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open database:", err)
+		return 1
+	}
+	defer database.Close()
+	if raffleAccountID, err := ton.ParseAccountID(configuration.Raffle.Address); err == nil {
+		database.SetSingleRaffleAddress(raffleAccountID.ToRaw())
+	}
+
+	statuses, err := database.MigrationStatus()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migration status:", err)
+		return 1
+	}
+	printMigrations(statuses)
+
+	if *statusOnly {
+		return 0
+	}
+
+	applied, err := database.Migrate()
+	for _, migration := range applied {
+		fmt.Printf("migrated %04d %s\n", migration.Version, migration.Name)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 1
+	}
+
+	if len(applied) == 0 {
+		fmt.Println("database is up to date")
+	}
+
+	return 0
+}
+
+func openDatabase(configuration config.StorageConfiguration) (*storage.Database, error) {
+	if configuration.Driver == storage.PostgresDriverType {
+		return storage.OpenPostgres(configuration.DSN, storage.PoolOptions{
+			MaxOpenConns:    configuration.MaxOpenConns,
+			MaxIdleConns:    configuration.MaxIdleConns,
+			ConnMaxLifetime: configuration.ConnMaxLifetime,
+		})
+	}
+
+	return storage.OpenSqlite(configuration.Path)
+}
+
+func printMigrations(statuses []storage.MigrationStatus) {
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			fmt.Printf("pending  %04d %s\n", status.Version, status.Name)
+			continue
+		}
+
+		fmt.Printf("applied  %04d %s  %s\n", status.Version, status.Name, status.AppliedAt.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"backend/internal/logger"
+	"backend/internal/tracker"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// supervisor runs one tracker pipeline per configured raffle, each with its own start lt,
+// conditions and cursors.
+type supervisor struct {
+	trackers []*tracker.Tracker
+	startLts map[string]int64
+	wg       sync.WaitGroup
+}
+
+func newSupervisor(trackers []*tracker.Tracker, startLts map[string]int64) *supervisor {
+	return &supervisor{
+		trackers: trackers,
+		startLts: startLts,
+	}
+}
+
+func (s *supervisor) start(ctx context.Context, errCh chan<- error) {
+	if len(s.trackers) > 0 {
+		s.wg.Add(1)
+		go s.reportWalletBalance(ctx)
+	}
+
+	for _, trackerInstance := range s.trackers {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			if err := s.run(ctx, trackerInstance); err != nil {
+				errCh <- fmt.Errorf("raffle %s: %w", trackerInstance.RaffleAddress(), err)
+			}
+		}()
+	}
+}
+
+// walletBalanceInterval is how often the shared oracle wallet balance is refreshed
+const walletBalanceInterval = time.Minute
+
+func (s *supervisor) reportWalletBalance(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		if err := s.trackers[0].ReportWalletBalance(); err != nil && ctx.Err() == nil {
+			logger.Warn("supervisor: cannot report wallet balance", zap.Error(err))
+		}
+
+		if !sleep(ctx, walletBalanceInterval) {
+			return
+		}
+	}
+}
+
+func (s *supervisor) wait() {
+	s.wg.Wait()
+}
+
+// run drives the pipeline of a single raffle until the context is canceled. Failed steps are
+// retried with the backoff of their error class, only unrecoverable errors stop the pipeline.
+func (s *supervisor) run(ctx context.Context, trackerInstance *tracker.Tracker) error {
+	raffleAddress := trackerInstance.RaffleAddress()
+	defer trackerInstance.Finalize()
+
+	var raffleDeployedLt int64
+	var raffleAccountData *tracker.RaffleAccountData
+	err := s.retry(ctx, raffleAddress, func() error {
+		var err error
+		raffleDeployedLt, err = trackerInstance.ResolveRaffleDeployedLt(s.startLts[raffleAddress])
+		if err != nil {
+			return err
+		}
+
+		raffleAccountData, err = trackerInstance.GetRaffleAccountData()
+		if err != nil {
+			return err
+		}
+
+		return trackerInstance.StoreRaffleConditions(raffleAccountData.Conditions)
+	})
+	if err != nil || ctx.Err() != nil {
+		return err
+	}
+
+	logger.Info("supervisor: raffle pipeline started",
+		zap.String("raffle address", raffleAddress),
+		zap.Int64("deployed lt", raffleDeployedLt),
+		zap.Uint64s("targets", raffleAccountData.Conditions.Targets),
+	)
+
+	for ctx.Err() == nil {
+		err := s.retry(ctx, raffleAddress, func() error {
+			if err := trackerInstance.Run(raffleDeployedLt, raffleAccountData.Conditions); err != nil {
+				return err
+			}
+
+			return trackerInstance.DrawWinners(raffleDeployedLt)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retry repeats fn until it succeeds, the context is canceled or its error gives up
+func (s *supervisor) retry(ctx context.Context, raffleAddress string, fn func() error) error {
+	cycleBackoff := newBackoff()
+
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		class := tracker.Classify(err)
+		if class == tracker.CanceledErrorClass && ctx.Err() != nil {
+			return nil
+		}
+
+		delay, ok := cycleBackoff.next(class)
+		if !ok {
+			logger.Error("supervisor: raffle pipeline failed", zap.String("raffle address", raffleAddress), zap.String("class", class), zap.Error(err))
+			return err
+		}
+
+		logger.Warn("supervisor: raffle pipeline cycle failed, backing off",
+			zap.String("raffle address", raffleAddress),
+			zap.String("class", class),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+
+		if !sleep(ctx, delay) {
+			return nil
+		}
+	}
+}
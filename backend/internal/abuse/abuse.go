@@ -0,0 +1,83 @@
+package abuse
+
+import (
+	"backend/internal/conditions"
+	"cmp"
+	"slices"
+)
+
+// Reason is why a purchase is excluded from the conditions, empty when it counts
+type Reason = string
+
+const (
+	// ClosedLoopReason flags an item coming back to a wallet that already held it, the trades
+	// of an item back and forth between the same wallets count once
+	ClosedLoopReason Reason = "closed_loop"
+	// SellerFundedReason flags a purchase from a seller the buyer sent funds to, a second
+	// wallet of the buyer
+	SellerFundedReason Reason = "seller_funded_by_buyer"
+	// ItemCountedReason flags the purchase of an item the raffle already counted for another buyer
+	ItemCountedReason Reason = "item_already_counted"
+)
+
+// Transfer is a purchase of an item, addresses are raw
+type Transfer struct {
+	Item   string
+	Seller string
+	Buyer  string
+	Lt     int64
+	// Reason is the flag of a transfer of the history
+	Reason Reason
+}
+
+// Detector flags the wash trades among the purchases of a slot. It keeps the wallets that held
+// every item and the items already counted, the checked transfers are recorded into them.
+type Detector struct {
+	itemCounting conditions.ItemCounting
+	holders      map[string][]string
+	counted      map[string]bool
+}
+
+// NewDetector replays the stored transfers of the slot in lt order
+func NewDetector(itemCounting conditions.ItemCounting, history []Transfer) *Detector {
+	detector := &Detector{
+		itemCounting: itemCounting,
+		holders:      make(map[string][]string),
+		counted:      make(map[string]bool),
+	}
+
+	history = slices.Clone(history)
+	slices.SortStableFunc(history, func(a, b Transfer) int { return cmp.Compare(a.Lt, b.Lt) })
+	for _, transfer := range history {
+		detector.Record(transfer, transfer.Reason)
+	}
+
+	return detector
+}
+
+// Reason returns why the transfer does not count, empty when it counts
+func (d *Detector) Reason(transfer Transfer) Reason {
+	if slices.Contains(d.holders[transfer.Item], transfer.Buyer) {
+		return ClosedLoopReason
+	}
+
+	if d.itemCounting == conditions.PerRaffleItemCounting && d.counted[transfer.Item] {
+		return ItemCountedReason
+	}
+
+	return ""
+}
+
+// Record adds a checked transfer to the history with the reason it was flagged for, empty when
+// it counts
+func (d *Detector) Record(transfer Transfer, reason Reason) {
+	for _, wallet := range []string{transfer.Seller, transfer.Buyer} {
+		if wallet != "" && !slices.Contains(d.holders[transfer.Item], wallet) {
+			d.holders[transfer.Item] = append(d.holders[transfer.Item], wallet)
+		}
+	}
+
+	if reason == "" {
+		d.counted[transfer.Item] = true
+	}
+}
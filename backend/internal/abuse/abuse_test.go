@@ -0,0 +1,68 @@
+package abuse
+
+import (
+	"backend/internal/conditions"
+	"testing"
+)
+
+// check flags the transfer and records it, like the tracker does once the funding is checked
+func check(detector *Detector, transfer Transfer) Reason {
+	reason := detector.Reason(transfer)
+	detector.Record(transfer, reason)
+	return reason
+}
+
+const (
+	testItem   = "0:01"
+	testOther  = "0:02"
+	testAlice  = "0:a1"
+	testBob    = "0:b0"
+	testCarol  = "0:c0"
+	testMallet = "0:d0"
+)
+
+func TestDetectorClosedLoop(t *testing.T) {
+	detector := NewDetector(conditions.PerUserItemCounting, []Transfer{
+		{Item: testItem, Seller: testAlice, Buyer: testBob, Lt: 10},
+	})
+
+	// the item goes back to the wallet that sold it
+	if reason := check(detector, Transfer{Item: testItem, Seller: testBob, Buyer: testAlice, Lt: 20}); reason != ClosedLoopReason {
+		t.Errorf("expected a closed loop, got %q", reason)
+	}
+
+	if reason := check(detector, Transfer{Item: testItem, Seller: testAlice, Buyer: testBob, Lt: 30}); reason != ClosedLoopReason {
+		t.Errorf("expected a closed loop on the way back, got %q", reason)
+	}
+
+	if reason := check(detector, Transfer{Item: testItem, Seller: testBob, Buyer: testCarol, Lt: 40}); reason != "" {
+		t.Errorf("expected a resale to a new wallet to count, got %q", reason)
+	}
+
+	if reason := check(detector, Transfer{Item: testOther, Seller: testBob, Buyer: testAlice, Lt: 50}); reason != "" {
+		t.Errorf("expected another item to count, got %q", reason)
+	}
+}
+
+func TestDetectorItemCounting(t *testing.T) {
+	history := []Transfer{
+		{Item: testItem, Seller: testMallet, Buyer: testBob, Lt: 20, Reason: SellerFundedReason},
+		{Item: testItem, Seller: testAlice, Buyer: testMallet, Lt: 10},
+	}
+
+	perUser := NewDetector(conditions.PerUserItemCounting, history)
+	if reason := check(perUser, Transfer{Item: testItem, Seller: testBob, Buyer: testCarol, Lt: 30}); reason != "" {
+		t.Errorf("expected the item to count for every buyer, got %q", reason)
+	}
+
+	perRaffle := NewDetector(conditions.PerRaffleItemCounting, history)
+	if reason := check(perRaffle, Transfer{Item: testItem, Seller: testBob, Buyer: testCarol, Lt: 30}); reason != ItemCountedReason {
+		t.Errorf("expected the item to count once, got %q", reason)
+	}
+
+	// a flagged transfer does not use up the item
+	flagged := NewDetector(conditions.PerRaffleItemCounting, history[:1])
+	if reason := check(flagged, Transfer{Item: testItem, Seller: testBob, Buyer: testCarol, Lt: 30}); reason != "" {
+		t.Errorf("expected the item to count after a flagged purchase, got %q", reason)
+	}
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"backend/internal/logger"
+	"backend/internal/metrics"
+	"backend/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/tonkeeper/tongo/ton"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Server is a read-only HTTP API over the oracle storage. It reports what the oracle has
+// counted for every raffle, the user conditions both as confirmed on-chain and as pending.
+type Server struct {
+	storages map[string]storage.Storage
+	server   *http.Server
+}
+
+type raffleResponse struct {
+	Address           string                     `json:"address"`
+	DeployedLt        int64                      `json:"deployed_lt"`
+	Conditions        []*raffleConditionResponse `json:"conditions"`
+	Candidates        int64                      `json:"candidates"`
+	ConditionsReached int64                      `json:"conditions_reached"`
+	Participants      int64                      `json:"participants"`
+	Winners           []*winnerResponse          `json:"winners"`
+}
+
+// winnerResponse is a winner whose notification the oracle has seen, the draws still pending
+// are not reported
+type winnerResponse struct {
+	WinnerIndex        int    `json:"winner_index"`
+	ParticipantIndex   uint64 `json:"participant_index"`
+	ParticipantAddress string `json:"participant_address"`
+	UserAddress        string `json:"user_address"`
+	TransactionHash    string `json:"transaction_hash"`
+	TransactionLt      int64  `json:"transaction_lt"`
+}
+
+type raffleConditionResponse struct {
+	Slot   string `json:"slot"`
+	Kind   string `json:"kind"`
+	Target uint64 `json:"target"`
+	// Users is the number of candidates with a confirmed value in the slot
+	Users int64 `json:"users"`
+}
+
+type userStatusResponse struct {
+	RaffleAddress             string                   `json:"raffle_address"`
+	UserAddress               string                   `json:"user_address"`
+	Conditions                []*userConditionResponse `json:"conditions"`
+	ConditionsReached         bool                     `json:"conditions_reached"`
+	CandidateRegistrationLt   int64                    `json:"candidate_registration_lt"`
+	ParticipantRegistrationLt int64                    `json:"participant_registration_lt"`
+}
+
+type userConditionResponse struct {
+	Slot string `json:"slot"`
+	Kind string `json:"kind"`
+	// Value is confirmed on-chain, Pending is what the oracle has counted and queued or sent
+	// but not confirmed yet, it equals Value when nothing is in flight
+	Value   uint64 `json:"value"`
+	Pending uint64 `json:"pending"`
+	Target  uint64 `json:"target"`
+}
+
+type userActionResponse struct {
+	ActionType          string `json:"action_type"`
+	Address             string `json:"address"`
+	TransactionHash     string `json:"transaction_hash"`
+	TransactionLt       int64  `json:"transaction_lt"`
+	TransactionUnixTime int64  `json:"transaction_unix_time"`
+	Counterparty        string `json:"counterparty,omitempty"`
+	// FlagReason is set when the action does not count for the conditions
+	FlagReason string `json:"flag_reason,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewServer serves the given raffle storages, keyed by any form of the raffle address
+func NewServer(address string, storages map[string]storage.Storage) (*Server, error) {
+	s := &Server{
+		storages: make(map[string]storage.Storage, len(storages)),
+	}
+
+	for raffleAddress, raffleStorage := range storages {
+		raffleAccountID, err := ton.ParseAccountID(raffleAddress)
+		if err != nil {
+			return nil, err
+		}
+		s.storages[raffleAccountID.ToRaw()] = raffleStorage
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /raffles", s.handleRaffles)
+	mux.HandleFunc("GET /raffles/{raffle}", s.handleRaffle)
+	mux.HandleFunc("GET /raffles/{raffle}/users/{user}", s.handleUserStatus)
+	mux.HandleFunc("GET /raffles/{raffle}/users/{user}/actions", s.handleUserActions)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	s.server = &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+func (s *Server) ListenAndServe() error {
+	logger.Info("api: listening...", zap.String("address", s.server.Addr))
+
+	err := s.server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) handleRaffles(w http.ResponseWriter, _ *http.Request) {
+	raffles := make([]*raffleResponse, 0, len(s.storages))
+	for raffleAddress, raffleStorage := range s.storages {
+		raffle, err := getRaffle(raffleAddress, raffleStorage)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		raffles = append(raffles, raffle)
+	}
+
+	writeJSON(w, http.StatusOK, raffles)
+}
+
+func (s *Server) handleRaffle(w http.ResponseWriter, r *http.Request) {
+	raffleAddress, raffleStorage, ok := s.raffleStorage(w, r)
+	if !ok {
+		return
+	}
+
+	raffle, err := getRaffle(raffleAddress, raffleStorage)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, raffle)
+}
+
+func (s *Server) handleUserStatus(w http.ResponseWriter, r *http.Request) {
+	raffleAddress, raffleStorage, ok := s.raffleStorage(w, r)
+	if !ok {
+		return
+	}
+
+	userAddress, ok := parseUserAddress(w, r.PathValue("user"))
+	if !ok {
+		return
+	}
+
+	userStatus, err := raffleStorage.GetUserStatusByAddress(userAddress)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, errors.New("user is not a candidate of the raffle"))
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	raffleConditions, err := raffleStorage.GetRaffleConditions(raffleAddress)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	userConditions, err := raffleStorage.GetUserConditions([]string{userAddress})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	messages, err := raffleStorage.GetOutboxMessagesByUser(userAddress)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	values := make(map[string]uint64)
+	for _, userCondition := range userConditions {
+		values[userCondition.Slot] = userCondition.Value
+	}
+
+	// the queued message carries the values still in flight merged in
+	pending := make(map[string]uint64)
+	for _, message := range messages {
+		if message.State != storage.QueuedOutboxState && message.State != storage.SentOutboxState {
+			continue
+		}
+
+		for _, value := range message.Conditions {
+			pending[value.Slot] = max(pending[value.Slot], value.Value)
+		}
+	}
+
+	conditions := make([]*userConditionResponse, len(raffleConditions))
+	for i, raffleCondition := range raffleConditions {
+		conditions[i] = &userConditionResponse{
+			Slot:    raffleCondition.Slot,
+			Kind:    raffleCondition.Kind,
+			Value:   values[raffleCondition.Slot],
+			Pending: max(values[raffleCondition.Slot], pending[raffleCondition.Slot]),
+			Target:  raffleCondition.Target,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, &userStatusResponse{
+		RaffleAddress:             raffleAddress,
+		UserAddress:               userAddress,
+		Conditions:                conditions,
+		ConditionsReached:         userStatus.ConditionsReached,
+		CandidateRegistrationLt:   userStatus.CandidateRegistrationLt,
+		ParticipantRegistrationLt: userStatus.ParticipantRegistrationLt,
+	})
+}
+
+func (s *Server) handleUserActions(w http.ResponseWriter, r *http.Request) {
+	_, raffleStorage, ok := s.raffleStorage(w, r)
+	if !ok {
+		return
+	}
+
+	userAddress, ok := parseUserAddress(w, r.PathValue("user"))
+	if !ok {
+		return
+	}
+
+	actions, err := raffleStorage.GetUserActionsByUser(userAddress)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := make([]*userActionResponse, len(actions))
+	for i, action := range actions {
+		response[i] = &userActionResponse{
+			ActionType:          action.ActionType,
+			Address:             action.Address,
+			TransactionHash:     action.TransactionHash,
+			TransactionLt:       action.TransactionLt,
+			TransactionUnixTime: action.TransactionUnixTime,
+			Counterparty:        action.Counterparty,
+			FlagReason:          action.FlagReason,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) raffleStorage(w http.ResponseWriter, r *http.Request) (string, storage.Storage, bool) {
+	raffleAddress, ok := parseAddress(w, r.PathValue("raffle"))
+	if !ok {
+		return "", nil, false
+	}
+
+	raffleStorage, ok := s.storages[raffleAddress]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("raffle is not tracked by the oracle"))
+		return "", nil, false
+	}
+
+	return raffleAddress, raffleStorage, true
+}
+
+func getRaffle(raffleAddress string, raffleStorage storage.Storage) (*raffleResponse, error) {
+	raffle, err := raffleStorage.GetRaffle(raffleAddress)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		raffle, err = &storage.Raffle{Address: raffleAddress}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	raffleConditions, err := raffleStorage.GetRaffleConditions(raffleAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	statistics, err := raffleStorage.GetRaffleStatistics()
+	if err != nil {
+		return nil, err
+	}
+
+	winners, err := raffleStorage.GetWinners()
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := make([]*raffleConditionResponse, len(raffleConditions))
+	for i, raffleCondition := range raffleConditions {
+		conditions[i] = &raffleConditionResponse{
+			Slot:   raffleCondition.Slot,
+			Kind:   raffleCondition.Kind,
+			Target: raffleCondition.Target,
+			Users:  statistics.Conditions[raffleCondition.Slot],
+		}
+	}
+
+	winnerResponses := make([]*winnerResponse, 0, len(winners))
+	for _, winner := range winners {
+		if winner.State != storage.DrawnWinnerState {
+			continue
+		}
+
+		winnerResponses = append(winnerResponses, &winnerResponse{
+			WinnerIndex:        winner.WinnerIndex,
+			ParticipantIndex:   winner.ParticipantIndex,
+			ParticipantAddress: winner.ParticipantAddress,
+			UserAddress:        winner.UserAddress,
+			TransactionHash:    winner.TransactionHash,
+			TransactionLt:      winner.TransactionLt,
+		})
+	}
+
+	return &raffleResponse{
+		Address:           raffleAddress,
+		DeployedLt:        raffle.DeployedLt,
+		Conditions:        conditions,
+		Candidates:        statistics.Candidates,
+		ConditionsReached: statistics.ConditionsReached,
+		Participants:      statistics.Participants,
+		Winners:           winnerResponses,
+	}, nil
+}
+
+func parseAddress(w http.ResponseWriter, address string) (string, bool) {
+	accountID, err := ton.ParseAccountID(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid address "+address))
+		return "", false
+	}
+
+	return accountID.ToRaw(), true
+}
+
+// parseUserAddress accepts any form of the user address and returns the one the tracker stores
+func parseUserAddress(w http.ResponseWriter, address string) (string, bool) {
+	accountID, err := ton.ParseAccountID(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid address "+address))
+		return "", false
+	}
+
+	return accountID.ToHuman(true, false), true
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Warn("api: failed to write response", zap.Error(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	if statusCode == http.StatusInternalServerError {
+		logger.Warn("api: request failed", zap.Error(err))
+	}
+
+	writeJSON(w, statusCode, &errorResponse{Error: err.Error()})
+}
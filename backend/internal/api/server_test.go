@@ -0,0 +1,200 @@
+package api
+
+import (
+	"backend/internal/blockchain"
+	"backend/internal/logger"
+	"backend/internal/storage"
+	"backend/internal/tracker"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/wallet"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	testRaffleAddress = "EQAREREREREREREREREREREREREREREREREREREREREREeYT"
+	testUser1Address  = "EQAxMTExMTExMTExMTExMTExMTExMTExMTExMTExMTExMbHz"
+	testUser2Address  = "EQAyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMrYy"
+	testItemAddress   = "EQBhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYTXD"
+
+	testWhiteTicketCollectionAddress = "EQAhISEhISEhISEhISEhISEhISEhISEhISEhISEhISEhIZoD"
+	testBlackTicketCollectionAddress = "EQAiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIp3C"
+	testOracleAddress                = "0:1212121212121212121212121212121212121212121212121212121212121212"
+	testRaffleDeployedLt             = 900
+)
+
+// nullSender accepts every message without sending it, or fails them all with err
+type nullSender struct {
+	err error
+}
+
+func (s nullSender) Send(context.Context, time.Duration, ...wallet.Sendable) (*blockchain.SendResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return &blockchain.SendResult{}, nil
+}
+
+func (nullSender) GetAddress() ton.AccountID {
+	return ton.MustParseAccountID(testOracleAddress)
+}
+
+func (nullSender) MaxMessages() int {
+	return 4
+}
+
+// newTestTracker runs the raffle trackers on the fixtures of the tracker package
+func newTestTracker(t *testing.T, raffleStorage storage.Storage, sender nullSender, winnersQuantity int, fixtures ...string) *tracker.Tracker {
+	t.Helper()
+
+	paths := make([]string, len(fixtures))
+	for i, fixture := range fixtures {
+		paths[i] = filepath.Join("..", "tracker", "testdata", fixture)
+	}
+
+	source, err := blockchain.LoadFixtureSource(paths...)
+	if err != nil {
+		t.Fatalf("load fixtures: %v", err)
+	}
+
+	return tracker.NewTrackerWithOptions(context.Background(), tracker.Options{
+		Storage:                      raffleStorage,
+		Source:                       source,
+		Wallet:                       sender,
+		RaffleAddress:                testRaffleAddress,
+		BlackTicketCollectionAddress: testBlackTicketCollectionAddress,
+		WhiteTicketCollectionAddress: testWhiteTicketCollectionAddress,
+		WinnersQuantity:              winnersQuantity,
+	})
+}
+
+func newTestServer(t *testing.T, sender nullSender) *Server {
+	t.Helper()
+
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	sqliteStorage, err := storage.NewSqliteStorage(filepath.Join(t.TempDir(), "api.db"), "")
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	raffleStorage := sqliteStorage.ForRaffle(ton.MustParseAccountID(testRaffleAddress).ToRaw())
+
+	// user 1 registers, mints, purchases and becomes a participant, user 2 only registers
+	raffleConditions := tracker.RaffleConditions{Targets: []uint64{1, 1}}
+	trackerInstance := newTestTracker(t, raffleStorage, sender, 0,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	if err := trackerInstance.StoreRaffleConditions(raffleConditions); err != nil {
+		t.Fatalf("store raffle conditions: %v", err)
+	}
+
+	if err := trackerInstance.Run(testRaffleDeployedLt, raffleConditions); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// winner 0 is drawn, winner 1 is still drawing
+	if err := newTestTracker(t, raffleStorage, nullSender{}, 2, "winners.json").DrawWinners(testRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	server, err := NewServer("", map[string]storage.Storage{testRaffleAddress: raffleStorage})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	return server
+}
+
+func get(t *testing.T, server *Server, path string, expectedStatusCode int, value any) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if recorder.Code != expectedStatusCode {
+		t.Fatalf("GET %s: expected status %d, got %d: %s", path, expectedStatusCode, recorder.Code, recorder.Body.String())
+	}
+
+	if value != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), value); err != nil {
+			t.Fatalf("GET %s: decode response: %v", path, err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	server := newTestServer(t, nullSender{})
+
+	// the user may be given in any form, raw included
+	for _, userAddress := range []string{testUser1Address, ton.MustParseAccountID(testUser1Address).ToRaw()} {
+		var userStatus userStatusResponse
+		get(t, server, "/raffles/"+testRaffleAddress+"/users/"+userAddress, http.StatusOK, &userStatus)
+		if userStatus.UserAddress != testUser1Address || len(userStatus.Conditions) != 2 || userStatus.Conditions[0].Value != 1 ||
+			userStatus.Conditions[1].Value != 1 || userStatus.Conditions[1].Pending != 1 || userStatus.Conditions[1].Target != 1 || !userStatus.ConditionsReached {
+			t.Errorf("unexpected user status %+v", userStatus)
+		}
+
+		var actions []userActionResponse
+		get(t, server, "/raffles/"+testRaffleAddress+"/users/"+userAddress+"/actions", http.StatusOK, &actions)
+		if len(actions) != 4 {
+			t.Errorf("expected the 4 actions of user 1, got %+v", actions)
+		}
+	}
+
+	var userStatus userStatusResponse
+	get(t, server, "/raffles/"+testRaffleAddress+"/users/"+testUser2Address, http.StatusOK, &userStatus)
+	if userStatus.Conditions[0].Value != 0 || userStatus.ConditionsReached || userStatus.CandidateRegistrationLt == 0 {
+		t.Errorf("unexpected user status of user 2 %+v", userStatus)
+	}
+
+	var raffle raffleResponse
+	get(t, server, "/raffles/"+testRaffleAddress, http.StatusOK, &raffle)
+	if raffle.Candidates != 2 || len(raffle.Conditions) != 2 || raffle.Conditions[0].Users != 1 || raffle.Conditions[1].Users != 1 || raffle.ConditionsReached != 1 {
+		t.Errorf("unexpected raffle statistics %+v", raffle)
+	}
+
+	if len(raffle.Winners) != 1 || raffle.Winners[0].WinnerIndex != 0 || raffle.Winners[0].UserAddress != testUser1Address {
+		t.Errorf("expected the drawn winner only, got %+v", raffle.Winners)
+	}
+
+	var raffles []raffleResponse
+	get(t, server, "/raffles", http.StatusOK, &raffles)
+	if len(raffles) != 1 {
+		t.Errorf("expected 1 raffle, got %d", len(raffles))
+	}
+
+	get(t, server, "/raffles/"+testItemAddress, http.StatusNotFound, nil)
+	get(t, server, "/raffles/"+testRaffleAddress+"/users/"+testItemAddress, http.StatusNotFound, nil)
+	get(t, server, "/raffles/invalid", http.StatusBadRequest, nil)
+	get(t, server, "/metrics", http.StatusOK, nil)
+}
+
+func TestServerPendingConditions(t *testing.T) {
+	// the set conditions of user 1 cannot be sent, they stay queued
+	server := newTestServer(t, nullSender{err: errors.New("wallet unavailable")})
+
+	var userStatus userStatusResponse
+	get(t, server, "/raffles/"+testRaffleAddress+"/users/"+testUser1Address, http.StatusOK, &userStatus)
+	if len(userStatus.Conditions) != 2 || userStatus.ConditionsReached {
+		t.Fatalf("unexpected user status %+v", userStatus)
+	}
+
+	for _, condition := range userStatus.Conditions {
+		if condition.Value != 0 || condition.Pending != 1 {
+			t.Errorf("expected %s pending 1 and confirmed 0, got %+v", condition.Slot, condition)
+		}
+	}
+}
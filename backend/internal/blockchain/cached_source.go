@@ -0,0 +1,222 @@
+package blockchain
+
+import (
+	"backend/internal/marketplace"
+	"backend/internal/metrics"
+	"context"
+	"encoding/json"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+const (
+	traceLookupKind          = "trace"
+	itemCollectionLookupKind = "item_collection"
+	completedSaleLookupKind  = "completed_sale"
+)
+
+// LookupCache persists the cached lookups, the storage implements it
+type LookupCache interface {
+	GetCachedLookup(kind string, key string) (string, bool, error)
+	UpdateCachedLookup(kind string, key string, value string) error
+}
+
+// CachedSource serves the facts that cannot change once final from a persistent cache: the
+// bodies of finished traces, the collection of NFT items and the sale data of completed sales.
+// Accounts, trace lists, blocks, transactions and the other get-methods always reach the
+// wrapped source.
+type CachedSource struct {
+	source  ChainSource
+	lookups *DirectLookups
+	cache   LookupCache
+}
+
+func NewCachedSource(source ChainSource, cache LookupCache) *CachedSource {
+	return &CachedSource{
+		source:  source,
+		lookups: NewDirectLookups(source),
+		cache:   cache,
+	}
+}
+
+// WithCache returns a source sharing the wrapped source and caching into another cache
+func (s *CachedSource) WithCache(cache LookupCache) *CachedSource {
+	return &CachedSource{
+		source:  s.source,
+		lookups: s.lookups,
+		cache:   cache,
+	}
+}
+
+func (s *CachedSource) GetAccountTraces(ctx context.Context, accountID string, limit int, beforeLt int64) (*tonapi.TraceIDs, error) {
+	return s.source.GetAccountTraces(ctx, accountID, limit, beforeLt)
+}
+
+func (s *CachedSource) GetTrace(ctx context.Context, traceID string) (*tonapi.Trace, error) {
+	var trace tonapi.Trace
+	if s.get(traceLookupKind, traceID, &trace) {
+		return &trace, nil
+	}
+
+	result, err := s.source.GetTrace(ctx, traceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if traceFinished(result) {
+		s.put(traceLookupKind, traceID, result)
+	}
+
+	return result, nil
+}
+
+func (s *CachedSource) GetAccount(ctx context.Context, accountID string) (*tonapi.Account, error) {
+	return s.source.GetAccount(ctx, accountID)
+}
+
+func (s *CachedSource) GetNftItem(ctx context.Context, accountID string) (*tonapi.NftItem, error) {
+	return s.source.GetNftItem(ctx, accountID)
+}
+
+func (s *CachedSource) ExecGetMethod(ctx context.Context, accountID string, methodName string, args ...tonapi.ExecGetMethodArg) (*tonapi.MethodExecutionResult, error) {
+	return s.source.ExecGetMethod(ctx, accountID, methodName, args...)
+}
+
+func (s *CachedSource) GetMasterchainHead(ctx context.Context) (*tonapi.BlockchainBlock, error) {
+	return s.source.GetMasterchainHead(ctx)
+}
+
+func (s *CachedSource) GetMasterchainBlock(ctx context.Context, seqno int32) (*tonapi.BlockchainBlock, error) {
+	return s.source.GetMasterchainBlock(ctx, seqno)
+}
+
+func (s *CachedSource) GetTransaction(ctx context.Context, hash string) (*tonapi.Transaction, error) {
+	return s.source.GetTransaction(ctx, hash)
+}
+
+func (s *CachedSource) GetTransactionByMessageHash(ctx context.Context, messageHash string) (*tonapi.Transaction, error) {
+	return s.source.GetTransactionByMessageHash(ctx, messageHash)
+}
+
+// GetItemCollection caches the collection of an item, an item never changes its collection
+func (s *CachedSource) GetItemCollection(ctx context.Context, itemAddress string) (string, error) {
+	var collection string
+	if s.get(itemCollectionLookupKind, itemAddress, &collection) {
+		return collection, nil
+	}
+
+	collection, err := s.lookups.GetItemCollection(ctx, itemAddress)
+	if err != nil {
+		return "", err
+	}
+
+	s.put(itemCollectionLookupKind, itemAddress, collection)
+	return collection, nil
+}
+
+// cachedSale is a completed sale as stored in the cache
+type cachedSale struct {
+	Adapter     string `json:"adapter"`
+	Marketplace string `json:"marketplace"`
+	Item        string `json:"item"`
+	Owner       string `json:"owner"`
+}
+
+// GetCompletedSale caches the sale data of a completed sale, the contract may be destroyed
+// afterwards but its marketplace, item and seller stay what they were
+func (s *CachedSource) GetCompletedSale(ctx context.Context, saleAddress string, marketplaces *marketplace.Registry) (*marketplace.Sale, error) {
+	var cached cachedSale
+	if s.get(completedSaleLookupKind, saleAddress, &cached) {
+		sale, err := cached.sale()
+		if err == nil {
+			return sale, nil
+		}
+	}
+
+	sale, err := s.lookups.GetCompletedSale(ctx, saleAddress, marketplaces)
+	if err != nil {
+		return nil, err
+	}
+
+	s.put(completedSaleLookupKind, saleAddress, cachedSale{
+		Adapter:     sale.Adapter,
+		Marketplace: sale.Marketplace.ToRaw(),
+		Item:        sale.Item.ToRaw(),
+		Owner:       sale.Owner.ToRaw(),
+	})
+	return sale, nil
+}
+
+func (c cachedSale) sale() (*marketplace.Sale, error) {
+	marketplaceAccountID, err := ton.ParseAccountID(c.Marketplace)
+	if err != nil {
+		return nil, err
+	}
+
+	itemAccountID, err := ton.ParseAccountID(c.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerAccountID, err := ton.ParseAccountID(c.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &marketplace.Sale{
+		Adapter:     c.Adapter,
+		Marketplace: marketplaceAccountID,
+		Item:        itemAccountID,
+		Owner:       ownerAccountID,
+	}, nil
+}
+
+// get reads a cached lookup into value, a storage failure or an unreadable entry is a miss
+func (s *CachedSource) get(kind string, key string, value any) bool {
+	encoded, ok, err := s.cache.GetCachedLookup(kind, key)
+	if err == nil && ok && json.Unmarshal([]byte(encoded), value) == nil {
+		metrics.ChainCacheLookups.WithLabelValues(kind, "hit").Inc()
+		return true
+	}
+
+	metrics.ChainCacheLookups.WithLabelValues(kind, "miss").Inc()
+	return false
+}
+
+// put caches a lookup, a failure only costs a source call on the next lookup
+func (s *CachedSource) put(kind string, key string, value any) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	_ = s.cache.UpdateCachedLookup(kind, key, string(encoded))
+}
+
+// traceFinished tells whether a trace is final: it is not emulated and every internal message
+// sent in it was already delivered, so no child can be appended later
+func traceFinished(trace *tonapi.Trace) bool {
+	if trace.Emulated.Value {
+		return false
+	}
+
+	internalMessages := 0
+	for _, message := range trace.Transaction.OutMsgs {
+		if message.MsgType == tonapi.MessageMsgTypeIntMsg {
+			internalMessages++
+		}
+	}
+
+	if len(trace.Children) < internalMessages {
+		return false
+	}
+
+	for i := range trace.Children {
+		if !traceFinished(&trace.Children[i]) {
+			return false
+		}
+	}
+
+	return true
+}
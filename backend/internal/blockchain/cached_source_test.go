@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+type mapCache map[string]string
+
+func (c mapCache) GetCachedLookup(kind string, key string) (string, bool, error) {
+	value, ok := c[kind+"/"+key]
+	return value, ok, nil
+}
+
+func (c mapCache) UpdateCachedLookup(kind string, key string, value string) error {
+	c[kind+"/"+key] = value
+	return nil
+}
+
+const (
+	testFinishedTraceID = "01"
+	testPendingTraceID  = "02"
+	testItemAddress     = "0:6262626262626262626262626262626262626262626262626262626262626262"
+	testCollection      = "0:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func TestCachedSource(t *testing.T) {
+	source := NewFixtureSource()
+	err := source.Load(&Fixture{
+		Traces: map[string]json.RawMessage{
+			testFinishedTraceID: json.RawMessage(`{"transaction":{"hash":"01","lt":1,"account":{"address":"0:01","is_scam":false,"is_wallet":false},"success":true,"utime":1,"orig_status":"active","end_status":"active","total_fees":0,"end_balance":0,"transaction_type":"TransOrd","state_update_old":"","state_update_new":"","out_msgs":[],"block":"","aborted":false,"destroyed":false,"raw":""},"interfaces":[]}`),
+			// the internal message it sent is not delivered yet
+			testPendingTraceID: json.RawMessage(`{"transaction":{"hash":"02","lt":2,"account":{"address":"0:01","is_scam":false,"is_wallet":false},"success":true,"utime":1,"orig_status":"active","end_status":"active","total_fees":0,"end_balance":0,"transaction_type":"TransOrd","state_update_old":"","state_update_new":"","out_msgs":[{"msg_type":"int_msg","created_lt":2,"ihr_disabled":false,"bounce":false,"bounced":false,"value":0,"fwd_fee":0,"ihr_fee":0,"import_fee":0,"created_at":1,"hash":"03"}],"block":"","aborted":false,"destroyed":false,"raw":""},"interfaces":[]}`),
+		},
+		NftItems: map[string]json.RawMessage{
+			testItemAddress: json.RawMessage(`{"address":"` + testItemAddress + `","index":0,"collection":{"address":"` + testCollection + `","name":"","description":""},"verified":true,"metadata":{},"approved_by":[],"trust":"none"}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("load fixture: %v", err)
+	}
+
+	cache := mapCache{}
+	cachedSource := NewCachedSource(source, cache)
+
+	for _, traceID := range []string{testFinishedTraceID, testPendingTraceID} {
+		if _, err := cachedSource.GetTrace(context.Background(), traceID); err != nil {
+			t.Fatalf("get trace %s: %v", traceID, err)
+		}
+	}
+
+	if _, ok := cache[traceLookupKind+"/"+testFinishedTraceID]; !ok {
+		t.Error("expected the finished trace to be cached")
+	}
+
+	if _, ok := cache[traceLookupKind+"/"+testPendingTraceID]; ok {
+		t.Error("expected the pending trace not to be cached")
+	}
+
+	// a cached fact is served even once the source forgot it
+	cachedTrace, err := NewCachedSource(NewFixtureSource(), cache).GetTrace(context.Background(), testFinishedTraceID)
+	if err != nil || cachedTrace.Transaction.Lt != 1 {
+		t.Errorf("expected the cached trace, got %v", err)
+	}
+
+	collection, err := cachedSource.GetItemCollection(context.Background(), testItemAddress)
+	if err != nil || collection != testCollection {
+		t.Fatalf("expected collection %s, got %q (%v)", testCollection, collection, err)
+	}
+
+	collection, err = NewCachedSource(NewFixtureSource(), cache).GetItemCollection(context.Background(), testItemAddress)
+	if err != nil || collection != testCollection {
+		t.Errorf("expected the cached collection, got %q (%v)", collection, err)
+	}
+}
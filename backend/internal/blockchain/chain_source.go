@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tonkeeper/tonapi-go"
+)
+
+// ErrLookupUnsupported is returned by sources that cannot look a transaction up by hash alone
+var ErrLookupUnsupported = errors.New("chain source: lookup unsupported")
+
+// ChainSource is the read side of the blockchain used by the tracker: account traces,
+// accounts, NFT items, get-method execution, masterchain blocks and transactions. Results are expressed in TonAPI models,
+// so every implementation has to return the same shapes the collectors already walk.
+//
+// The trace shapes differ between the sources. TonAPI roots a trace at the external message
+// that started it, so a trace of an account may begin at another wallet. The liteapi source
+// roots it at the transaction of the account itself and only follows its outgoing messages,
+// the parents are never part of it. The collectors and the outbox only walk from the account
+// transaction down, which both shapes serve. GetTransaction and GetTransactionByMessageHash
+// return ErrLookupUnsupported from the liteapi source, liteservers index neither hash.
+type ChainSource interface {
+	GetAccountTraces(ctx context.Context, accountID string, limit int, beforeLt int64) (*tonapi.TraceIDs, error)
+	GetTrace(ctx context.Context, traceID string) (*tonapi.Trace, error)
+	GetAccount(ctx context.Context, accountID string) (*tonapi.Account, error)
+	GetNftItem(ctx context.Context, accountID string) (*tonapi.NftItem, error)
+	ExecGetMethod(ctx context.Context, accountID string, methodName string, args ...tonapi.ExecGetMethodArg) (*tonapi.MethodExecutionResult, error)
+	GetMasterchainHead(ctx context.Context) (*tonapi.BlockchainBlock, error)
+	GetMasterchainBlock(ctx context.Context, seqno int32) (*tonapi.BlockchainBlock, error)
+	GetTransaction(ctx context.Context, hash string) (*tonapi.Transaction, error)
+	GetTransactionByMessageHash(ctx context.Context, messageHash string) (*tonapi.Transaction, error)
+}
+
+type ChainSourceType = string
+
+const (
+	TonapiChainSourceType  ChainSourceType = "tonapi"
+	LiteapiChainSourceType ChainSourceType = "liteapi"
+)
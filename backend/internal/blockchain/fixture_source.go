@@ -0,0 +1,362 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+var ErrFixtureNotFound = errors.New("fixture source: not found")
+
+// Fixture is the on-disk format of recorded chain data. Every payload is kept exactly as
+// TonAPI returns it, so responses can be recorded from the live API and replayed as is.
+// Get-method results are keyed by method name, or by "name(arg,...)" when the result
+// depends on the arguments. Masterchain blocks are keyed by seqno; a fixture set without any
+// replays a chain on which every loaded trace is final.
+type Fixture struct {
+	AccountTraces     map[string][]string                   `json:"account_traces"`
+	Traces            map[string]json.RawMessage            `json:"traces"`
+	Accounts          map[string]json.RawMessage            `json:"accounts"`
+	NftItems          map[string]json.RawMessage            `json:"nft_items"`
+	GetMethods        map[string]map[string]json.RawMessage `json:"get_methods"`
+	MasterchainHead   int32                                 `json:"masterchain_head"`
+	MasterchainBlocks map[string]json.RawMessage            `json:"masterchain_blocks"`
+}
+
+// FixtureSource is an in-memory ChainSource replaying recorded fixtures, used to run the
+// tracker offline.
+type FixtureSource struct {
+	mutex         sync.RWMutex
+	accountTraces map[string][]string
+	traces        map[string]*tonapi.Trace
+	accounts      map[string]*tonapi.Account
+	nftItems      map[string]*tonapi.NftItem
+	getMethods    map[string]map[string]*tonapi.MethodExecutionResult
+	// transactions index the transactions of the loaded traces by hash and by in-message hash
+	transactions        map[string]*tonapi.Transaction
+	messageTransactions map[string]*tonapi.Transaction
+	masterchainHead     int32
+	masterchainBlocks   map[int32]*tonapi.BlockchainBlock
+}
+
+func NewFixtureSource() *FixtureSource {
+	return &FixtureSource{
+		accountTraces: make(map[string][]string),
+		traces:        make(map[string]*tonapi.Trace),
+		accounts:      make(map[string]*tonapi.Account),
+		nftItems:      make(map[string]*tonapi.NftItem),
+		getMethods:    make(map[string]map[string]*tonapi.MethodExecutionResult),
+
+		transactions:        make(map[string]*tonapi.Transaction),
+		messageTransactions: make(map[string]*tonapi.Transaction),
+		masterchainBlocks:   make(map[int32]*tonapi.BlockchainBlock),
+	}
+}
+
+func LoadFixtureSource(paths ...string) (*FixtureSource, error) {
+	source := NewFixtureSource()
+	for _, path := range paths {
+		if err := source.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return source, nil
+}
+
+func (s *FixtureSource) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return fmt.Errorf("fixture source: %s: %w", path, err)
+	}
+
+	if err := s.Load(&fixture); err != nil {
+		return fmt.Errorf("fixture source: %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (s *FixtureSource) Load(fixture *Fixture) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, raw := range fixture.Traces {
+		var trace tonapi.Trace
+		if err := trace.UnmarshalJSON(raw); err != nil {
+			return fmt.Errorf("trace %s: %w", id, err)
+		}
+		s.traces[id] = &trace
+		s.indexTransactions(&trace)
+	}
+
+	for address, raw := range fixture.Accounts {
+		key, err := fixtureKey(address)
+		if err != nil {
+			return err
+		}
+
+		var account tonapi.Account
+		if err := account.UnmarshalJSON(raw); err != nil {
+			return fmt.Errorf("account %s: %w", address, err)
+		}
+		s.accounts[key] = &account
+	}
+
+	for address, raw := range fixture.NftItems {
+		key, err := fixtureKey(address)
+		if err != nil {
+			return err
+		}
+
+		var item tonapi.NftItem
+		if err := item.UnmarshalJSON(raw); err != nil {
+			return fmt.Errorf("nft item %s: %w", address, err)
+		}
+		s.nftItems[key] = &item
+	}
+
+	for address, methods := range fixture.GetMethods {
+		key, err := fixtureKey(address)
+		if err != nil {
+			return err
+		}
+
+		if s.getMethods[key] == nil {
+			s.getMethods[key] = make(map[string]*tonapi.MethodExecutionResult)
+		}
+
+		for method, raw := range methods {
+			var result tonapi.MethodExecutionResult
+			if err := result.UnmarshalJSON(raw); err != nil {
+				return fmt.Errorf("get method %s of %s: %w", method, address, err)
+			}
+			s.getMethods[key][method] = &result
+		}
+	}
+
+	for address, traceIDs := range fixture.AccountTraces {
+		key, err := fixtureKey(address)
+		if err != nil {
+			return err
+		}
+
+		for _, traceID := range traceIDs {
+			if _, ok := s.traces[traceID]; !ok {
+				return fmt.Errorf("account %s references unknown trace %s", address, traceID)
+			}
+		}
+
+		s.accountTraces[key] = append(s.accountTraces[key], traceIDs...)
+		sort.SliceStable(s.accountTraces[key], func(i, j int) bool {
+			return s.traces[s.accountTraces[key][i]].Transaction.Lt > s.traces[s.accountTraces[key][j]].Transaction.Lt
+		})
+	}
+
+	for seqno, raw := range fixture.MasterchainBlocks {
+		value, err := strconv.ParseInt(seqno, 10, 32)
+		if err != nil {
+			return fmt.Errorf("masterchain block %s: %w", seqno, err)
+		}
+
+		var block tonapi.BlockchainBlock
+		if err := block.UnmarshalJSON(raw); err != nil {
+			return fmt.Errorf("masterchain block %s: %w", seqno, err)
+		}
+		s.masterchainBlocks[int32(value)] = &block
+	}
+
+	if fixture.MasterchainHead > 0 {
+		s.masterchainHead = fixture.MasterchainHead
+	}
+
+	return nil
+}
+
+func (s *FixtureSource) indexTransactions(trace *tonapi.Trace) {
+	s.transactions[trace.Transaction.Hash] = &trace.Transaction
+	if message, ok := trace.Transaction.InMsg.Get(); ok {
+		s.messageTransactions[message.Hash] = &trace.Transaction
+	}
+
+	for i := range trace.Children {
+		s.indexTransactions(&trace.Children[i])
+	}
+}
+
+func (s *FixtureSource) GetAccountTraces(_ context.Context, accountID string, limit int, beforeLt int64) (*tonapi.TraceIDs, error) {
+	key, err := fixtureKey(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := &tonapi.TraceIDs{Traces: make([]tonapi.TraceID, 0)}
+	for _, traceID := range s.accountTraces[key] {
+		trace := s.traces[traceID]
+		if beforeLt > 0 && trace.Transaction.Lt >= beforeLt {
+			continue
+		}
+
+		result.Traces = append(result.Traces, tonapi.TraceID{ID: traceID, Utime: trace.Transaction.Utime})
+		if len(result.Traces) >= limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func (s *FixtureSource) GetTrace(_ context.Context, traceID string) (*tonapi.Trace, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	trace, ok := s.traces[traceID]
+	if !ok {
+		return nil, fmt.Errorf("%w: trace %s", ErrFixtureNotFound, traceID)
+	}
+
+	return trace, nil
+}
+
+func (s *FixtureSource) GetAccount(_ context.Context, accountID string) (*tonapi.Account, error) {
+	key, err := fixtureKey(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	account, ok := s.accounts[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: account %s", ErrFixtureNotFound, accountID)
+	}
+
+	return account, nil
+}
+
+func (s *FixtureSource) GetNftItem(_ context.Context, accountID string) (*tonapi.NftItem, error) {
+	key, err := fixtureKey(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	item, ok := s.nftItems[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: nft item %s", ErrFixtureNotFound, accountID)
+	}
+
+	return item, nil
+}
+
+func (s *FixtureSource) ExecGetMethod(_ context.Context, accountID string, methodName string, args ...tonapi.ExecGetMethodArg) (*tonapi.MethodExecutionResult, error) {
+	key, err := fixtureKey(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	methods := s.getMethods[key]
+	if len(args) > 0 {
+		values := make([]string, len(args))
+		for i, arg := range args {
+			values[i] = arg.Value
+		}
+
+		if result, ok := methods[methodName+"("+strings.Join(values, ",")+")"]; ok {
+			return result, nil
+		}
+	}
+
+	result, ok := methods[methodName]
+	if !ok {
+		return nil, fmt.Errorf("%w: get method %s of %s", ErrFixtureNotFound, methodName, accountID)
+	}
+
+	return result, nil
+}
+
+func (s *FixtureSource) GetMasterchainHead(ctx context.Context) (*tonapi.BlockchainBlock, error) {
+	s.mutex.RLock()
+	seqno := s.masterchainHead
+	s.mutex.RUnlock()
+
+	if seqno == 0 {
+		seqno = math.MaxInt32
+	}
+
+	return s.GetMasterchainBlock(ctx, seqno)
+}
+
+func (s *FixtureSource) GetMasterchainBlock(_ context.Context, seqno int32) (*tonapi.BlockchainBlock, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// without recorded blocks the chain is final up to the last lt
+	if len(s.masterchainBlocks) == 0 {
+		return &tonapi.BlockchainBlock{WorkchainID: -1, Shard: "8000000000000000", Seqno: seqno, StartLt: math.MaxInt64, EndLt: math.MaxInt64}, nil
+	}
+
+	block, ok := s.masterchainBlocks[seqno]
+	if !ok {
+		return nil, fmt.Errorf("%w: masterchain block %d", ErrFixtureNotFound, seqno)
+	}
+
+	return block, nil
+}
+
+func (s *FixtureSource) GetTransaction(_ context.Context, hash string) (*tonapi.Transaction, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	transaction, ok := s.transactions[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: transaction %s", ErrFixtureNotFound, hash)
+	}
+
+	return transaction, nil
+}
+
+func (s *FixtureSource) GetTransactionByMessageHash(_ context.Context, messageHash string) (*tonapi.Transaction, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	transaction, ok := s.messageTransactions[messageHash]
+	if !ok {
+		return nil, fmt.Errorf("%w: message %s", ErrFixtureNotFound, messageHash)
+	}
+
+	return transaction, nil
+}
+
+func fixtureKey(address string) (string, error) {
+	accountID, err := ton.ParseAccountID(address)
+	if err != nil {
+		return "", fmt.Errorf("fixture source: invalid address %s: %w", address, err)
+	}
+
+	return accountID.ToRaw(), nil
+}
@@ -0,0 +1,238 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/liteapi"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/wallet"
+)
+
+const HighloadMaxMessages = 254
+const highloadDefaultLifetime = 60 * time.Second
+
+// highloadClockSkew is how long after valid until the query is checked a last time, the block
+// accepting it may be timed behind the local clock. The wallet keeps the query for 64 seconds
+// after valid until, so the last check still finds it.
+const highloadClockSkew = 15 * time.Second
+
+var ErrHighloadNotProcessed = errors.New("highload sender: query was not processed in time")
+
+// HighloadSender packs up to maxMessages internal messages into one highload-wallet v2
+// external message. Every external message gets its own bounded query id, the wallet
+// contract processes it at most once and forgets it after valid until.
+type HighloadSender struct {
+	mutex       sync.Mutex
+	wallet      *wallet.Wallet
+	key         ed25519.PrivateKey
+	subWalletID uint32
+	client      *liteapi.Client
+	maxMessages int
+	counter     uint32
+}
+
+func NewHighloadSender(key ed25519.PrivateKey, client *liteapi.Client, maxMessages int) (*HighloadSender, error) {
+	if maxMessages <= 0 || maxMessages > HighloadMaxMessages {
+		return nil, fmt.Errorf("highload sender: max messages must be within 1..%d", HighloadMaxMessages)
+	}
+
+	highloadWallet, err := wallet.New(key, wallet.HighLoadV2R2, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HighloadSender{
+		wallet:      &highloadWallet,
+		key:         key,
+		subWalletID: uint32(wallet.DefaultSubWallet),
+		client:      client,
+		maxMessages: maxMessages,
+		counter:     rand.Uint32(),
+	}, nil
+}
+
+func (s *HighloadSender) Send(ctx context.Context, waitingConfirmation time.Duration, messages ...wallet.Sendable) (*SendResult, error) {
+	if len(messages) == 0 || len(messages) > s.maxMessages {
+		return nil, notSent(fmt.Errorf("highload sender: %d messages, expected 1..%d", len(messages), s.maxMessages))
+	}
+
+	rawMessages := make([]wallet.RawMessage, len(messages))
+	for i, message := range messages {
+		intMsg, mode, err := message.ToInternal()
+		if err != nil {
+			return nil, notSent(err)
+		}
+
+		cell := boc.NewCell()
+		if err := tlb.Marshal(cell, intMsg); err != nil {
+			return nil, notSent(err)
+		}
+		rawMessages[i] = wallet.RawMessage{Message: cell, Mode: mode}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	address := s.wallet.GetAddress()
+	state, err := s.client.GetAccountState(ctx, address)
+	if err != nil {
+		return nil, notSent(fmt.Errorf("highload sender: get account state: %w", err))
+	}
+
+	var init *tlb.StateInit
+	if status := state.Account.Status(); status == tlb.AccountUninit || status == tlb.AccountNone {
+		stateInit, err := s.wallet.StateInit()
+		if err != nil {
+			return nil, notSent(err)
+		}
+		init = stateInit
+	}
+
+	// the query is valid for as long as we are ready to wait for it, so a timeout means it
+	// will never be processed and the messages can be sent again
+	lifetime := highloadDefaultLifetime
+	if waitingConfirmation > 0 {
+		lifetime = waitingConfirmation
+	}
+
+	s.counter++
+	validUntil := time.Now().Add(lifetime)
+	queryID := uint64(validUntil.Unix())<<32 | uint64(s.counter)
+
+	externalMessage, err := s.externalMessage(queryID, rawMessages, init)
+	if err != nil {
+		return nil, notSent(err)
+	}
+
+	messageHash, err := externalMessage.Hash256()
+	if err != nil {
+		return nil, notSent(err)
+	}
+
+	payload, err := externalMessage.ToBocCustom(false, false, false, 0)
+	if err != nil {
+		return nil, notSent(err)
+	}
+
+	// a failed broadcast may still have reached a validator, the query is left to expire
+	if _, err := s.client.SendMessage(ctx, payload); err != nil {
+		return nil, err
+	}
+
+	result := &SendResult{QueryID: queryID, MessageHash: messageHash}
+	if waitingConfirmation == 0 {
+		return result, nil
+	}
+
+	if err := s.waitProcessed(ctx, queryID, validUntil, waitingConfirmation/10); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// waitProcessed polls the processed? get-method until the query is accepted. ErrHighloadNotProcessed
+// is only returned once the query has expired, so its messages can never be delivered.
+func (s *HighloadSender) waitProcessed(ctx context.Context, queryID uint64, validUntil time.Time, interval time.Duration) error {
+	ticker := time.NewTicker(max(interval, time.Second))
+	defer ticker.Stop()
+
+	deadline := time.NewTimer(time.Until(validUntil.Add(highloadClockSkew)))
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if processed, err := s.processed(ctx, queryID); err == nil && processed {
+				return nil
+			}
+		case <-deadline.C:
+			processed, err := s.processed(ctx, queryID)
+			if err != nil {
+				return fmt.Errorf("highload sender: last processed? check: %w", err)
+			}
+
+			if processed {
+				return nil
+			}
+
+			return notSent(ErrHighloadNotProcessed)
+		}
+	}
+}
+
+func (s *HighloadSender) GetAddress() ton.AccountID {
+	return s.wallet.GetAddress()
+}
+
+func (s *HighloadSender) MaxMessages() int {
+	return s.maxMessages
+}
+
+func (s *HighloadSender) externalMessage(queryID uint64, rawMessages []wallet.RawMessage, init *tlb.StateInit) (*boc.Cell, error) {
+	bodyCell := boc.NewCell()
+	err := tlb.Marshal(bodyCell, wallet.HighloadV2Message{
+		SubWalletId:    s.subWalletID,
+		BoundedQueryID: queryID,
+		RawMessages:    wallet.PayloadHighload(rawMessages),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := bodyCell.Sign(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	var sign tlb.Bits512
+	copy(sign[:], signature)
+
+	signedBodyCell := boc.NewCell()
+	if err := tlb.Marshal(signedBodyCell, wallet.SignedMsgBody{Sign: sign, Message: tlb.Any(*bodyCell)}); err != nil {
+		return nil, err
+	}
+
+	message, err := ton.CreateExternalMessage(s.wallet.GetAddress(), signedBodyCell, init, tlb.VarUInteger16{})
+	if err != nil {
+		return nil, err
+	}
+
+	messageCell := boc.NewCell()
+	if err := tlb.Marshal(messageCell, message); err != nil {
+		return nil, err
+	}
+
+	return messageCell, nil
+}
+
+// processed runs the processed? get-method of the highload wallet, true means the query has
+// been accepted by the contract
+func (s *HighloadSender) processed(ctx context.Context, queryID uint64) (bool, error) {
+	exitCode, stack, err := s.client.RunSmcMethod(ctx, s.wallet.GetAddress(), "processed?", tlb.VmStack{
+		tlb.VmStackValue{SumType: "VmStkTinyInt", VmStkTinyInt: int64(queryID)},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if exitCode != 0 && exitCode != 1 {
+		return false, fmt.Errorf("highload sender: processed? exit code %d", exitCode)
+	}
+
+	if len(stack) == 0 {
+		return false, errors.New("highload sender: empty processed? result")
+	}
+
+	return stackValueInt(stack[0]).Sign() != 0, nil
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/liteapi"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/wallet"
+)
+
+func TestHighloadSenderExternalMessage(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := NewHighloadSender(privateKey, (*liteapi.Client)(nil), 3)
+	if err != nil {
+		t.Fatalf("new highload sender: %v", err)
+	}
+
+	if _, err := NewHighloadSender(privateKey, (*liteapi.Client)(nil), HighloadMaxMessages+1); err == nil {
+		t.Error("expected an error for a batch above the highload limit")
+	}
+
+	recipient := ton.MustParseAccountID("EQAREREREREREREREREREREREREREREREREREREREREREeYT")
+	rawMessages := make([]wallet.RawMessage, 3)
+	for i := range rawMessages {
+		intMsg, mode, err := wallet.Message{Amount: tlb.Grams(i + 1), Address: recipient, Mode: wallet.DefaultMessageMode}.ToInternal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cell := boc.NewCell()
+		if err := tlb.Marshal(cell, intMsg); err != nil {
+			t.Fatal(err)
+		}
+		rawMessages[i] = wallet.RawMessage{Message: cell, Mode: mode}
+	}
+
+	const queryID = uint64(1700000000)<<32 | 42
+	messageCell, err := sender.externalMessage(queryID, rawMessages, nil)
+	if err != nil {
+		t.Fatalf("external message: %v", err)
+	}
+
+	var message tlb.Message
+	if err := tlb.Unmarshal(messageCell, &message); err != nil {
+		t.Fatalf("unmarshal external message: %v", err)
+	}
+
+	if message.Info.SumType != "ExtInMsgInfo" {
+		t.Fatalf("expected an external message, got %s", message.Info.SumType)
+	}
+
+	destination, err := ton.AccountIDFromTlb(message.Info.ExtInMsgInfo.Dest)
+	if err != nil || destination == nil || *destination != sender.GetAddress() {
+		t.Errorf("external message is not addressed to the highload wallet")
+	}
+
+	var signedBody wallet.SignedMsgBody
+	body := boc.Cell(message.Body.Value)
+	if err := tlb.Unmarshal(&body, &signedBody); err != nil {
+		t.Fatalf("unmarshal signed body: %v", err)
+	}
+
+	bodyCell := boc.Cell(signedBody.Message)
+	hash, err := bodyCell.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ed25519.Verify(publicKey, hash, signedBody.Sign[:]) {
+		t.Error("invalid signature")
+	}
+
+	var highloadMessage wallet.HighloadV2Message
+	if err := tlb.Unmarshal(&bodyCell, &highloadMessage); err != nil {
+		t.Fatalf("unmarshal highload message: %v", err)
+	}
+
+	if highloadMessage.BoundedQueryID != queryID || highloadMessage.SubWalletId != wallet.DefaultSubWallet {
+		t.Errorf("unexpected query id %d and sub wallet %d", highloadMessage.BoundedQueryID, highloadMessage.SubWalletId)
+	}
+
+	if len(highloadMessage.RawMessages) != len(rawMessages) {
+		t.Errorf("expected %d messages in the batch, got %d", len(rawMessages), len(highloadMessage.RawMessages))
+	}
+}
@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"backend/internal/metrics"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/tonkeeper/tonapi-go"
+)
+
+// InstrumentedSource counts the calls of the wrapped source by method and result
+type InstrumentedSource struct {
+	source ChainSource
+}
+
+func NewInstrumentedSource(source ChainSource) *InstrumentedSource {
+	return &InstrumentedSource{
+		source: source,
+	}
+}
+
+func (s *InstrumentedSource) GetAccountTraces(ctx context.Context, accountID string, limit int, beforeLt int64) (*tonapi.TraceIDs, error) {
+	result, err := s.source.GetAccountTraces(ctx, accountID, limit, beforeLt)
+	observe("get_account_traces", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) GetTrace(ctx context.Context, traceID string) (*tonapi.Trace, error) {
+	result, err := s.source.GetTrace(ctx, traceID)
+	observe("get_trace", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) GetAccount(ctx context.Context, accountID string) (*tonapi.Account, error) {
+	result, err := s.source.GetAccount(ctx, accountID)
+	observe("get_account", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) GetNftItem(ctx context.Context, accountID string) (*tonapi.NftItem, error) {
+	result, err := s.source.GetNftItem(ctx, accountID)
+	observe("get_nft_item", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) ExecGetMethod(ctx context.Context, accountID string, methodName string, args ...tonapi.ExecGetMethodArg) (*tonapi.MethodExecutionResult, error) {
+	result, err := s.source.ExecGetMethod(ctx, accountID, methodName, args...)
+	observe("exec_get_method", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) GetMasterchainHead(ctx context.Context) (*tonapi.BlockchainBlock, error) {
+	result, err := s.source.GetMasterchainHead(ctx)
+	observe("get_masterchain_head", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) GetMasterchainBlock(ctx context.Context, seqno int32) (*tonapi.BlockchainBlock, error) {
+	result, err := s.source.GetMasterchainBlock(ctx, seqno)
+	observe("get_masterchain_block", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) GetTransaction(ctx context.Context, hash string) (*tonapi.Transaction, error) {
+	result, err := s.source.GetTransaction(ctx, hash)
+	observe("get_transaction", err)
+	return result, err
+}
+
+func (s *InstrumentedSource) GetTransactionByMessageHash(ctx context.Context, messageHash string) (*tonapi.Transaction, error) {
+	result, err := s.source.GetTransactionByMessageHash(ctx, messageHash)
+	observe("get_transaction_by_message_hash", err)
+	return result, err
+}
+
+func observe(method string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+
+		var statusCodeError *tonapi.ErrorStatusCode
+		if errors.As(err, &statusCodeError) && statusCodeError.StatusCode == http.StatusTooManyRequests {
+			result = "rate_limited"
+		}
+	}
+
+	metrics.ChainSourceRequests.WithLabelValues(method, result).Inc()
+}
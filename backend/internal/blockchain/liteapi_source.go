@@ -0,0 +1,667 @@
+package blockchain
+
+import (
+	"backend/internal/marketplace"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/code"
+	"github.com/tonkeeper/tongo/liteapi"
+	"github.com/tonkeeper/tongo/liteclient"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/utils"
+)
+
+const liteapiTransactionsPageSize = 16
+const liteapiTraceMaxDepth = 8
+const liteapiChildSearchLimit = 64
+const liteapiMasterchainShard = 0x8000000000000000
+
+// knownGetMethods are the get-method names reported in tonapi.Account.GetMethods; liteservers
+// only expose method ids, so names are resolved against the sale methods of the marketplace
+// adapters and the methods of the raffle contracts. A custom adapter whose method is not among
+// them is not found through this source.
+var knownGetMethods = append(marketplaceGetMethods(marketplace.DefaultRegistry()),
+	"get_nft_data",
+	"get_collection_data",
+	"raffleData",
+	"raffleCandidateAddress",
+	"raffleParticipantAddress",
+	"raffleCandidateData",
+	"raffleParticipantData",
+)
+
+var ErrLiteapiUnknownTrace = errors.New("liteapi source: unknown trace id")
+
+// liteapiCursorsSize bounds the remembered page cursors, a forgotten cursor only costs a scan
+// from the last transaction of the account
+const liteapiCursorsSize = 4096
+
+type liteapiTransactionRef struct {
+	accountID ton.AccountID
+	lt        uint64
+	hash      ton.Bits256
+}
+
+// LiteapiSource serves the ChainSource through liteservers. A trace here is rooted at the
+// account transaction itself and descendants are found by following outgoing internal
+// messages, which covers the message chains the collectors look for. The trace id carries the
+// account, lt and hash of the root, so any process resolves it.
+type LiteapiSource struct {
+	client  *liteapi.Client
+	mutex   sync.Mutex
+	cursors *lruCache[string, liteapiTransactionRef]
+}
+
+func NewLiteapiSource(client *liteapi.Client) *LiteapiSource {
+	return &LiteapiSource{
+		client:  client,
+		cursors: newLRUCache[string, liteapiTransactionRef](liteapiCursorsSize),
+	}
+}
+
+func (s *LiteapiSource) GetAccountTraces(ctx context.Context, accountID string, limit int, beforeLt int64) (*tonapi.TraceIDs, error) {
+	id, err := ton.ParseAccountID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	lt, hash, err := s.startTransaction(ctx, id, beforeLt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &tonapi.TraceIDs{Traces: make([]tonapi.TraceID, 0, limit)}
+	var last *ton.Transaction
+	for lt != 0 && len(result.Traces) < limit {
+		transactions, err := s.client.GetTransactions(ctx, liteapiTransactionsPageSize, id, lt, hash)
+		if err != nil {
+			var e liteclient.LiteServerErrorC
+			if errors.As(err, &e) && int32(e.Code) == -400 {
+				// liteserver does not keep the full history
+				break
+			}
+			return nil, err
+		}
+
+		if len(transactions) == 0 {
+			break
+		}
+
+		for i := range transactions {
+			transaction := &transactions[i]
+			lt, hash = transaction.PrevTransLt, ton.Bits256(transaction.PrevTransHash)
+
+			if beforeLt > 0 && int64(transaction.Lt) >= beforeLt {
+				continue
+			}
+
+			traceID := liteapiTraceID(liteapiTransactionRef{accountID: id, lt: transaction.Lt, hash: ton.Bits256(transaction.Hash())})
+			result.Traces = append(result.Traces, tonapi.TraceID{ID: traceID, Utime: int64(transaction.Now)})
+			last = transaction
+
+			if len(result.Traces) >= limit {
+				break
+			}
+		}
+	}
+
+	if last != nil {
+		s.mutex.Lock()
+		s.cursors.put(cursorKey(id, int64(last.Lt)), liteapiTransactionRef{accountID: id, lt: last.PrevTransLt, hash: ton.Bits256(last.PrevTransHash)})
+		s.mutex.Unlock()
+	}
+
+	return result, nil
+}
+
+func (s *LiteapiSource) GetTrace(ctx context.Context, traceID string) (*tonapi.Trace, error) {
+	root, err := parseLiteapiTraceID(traceID)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.client.GetTransactions(ctx, 1, root.accountID, root.lt, root.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(transactions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrLiteapiUnknownTrace, traceID)
+	}
+
+	return s.buildTrace(ctx, root.accountID, transactions[0], 0)
+}
+
+func (s *LiteapiSource) GetAccount(ctx context.Context, accountID string) (*tonapi.Account, error) {
+	id, err := ton.ParseAccountID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.client.GetAccountState(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &tonapi.Account{
+		Address:    id.ToRaw(),
+		Status:     tonapi.AccountStatusNonexist,
+		GetMethods: make([]string, 0),
+	}
+
+	if state.Account.SumType != "Account" {
+		return account, nil
+	}
+
+	storage := state.Account.Account.Storage
+	account.Balance = int64(storage.Balance.Grams)
+	account.LastActivity = int64(storage.LastTransLt)
+
+	switch storage.State.SumType {
+	case "AccountUninit":
+		account.Status = tonapi.AccountStatusUninit
+	case "AccountFrozen":
+		account.Status = tonapi.AccountStatusFrozen
+	case "AccountActive":
+		account.Status = tonapi.AccountStatusActive
+
+		stateInit := storage.State.AccountActive.StateInit
+		if stateInit.Code.Exists {
+			account.GetMethods = resolveGetMethods(&stateInit.Code.Value.Value)
+		}
+	}
+
+	return account, nil
+}
+
+func (s *LiteapiSource) GetNftItem(ctx context.Context, accountID string) (*tonapi.NftItem, error) {
+	id, err := ton.ParseAccountID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	exitCode, stack, err := s.client.RunSmcMethod(ctx, id, "get_nft_data", tlb.VmStack{})
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 && exitCode != 1 || len(stack) < 4 {
+		return nil, fmt.Errorf("liteapi source: get_nft_data failed with exit code %d", exitCode)
+	}
+
+	item := &tonapi.NftItem{
+		Address: id.ToRaw(),
+		Index:   stackValueInt(stack[1]).Int64(),
+	}
+
+	if collectionAccountID, ok := stackValueAccountID(stack[2]); ok {
+		item.Collection = tonapi.NewOptNftItemCollection(tonapi.NftItemCollection{Address: collectionAccountID.ToRaw()})
+	}
+
+	if ownerAccountID, ok := stackValueAccountID(stack[3]); ok {
+		item.Owner = tonapi.NewOptAccountAddress(tonapi.AccountAddress{Address: ownerAccountID.ToRaw()})
+	}
+
+	return item, nil
+}
+
+func (s *LiteapiSource) ExecGetMethod(ctx context.Context, accountID string, methodName string, args ...tonapi.ExecGetMethodArg) (*tonapi.MethodExecutionResult, error) {
+	id, err := ton.ParseAccountID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(tlb.VmStack, 0, len(args))
+	for _, arg := range args {
+		value, err := convertMethodArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, value)
+	}
+
+	exitCode, stack, err := s.client.RunSmcMethod(ctx, id, methodName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := convertStack(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tonapi.MethodExecutionResult{
+		Success:  exitCode == 0 || exitCode == 1,
+		ExitCode: int(exitCode),
+		Stack:    records,
+	}, nil
+}
+
+func (s *LiteapiSource) GetMasterchainHead(ctx context.Context) (*tonapi.BlockchainBlock, error) {
+	info, err := s.client.GetMasterchainInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetMasterchainBlock(ctx, int32(info.Last.Seqno))
+}
+
+func (s *LiteapiSource) GetMasterchainBlock(ctx context.Context, seqno int32) (*tonapi.BlockchainBlock, error) {
+	blockID := ton.BlockID{Workchain: -1, Shard: liteapiMasterchainShard, Seqno: uint32(seqno)}
+	id, info, err := s.client.LookupBlock(ctx, blockID, 1, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tonapi.BlockchainBlock{
+		WorkchainID: id.Workchain,
+		Shard:       strconv.FormatUint(id.Shard, 16),
+		Seqno:       int32(id.Seqno),
+		RootHash:    id.RootHash.Hex(),
+		FileHash:    id.FileHash.Hex(),
+		GenUtime:    int64(info.GenUtime),
+		StartLt:     int64(info.StartLt),
+		EndLt:       int64(info.EndLt),
+	}, nil
+}
+
+// GetTransaction is unsupported, liteservers look transactions up by account and lt only
+func (s *LiteapiSource) GetTransaction(_ context.Context, hash string) (*tonapi.Transaction, error) {
+	return nil, fmt.Errorf("%w: transaction %s", ErrLookupUnsupported, hash)
+}
+
+// GetTransactionByMessageHash is unsupported, liteservers do not index messages
+func (s *LiteapiSource) GetTransactionByMessageHash(_ context.Context, messageHash string) (*tonapi.Transaction, error) {
+	return nil, fmt.Errorf("%w: message %s", ErrLookupUnsupported, messageHash)
+}
+
+func (s *LiteapiSource) startTransaction(ctx context.Context, id ton.AccountID, beforeLt int64) (uint64, ton.Bits256, error) {
+	if beforeLt > 0 {
+		s.mutex.Lock()
+		cursor, ok := s.cursors.get(cursorKey(id, beforeLt))
+		s.mutex.Unlock()
+
+		if ok {
+			return cursor.lt, cursor.hash, nil
+		}
+	}
+
+	state, err := s.client.GetAccountState(ctx, id)
+	if err != nil {
+		return 0, ton.Bits256{}, err
+	}
+
+	return state.LastTransLt, ton.Bits256(state.LastTransHash), nil
+}
+
+func (s *LiteapiSource) buildTrace(ctx context.Context, accountID ton.AccountID, transaction ton.Transaction, depth int) (*tonapi.Trace, error) {
+	trace := &tonapi.Trace{
+		Transaction: convertTransaction(accountID, transaction),
+		Interfaces:  make([]string, 0),
+		Children:    make([]tonapi.Trace, 0),
+	}
+
+	if depth >= liteapiTraceMaxDepth {
+		return trace, nil
+	}
+
+	for _, outMessage := range transaction.Msgs.OutMsgs.Values() {
+		message := outMessage.Value
+		if message.Info.SumType != "IntMsgInfo" {
+			continue
+		}
+
+		destinationAccountID, err := ton.AccountIDFromTlb(message.Info.IntMsgInfo.Dest)
+		if err != nil || destinationAccountID == nil {
+			continue
+		}
+
+		child, ok, err := s.findTransactionByInMessage(ctx, *destinationAccountID, message.Hash(true), message.Info.IntMsgInfo.CreatedLt)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		childTrace, err := s.buildTrace(ctx, *destinationAccountID, child, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		trace.Children = append(trace.Children, *childTrace)
+	}
+
+	return trace, nil
+}
+
+func (s *LiteapiSource) findTransactionByInMessage(ctx context.Context, accountID ton.AccountID, messageHash tlb.Bits256, createdLt uint64) (ton.Transaction, bool, error) {
+	state, err := s.client.GetAccountState(ctx, accountID)
+	if err != nil {
+		return ton.Transaction{}, false, err
+	}
+
+	lt, hash := state.LastTransLt, ton.Bits256(state.LastTransHash)
+	for searched := 0; lt > createdLt && searched < liteapiChildSearchLimit; {
+		transactions, err := s.client.GetTransactions(ctx, liteapiTransactionsPageSize, accountID, lt, hash)
+		if err != nil || len(transactions) == 0 {
+			return ton.Transaction{}, false, nil
+		}
+
+		for _, transaction := range transactions {
+			searched++
+			if transaction.Lt <= createdLt {
+				return ton.Transaction{}, false, nil
+			}
+
+			if transaction.Msgs.InMsg.Exists && transaction.Msgs.InMsg.Value.Value.Hash(true) == messageHash {
+				return transaction, true, nil
+			}
+
+			lt, hash = transaction.PrevTransLt, ton.Bits256(transaction.PrevTransHash)
+		}
+	}
+
+	return ton.Transaction{}, false, nil
+}
+
+// liteapiTraceID is the raw account, the lt and the hash of the root transaction
+func liteapiTraceID(ref liteapiTransactionRef) string {
+	return ref.accountID.ToRaw() + ":" + strconv.FormatUint(ref.lt, 10) + ":" + ref.hash.Hex()
+}
+
+func parseLiteapiTraceID(traceID string) (liteapiTransactionRef, error) {
+	parts := strings.Split(traceID, ":")
+	if len(parts) != 4 {
+		return liteapiTransactionRef{}, fmt.Errorf("%w: %s", ErrLiteapiUnknownTrace, traceID)
+	}
+
+	accountID, err := ton.ParseAccountID(parts[0] + ":" + parts[1])
+	if err != nil {
+		return liteapiTransactionRef{}, fmt.Errorf("%w: %s: %w", ErrLiteapiUnknownTrace, traceID, err)
+	}
+
+	lt, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return liteapiTransactionRef{}, fmt.Errorf("%w: %s: %w", ErrLiteapiUnknownTrace, traceID, err)
+	}
+
+	hash, err := ton.ParseHash(parts[3])
+	if err != nil {
+		return liteapiTransactionRef{}, fmt.Errorf("%w: %s: %w", ErrLiteapiUnknownTrace, traceID, err)
+	}
+
+	return liteapiTransactionRef{accountID: accountID, lt: lt, hash: hash}, nil
+}
+
+func cursorKey(accountID ton.AccountID, lt int64) string {
+	return accountID.ToRaw() + ":" + strconv.FormatInt(lt, 10)
+}
+
+func resolveGetMethods(codeCell *boc.Cell) []string {
+	methods := make([]string, 0)
+
+	codeBoc, err := codeCell.ToBoc()
+	if err != nil {
+		return methods
+	}
+
+	ids, err := code.ParseContractMethods(codeBoc)
+	if err != nil {
+		return methods
+	}
+
+	available := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		available[id] = struct{}{}
+	}
+
+	for _, name := range knownGetMethods {
+		if _, ok := available[int64(utils.MethodIdFromName(name))]; ok {
+			methods = append(methods, name)
+		}
+	}
+
+	return methods
+}
+
+func marketplaceGetMethods(marketplaces *marketplace.Registry) []string {
+	var methods []string
+	for _, adapter := range marketplaces.Adapters() {
+		if !slices.Contains(methods, adapter.Method) {
+			methods = append(methods, adapter.Method)
+		}
+	}
+
+	return methods
+}
+
+func convertTransaction(accountID ton.AccountID, transaction ton.Transaction) tonapi.Transaction {
+	result := tonapi.Transaction{
+		Hash:          transaction.Hash().Hex(),
+		Lt:            int64(transaction.Lt),
+		Account:       tonapi.AccountAddress{Address: accountID.ToRaw()},
+		Success:       transaction.IsSuccess(),
+		Utime:         int64(transaction.Now),
+		OrigStatus:    tonapi.AccountStatus(transaction.OrigStatus),
+		EndStatus:     tonapi.AccountStatus(transaction.EndStatus),
+		TotalFees:     int64(transaction.TotalFees.Grams),
+		OutMsgs:       make([]tonapi.Message, 0),
+		PrevTransHash: tonapi.NewOptString(transaction.PrevTransHash.Hex()),
+		PrevTransLt:   tonapi.NewOptInt64(int64(transaction.PrevTransLt)),
+	}
+
+	if transaction.Msgs.InMsg.Exists {
+		result.InMsg = tonapi.NewOptMessage(convertMessage(transaction.Msgs.InMsg.Value.Value))
+	}
+
+	for _, outMessage := range transaction.Msgs.OutMsgs.Values() {
+		result.OutMsgs = append(result.OutMsgs, convertMessage(outMessage.Value))
+	}
+
+	return result
+}
+
+func convertMessage(message tlb.Message) tonapi.Message {
+	result := tonapi.Message{
+		Hash: message.Hash(true).Hex(),
+	}
+
+	switch message.Info.SumType {
+	case "IntMsgInfo":
+		info := message.Info.IntMsgInfo
+		result.MsgType = tonapi.MessageMsgTypeIntMsg
+		result.IhrDisabled = info.IhrDisabled
+		result.Bounce = info.Bounce
+		result.Bounced = info.Bounced
+		result.Value = int64(info.Value.Grams)
+		result.FwdFee = int64(info.FwdFee)
+		result.CreatedLt = int64(info.CreatedLt)
+		result.CreatedAt = int64(info.CreatedAt)
+		result.Source = convertMessageAddress(info.Src)
+		result.Destination = convertMessageAddress(info.Dest)
+	case "ExtInMsgInfo":
+		result.MsgType = tonapi.MessageMsgTypeExtInMsg
+		result.Destination = convertMessageAddress(message.Info.ExtInMsgInfo.Dest)
+	case "ExtOutMsgInfo":
+		info := message.Info.ExtOutMsgInfo
+		result.MsgType = tonapi.MessageMsgTypeExtOutMsg
+		result.CreatedLt = int64(info.CreatedLt)
+		result.CreatedAt = int64(info.CreatedAt)
+		result.Source = convertMessageAddress(info.Src)
+	}
+
+	body := boc.Cell(message.Body.Value)
+	body.ResetCounters()
+
+	if body.BitsAvailableForRead() >= 32 {
+		opCode, err := body.ReadUint(32)
+		if err == nil {
+			result.OpCode = tonapi.NewOptString(fmt.Sprintf("0x%08x", opCode))
+		}
+		body.ResetCounters()
+	}
+
+	rawBody, err := body.ToBoc()
+	if err == nil {
+		result.RawBody = tonapi.NewOptString(hex.EncodeToString(rawBody))
+	}
+
+	return result
+}
+
+func convertMessageAddress(address tlb.MsgAddress) tonapi.OptAccountAddress {
+	accountID, err := ton.AccountIDFromTlb(address)
+	if err != nil || accountID == nil {
+		return tonapi.OptAccountAddress{}
+	}
+
+	return tonapi.NewOptAccountAddress(tonapi.AccountAddress{Address: accountID.ToRaw()})
+}
+
+func convertMethodArg(arg tonapi.ExecGetMethodArg) (tlb.VmStackValue, error) {
+	switch arg.Type {
+	case tonapi.ExecGetMethodArgTypeNull:
+		return tlb.VmStackValue{SumType: "VmStkNull"}, nil
+	case tonapi.ExecGetMethodArgTypeNan:
+		return tlb.VmStackValue{SumType: "VmStkNan"}, nil
+	case tonapi.ExecGetMethodArgTypeTinyint:
+		value, err := strconv.ParseInt(arg.Value, 0, 64)
+		if err != nil {
+			return tlb.VmStackValue{}, err
+		}
+		return tlb.VmStackValue{SumType: "VmStkTinyInt", VmStkTinyInt: value}, nil
+	case tonapi.ExecGetMethodArgTypeInt257:
+		value, ok := new(big.Int).SetString(arg.Value, 0)
+		if !ok {
+			return tlb.VmStackValue{}, fmt.Errorf("liteapi source: invalid int257 argument %q", arg.Value)
+		}
+		return tlb.VmStackValue{SumType: "VmStkInt", VmStkInt: tlb.Int257(*value)}, nil
+	case tonapi.ExecGetMethodArgTypeSlice:
+		accountID, err := ton.ParseAccountID(arg.Value)
+		if err != nil {
+			return tlb.VmStackValue{}, err
+		}
+		return tlb.TlbStructToVmCellSlice(accountID.ToMsgAddress())
+	case tonapi.ExecGetMethodArgTypeSliceBocHex:
+		cells, err := boc.DeserializeBocHex(arg.Value)
+		if err != nil {
+			return tlb.VmStackValue{}, err
+		}
+		return tlb.CellToVmCellSlice(cells[0])
+	case tonapi.ExecGetMethodArgTypeCellBocBase64:
+		cells, err := boc.DeserializeBocBase64(arg.Value)
+		if err != nil {
+			return tlb.VmStackValue{}, err
+		}
+		return tlb.VmStackValue{SumType: "VmStkCell", VmStkCell: tlb.Ref[boc.Cell]{Value: *cells[0]}}, nil
+	}
+
+	return tlb.VmStackValue{}, fmt.Errorf("liteapi source: unsupported argument type %q", arg.Type)
+}
+
+func convertStack(stack tlb.VmStack) ([]tonapi.TvmStackRecord, error) {
+	records := make([]tonapi.TvmStackRecord, 0, len(stack))
+	for _, value := range stack {
+		record, err := convertStackValue(value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func convertStackValue(value tlb.VmStackValue) (tonapi.TvmStackRecord, error) {
+	switch value.SumType {
+	case "VmStkNull":
+		return tonapi.TvmStackRecord{Type: tonapi.TvmStackRecordTypeNull}, nil
+	case "VmStkNan":
+		return tonapi.TvmStackRecord{Type: tonapi.TvmStackRecordTypeNan}, nil
+	case "VmStkTinyInt", "VmStkInt":
+		return tonapi.TvmStackRecord{Type: tonapi.TvmStackRecordTypeNum, Num: tonapi.NewOptString(formatNum(stackValueInt(value)))}, nil
+	case "VmStkCell", "VmStkSlice":
+		cell := &value.VmStkCell.Value
+		if value.SumType == "VmStkSlice" {
+			cell = value.VmStkSlice.Cell()
+		}
+
+		cellBoc, err := cell.ToBoc()
+		if err != nil {
+			return tonapi.TvmStackRecord{}, err
+		}
+		return tonapi.TvmStackRecord{Type: tonapi.TvmStackRecordTypeCell, Cell: tonapi.NewOptString(hex.EncodeToString(cellBoc))}, nil
+	case "VmStkTuple":
+		records, err := convertStack(flattenTuple(value.VmStkTuple.Data, int(value.VmStkTuple.Len)))
+		if err != nil {
+			return tonapi.TvmStackRecord{}, err
+		}
+		return tonapi.TvmStackRecord{Type: tonapi.TvmStackRecordTypeTuple, Tuple: records}, nil
+	}
+
+	return tonapi.TvmStackRecord{}, fmt.Errorf("liteapi source: unsupported stack value %q", value.SumType)
+}
+
+func stackValueInt(value tlb.VmStackValue) *big.Int {
+	if value.SumType == "VmStkTinyInt" {
+		return big.NewInt(value.VmStkTinyInt)
+	}
+
+	number := big.Int(value.VmStkInt)
+	return &number
+}
+
+func flattenTuple(tuple *tlb.VmTuple, length int) tlb.VmStack {
+	if tuple == nil || length == 0 {
+		return tlb.VmStack{}
+	}
+
+	var head tlb.VmStack
+	switch {
+	case length == 2 && tuple.Head.Entry != nil:
+		head = tlb.VmStack{*tuple.Head.Entry}
+	case length > 2:
+		head = flattenTuple(tuple.Head.Ref, length-1)
+	}
+
+	return append(head, tuple.Tail)
+}
+
+func formatNum(number *big.Int) string {
+	if number.Sign() < 0 {
+		return "-0x" + new(big.Int).Neg(number).Text(16)
+	}
+
+	return "0x" + number.Text(16)
+}
+
+func stackValueAccountID(value tlb.VmStackValue) (*ton.AccountID, bool) {
+	if value.SumType != "VmStkSlice" {
+		return nil, false
+	}
+
+	var address tlb.MsgAddress
+	if err := value.VmStkSlice.UnmarshalToTlbStruct(&address); err != nil {
+		return nil, false
+	}
+
+	accountID, err := ton.AccountIDFromTlb(address)
+	if err != nil || accountID == nil {
+		return nil, false
+	}
+
+	return accountID, true
+}
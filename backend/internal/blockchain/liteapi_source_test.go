@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestLiteapiTraceIDRoundTrip(t *testing.T) {
+	ref := liteapiTransactionRef{
+		accountID: ton.MustParseAccountID("-1:1111111111111111111111111111111111111111111111111111111111111111"),
+		lt:        42,
+		hash:      ton.Bits256{1, 2, 3},
+	}
+
+	// the id resolves without the source that issued it
+	parsed, err := parseLiteapiTraceID(liteapiTraceID(ref))
+	if err != nil || parsed != ref {
+		t.Errorf("expected %+v, got %+v (%v)", ref, parsed, err)
+	}
+
+	if _, err := parseLiteapiTraceID(ref.hash.Hex()); !errors.Is(err, ErrLiteapiUnknownTrace) {
+		t.Errorf("expected an unknown trace error for a bare hash, got %v", err)
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRUCache[string, int](2)
+	cache.put("a", 1)
+	cache.put("b", 2)
+
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	cache.put("c", 3)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+
+	if value, ok := cache.get("a"); !ok || value != 1 {
+		t.Errorf("expected a to stay cached, got %d (%v)", value, ok)
+	}
+
+	if cache.len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.len())
+	}
+}
@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"backend/internal/marketplace"
+	"context"
+	"fmt"
+)
+
+// Lookups are the reads of chain facts that cannot change once final, a caching source serves
+// them from storage after the first read.
+type Lookups interface {
+	// GetItemCollection returns the raw address of the collection of an NFT item, empty when the
+	// item belongs to none
+	GetItemCollection(ctx context.Context, itemAddress string) (string, error)
+	// GetCompletedSale reads a sale contract that already transferred its item, with the first
+	// marketplace adapter that understands it
+	GetCompletedSale(ctx context.Context, saleAddress string, marketplaces *marketplace.Registry) (*marketplace.Sale, error)
+}
+
+// DirectLookups reads the facts from the source on every call
+type DirectLookups struct {
+	source ChainSource
+}
+
+func NewDirectLookups(source ChainSource) *DirectLookups {
+	return &DirectLookups{
+		source: source,
+	}
+}
+
+func (l *DirectLookups) GetItemCollection(ctx context.Context, itemAddress string) (string, error) {
+	item, err := l.source.GetNftItem(ctx, itemAddress)
+	if err != nil {
+		return "", err
+	}
+
+	collection, ok := item.GetCollection().Get()
+	if !ok {
+		return "", nil
+	}
+
+	return collection.Address, nil
+}
+
+func (l *DirectLookups) GetCompletedSale(ctx context.Context, saleAddress string, marketplaces *marketplace.Registry) (*marketplace.Sale, error) {
+	account, err := l.source.GetAccount(ctx, saleAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := marketplaces.Methods(account.GetMethods)
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("%w: no sale data method", marketplace.ErrUnknownSale)
+	}
+
+	// a method failing to execute leaves the other methods a chance, its error is reported
+	// only when none of them decodes
+	err = fmt.Errorf("%w: %v", marketplace.ErrUnknownSale, methods)
+	for _, method := range methods {
+		result, methodErr := l.source.ExecGetMethod(ctx, saleAddress, method)
+		if methodErr != nil {
+			err = methodErr
+			continue
+		}
+
+		sale, decodeErr := marketplaces.Decode(method, result.GetStack())
+		if decodeErr != nil {
+			continue
+		}
+
+		return sale, nil
+	}
+
+	return nil, err
+}
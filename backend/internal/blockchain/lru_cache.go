@@ -0,0 +1,53 @@
+package blockchain
+
+import "container/list"
+
+// lruCache keeps the size most recently used entries, it is not safe for concurrent use
+type lruCache[K comparable, V any] struct {
+	size    int
+	order   *list.List
+	entries map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](size int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:    max(size, 1),
+		order:   list.New(),
+		entries: make(map[K]*list.Element),
+	}
+}
+
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *lruCache[K, V]) put(key K, value V) {
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (c *lruCache[K, V]) len() int {
+	return c.order.Len()
+}
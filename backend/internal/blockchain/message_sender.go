@@ -0,0 +1,78 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tonkeeper/tongo/liteapi"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/wallet"
+)
+
+// ErrNotSent wraps the send failures of messages that provably never left the wallet: the
+// external message failed before it was broadcast, or it expired unprocessed. The messages
+// of any other failure may still be delivered.
+var ErrNotSent = errors.New("message sender: not sent")
+
+func notSent(err error) error {
+	return fmt.Errorf("%w: %w", ErrNotSent, err)
+}
+
+// MessageSender is the write side used by the tracker.
+type MessageSender interface {
+	Send(ctx context.Context, waitingConfirmation time.Duration, messages ...wallet.Sendable) (*SendResult, error)
+	GetAddress() ton.AccountID
+	// MaxMessages is the number of messages a single Send accepts
+	MaxMessages() int
+}
+
+// SendResult identifies the external message: by seqno for regular wallets, by query id for
+// highload ones.
+type SendResult struct {
+	Seqno       uint32
+	QueryID     uint64
+	MessageHash ton.Bits256
+}
+
+// WalletSender lets several trackers share one wallet, a send waits for the previous one so
+// they never race on the wallet seqno.
+type WalletSender struct {
+	mutex  sync.Mutex
+	wallet *wallet.Wallet
+	client *liteapi.Client
+}
+
+func NewWalletSender(wallet *wallet.Wallet, client *liteapi.Client) *WalletSender {
+	return &WalletSender{
+		wallet: wallet,
+		client: client,
+	}
+}
+
+func (s *WalletSender) Send(ctx context.Context, waitingConfirmation time.Duration, messages ...wallet.Sendable) (*SendResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seqno, err := s.client.GetSeqno(ctx, s.wallet.GetAddress())
+	if err != nil {
+		return nil, notSent(err)
+	}
+
+	messageHash, err := s.wallet.SendV2(ctx, waitingConfirmation, messages...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SendResult{Seqno: seqno, MessageHash: messageHash}, nil
+}
+
+func (s *WalletSender) GetAddress() ton.AccountID {
+	return s.wallet.GetAddress()
+}
+
+func (s *WalletSender) MaxMessages() int {
+	return 1
+}
@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+)
+
+// The raffle contract messages, laid out like onchain/contracts/messages.tolk. Every type is a
+// whole message body, its Magic is the op code.
+const (
+	RaffleRegisterCandidateOpCode                   = 0x13370010
+	RaffleSetConditionsOpCode                       = 0x13370011
+	RaffleApproveOpCode                             = 0x13370012
+	RaffleNextOpCode                                = 0x13370013
+	RaffleCandidateInitializeOpCode                 = 0x13370020
+	RaffleCandidateRegistrationNotificationOpCode   = 0x13370021
+	RaffleCandidateSetConditionsOpCode              = 0x13370022
+	RaffleCandidateSetParticipantIndexOpCode        = 0x13370023
+	RaffleParticipantInitializeOpCode               = 0x13370030
+	RaffleParticipantRegistrationNotificationOpCode = 0x13370031
+	RaffleParticipantWinnerNotificationOpCode       = 0x13370031
+)
+
+var errEmptyBody = errors.New("empty message body")
+
+// RaffleRegisterCandidate (0x13370010) is sent by a user to the raffle to deploy its candidate contract
+type RaffleRegisterCandidate struct {
+	Magic      tlb.Magic `tlb:"#13370010"`
+	TelegramID uint64
+}
+
+// RaffleSetConditions (0x13370011) is sent by the oracle to the raffle with the conditions of a user
+type RaffleSetConditions struct {
+	Magic       tlb.Magic `tlb:"#13370011"`
+	UserAddress tlb.MsgAddress
+	Conditions  tlb.Bits256
+}
+
+// RaffleApprove (0x13370012) is sent by a candidate contract to the raffle once its conditions match
+type RaffleApprove struct {
+	Magic            tlb.Magic `tlb:"#13370012"`
+	RecipientAddress tlb.MsgAddress
+	UserAddress      tlb.MsgAddress
+}
+
+// RaffleNext (0x13370013) is sent by the oracle to the raffle to draw the next winner, the
+// forward amount and payload are sent on to its wallet
+type RaffleNext struct {
+	Magic          tlb.Magic `tlb:"#13370013"`
+	ForwardAmount  tlb.Grams
+	ForwardPayload tlb.Text
+}
+
+// RaffleCandidateInitialize (0x13370020) is sent by the raffle to deploy the candidate contract of a user
+type RaffleCandidateInitialize struct {
+	Magic            tlb.Magic `tlb:"#13370020"`
+	RecipientAddress tlb.MsgAddress
+	TelegramID       uint64
+}
+
+// RaffleCandidateRegistrationNotification (0x13370021) is sent by a deployed candidate contract to the raffle
+type RaffleCandidateRegistrationNotification struct {
+	Magic            tlb.Magic `tlb:"#13370021"`
+	RecipientAddress tlb.MsgAddress
+}
+
+// RaffleCandidateSetConditions (0x13370022) is sent by the raffle to a candidate contract with the oracle conditions
+type RaffleCandidateSetConditions struct {
+	Magic            tlb.Magic `tlb:"#13370022"`
+	RecipientAddress tlb.MsgAddress
+	Conditions       tlb.Bits256
+	IsMatched        bool
+}
+
+// RaffleCandidateSetParticipantIndex (0x13370023) is sent by the raffle to an approved candidate contract
+type RaffleCandidateSetParticipantIndex struct {
+	Magic            tlb.Magic `tlb:"#13370023"`
+	ParticipantIndex uint64
+}
+
+// RaffleParticipantInitialize (0x13370030) is sent by the raffle to deploy the participant contract of an approved user
+type RaffleParticipantInitialize struct {
+	Magic            tlb.Magic `tlb:"#13370030"`
+	RecipientAddress tlb.MsgAddress
+	UserAddress      tlb.MsgAddress
+}
+
+// RaffleParticipantRegistrationNotification (0x13370031) shares its op code with the winner notification,
+// the contracts never send it
+type RaffleParticipantRegistrationNotification struct {
+	Magic            tlb.Magic `tlb:"#13370031"`
+	RecipientAddress tlb.MsgAddress
+}
+
+// RaffleParticipantWinnerNotification (0x13370031) is sent by the raffle to the participant contract of a drawn winner
+type RaffleParticipantWinnerNotification struct {
+	Magic          tlb.Magic `tlb:"#13370031"`
+	WinnerIndex    uint8
+	ForwardAmount  tlb.Grams
+	ForwardPayload tlb.Text
+}
+
+// OpCode formats an op code like tonapi reports the op code of a message
+func OpCode(opCode uint32) string {
+	return fmt.Sprintf("0x%08x", opCode)
+}
+
+// MarshalBody builds the body cell of a message
+func MarshalBody(message any) (*boc.Cell, error) {
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, message); err != nil {
+		return nil, err
+	}
+
+	return cell, nil
+}
+
+// UnmarshalBody decodes the hex BOC of a message body, it fails when the op code differs
+func UnmarshalBody(rawBody string, message any) error {
+	cells, err := boc.DeserializeBocHex(rawBody)
+	if err != nil {
+		return err
+	}
+
+	if len(cells) == 0 {
+		return errEmptyBody
+	}
+
+	return tlb.Unmarshal(cells[0], message)
+}
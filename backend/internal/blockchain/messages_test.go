@@ -0,0 +1,189 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+var (
+	testOracleAddress = ton.MustParseAccountID("0:1212121212121212121212121212121212121212121212121212121212121212")
+	testUserAddress   = ton.MustParseAccountID("0:3131313131313131313131313131313131313131313131313131313131313131")
+)
+
+// testConditions is one white and one black ticket in the default conditions layout
+var testConditions = tlb.Bits256{1, 1}
+
+// TestMessagesRoundTrip decodes bodies laid out by hand after the contract sources and encodes
+// the decoded message back into the same BOC
+func TestMessagesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		decoded  any
+		expected any
+	}{
+		{
+			name:     "approve",
+			body:     "b5ee9c7201010101004900008d133700128002424242424242424242424242424242424242424242424242424242424242425000c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c6",
+			decoded:  &RaffleApprove{},
+			expected: &RaffleApprove{Magic: RaffleApproveOpCode, RecipientAddress: testOracleAddress.ToMsgAddress(), UserAddress: testUserAddress.ToMsgAddress()},
+		},
+		{
+			name:     "candidate initialize",
+			body:     "b5ee9c7201010101003000005b13370020800626262626262626262626262626262626262626262626262626262626262626200000000000007d30",
+			decoded:  &RaffleCandidateInitialize{},
+			expected: &RaffleCandidateInitialize{Magic: RaffleCandidateInitializeOpCode, RecipientAddress: testUserAddress.ToMsgAddress(), TelegramID: 1001},
+		},
+		{
+			name:     "candidate registration notification",
+			body:     "b5ee9c7201010101002800004b1337002180062626262626262626262626262626262626262626262626262626262626262630",
+			decoded:  &RaffleCandidateRegistrationNotification{},
+			expected: &RaffleCandidateRegistrationNotification{Magic: RaffleCandidateRegistrationNotificationOpCode, RecipientAddress: testUserAddress.ToMsgAddress()},
+		},
+		{
+			name:     "candidate set conditions",
+			body:     "b5ee9c7201010101004800008b13370022800242424242424242424242424242424242424242424242424242424242424242402020000000000000000000000000000000000000000000000000000000000018",
+			decoded:  &RaffleCandidateSetConditions{},
+			expected: &RaffleCandidateSetConditions{Magic: RaffleCandidateSetConditionsOpCode, RecipientAddress: testOracleAddress.ToMsgAddress(), Conditions: testConditions, IsMatched: true},
+		},
+		{
+			name:     "candidate set participant index",
+			body:     "b5ee9c7201010101000e000018133700230000000000000007",
+			decoded:  &RaffleCandidateSetParticipantIndex{},
+			expected: &RaffleCandidateSetParticipantIndex{Magic: RaffleCandidateSetParticipantIndexOpCode, ParticipantIndex: 7},
+		},
+		{
+			name:     "participant initialize",
+			body:     "b5ee9c7201010101004900008d133700308002424242424242424242424242424242424242424242424242424242424242425000c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c4c6",
+			decoded:  &RaffleParticipantInitialize{},
+			expected: &RaffleParticipantInitialize{Magic: RaffleParticipantInitializeOpCode, RecipientAddress: testOracleAddress.ToMsgAddress(), UserAddress: testUserAddress.ToMsgAddress()},
+		},
+		{
+			name:     "participant registration notification",
+			body:     "b5ee9c7201010101002800004b1337003180062626262626262626262626262626262626262626262626262626262626262630",
+			decoded:  &RaffleParticipantRegistrationNotification{},
+			expected: &RaffleParticipantRegistrationNotification{Magic: RaffleParticipantRegistrationNotificationOpCode, RecipientAddress: testUserAddress.ToMsgAddress()},
+		},
+		{
+			name:     "participant winner notification",
+			body:     "b5ee9c7201010101000f000019133700310216477696e6e65728",
+			decoded:  &RaffleParticipantWinnerNotification{},
+			expected: &RaffleParticipantWinnerNotification{Magic: RaffleParticipantWinnerNotificationOpCode, WinnerIndex: 2, ForwardAmount: 100, ForwardPayload: "winner"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertRoundTrip(t, test.body, test.decoded, test.expected)
+		})
+	}
+}
+
+// TestWrapperMessagesRoundTrip checks the codecs against the bodies the Raffle wrapper sends.
+// testdata/wrapper_messages.json names its origin: onchain/scripts/messageBodies.ts writes it,
+// the committed copy was encoded by hand until the script is run.
+func TestWrapperMessagesRoundTrip(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "wrapper_messages.json"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	var fixture struct {
+		Origin   string            `json:"origin"`
+		Messages map[string]string `json:"messages"`
+	}
+	if err := json.Unmarshal(content, &fixture); err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		decoded  any
+		expected any
+	}{
+		{
+			name:     "register candidate",
+			decoded:  &RaffleRegisterCandidate{},
+			expected: &RaffleRegisterCandidate{Magic: RaffleRegisterCandidateOpCode, TelegramID: 1001},
+		},
+		// white 1 in the first slot and black 2 in the second, as sendConditions writes them
+		{
+			name:     "set conditions",
+			decoded:  &RaffleSetConditions{},
+			expected: &RaffleSetConditions{Magic: RaffleSetConditionsOpCode, UserAddress: testUserAddress.ToMsgAddress(), Conditions: tlb.Bits256{1, 2}},
+		},
+		{
+			name:     "next",
+			decoded:  &RaffleNext{},
+			expected: &RaffleNext{Magic: RaffleNextOpCode},
+		},
+		{
+			name:     "next with a forward payload",
+			decoded:  &RaffleNext{},
+			expected: &RaffleNext{Magic: RaffleNextOpCode, ForwardAmount: 1_000_000_000, ForwardPayload: "congratulations"},
+		},
+	}
+
+	if fixture.Origin == "" {
+		t.Error("the wrapper messages do not name their origin")
+	}
+
+	if len(fixture.Messages) != len(tests) {
+		t.Errorf("expected %d wrapper messages, got %d", len(tests), len(fixture.Messages))
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, ok := fixture.Messages[test.name]
+			if !ok {
+				t.Fatalf("no wrapper message %q", test.name)
+			}
+
+			assertRoundTrip(t, body, test.decoded, test.expected)
+		})
+	}
+}
+
+func assertRoundTrip(t *testing.T, rawBody string, decoded any, expected any) {
+	t.Helper()
+
+	if err := UnmarshalBody(rawBody, decoded); err != nil {
+		t.Fatalf("unmarshal body: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %+v, got %+v", expected, decoded)
+	}
+
+	cell, err := MarshalBody(expected)
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+
+	body, err := cell.ToBocString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body != rawBody {
+		t.Errorf("expected body %s, got %s", rawBody, body)
+	}
+}
+
+func TestUnmarshalBodyChecksOpCode(t *testing.T) {
+	// a set conditions body is not a candidate set conditions body
+	body := "b5ee9c7201010101004800008b13370011800626262626262626262626262626262626262626262626262626262626262626202020000000000000000000000000000000000000000000000000000000000010"
+	if err := UnmarshalBody(body, &RaffleCandidateSetConditions{}); err == nil {
+		t.Error("expected an op code mismatch")
+	}
+
+	if OpCode(RaffleNextOpCode) != "0x13370013" {
+		t.Errorf("unexpected op code format %s", OpCode(RaffleNextOpCode))
+	}
+}
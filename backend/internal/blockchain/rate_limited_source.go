@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"context"
+
+	"github.com/tonkeeper/tonapi-go"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedSource spends a token of a shared bucket before every call of the wrapped source,
+// so that concurrent collectors and raffles stay within one request budget.
+type RateLimitedSource struct {
+	source  ChainSource
+	limiter *rate.Limiter
+}
+
+// NewRateLimitedSource allows requestsPerSecond calls with bursts of burst calls, a zero
+// requestsPerSecond leaves the calls unlimited
+func NewRateLimitedSource(source ChainSource, requestsPerSecond int, burst int) *RateLimitedSource {
+	limit := rate.Inf
+	if requestsPerSecond > 0 {
+		limit = rate.Limit(requestsPerSecond)
+	}
+
+	return &RateLimitedSource{
+		source:  source,
+		limiter: rate.NewLimiter(limit, max(burst, 1)),
+	}
+}
+
+func (s *RateLimitedSource) GetAccountTraces(ctx context.Context, accountID string, limit int, beforeLt int64) (*tonapi.TraceIDs, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetAccountTraces(ctx, accountID, limit, beforeLt)
+}
+
+func (s *RateLimitedSource) GetTrace(ctx context.Context, traceID string) (*tonapi.Trace, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetTrace(ctx, traceID)
+}
+
+func (s *RateLimitedSource) GetAccount(ctx context.Context, accountID string) (*tonapi.Account, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetAccount(ctx, accountID)
+}
+
+func (s *RateLimitedSource) GetNftItem(ctx context.Context, accountID string) (*tonapi.NftItem, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetNftItem(ctx, accountID)
+}
+
+func (s *RateLimitedSource) ExecGetMethod(ctx context.Context, accountID string, methodName string, args ...tonapi.ExecGetMethodArg) (*tonapi.MethodExecutionResult, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.ExecGetMethod(ctx, accountID, methodName, args...)
+}
+
+func (s *RateLimitedSource) GetMasterchainHead(ctx context.Context) (*tonapi.BlockchainBlock, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetMasterchainHead(ctx)
+}
+
+func (s *RateLimitedSource) GetMasterchainBlock(ctx context.Context, seqno int32) (*tonapi.BlockchainBlock, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetMasterchainBlock(ctx, seqno)
+}
+
+func (s *RateLimitedSource) GetTransaction(ctx context.Context, hash string) (*tonapi.Transaction, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetTransaction(ctx, hash)
+}
+
+func (s *RateLimitedSource) GetTransactionByMessageHash(ctx context.Context, messageHash string) (*tonapi.Transaction, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.source.GetTransactionByMessageHash(ctx, messageHash)
+}
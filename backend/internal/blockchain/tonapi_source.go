@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tonkeeper/tonapi-go"
+)
+
+type TonapiSource struct {
+	client *tonapi.Client
+}
+
+func NewTonapiSource(client *tonapi.Client) *TonapiSource {
+	return &TonapiSource{
+		client: client,
+	}
+}
+
+func (s *TonapiSource) GetAccountTraces(ctx context.Context, accountID string, limit int, beforeLt int64) (*tonapi.TraceIDs, error) {
+	return s.client.GetAccountTraces(ctx, tonapi.GetAccountTracesParams{
+		AccountID: accountID,
+		Limit:     tonapi.NewOptInt(limit),
+		BeforeLt: tonapi.OptInt64{
+			Value: beforeLt,
+			Set:   beforeLt > 0,
+		},
+	})
+}
+
+func (s *TonapiSource) GetTrace(ctx context.Context, traceID string) (*tonapi.Trace, error) {
+	return s.client.GetTrace(ctx, tonapi.GetTraceParams{TraceID: traceID})
+}
+
+func (s *TonapiSource) GetAccount(ctx context.Context, accountID string) (*tonapi.Account, error) {
+	return s.client.GetAccount(ctx, tonapi.GetAccountParams{AccountID: accountID})
+}
+
+func (s *TonapiSource) GetNftItem(ctx context.Context, accountID string) (*tonapi.NftItem, error) {
+	return s.client.GetNftItemByAddress(ctx, tonapi.GetNftItemByAddressParams{AccountID: accountID})
+}
+
+func (s *TonapiSource) ExecGetMethod(ctx context.Context, accountID string, methodName string, args ...tonapi.ExecGetMethodArg) (*tonapi.MethodExecutionResult, error) {
+	if len(args) == 0 {
+		return s.client.ExecGetMethodForBlockchainAccount(ctx, tonapi.ExecGetMethodForBlockchainAccountParams{
+			AccountID:  accountID,
+			MethodName: methodName,
+			Args:       make([]string, 0),
+		})
+	}
+
+	return s.client.ExecGetMethodWithBodyForBlockchainAccount(ctx,
+		tonapi.OptExecGetMethodWithBodyForBlockchainAccountReq{
+			Value: tonapi.ExecGetMethodWithBodyForBlockchainAccountReq{Args: args},
+			Set:   true,
+		},
+		tonapi.ExecGetMethodWithBodyForBlockchainAccountParams{
+			AccountID:  accountID,
+			MethodName: methodName,
+		},
+	)
+}
+
+func (s *TonapiSource) GetMasterchainHead(ctx context.Context) (*tonapi.BlockchainBlock, error) {
+	return s.client.GetBlockchainMasterchainHead(ctx)
+}
+
+func (s *TonapiSource) GetMasterchainBlock(ctx context.Context, seqno int32) (*tonapi.BlockchainBlock, error) {
+	return s.client.GetBlockchainBlock(ctx, tonapi.GetBlockchainBlockParams{
+		BlockID: fmt.Sprintf("(-1,8000000000000000,%d)", seqno),
+	})
+}
+
+func (s *TonapiSource) GetTransaction(ctx context.Context, hash string) (*tonapi.Transaction, error) {
+	return s.client.GetBlockchainTransaction(ctx, tonapi.GetBlockchainTransactionParams{TransactionID: hash})
+}
+
+func (s *TonapiSource) GetTransactionByMessageHash(ctx context.Context, messageHash string) (*tonapi.Transaction, error) {
+	return s.client.GetBlockchainTransactionByMessageHash(ctx, tonapi.GetBlockchainTransactionByMessageHashParams{MsgID: messageHash})
+}
@@ -1,8 +1,4 @@
-package tracker
-
-const MarketplaceAddressRaw = "0:584ee61b2dff0837116d0fcb5078d93964bcbe9c05fd6a141b1bfca5d6a43e18"
-const GlobalDeployedTimeout = 300
-const GlobalLimitWindowSize = 50
+package blockchain
 
 var WalletMap = map[string]int{
 	"V1R1":         0,
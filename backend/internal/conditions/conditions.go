@@ -0,0 +1,268 @@
+package conditions
+
+import (
+	"backend/internal/storage"
+	"errors"
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// PayloadBits is the size of the conditions field of the raffle contracts
+const PayloadBits = 256
+
+// DefaultBits is the width of a slot that does not declare one
+const DefaultBits = 8
+
+type Kind = string
+
+// Indexing is how the purchases of a purchase slot are found
+type Indexing = string
+
+const (
+	// WalletIndexing walks the wallet history of every candidate
+	WalletIndexing Indexing = "wallet"
+	// CollectionIndexing walks the transfer history of every item of the collection, the
+	// calls grow with the collection instead of the candidates
+	CollectionIndexing Indexing = "collection"
+)
+
+// ItemCounting is how often an item counts for a purchase slot
+type ItemCounting = string
+
+const (
+	// PerUserItemCounting counts an item once for every user who bought it
+	PerUserItemCounting ItemCounting = "per_user"
+	// PerRaffleItemCounting counts an item once for the raffle, for its first buyer
+	PerRaffleItemCounting ItemCounting = "per_raffle"
+)
+
+const (
+	// MintKind counts the items of a collection minted to the user
+	MintKind Kind = "mint"
+	// PurchaseKind counts the items of a collection the user bought on a marketplace
+	PurchaseKind Kind = "purchase"
+	// HoldKind counts the items of a collection the user holds at the snapshot time
+	HoldKind Kind = "hold"
+)
+
+// Slot is one field of the conditions payload. The actions counted for a slot are stored
+// under its name, renaming a slot starts its count over.
+type Slot struct {
+	Name       string
+	Kind       Kind
+	Collection string
+	// Marketplaces are the marketplaces the purchases of a purchase slot may be made on
+	Marketplaces []string
+	// Indexing of a purchase slot, the candidate wallets when empty
+	Indexing Indexing
+	// ItemCounting of a purchase slot, once per user when empty
+	ItemCounting ItemCounting
+	// Quantity is the expected target, zero takes whatever the raffle declares
+	Quantity uint64
+	Bits     int
+	// SnapshotUnixTime is when the holdings of a hold slot are counted
+	SnapshotUnixTime int64
+}
+
+// Rules is the ordered list of slots a raffle encodes into its conditions, the first slot
+// takes the most significant bits and the rest of the payload is zero.
+type Rules struct {
+	Slots []Slot
+}
+
+// DefaultSlots are the conditions of the original raffles: white tickets minted, then black
+// tickets bought on the marketplace.
+func DefaultSlots(whiteTicketCollection string, blackTicketCollection string, marketplace string) []Slot {
+	return []Slot{
+		{Name: storage.WhiteTicketMintedActionType, Kind: MintKind, Collection: whiteTicketCollection, Bits: DefaultBits},
+		{Name: storage.BlackTicketPurchasedActionType, Kind: PurchaseKind, Collection: blackTicketCollection, Marketplaces: []string{marketplace}, Bits: DefaultBits},
+	}
+}
+
+// NewRules validates the slots, addresses are normalized to their raw form
+func NewRules(slots []Slot) (Rules, error) {
+	if len(slots) == 0 {
+		return Rules{}, errors.New("conditions: no slots")
+	}
+
+	rules := Rules{Slots: make([]Slot, len(slots))}
+	names := make(map[string]bool)
+	bits := 0
+	for i, slot := range slots {
+		if slot.Name == "" {
+			return Rules{}, fmt.Errorf("conditions: slot %d has no name", i)
+		}
+		if names[slot.Name] {
+			return Rules{}, fmt.Errorf("conditions: slot %s is declared twice", slot.Name)
+		}
+		names[slot.Name] = true
+
+		if slot.Bits == 0 {
+			slot.Bits = DefaultBits
+		}
+		if slot.Bits < 1 || slot.Bits > 64 {
+			return Rules{}, fmt.Errorf("conditions: slot %s must take 1..64 bits", slot.Name)
+		}
+		bits += slot.Bits
+
+		if slot.Quantity > slot.max() {
+			return Rules{}, fmt.Errorf("conditions: slot %s quantity %d does not fit %d bits", slot.Name, slot.Quantity, slot.Bits)
+		}
+
+		collection, err := ton.ParseAccountID(slot.Collection)
+		if err != nil {
+			return Rules{}, fmt.Errorf("conditions: slot %s: invalid collection %q", slot.Name, slot.Collection)
+		}
+		slot.Collection = collection.ToRaw()
+
+		switch slot.Kind {
+		case MintKind:
+		case PurchaseKind:
+			if len(slot.Marketplaces) == 0 {
+				return Rules{}, fmt.Errorf("conditions: purchase slot %s needs a marketplace", slot.Name)
+			}
+
+			marketplaces := make([]string, len(slot.Marketplaces))
+			for j, address := range slot.Marketplaces {
+				marketplace, err := ton.ParseAccountID(address)
+				if err != nil {
+					return Rules{}, fmt.Errorf("conditions: slot %s: invalid marketplace %q", slot.Name, address)
+				}
+				marketplaces[j] = marketplace.ToRaw()
+			}
+			slot.Marketplaces = marketplaces
+
+			if slot.Indexing != "" && slot.Indexing != WalletIndexing && slot.Indexing != CollectionIndexing {
+				return Rules{}, fmt.Errorf("conditions: slot %s has unknown indexing %q", slot.Name, slot.Indexing)
+			}
+
+			if slot.ItemCounting != "" && slot.ItemCounting != PerUserItemCounting && slot.ItemCounting != PerRaffleItemCounting {
+				return Rules{}, fmt.Errorf("conditions: slot %s has unknown item counting %q", slot.Name, slot.ItemCounting)
+			}
+		case HoldKind:
+			if slot.SnapshotUnixTime <= 0 {
+				return Rules{}, fmt.Errorf("conditions: hold slot %s needs a snapshot time", slot.Name)
+			}
+		default:
+			return Rules{}, fmt.Errorf("conditions: slot %s has unknown kind %q", slot.Name, slot.Kind)
+		}
+
+		rules.Slots[i] = slot
+	}
+
+	if bits > PayloadBits {
+		return Rules{}, fmt.Errorf("conditions: slots take %d bits, the payload has %d", bits, PayloadBits)
+	}
+
+	return rules, nil
+}
+
+func (s Slot) max() uint64 {
+	if s.Bits >= 64 {
+		return ^uint64(0)
+	}
+
+	return 1<<s.Bits - 1
+}
+
+// Slot returns the slot with the given name
+func (r Rules) Slot(name string) (Slot, bool) {
+	for _, slot := range r.Slots {
+		if slot.Name == name {
+			return slot, true
+		}
+	}
+
+	return Slot{}, false
+}
+
+// Write appends the payload of the slot values to the cell
+func (r Rules) Write(cell *boc.Cell, values []uint64) error {
+	if len(values) != len(r.Slots) {
+		return fmt.Errorf("conditions: %d values for %d slots", len(values), len(r.Slots))
+	}
+
+	bits := 0
+	for i, slot := range r.Slots {
+		if values[i] > slot.max() {
+			return fmt.Errorf("conditions: slot %s value %d does not fit %d bits", slot.Name, values[i], slot.Bits)
+		}
+
+		if err := cell.WriteUint(values[i], slot.Bits); err != nil {
+			return err
+		}
+		bits += slot.Bits
+	}
+
+	for padding := PayloadBits - bits; padding > 0; padding -= min(padding, 64) {
+		if err := cell.WriteUint(0, min(padding, 64)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read reads the slot values of a payload written by Write
+func (r Rules) Read(cell *boc.Cell) ([]uint64, error) {
+	values := make([]uint64, len(r.Slots))
+	for i, slot := range r.Slots {
+		value, err := cell.ReadUint(slot.Bits)
+		if err != nil {
+			return nil, fmt.Errorf("conditions: read slot %s: %w", slot.Name, err)
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// Bits lays the slot values out as the conditions field of the raffle messages
+func (r Rules) Bits(values []uint64) (tlb.Bits256, error) {
+	cell := boc.NewCell()
+	if err := r.Write(cell, values); err != nil {
+		return tlb.Bits256{}, err
+	}
+
+	var bits tlb.Bits256
+	if err := tlb.Unmarshal(cell, &bits); err != nil {
+		return tlb.Bits256{}, err
+	}
+
+	return bits, nil
+}
+
+// CheckTargets checks the targets read from the raffle against the declared quantities
+func (r Rules) CheckTargets(targets []uint64) error {
+	if len(targets) != len(r.Slots) {
+		return fmt.Errorf("conditions: %d targets for %d slots", len(targets), len(r.Slots))
+	}
+
+	for i, slot := range r.Slots {
+		if slot.Quantity != 0 && slot.Quantity != targets[i] {
+			return fmt.Errorf("conditions: slot %s expects %d, the raffle declares %d", slot.Name, slot.Quantity, targets[i])
+		}
+	}
+
+	return nil
+}
+
+// Reached tells whether the values match the targets. Values are capped at the targets, so
+// the payloads are then equal, which is what the raffle contract checks.
+func Reached(values []uint64, targets []uint64) bool {
+	if len(values) != len(targets) {
+		return false
+	}
+
+	for i := range values {
+		if values[i] < targets[i] {
+			return false
+		}
+	}
+
+	return true
+}
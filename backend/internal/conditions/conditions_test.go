@@ -0,0 +1,129 @@
+package conditions
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+)
+
+const (
+	testWhiteCollectionAddress = "EQAhISEhISEhISEhISEhISEhISEhISEhISEhISEhISEhIZoD"
+	testBlackCollectionAddress = "EQAiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIp3C"
+	testMarketplaceAddress     = "0:584ee61b2dff0837116d0fcb5078d93964bcbe9c05fd6a141b1bfca5d6a43e18"
+)
+
+func TestRulesRoundTrip(t *testing.T) {
+	rules, err := NewRules([]Slot{
+		{Name: "minted", Kind: MintKind, Collection: testWhiteCollectionAddress},
+		{Name: "bought", Kind: PurchaseKind, Collection: testBlackCollectionAddress, Marketplaces: []string{testMarketplaceAddress}, Bits: 16},
+		{Name: "held", Kind: HoldKind, Collection: testWhiteCollectionAddress, Bits: 64, SnapshotUnixTime: 1},
+	})
+	if err != nil {
+		t.Fatalf("new rules: %v", err)
+	}
+
+	cell := boc.NewCell()
+	values := []uint64{3, 1000, 1 << 40}
+	if err := rules.Write(cell, values); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if bits := cell.BitsAvailableForRead(); bits != PayloadBits {
+		t.Errorf("expected a %d bit payload, got %d", PayloadBits, bits)
+	}
+
+	read, err := rules.Read(cell)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if !reflect.DeepEqual(read, values) {
+		t.Errorf("expected %v, got %v", values, read)
+	}
+
+	if err := rules.Write(boc.NewCell(), []uint64{256, 0, 0}); err == nil {
+		t.Error("expected a value wider than its slot to be rejected")
+	}
+}
+
+// the default slots keep the layout the candidate contracts already store: white, then black
+func TestDefaultSlotsLayout(t *testing.T) {
+	rules, err := NewRules(DefaultSlots(testWhiteCollectionAddress, testBlackCollectionAddress, testMarketplaceAddress))
+	if err != nil {
+		t.Fatalf("new rules: %v", err)
+	}
+
+	cells, err := boc.DeserializeBocHex("b5ee9c720101010100220000400102000000000000000000000000000000000000000000000000000000000000")
+	if err != nil {
+		t.Fatalf("deserialize: %v", err)
+	}
+
+	values, err := rules.Read(cells[0])
+	if err != nil || !reflect.DeepEqual(values, []uint64{1, 2}) {
+		t.Errorf("expected white/black 1/2, got %v (%v)", values, err)
+	}
+
+	bits, err := rules.Bits([]uint64{1, 2})
+	if err != nil || bits != (tlb.Bits256{1, 2}) {
+		t.Errorf("expected the white/black bytes first, got %x (%v)", bits, err)
+	}
+}
+
+func TestNewRulesValidation(t *testing.T) {
+	mint := Slot{Name: "minted", Kind: MintKind, Collection: testWhiteCollectionAddress}
+
+	cases := map[string][]Slot{
+		"no slots":         nil,
+		"no name":          {{Kind: MintKind, Collection: testWhiteCollectionAddress}},
+		"duplicate name":   {mint, mint},
+		"unknown kind":     {{Name: "other", Kind: "burn", Collection: testWhiteCollectionAddress}},
+		"invalid address":  {{Name: "minted", Kind: MintKind, Collection: "nope"}},
+		"no marketplace":   {{Name: "bought", Kind: PurchaseKind, Collection: testBlackCollectionAddress}},
+		"no snapshot":      {{Name: "held", Kind: HoldKind, Collection: testWhiteCollectionAddress}},
+		"unknown indexing": {{Name: "bought", Kind: PurchaseKind, Collection: testBlackCollectionAddress, Marketplaces: []string{testMarketplaceAddress}, Indexing: "mempool"}},
+		"unknown counting": {{Name: "bought", Kind: PurchaseKind, Collection: testBlackCollectionAddress, Marketplaces: []string{testMarketplaceAddress}, ItemCounting: "per_block"}},
+		"too wide":         {{Name: "minted", Kind: MintKind, Collection: testWhiteCollectionAddress, Bits: 65}},
+		"quantity overrun": {{Name: "minted", Kind: MintKind, Collection: testWhiteCollectionAddress, Quantity: 256}},
+		"payload overrun": {
+			{Name: "a", Kind: MintKind, Collection: testWhiteCollectionAddress, Bits: 64},
+			{Name: "b", Kind: MintKind, Collection: testWhiteCollectionAddress, Bits: 64},
+			{Name: "c", Kind: MintKind, Collection: testWhiteCollectionAddress, Bits: 64},
+			{Name: "d", Kind: MintKind, Collection: testWhiteCollectionAddress, Bits: 64},
+			{Name: "e", Kind: MintKind, Collection: testWhiteCollectionAddress, Bits: 1},
+		},
+	}
+
+	for name, slots := range cases {
+		if _, err := NewRules(slots); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	rules, err := NewRules([]Slot{
+		{Name: "minted", Kind: MintKind, Collection: testWhiteCollectionAddress, Quantity: 2},
+		{Name: "bought", Kind: PurchaseKind, Collection: testBlackCollectionAddress, Marketplaces: []string{testMarketplaceAddress}},
+	})
+	if err != nil {
+		t.Fatalf("new rules: %v", err)
+	}
+
+	if err := rules.CheckTargets([]uint64{2, 5}); err != nil {
+		t.Errorf("expected targets to match, got %v", err)
+	}
+
+	if err := rules.CheckTargets([]uint64{1, 5}); err == nil {
+		t.Error("expected a quantity mismatch")
+	}
+
+	if err := rules.CheckTargets([]uint64{2}); err == nil {
+		t.Error("expected a slot count mismatch")
+	}
+
+	if !Reached([]uint64{2, 5}, []uint64{2, 5}) || Reached([]uint64{2, 4}, []uint64{2, 5}) {
+		t.Error("unexpected reached")
+	}
+}
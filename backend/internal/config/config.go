@@ -0,0 +1,525 @@
+package config
+
+import (
+	"backend/internal/blockchain"
+	"backend/internal/conditions"
+	"backend/internal/marketplace"
+	"backend/internal/storage"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/tonkeeper/tongo/ton"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultMarketplaceAddress = marketplace.GetGemsAddress
+const DefaultLimitWindowSize = 50
+const DefaultRequestsPerSecond = 1
+const DefaultWorkers = 4
+const DefaultFinalityDepth = 3
+const DefaultSetConditionsAmount = 50_000_000
+const DefaultDrawAmount = 50_000_000
+const DefaultDatabasePath = "persistent.db"
+const DefaultLogFile = "tracker.log"
+const DefaultAPIAddress = "127.0.0.1:8080"
+const DefaultMaxBatchSize = 100
+const DefaultMaxOpenConns = 10
+const DefaultMaxIdleConns = 5
+const DefaultConnMaxLifetime = 30 * time.Minute
+
+// raffleNextMinAmount is the participant storage and the RaffleNext fee the raffle asserts
+const raffleNextMinAmount = 5_000_000 + 8814*400
+
+type Configuration struct {
+	Wallet WalletConfiguration `yaml:"wallet"`
+	// Raffle is the single raffle configured by the environment, Raffles lists the others
+	Raffle  RaffleConfiguration   `yaml:"raffle"`
+	Raffles []RaffleConfiguration `yaml:"raffles"`
+	Chain   ChainConfiguration    `yaml:"chain"`
+	Storage StorageConfiguration  `yaml:"storage"`
+	Logger  LoggerConfiguration   `yaml:"logger"`
+	API     APIConfiguration      `yaml:"api"`
+}
+
+type WalletConfiguration struct {
+	Mnemonic string `yaml:"mnemonic" env:"WALLET_MNEMONIC"`
+	Version  string `yaml:"version" env:"WALLET_VERSION"`
+	// MaxBatchSize caps the set conditions messages packed into one highload wallet transfer
+	MaxBatchSize int `yaml:"max_batch_size" env:"WALLET_MAX_BATCH_SIZE"`
+}
+
+type RaffleConfiguration struct {
+	Address                      string `yaml:"address" env:"RAFFLE_ADDRESS"`
+	BlackTicketCollectionAddress string `yaml:"black_ticket_collection_address" env:"BLACK_TICKET_COLLECTION_ADDRESS"`
+	WhiteTicketCollectionAddress string `yaml:"white_ticket_collection_address" env:"WHITE_TICKET_COLLECTION_ADDRESS"`
+	MarketplaceAddress           string `yaml:"marketplace_address" env:"MARKETPLACE_ADDRESS"`
+	// StartLt overrides the discovered raffle deployment lt, zero means discover it on the first start
+	StartLt int64 `yaml:"start_lt" env:"RAFFLE_START_LT"`
+	// SetConditionsAmount is attached to every RaffleSetConditions message, in nanotons
+	SetConditionsAmount uint64 `yaml:"set_conditions_amount" env:"SET_CONDITIONS_AMOUNT"`
+	// WinnersQuantity is the number of winners drawn with RaffleNext once the conditions duration
+	// elapsed, zero leaves the drawing to the owner
+	WinnersQuantity int `yaml:"winners_quantity" env:"WINNERS_QUANTITY"`
+	// DrawAmount is attached to every RaffleNext message, in nanotons
+	DrawAmount uint64 `yaml:"draw_amount" env:"DRAW_AMOUNT"`
+	// WinnerForwardAmount and WinnerForwardPayload are sent on to the winner wallet by its
+	// participant contract, the payload is a text comment
+	WinnerForwardAmount  uint64 `yaml:"winner_forward_amount" env:"WINNER_FORWARD_AMOUNT"`
+	WinnerForwardPayload string `yaml:"winner_forward_payload" env:"WINNER_FORWARD_PAYLOAD"`
+	// PurchaseIndexing finds the purchases through the candidate wallets or the collection items
+	PurchaseIndexing string `yaml:"purchase_indexing" env:"PURCHASE_INDEXING"`
+	// ItemCounting is how often an item counts for the purchase slots that do not set it
+	ItemCounting string `yaml:"item_counting" env:"ITEM_COUNTING"`
+	// Conditions are the slots of the raffle conditions payload, in order. Empty means white
+	// tickets minted then black tickets purchased, from the collections above
+	Conditions []ConditionConfiguration `yaml:"conditions"`
+}
+
+type ConditionConfiguration struct {
+	Name       string `yaml:"name"`
+	Kind       string `yaml:"kind"`
+	Collection string `yaml:"collection"`
+	// Marketplace of a purchase slot, the raffle marketplace when neither it nor Marketplaces are set
+	Marketplace string `yaml:"marketplace"`
+	// Marketplaces are further marketplaces the purchases of a purchase slot may be made on
+	Marketplaces []string `yaml:"marketplaces"`
+	// ItemCounting of a purchase slot, the raffle item counting when empty
+	ItemCounting string `yaml:"item_counting"`
+	// Quantity is the expected target, zero accepts what the raffle declares
+	Quantity uint64 `yaml:"quantity"`
+	Bits     int    `yaml:"bits"`
+	// SnapshotUnixTime is when a hold slot counts the holdings
+	SnapshotUnixTime int64 `yaml:"snapshot_unix_time"`
+}
+
+type ChainConfiguration struct {
+	Source          string `yaml:"source" env:"CHAIN_SOURCE"`
+	TonapiToken     string `yaml:"tonapi_token" env:"TONAPI_TOKEN"`
+	LimitWindowSize int    `yaml:"limit_window_size" env:"CHAIN_LIMIT_WINDOW_SIZE"`
+	// RequestsPerSecond is the request budget of the chain source plan shared by all raffles,
+	// zero leaves the requests unlimited
+	RequestsPerSecond int `yaml:"requests_per_second" env:"CHAIN_REQUESTS_PER_SECOND"`
+	Burst             int `yaml:"burst" env:"CHAIN_BURST"`
+	// Workers is the number of accounts a collector scans at once
+	Workers int `yaml:"workers" env:"CHAIN_WORKERS"`
+	// FinalityDepth is the number of masterchain blocks below the head a trace has to be in
+	// before it is collected, zero trusts the head. The liteapi source only supports zero
+	FinalityDepth int `yaml:"finality_depth" env:"CHAIN_FINALITY_DEPTH"`
+}
+
+type StorageConfiguration struct {
+	Driver string `yaml:"driver" env:"DATABASE_DRIVER"`
+	// Path is the database file of the sqlite driver
+	Path string `yaml:"path" env:"DATABASE_PATH"`
+	// DSN is the connection string of the postgres driver
+	DSN             string        `yaml:"dsn" env:"DATABASE_DSN"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DATABASE_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DATABASE_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DATABASE_CONN_MAX_LIFETIME"`
+}
+
+type LoggerConfiguration struct {
+	File    string `yaml:"file" env:"LOG_FILE"`
+	Level   string `yaml:"level" env:"LOG_LEVEL"`
+	Console bool   `yaml:"console" env:"LOG_CONSOLE"`
+}
+
+type APIConfiguration struct {
+	// Address is the listen address of the HTTP API, empty disables it. The default listens on
+	// the loopback interface only, serving other hosts has to be configured
+	Address string `yaml:"address" env:"API_ADDRESS"`
+}
+
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		lines[i] = fmt.Sprintf("  %s: %s", fieldError.Field, fieldError.Message)
+	}
+
+	return "invalid configuration:\n" + strings.Join(lines, "\n")
+}
+
+func Default() *Configuration {
+	return &Configuration{
+		Wallet: WalletConfiguration{
+			Version:      "V4R2",
+			MaxBatchSize: DefaultMaxBatchSize,
+		},
+		Raffle: RaffleConfiguration{
+			MarketplaceAddress:  DefaultMarketplaceAddress,
+			SetConditionsAmount: DefaultSetConditionsAmount,
+			DrawAmount:          DefaultDrawAmount,
+			PurchaseIndexing:    conditions.WalletIndexing,
+			ItemCounting:        conditions.PerUserItemCounting,
+		},
+		Chain: ChainConfiguration{
+			Source:            blockchain.TonapiChainSourceType,
+			LimitWindowSize:   DefaultLimitWindowSize,
+			RequestsPerSecond: DefaultRequestsPerSecond,
+			Burst:             DefaultRequestsPerSecond,
+			Workers:           DefaultWorkers,
+			FinalityDepth:     DefaultFinalityDepth,
+		},
+		Storage: StorageConfiguration{
+			Driver:          storage.SqliteDriverType,
+			Path:            DefaultDatabasePath,
+			MaxOpenConns:    DefaultMaxOpenConns,
+			MaxIdleConns:    DefaultMaxIdleConns,
+			ConnMaxLifetime: DefaultConnMaxLifetime,
+		},
+		Logger: LoggerConfiguration{
+			File:    DefaultLogFile,
+			Level:   "info",
+			Console: true,
+		},
+		API: APIConfiguration{
+			Address: DefaultAPIAddress,
+		},
+	}
+}
+
+// Load reads the YAML file at path (skipped when path is empty), then applies .env and
+// environment overrides on top and validates the result.
+func Load(path string) (*Configuration, error) {
+	configuration := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read configuration: %w", err)
+		}
+
+		if err := yaml.UnmarshalStrict(data, configuration); err != nil {
+			return nil, fmt.Errorf("parse configuration %s: %w", path, err)
+		}
+
+		for i := range configuration.Raffles {
+			if configuration.Raffles[i].MarketplaceAddress == "" {
+				configuration.Raffles[i].MarketplaceAddress = DefaultMarketplaceAddress
+			}
+
+			if configuration.Raffles[i].SetConditionsAmount == 0 {
+				configuration.Raffles[i].SetConditionsAmount = DefaultSetConditionsAmount
+			}
+
+			if configuration.Raffles[i].DrawAmount == 0 {
+				configuration.Raffles[i].DrawAmount = DefaultDrawAmount
+			}
+
+			if configuration.Raffles[i].PurchaseIndexing == "" {
+				configuration.Raffles[i].PurchaseIndexing = conditions.WalletIndexing
+			}
+
+			if configuration.Raffles[i].ItemCounting == "" {
+				configuration.Raffles[i].ItemCounting = conditions.PerUserItemCounting
+			}
+		}
+	}
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read .env: %w", err)
+	}
+
+	var fieldErrors []FieldError
+	applyEnvironment(reflect.ValueOf(configuration).Elem(), &fieldErrors)
+	fieldErrors = append(fieldErrors, configuration.validate()...)
+
+	if len(fieldErrors) > 0 {
+		return nil, &ValidationError{Errors: fieldErrors}
+	}
+
+	return configuration, nil
+}
+
+// RaffleConfigurations returns every raffle the oracle tracks, the single raffle block counts
+// once its address is set.
+func (c *Configuration) RaffleConfigurations() []RaffleConfiguration {
+	raffles := make([]RaffleConfiguration, 0, len(c.Raffles)+1)
+	if c.Raffle.Address != "" {
+		raffles = append(raffles, c.Raffle)
+	}
+
+	return append(raffles, c.Raffles...)
+}
+
+// ConditionSlots returns the condition slots of the raffle
+func (r RaffleConfiguration) ConditionSlots() []conditions.Slot {
+	if len(r.Conditions) == 0 {
+		slots := conditions.DefaultSlots(r.WhiteTicketCollectionAddress, r.BlackTicketCollectionAddress, r.MarketplaceAddress)
+		for i := range slots {
+			if slots[i].Kind == conditions.PurchaseKind {
+				slots[i].Indexing = r.PurchaseIndexing
+				slots[i].ItemCounting = r.ItemCounting
+			}
+		}
+
+		return slots
+	}
+
+	slots := make([]conditions.Slot, len(r.Conditions))
+	for i, condition := range r.Conditions {
+		slots[i] = conditions.Slot{
+			Name:             condition.Name,
+			Kind:             condition.Kind,
+			Collection:       condition.Collection,
+			Quantity:         condition.Quantity,
+			Bits:             condition.Bits,
+			SnapshotUnixTime: condition.SnapshotUnixTime,
+		}
+
+		if condition.Kind == conditions.PurchaseKind {
+			if condition.Marketplace != "" {
+				slots[i].Marketplaces = append(slots[i].Marketplaces, condition.Marketplace)
+			}
+			slots[i].Marketplaces = append(slots[i].Marketplaces, condition.Marketplaces...)
+
+			if len(slots[i].Marketplaces) == 0 {
+				slots[i].Marketplaces = []string{r.MarketplaceAddress}
+			}
+
+			slots[i].Indexing = r.PurchaseIndexing
+
+			slots[i].ItemCounting = condition.ItemCounting
+			if slots[i].ItemCounting == "" {
+				slots[i].ItemCounting = r.ItemCounting
+			}
+		}
+	}
+
+	return slots
+}
+
+func (c *Configuration) LogLevel() zapcore.Level {
+	level, err := zapcore.ParseLevel(c.Logger.Level)
+	if err != nil {
+		return zapcore.InfoLevel
+	}
+
+	return level
+}
+
+func (c *Configuration) validate() []FieldError {
+	var fieldErrors []FieldError
+	report := func(field string, format string, args ...any) {
+		fieldErrors = append(fieldErrors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.Wallet.Mnemonic == "" {
+		report("wallet.mnemonic", "is required")
+	} else if words := len(strings.Fields(c.Wallet.Mnemonic)); words != 24 {
+		report("wallet.mnemonic", "must contain 24 words, got %d", words)
+	}
+
+	if _, ok := blockchain.WalletMap[c.Wallet.Version]; !ok {
+		report("wallet.version", "unknown wallet version %q", c.Wallet.Version)
+	} else if strings.HasPrefix(c.Wallet.Version, "HighLoad") && c.Wallet.Version != "HighLoadV2R2" {
+		report("wallet.version", "highload wallet %q is not supported, use HighLoadV2R2", c.Wallet.Version)
+	}
+
+	if c.Wallet.MaxBatchSize <= 0 || c.Wallet.MaxBatchSize > blockchain.HighloadMaxMessages {
+		report("wallet.max_batch_size", "must be within 1..%d", blockchain.HighloadMaxMessages)
+	}
+
+	validateAddress := func(field string, value string) {
+		if value == "" {
+			report(field, "is required")
+			return
+		}
+
+		if _, err := ton.ParseAccountID(value); err != nil {
+			report(field, "invalid address %q", value)
+		}
+	}
+
+	raffles := c.RaffleConfigurations()
+	if len(raffles) == 0 {
+		report("raffle.address", "is required, or list the raffles under raffles")
+	}
+
+	// the single raffle block, when set, comes first
+	offset := len(raffles) - len(c.Raffles)
+	raffleAddresses := make(map[string]bool)
+	for i, raffle := range raffles {
+		prefix := "raffle"
+		if i >= offset {
+			prefix = fmt.Sprintf("raffles[%d]", i-offset)
+		}
+
+		validateAddress(prefix+".address", raffle.Address)
+		validateAddress(prefix+".marketplace_address", raffle.MarketplaceAddress)
+
+		// the ticket collections only make the default conditions
+		if len(raffle.Conditions) == 0 {
+			validateAddress(prefix+".black_ticket_collection_address", raffle.BlackTicketCollectionAddress)
+			validateAddress(prefix+".white_ticket_collection_address", raffle.WhiteTicketCollectionAddress)
+		} else if _, err := conditions.NewRules(raffle.ConditionSlots()); err != nil {
+			report(prefix+".conditions", "%s", strings.TrimPrefix(err.Error(), "conditions: "))
+		}
+
+		if raffle.StartLt < 0 {
+			report(prefix+".start_lt", "must be a positive logical time or zero to discover it")
+		}
+
+		if raffle.SetConditionsAmount == 0 {
+			report(prefix+".set_conditions_amount", "must be positive")
+		}
+
+		// the raffle keeps the winners quantity in 8 bits
+		if raffle.WinnersQuantity < 0 || raffle.WinnersQuantity > 255 {
+			report(prefix+".winners_quantity", "must be within 0..255")
+		}
+
+		if raffle.WinnersQuantity > 0 && raffle.DrawAmount < raffleNextMinAmount+raffle.WinnerForwardAmount {
+			report(prefix+".draw_amount", "must cover the RaffleNext fees and the winner forward amount, at least %d", raffleNextMinAmount+raffle.WinnerForwardAmount)
+		}
+
+		switch raffle.PurchaseIndexing {
+		case conditions.WalletIndexing, conditions.CollectionIndexing:
+		default:
+			report(prefix+".purchase_indexing", "must be one of %q, %q", conditions.WalletIndexing, conditions.CollectionIndexing)
+		}
+
+		switch raffle.ItemCounting {
+		case conditions.PerUserItemCounting, conditions.PerRaffleItemCounting:
+		default:
+			report(prefix+".item_counting", "must be one of %q, %q", conditions.PerUserItemCounting, conditions.PerRaffleItemCounting)
+		}
+
+		if raffleAccountID, err := ton.ParseAccountID(raffle.Address); err == nil {
+			if raffleAddresses[raffleAccountID.ToRaw()] {
+				report(prefix+".address", "raffle %s is configured twice", raffle.Address)
+			}
+			raffleAddresses[raffleAccountID.ToRaw()] = true
+		}
+	}
+
+	switch c.Chain.Source {
+	case blockchain.TonapiChainSourceType:
+		if c.Chain.TonapiToken == "" {
+			report("chain.tonapi_token", "is required for the tonapi chain source")
+		}
+	case blockchain.LiteapiChainSourceType:
+		// the final actions are verified by looking their transactions up by hash, which
+		// liteservers do not index
+		if c.Chain.FinalityDepth > 0 {
+			report("chain.finality_depth", "must be 0 for the liteapi chain source, it cannot verify final actions")
+		}
+	default:
+		report("chain.source", "must be one of %q, %q", blockchain.TonapiChainSourceType, blockchain.LiteapiChainSourceType)
+	}
+
+	if c.Chain.LimitWindowSize <= 0 || c.Chain.LimitWindowSize > 1000 {
+		report("chain.limit_window_size", "must be within 1..1000")
+	}
+
+	if c.Chain.RequestsPerSecond < 0 {
+		report("chain.requests_per_second", "must not be negative")
+	}
+
+	if c.Chain.Burst <= 0 {
+		report("chain.burst", "must be positive")
+	}
+
+	if c.Chain.Workers <= 0 || c.Chain.Workers > 64 {
+		report("chain.workers", "must be within 1..64")
+	}
+
+	if c.Chain.FinalityDepth < 0 {
+		report("chain.finality_depth", "must not be negative")
+	}
+
+	switch c.Storage.Driver {
+	case storage.SqliteDriverType:
+		if c.Storage.Path == "" {
+			report("storage.path", "is required for the sqlite driver")
+		}
+	case storage.PostgresDriverType:
+		if c.Storage.DSN == "" {
+			report("storage.dsn", "is required for the postgres driver")
+		}
+	default:
+		report("storage.driver", "must be one of %q, %q", storage.SqliteDriverType, storage.PostgresDriverType)
+	}
+
+	if c.Storage.MaxOpenConns < 0 {
+		report("storage.max_open_conns", "must not be negative")
+	}
+
+	if c.Storage.MaxIdleConns < 0 {
+		report("storage.max_idle_conns", "must not be negative")
+	}
+
+	if c.Storage.ConnMaxLifetime < 0 {
+		report("storage.conn_max_lifetime", "must not be negative")
+	}
+
+	if _, err := zapcore.ParseLevel(c.Logger.Level); err != nil {
+		report("logger.level", "unknown level %q", c.Logger.Level)
+	}
+
+	return fieldErrors
+}
+
+func applyEnvironment(value reflect.Value, fieldErrors *[]FieldError) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		fieldType := value.Type().Field(i)
+
+		if field.Kind() == reflect.Struct {
+			applyEnvironment(field, fieldErrors)
+			continue
+		}
+
+		name := fieldType.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+
+		environmentValue, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch {
+		case field.Type() == reflect.TypeOf(time.Duration(0)):
+			var parsed time.Duration
+			parsed, err = time.ParseDuration(environmentValue)
+			field.SetInt(int64(parsed))
+		case field.Kind() == reflect.String:
+			field.SetString(environmentValue)
+		case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+			var parsed int64
+			parsed, err = strconv.ParseInt(environmentValue, 10, 64)
+			field.SetInt(parsed)
+		case field.Kind() == reflect.Uint64:
+			var parsed uint64
+			parsed, err = strconv.ParseUint(environmentValue, 10, 64)
+			field.SetUint(parsed)
+		case field.Kind() == reflect.Bool:
+			var parsed bool
+			parsed, err = strconv.ParseBool(environmentValue)
+			field.SetBool(parsed)
+		}
+
+		if err != nil {
+			*fieldErrors = append(*fieldErrors, FieldError{Field: name, Message: fmt.Sprintf("invalid value %q", environmentValue)})
+		}
+	}
+}
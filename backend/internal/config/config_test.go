@@ -0,0 +1,268 @@
+package config
+
+import (
+	"backend/internal/blockchain"
+	"backend/internal/conditions"
+	"backend/internal/storage"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testRaffleAddress          = "0:1111111111111111111111111111111111111111111111111111111111111111"
+	testOtherRaffleAddress     = "0:1212121212121212121212121212121212121212121212121212121212121212"
+	testWhiteCollectionAddress = "EQAhISEhISEhISEhISEhISEhISEhISEhISEhISEhISEhIZoD"
+	testBlackCollectionAddress = "EQAiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIp3C"
+)
+
+var testMnemonic = strings.TrimSpace(strings.Repeat("word ", 24))
+
+var testConfiguration = `
+wallet:
+  mnemonic: "` + testMnemonic + `"
+  version: HighLoadV2R2
+  max_batch_size: 20
+raffle:
+  address: "` + testRaffleAddress + `"
+  black_ticket_collection_address: "` + testBlackCollectionAddress + `"
+  white_ticket_collection_address: "` + testWhiteCollectionAddress + `"
+  winners_quantity: 2
+raffles:
+  - address: "` + testOtherRaffleAddress + `"
+    conditions:
+      - name: minted
+        kind: mint
+        collection: "` + testWhiteCollectionAddress + `"
+chain:
+  tonapi_token: token
+  finality_depth: 5
+storage:
+  path: oracle.db
+api:
+  address: ":9090"
+logger:
+  level: debug
+`
+
+func writeConfiguration(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "oracle.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write configuration: %v", err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	configuration, err := Load(writeConfiguration(t, testConfiguration))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if configuration.Wallet.Version != "HighLoadV2R2" || configuration.Wallet.MaxBatchSize != 20 {
+		t.Errorf("expected the file wallet, got %+v", configuration.Wallet)
+	}
+
+	raffles := configuration.RaffleConfigurations()
+	if len(raffles) != 2 || raffles[0].Address != testRaffleAddress || raffles[1].Address != testOtherRaffleAddress {
+		t.Fatalf("expected the single raffle, then the listed one, got %+v", raffles)
+	}
+
+	if raffles[0].WinnersQuantity != 2 || raffles[0].DrawAmount != DefaultDrawAmount {
+		t.Errorf("expected the file winners and the default draw amount, got %+v", raffles[0])
+	}
+
+	// the listed raffles get the defaults the single raffle block starts from
+	listed := raffles[1]
+	if listed.MarketplaceAddress != DefaultMarketplaceAddress ||
+		listed.SetConditionsAmount != DefaultSetConditionsAmount ||
+		listed.DrawAmount != DefaultDrawAmount ||
+		listed.PurchaseIndexing != conditions.WalletIndexing ||
+		listed.ItemCounting != conditions.PerUserItemCounting {
+		t.Errorf("expected the defaults on the listed raffle, got %+v", listed)
+	}
+
+	if configuration.Chain.Source != blockchain.TonapiChainSourceType || configuration.Chain.FinalityDepth != 5 || configuration.Chain.Workers != DefaultWorkers {
+		t.Errorf("expected the file chain over the defaults, got %+v", configuration.Chain)
+	}
+
+	if configuration.Storage.Driver != storage.SqliteDriverType || configuration.Storage.Path != "oracle.db" {
+		t.Errorf("expected the file storage, got %+v", configuration.Storage)
+	}
+
+	if configuration.API.Address != ":9090" || configuration.Logger.Level != "debug" {
+		t.Errorf("expected the file api and logger, got %+v %+v", configuration.API, configuration.Logger)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("WALLET_MNEMONIC", testMnemonic)
+	t.Setenv("RAFFLE_ADDRESS", testRaffleAddress)
+	t.Setenv("BLACK_TICKET_COLLECTION_ADDRESS", testBlackCollectionAddress)
+	t.Setenv("WHITE_TICKET_COLLECTION_ADDRESS", testWhiteCollectionAddress)
+	t.Setenv("TONAPI_TOKEN", "token")
+
+	configuration, err := Load("")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if configuration.API.Address != DefaultAPIAddress || !strings.HasPrefix(DefaultAPIAddress, "127.0.0.1:") {
+		t.Errorf("expected the API on the loopback interface by default, got %q", configuration.API.Address)
+	}
+
+	if configuration.Chain.FinalityDepth != DefaultFinalityDepth || configuration.Storage.Path != DefaultDatabasePath {
+		t.Errorf("expected the defaults, got %+v %+v", configuration.Chain, configuration.Storage)
+	}
+}
+
+func TestLoadEnvironmentOverrides(t *testing.T) {
+	t.Setenv("WALLET_VERSION", "V4R2")
+	t.Setenv("RAFFLE_START_LT", "900")
+	t.Setenv("SET_CONDITIONS_AMOUNT", "70000000")
+	t.Setenv("CHAIN_FINALITY_DEPTH", "0")
+	t.Setenv("DATABASE_CONN_MAX_LIFETIME", "5m")
+	t.Setenv("LOG_CONSOLE", "false")
+	t.Setenv("API_ADDRESS", "")
+
+	configuration, err := Load(writeConfiguration(t, testConfiguration))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		actual   any
+		expected any
+	}{
+		{"string", configuration.Wallet.Version, "V4R2"},
+		{"int64", configuration.Raffle.StartLt, int64(900)},
+		{"uint64", configuration.Raffle.SetConditionsAmount, uint64(70_000_000)},
+		{"int set to zero", configuration.Chain.FinalityDepth, 0},
+		{"duration", configuration.Storage.ConnMaxLifetime, 5 * time.Minute},
+		{"bool", configuration.Logger.Console, false},
+		{"string set to empty", configuration.API.Address, ""},
+		{"not overridden", configuration.Wallet.MaxBatchSize, 20},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, test.actual)
+			}
+		})
+	}
+
+	// the environment only reaches the single raffle block
+	if configuration.Raffles[0].SetConditionsAmount != DefaultSetConditionsAmount {
+		t.Errorf("expected the listed raffle to keep its amount, got %d", configuration.Raffles[0].SetConditionsAmount)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment map[string]string
+		field       string
+	}{
+		{"invalid integer", map[string]string{"RAFFLE_START_LT": "soon"}, "RAFFLE_START_LT"},
+		{"invalid duration", map[string]string{"DATABASE_CONN_MAX_LIFETIME": "soon"}, "DATABASE_CONN_MAX_LIFETIME"},
+		{"invalid bool", map[string]string{"LOG_CONSOLE": "maybe"}, "LOG_CONSOLE"},
+		{"validated after the environment", map[string]string{"WALLET_MNEMONIC": "one two"}, "wallet.mnemonic"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.environment {
+				t.Setenv(name, value)
+			}
+
+			_, err := Load(writeConfiguration(t, testConfiguration))
+
+			var validationError *ValidationError
+			if !errors.As(err, &validationError) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+
+			if len(validationError.Errors) != 1 || validationError.Errors[0].Field != test.field {
+				t.Errorf("expected an error on %s, got %v", test.field, validationError.Errors)
+			}
+		})
+	}
+
+	if _, err := Load(writeConfiguration(t, testConfiguration+"unknown: true\n")); err == nil || !strings.Contains(err.Error(), "parse configuration") {
+		t.Errorf("expected unknown keys to be rejected, got %v", err)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "read configuration") {
+		t.Errorf("expected a missing file to be reported, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *Configuration)
+		field  string
+	}{
+		{"no mnemonic", func(c *Configuration) { c.Wallet.Mnemonic = "" }, "wallet.mnemonic"},
+		{"short mnemonic", func(c *Configuration) { c.Wallet.Mnemonic = "one two three" }, "wallet.mnemonic"},
+		{"unknown wallet", func(c *Configuration) { c.Wallet.Version = "V9" }, "wallet.version"},
+		{"old highload wallet", func(c *Configuration) { c.Wallet.Version = "HighLoadV2" }, "wallet.version"},
+		{"empty batch", func(c *Configuration) { c.Wallet.MaxBatchSize = 0 }, "wallet.max_batch_size"},
+		{"oversized batch", func(c *Configuration) { c.Wallet.MaxBatchSize = blockchain.HighloadMaxMessages + 1 }, "wallet.max_batch_size"},
+		{"no raffle", func(c *Configuration) { c.Raffle.Address, c.Raffles = "", nil }, "raffle.address"},
+		{"invalid raffle", func(c *Configuration) { c.Raffle.Address = "nope" }, "raffle.address"},
+		{"raffle twice", func(c *Configuration) { c.Raffles[0].Address = testRaffleAddress }, "raffles[0].address"},
+		{"no marketplace", func(c *Configuration) { c.Raffle.MarketplaceAddress = "" }, "raffle.marketplace_address"},
+		{"no black collection", func(c *Configuration) { c.Raffle.BlackTicketCollectionAddress = "" }, "raffle.black_ticket_collection_address"},
+		{"invalid white collection", func(c *Configuration) { c.Raffle.WhiteTicketCollectionAddress = "nope" }, "raffle.white_ticket_collection_address"},
+		{"invalid conditions", func(c *Configuration) { c.Raffles[0].Conditions[0].Kind = "burn" }, "raffles[0].conditions"},
+		{"negative start lt", func(c *Configuration) { c.Raffle.StartLt = -1 }, "raffle.start_lt"},
+		{"no set conditions amount", func(c *Configuration) { c.Raffles[0].SetConditionsAmount = 0 }, "raffles[0].set_conditions_amount"},
+		{"too many winners", func(c *Configuration) { c.Raffle.WinnersQuantity = 256 }, "raffle.winners_quantity"},
+		{"draw amount below the fees", func(c *Configuration) { c.Raffle.DrawAmount = raffleNextMinAmount - 1 }, "raffle.draw_amount"},
+		{"draw amount below the forward amount", func(c *Configuration) { c.Raffle.WinnerForwardAmount = DefaultDrawAmount }, "raffle.draw_amount"},
+		{"unknown purchase indexing", func(c *Configuration) { c.Raffle.PurchaseIndexing = "mempool" }, "raffle.purchase_indexing"},
+		{"unknown item counting", func(c *Configuration) { c.Raffles[0].ItemCounting = "per_block" }, "raffles[0].item_counting"},
+		{"no tonapi token", func(c *Configuration) { c.Chain.TonapiToken = "" }, "chain.tonapi_token"},
+		{"unknown chain source", func(c *Configuration) { c.Chain.Source = "toncenter" }, "chain.source"},
+		{"liteapi with finality checks", func(c *Configuration) { c.Chain.Source = blockchain.LiteapiChainSourceType }, "chain.finality_depth"},
+		{"empty limit window", func(c *Configuration) { c.Chain.LimitWindowSize = 0 }, "chain.limit_window_size"},
+		{"oversized limit window", func(c *Configuration) { c.Chain.LimitWindowSize = 1001 }, "chain.limit_window_size"},
+		{"negative requests per second", func(c *Configuration) { c.Chain.RequestsPerSecond = -1 }, "chain.requests_per_second"},
+		{"no burst", func(c *Configuration) { c.Chain.Burst = 0 }, "chain.burst"},
+		{"too many workers", func(c *Configuration) { c.Chain.Workers = 65 }, "chain.workers"},
+		{"negative finality depth", func(c *Configuration) { c.Chain.FinalityDepth = -1 }, "chain.finality_depth"},
+		{"no sqlite path", func(c *Configuration) { c.Storage.Path = "" }, "storage.path"},
+		{"no postgres dsn", func(c *Configuration) { c.Storage.Driver = storage.PostgresDriverType }, "storage.dsn"},
+		{"unknown driver", func(c *Configuration) { c.Storage.Driver = "mysql" }, "storage.driver"},
+		{"negative open connections", func(c *Configuration) { c.Storage.MaxOpenConns = -1 }, "storage.max_open_conns"},
+		{"negative idle connections", func(c *Configuration) { c.Storage.MaxIdleConns = -1 }, "storage.max_idle_conns"},
+		{"negative connection lifetime", func(c *Configuration) { c.Storage.ConnMaxLifetime = -time.Second }, "storage.conn_max_lifetime"},
+		{"unknown log level", func(c *Configuration) { c.Logger.Level = "loud" }, "logger.level"},
+	}
+
+	path := writeConfiguration(t, testConfiguration)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration, err := Load(path)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			test.mutate(configuration)
+
+			fieldErrors := configuration.validate()
+			if len(fieldErrors) != 1 || fieldErrors[0].Field != test.field {
+				t.Errorf("expected an error on %s, got %v", test.field, fieldErrors)
+			}
+		})
+	}
+}
@@ -70,6 +70,10 @@ func Warn(message string, fields ...zap.Field) {
 	log.Warn(message, fields...)
 }
 
+func Error(message string, fields ...zap.Field) {
+	log.Error(message, fields...)
+}
+
 func Fatal(message string, fields ...zap.Field) {
 	log.Fatal(message, fields...)
 }
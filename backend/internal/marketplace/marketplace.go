@@ -0,0 +1,180 @@
+package marketplace
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// GetGemsAddress is the GetGems marketplace, the one of the original raffles
+const GetGemsAddress = "0:584ee61b2dff0837116d0fcb5078d93964bcbe9c05fd6a141b1bfca5d6a43e18"
+
+const (
+	// fixPriceMagic is the "FIXP" tag GetGems fixed price sales put first in get_sale_data
+	fixPriceMagic = 0x46495850
+	// auctionMagic is the "AUC" tag GetGems auctions put first in get_sale_data
+	auctionMagic = 0x415543
+)
+
+var ErrUnknownSale = errors.New("marketplace: no adapter for the sale contract")
+
+// Sale is what a sale contract reports about the item it sells
+type Sale struct {
+	Adapter     string
+	Marketplace ton.AccountID
+	Item        ton.AccountID
+	Owner       ton.AccountID
+}
+
+// Adapter reads one kind of sale contract: the get-method reporting the sale and where its
+// stack keeps the marketplace, the item and the seller.
+type Adapter struct {
+	Name   string
+	Method string
+	// Magic is the number the first stack entry holds, zero when the layout has no tag
+	Magic uint64
+	// Marketplace is the only marketplace deploying these contracts, empty when any may
+	Marketplace      string
+	MarketplaceIndex int
+	ItemIndex        int
+	OwnerIndex       int
+}
+
+// Registry is the ordered list of adapters tried on a sale contract, the first one decoding
+// its stack wins.
+type Registry struct {
+	adapters []Adapter
+}
+
+func NewRegistry(adapters ...Adapter) *Registry {
+	return &Registry{adapters: adapters}
+}
+
+// DefaultRegistry knows the GetGems fixed price v3 and v4 sales, the GetGems auctions and the
+// reference NFT sale contract, which other marketplaces deploy as is.
+func DefaultRegistry() *Registry {
+	return NewRegistry(
+		Adapter{Name: "getgems_fixprice_v3", Method: "get_sale_data", Magic: fixPriceMagic, Marketplace: GetGemsAddress, MarketplaceIndex: 3, ItemIndex: 4, OwnerIndex: 5},
+		Adapter{Name: "getgems_auction", Method: "get_sale_data", Magic: auctionMagic, Marketplace: GetGemsAddress, MarketplaceIndex: 3, ItemIndex: 4, OwnerIndex: 5},
+		Adapter{Name: "getgems_fixprice_v4", Method: "get_fix_price_data_v4", Marketplace: GetGemsAddress, MarketplaceIndex: 2, ItemIndex: 3, OwnerIndex: 4},
+		Adapter{Name: "nft_sale", Method: "get_sale_data", MarketplaceIndex: 0, ItemIndex: 1, OwnerIndex: 2},
+	)
+}
+
+func (r *Registry) Adapters() []Adapter {
+	return r.adapters
+}
+
+// Methods returns the get-methods of the adapters the contract provides, in registry order
+// and without repetition
+func (r *Registry) Methods(getMethods []string) []string {
+	var methods []string
+	for _, adapter := range r.adapters {
+		if slices.Contains(getMethods, adapter.Method) && !slices.Contains(methods, adapter.Method) {
+			methods = append(methods, adapter.Method)
+		}
+	}
+
+	return methods
+}
+
+// Decode reads the sale from the result of method, trying every adapter of the method
+func (r *Registry) Decode(method string, stack []tonapi.TvmStackRecord) (*Sale, error) {
+	for _, adapter := range r.adapters {
+		if adapter.Method != method {
+			continue
+		}
+
+		if sale, err := adapter.Decode(stack); err == nil {
+			return sale, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSale, method)
+}
+
+func (a Adapter) Decode(stack []tonapi.TvmStackRecord) (*Sale, error) {
+	if a.Magic != 0 {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("%s: empty stack", a.Name)
+		}
+
+		magic, ok := stack[0].GetNum().Get()
+		if !ok {
+			return nil, fmt.Errorf("%s: missing tag", a.Name)
+		}
+
+		value, err := strconv.ParseUint(magic, 0, 64)
+		if err != nil || value != a.Magic {
+			return nil, fmt.Errorf("%s: unexpected tag %s", a.Name, magic)
+		}
+	}
+
+	marketplace, err := stackAddress(stack, a.MarketplaceIndex)
+	if err != nil {
+		return nil, fmt.Errorf("%s: marketplace: %w", a.Name, err)
+	}
+
+	if a.Marketplace != "" && marketplace.ToRaw() != a.Marketplace {
+		return nil, fmt.Errorf("%s: sale of marketplace %s", a.Name, marketplace.ToRaw())
+	}
+
+	item, err := stackAddress(stack, a.ItemIndex)
+	if err != nil {
+		return nil, fmt.Errorf("%s: item: %w", a.Name, err)
+	}
+
+	owner, err := stackAddress(stack, a.OwnerIndex)
+	if err != nil {
+		return nil, fmt.Errorf("%s: owner: %w", a.Name, err)
+	}
+
+	return &Sale{
+		Adapter:     a.Name,
+		Marketplace: marketplace,
+		Item:        item,
+		Owner:       owner,
+	}, nil
+}
+
+func stackAddress(stack []tonapi.TvmStackRecord, index int) (ton.AccountID, error) {
+	if index >= len(stack) {
+		return ton.AccountID{}, fmt.Errorf("stack has %d entries", len(stack))
+	}
+
+	value, ok := stack[index].GetCell().Get()
+	if !ok {
+		value, ok = stack[index].GetSlice().Get()
+	}
+
+	if !ok {
+		return ton.AccountID{}, fmt.Errorf("entry %d is a %s", index, stack[index].GetType())
+	}
+
+	cells, err := boc.DeserializeBocHex(value)
+	if err != nil || len(cells) == 0 {
+		return ton.AccountID{}, fmt.Errorf("entry %d is not a cell", index)
+	}
+
+	var address tlb.MsgAddress
+	if err := tlb.Unmarshal(cells[0], &address); err != nil {
+		return ton.AccountID{}, err
+	}
+
+	accountID, err := ton.AccountIDFromTlb(address)
+	if err != nil {
+		return ton.AccountID{}, err
+	}
+
+	if accountID == nil {
+		return ton.AccountID{}, fmt.Errorf("entry %d is an empty address", index)
+	}
+
+	return *accountID, nil
+}
@@ -0,0 +1,129 @@
+package marketplace
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+const (
+	testItemAddress        = "0:6262626262626262626262626262626262626262626262626262626262626262"
+	testOwnerAddress       = "0:3232323232323232323232323232323232323232323232323232323232323232"
+	testMarketplaceAddress = "0:7373737373737373737373737373737373737373737373737373737373737373"
+)
+
+func addressRecord(t *testing.T, address string) tonapi.TvmStackRecord {
+	t.Helper()
+
+	accountID := ton.MustParseAccountID(address)
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, accountID.ToMsgAddress()); err != nil {
+		t.Fatalf("marshal address: %v", err)
+	}
+
+	value, err := cell.ToBocString()
+	if err != nil {
+		t.Fatalf("serialize address: %v", err)
+	}
+
+	return tonapi.TvmStackRecord{Type: tonapi.TvmStackRecordTypeCell, Cell: tonapi.NewOptString(value)}
+}
+
+func numRecord(value string) tonapi.TvmStackRecord {
+	return tonapi.TvmStackRecord{Type: tonapi.TvmStackRecordTypeNum, Num: tonapi.NewOptString(value)}
+}
+
+func TestDefaultRegistryDecode(t *testing.T) {
+	registry := DefaultRegistry()
+
+	cases := []struct {
+		adapter string
+		method  string
+		stack   []tonapi.TvmStackRecord
+		market  string
+	}{
+		{
+			adapter: "getgems_fixprice_v3",
+			method:  "get_sale_data",
+			stack: []tonapi.TvmStackRecord{
+				numRecord("0x46495850"), numRecord("0x1"), numRecord("0x68d6b820"),
+				addressRecord(t, GetGemsAddress), addressRecord(t, testItemAddress), addressRecord(t, testOwnerAddress),
+				numRecord("0x3b9aca00"),
+			},
+			market: GetGemsAddress,
+		},
+		{
+			adapter: "getgems_auction",
+			method:  "get_sale_data",
+			stack: []tonapi.TvmStackRecord{
+				numRecord("0x415543"), numRecord("0x1"), numRecord("0x68d6b820"),
+				addressRecord(t, GetGemsAddress), addressRecord(t, testItemAddress), addressRecord(t, testOwnerAddress),
+				numRecord("0x3b9aca00"),
+			},
+			market: GetGemsAddress,
+		},
+		{
+			adapter: "getgems_fixprice_v4",
+			method:  "get_fix_price_data_v4",
+			stack: []tonapi.TvmStackRecord{
+				numRecord("0x1"), numRecord("0x68d6b820"),
+				addressRecord(t, GetGemsAddress), addressRecord(t, testItemAddress), addressRecord(t, testOwnerAddress),
+				numRecord("0x3b9aca00"),
+			},
+			market: GetGemsAddress,
+		},
+		{
+			adapter: "nft_sale",
+			method:  "get_sale_data",
+			stack: []tonapi.TvmStackRecord{
+				addressRecord(t, testMarketplaceAddress), addressRecord(t, testItemAddress), addressRecord(t, testOwnerAddress),
+				numRecord("0x3b9aca00"),
+			},
+			market: testMarketplaceAddress,
+		},
+	}
+
+	for _, c := range cases {
+		sale, err := registry.Decode(c.method, c.stack)
+		if err != nil {
+			t.Errorf("%s: decode: %v", c.adapter, err)
+			continue
+		}
+
+		if sale.Adapter != c.adapter {
+			t.Errorf("%s: decoded by %s", c.adapter, sale.Adapter)
+		}
+
+		if sale.Marketplace.ToRaw() != c.market || sale.Item.ToRaw() != testItemAddress || sale.Owner.ToRaw() != testOwnerAddress {
+			t.Errorf("%s: unexpected sale %+v", c.adapter, sale)
+		}
+	}
+}
+
+func TestDefaultRegistryRejects(t *testing.T) {
+	registry := DefaultRegistry()
+
+	// a GetGems layout deployed by another marketplace is not a GetGems sale
+	_, err := registry.Decode("get_sale_data", []tonapi.TvmStackRecord{
+		numRecord("0x46495850"), numRecord("0x1"), numRecord("0x68d6b820"),
+		addressRecord(t, testMarketplaceAddress), addressRecord(t, testItemAddress), addressRecord(t, testOwnerAddress),
+	})
+	if !errors.Is(err, ErrUnknownSale) {
+		t.Errorf("expected an unknown sale, got %v", err)
+	}
+
+	if _, err := registry.Decode("get_auction_data", nil); !errors.Is(err, ErrUnknownSale) {
+		t.Errorf("expected an unknown sale, got %v", err)
+	}
+}
+
+func TestRegistryMethods(t *testing.T) {
+	methods := DefaultRegistry().Methods([]string{"get_nft_data", "get_fix_price_data_v4", "get_sale_data"})
+	if len(methods) != 2 || methods[0] != "get_sale_data" || methods[1] != "get_fix_price_data_v4" {
+		t.Errorf("unexpected methods %v", methods)
+	}
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "oracle"
+
+// Registry holds the oracle metrics along with the go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	CollectorCycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "collector_cycle_duration_seconds",
+		Help:      "Duration of a single collector cycle.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	}, []string{"raffle", "collector"})
+
+	ChainSourceRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chain_source_requests_total",
+		Help:      "Chain source calls by method and result: ok, rate_limited or error.",
+	}, []string{"method", "result"})
+
+	ChainCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chain_cache_lookups_total",
+		Help:      "Cached chain lookups by kind and result: hit or miss.",
+	}, []string{"kind", "result"})
+
+	RateLimitRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_retries_total",
+		Help:      "Chain source calls repeated after a 429 response.",
+	})
+
+	ActionsDiscovered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_discovered_total",
+		Help:      "User actions found on-chain by action type.",
+	}, []string{"raffle", "action_type"})
+
+	FlaggedActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flagged_actions_total",
+		Help:      "Purchases excluded from the conditions as wash trades, by reason.",
+	}, []string{"raffle", "reason"})
+
+	RevertedActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reverted_actions_total",
+		Help:      "Stored actions reverted because their transaction no longer resolves, by action type.",
+	}, []string{"raffle", "action_type"})
+
+	PendingActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_actions",
+		Help:      "User actions not yet applied to the user statuses, as of the last synchronization.",
+	}, []string{"raffle", "action_type"})
+
+	SetConditions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "set_conditions_total",
+		Help:      "Set conditions messages by result: sent, confirmed, bounced or failed.",
+	}, []string{"raffle", "result"})
+
+	WinnerDraws = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "winner_draws_total",
+		Help:      "RaffleNext draws by result: sent, drawn or expired.",
+	}, []string{"raffle", "result"})
+
+	WalletBalance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "wallet_balance_nanotons",
+		Help:      "Balance of the oracle wallet.",
+	}, []string{"address"})
+
+	CursorLt = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cursor_lt",
+		Help:      "Logical time the collectors have reached, per action touch.",
+	}, []string{"raffle", "action_type", "user"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CollectorCycleDuration,
+		ChainSourceRequests,
+		ChainCacheLookups,
+		RateLimitRetries,
+		ActionsDiscovered,
+		FlaggedActions,
+		RevertedActions,
+		PendingActions,
+		SetConditions,
+		WinnerDraws,
+		WalletBalance,
+		CursorLt,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
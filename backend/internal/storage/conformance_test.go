@@ -0,0 +1,649 @@
+package storage
+
+import (
+	"backend/internal/logger"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"go.uber.org/zap/zapcore"
+)
+
+// scopedStorage opens the storage of a raffle on the database under test
+type scopedStorage func(raffleAddress string) Storage
+
+func TestSqliteStorageConformance(t *testing.T) {
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	sqliteStorage, err := NewSqliteStorage(filepath.Join(t.TempDir(), "conformance.db"), "")
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+
+	testStorageConformance(t, func(raffleAddress string) Storage { return sqliteStorage.ForRaffle(raffleAddress) })
+}
+
+func TestSqliteStorageSnapshot(t *testing.T) {
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	sqliteStorage, err := NewSqliteStorage(filepath.Join(t.TempDir(), "snapshot.db"), "")
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	s := sqliteStorage.ForRaffle("raffle")
+
+	action := &UserAction{ActionType: CandidateRegistrationActionType, UserAddress: "user1", Address: "candidate1", TransactionHash: "h1", TransactionLt: 10}
+	if err := s.UpdateUserActions([]*UserAction{action}); err != nil {
+		t.Fatalf("update actions: %v", err)
+	}
+
+	snapshot, release, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	// the snapshot holds a write transaction while the database is still written
+	err = snapshot.Transaction(func(tx Storage) error {
+		if err := tx.UpdateUserActions([]*UserAction{{ActionType: CandidateRegistrationActionType, UserAddress: "user2", Address: "candidate2", TransactionHash: "h2", TransactionLt: 20}}); err != nil {
+			return err
+		}
+
+		return s.UpdateUserActions([]*UserAction{{ActionType: CandidateRegistrationActionType, UserAddress: "user3", Address: "candidate3", TransactionHash: "h3", TransactionLt: 30}})
+	})
+	if err != nil {
+		t.Fatalf("write both databases: %v", err)
+	}
+
+	if actions, err := snapshot.GetUserActions(CandidateRegistrationActionType); err != nil || len(actions) != 2 || actions[1].UserAddress != "user2" {
+		t.Errorf("expected the copied and the snapshot actions, got %+v (%v)", actions, err)
+	}
+
+	if actions, err := s.GetUserActions(CandidateRegistrationActionType); err != nil || len(actions) != 2 || actions[1].UserAddress != "user3" {
+		t.Errorf("expected the snapshot writes kept out of the database, got %+v (%v)", actions, err)
+	}
+
+	if err := release(); err != nil {
+		t.Errorf("release: %v", err)
+	}
+}
+
+// TestPostgresStorageConformance runs against ORACLE_TEST_POSTGRES_DSN when set, otherwise it
+// spawns a local postgres instance and skips when it cannot be started.
+func TestPostgresStorageConformance(t *testing.T) {
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	dsn := os.Getenv("ORACLE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		if testing.Short() {
+			t.Skip("postgres instance is not spawned in short mode")
+		}
+		dsn = startPostgres(t)
+	}
+
+	postgresStorage, err := NewPostgresStorage(dsn, PoolOptions{MaxOpenConns: 4, MaxIdleConns: 2})
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	t.Cleanup(func() { _ = postgresStorage.Close() })
+
+	// a shared database may keep rows of a previous run
+	for _, table := range []string{"user_actions", "user_action_touches", "user_statuses", "raffles", "outbox_messages", "cached_lookups"} {
+		if err := postgresStorage.db.Exec("delete from " + table).Error; err != nil {
+			t.Fatalf("clean %s: %v", table, err)
+		}
+	}
+
+	testStorageConformance(t, func(raffleAddress string) Storage { return postgresStorage.ForRaffle(raffleAddress) })
+}
+
+func startPostgres(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Skipf("no free port for postgres: %v", err)
+	}
+	port := uint32(listener.Addr().(*net.TCPAddr).Port)
+	_ = listener.Close()
+
+	directory := t.TempDir()
+	configuration := embeddedpostgres.DefaultConfig().
+		Port(port).
+		Database("oracle").
+		RuntimePath(filepath.Join(directory, "runtime")).
+		DataPath(filepath.Join(directory, "data")).
+		Logger(nil)
+
+	database := embeddedpostgres.NewDatabase(configuration)
+	if err := database.Start(); err != nil {
+		t.Skipf("cannot start postgres: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Stop() })
+
+	return configuration.GetConnectionURL() + "?sslmode=disable"
+}
+
+func testStorageConformance(t *testing.T, open scopedStorage) {
+	t.Run("raffle", func(t *testing.T) { testRaffleConformance(t, open) })
+	t.Run("user actions", func(t *testing.T) { testUserActionsConformance(t, open) })
+	t.Run("user action touches", func(t *testing.T) { testUserActionTouchesConformance(t, open) })
+	t.Run("pending actions", func(t *testing.T) { testPendingActionsConformance(t, open) })
+	t.Run("user statuses", func(t *testing.T) { testUserStatusesConformance(t, open) })
+	t.Run("outbox", func(t *testing.T) { testOutboxConformance(t, open) })
+	t.Run("transaction", func(t *testing.T) { testTransactionConformance(t, open) })
+	t.Run("cached lookups", func(t *testing.T) { testCachedLookupsConformance(t, open) })
+	t.Run("action verification", func(t *testing.T) { testActionVerificationConformance(t, open) })
+	t.Run("winners", func(t *testing.T) { testWinnersConformance(t, open) })
+}
+
+// raffleAddress gives every subtest its own raffle, the scoping keeps them apart
+func raffleAddress(t *testing.T) string {
+	return "raffle/" + t.Name()
+}
+
+func testRaffleConformance(t *testing.T, open scopedStorage) {
+	address := raffleAddress(t)
+	s := open(address)
+
+	if deployedLt, err := s.GetRaffleDeployedLt(address); err != nil || deployedLt != 0 {
+		t.Fatalf("expected no deployed lt, got %d (%v)", deployedLt, err)
+	}
+
+	if err := s.UpdateRaffleDeployedLt(address, 1000); err != nil {
+		t.Fatalf("update deployed lt: %v", err)
+	}
+
+	err := s.UpdateRaffleConditions(address, []*RaffleCondition{
+		{Position: 0, Slot: "white", Kind: "mint", Target: 3},
+		{Position: 1, Slot: "black", Kind: "purchase", Target: 1},
+		{Position: 2, Slot: "hold", Kind: "hold", Target: 5},
+	})
+	if err != nil {
+		t.Fatalf("update conditions: %v", err)
+	}
+
+	// updated conditions replace the previous slots
+	err = s.UpdateRaffleConditions(address, []*RaffleCondition{
+		{Position: 0, Slot: "white", Kind: "mint", Target: 2},
+		{Position: 1, Slot: "black", Kind: "purchase", Target: 1},
+	})
+	if err != nil {
+		t.Fatalf("update conditions: %v", err)
+	}
+
+	raffle, err := s.GetRaffle(address)
+	if err != nil || raffle.DeployedLt != 1000 {
+		t.Errorf("conditions must not reset the deployed lt, got %+v (%v)", raffle, err)
+	}
+
+	conditions, err := s.GetRaffleConditions(address)
+	if err != nil || len(conditions) != 2 || conditions[0].Slot != "white" || conditions[0].Target != 2 || conditions[1].Slot != "black" {
+		t.Errorf("expected the white and black slots in order, got %+v (%v)", conditions, err)
+	}
+
+	if err := s.UpdateRaffleDeployedLt(address, 2000); err != nil {
+		t.Fatalf("update deployed lt: %v", err)
+	}
+
+	if deployedLt, err := s.GetRaffleDeployedLt(address); err != nil || deployedLt != 2000 {
+		t.Errorf("expected deployed lt 2000, got %d (%v)", deployedLt, err)
+	}
+}
+
+func testUserActionsConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	err := s.UpdateUserActions([]*UserAction{
+		{ActionType: WhiteTicketMintedActionType, UserAddress: "user1", Address: "item1", TransactionHash: "hash1", TransactionLt: 20},
+		{ActionType: WhiteTicketMintedActionType, UserAddress: "user1", Address: "item2", TransactionHash: "hash2", TransactionLt: 10},
+		// the same action twice in one batch, the last one wins
+		{ActionType: WhiteTicketMintedActionType, UserAddress: "user1", Address: "item1", TransactionHash: "hash3", TransactionLt: 30},
+		{ActionType: CandidateRegistrationActionType, UserAddress: "user1", Address: "candidate1", TransactionHash: "hash4", TransactionLt: 5},
+	})
+	if err != nil {
+		t.Fatalf("update actions: %v", err)
+	}
+
+	// a later batch updates the stored action
+	err = s.UpdateUserActions([]*UserAction{
+		{ActionType: WhiteTicketMintedActionType, UserAddress: "user1", Address: "item2", TransactionHash: "hash5", TransactionLt: 40},
+	})
+	if err != nil {
+		t.Fatalf("update actions: %v", err)
+	}
+
+	actions, err := s.GetUserActions(WhiteTicketMintedActionType)
+	if err != nil {
+		t.Fatalf("get actions: %v", err)
+	}
+
+	hashes := make(map[string]string)
+	for _, action := range actions {
+		hashes[action.Address] = action.TransactionHash
+	}
+
+	if len(actions) != 2 || hashes["item1"] != "hash3" || hashes["item2"] != "hash5" {
+		t.Errorf("unexpected upserted actions %v", hashes)
+	}
+
+	actions, err = s.GetUserActionsByUser("user1")
+	if err != nil {
+		t.Fatalf("get actions by user: %v", err)
+	}
+
+	if len(actions) != 3 || actions[0].TransactionLt != 5 || actions[2].TransactionLt != 40 {
+		t.Errorf("expected 3 actions ordered by lt, got %d", len(actions))
+	}
+
+	if actions, err := open(raffleAddress(t) + "/other").GetUserActionsByUser("user1"); err != nil || len(actions) != 0 {
+		t.Errorf("actions leaked to another raffle: %d (%v)", len(actions), err)
+	}
+}
+
+func testUserActionTouchesConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	if lt, err := s.GetUserActionTouch(CandidateRegistrationActionType); err != nil || lt != 0 {
+		t.Fatalf("expected no touch, got %d (%v)", lt, err)
+	}
+
+	for _, touch := range []*UserActionTouch{
+		{ActionType: CandidateRegistrationActionType, UserAddress: "-", TransactionLt: 100},
+		{ActionType: CandidateRegistrationActionType, UserAddress: "-", TransactionLt: 200},
+		{ActionType: BlackTicketPurchasedActionType, UserAddress: "user1", TransactionLt: 300},
+	} {
+		if err := s.UpdateUserActionTouch(touch); err != nil {
+			t.Fatalf("update touch: %v", err)
+		}
+	}
+
+	if lt, err := s.GetUserActionTouch(CandidateRegistrationActionType); err != nil || lt != 200 {
+		t.Errorf("expected touch 200, got %d (%v)", lt, err)
+	}
+
+	if lt, err := s.GetUserActionTouchByAddress(BlackTicketPurchasedActionType, "user1"); err != nil || lt != 300 {
+		t.Errorf("expected user touch 300, got %d (%v)", lt, err)
+	}
+
+	if lt, err := s.GetUserActionTouchByAddress(BlackTicketPurchasedActionType, "user2"); err != nil || lt != 0 {
+		t.Errorf("expected no user touch, got %d (%v)", lt, err)
+	}
+
+	touches, err := s.GetUserActionTouchesByAddress("user1")
+	if err != nil || len(touches) != 1 || touches[0].ActionType != BlackTicketPurchasedActionType || touches[0].TransactionLt != 300 {
+		t.Errorf("expected the purchase touch of user 1, got %+v (%v)", touches, err)
+	}
+
+	if err := s.DeleteUserActionTouchesByAddress("user1"); err != nil {
+		t.Fatalf("delete user touches: %v", err)
+	}
+
+	if lt, err := s.GetUserActionTouchByAddress(BlackTicketPurchasedActionType, "user1"); err != nil || lt != 0 {
+		t.Errorf("expected the user touch deleted, got %d (%v)", lt, err)
+	}
+
+	if lt, err := s.GetUserActionTouch(CandidateRegistrationActionType); err != nil || lt != 200 {
+		t.Errorf("expected the other touches kept, got %d (%v)", lt, err)
+	}
+}
+
+func testPendingActionsConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	err := s.UpdateUserActions([]*UserAction{
+		{ActionType: CandidateRegistrationActionType, UserAddress: "user1", Address: "candidate1", TransactionLt: 10},
+		{ActionType: CandidateRegistrationActionType, UserAddress: "user2", Address: "candidate2", TransactionLt: 11},
+		{ActionType: WhiteTicketMintedActionType, UserAddress: "user1", Address: "white1", TransactionLt: 20},
+		{ActionType: BlackTicketPurchasedActionType, UserAddress: "user1", Address: "black1", TransactionLt: 30},
+		{ActionType: BlackTicketPurchasedActionType, UserAddress: "user1", Address: "black2", Counterparty: "user1b", TransactionLt: 35, FlagReason: "closed_loop"},
+		{ActionType: ParticipantRegistrationActionType, UserAddress: "user1", Address: "participant1", TransactionLt: 40},
+	})
+	if err != nil {
+		t.Fatalf("update actions: %v", err)
+	}
+
+	assertPending := func(name string, get func() ([]*UserAction, error), expected int) {
+		t.Helper()
+
+		actions, err := get()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(actions) != expected {
+			t.Errorf("%s: expected %d pending actions, got %d", name, expected, len(actions))
+		}
+	}
+
+	// tickets only count once the user is a known candidate
+	assertPending("candidate registrations", s.GetPendingCandidateRegistrationActions, 2)
+	assertPending("white tickets", pendingConditionActions(s, WhiteTicketMintedActionType), 0)
+	assertPending("black tickets", pendingConditionActions(s, BlackTicketPurchasedActionType), 0)
+	assertPending("participant registrations", s.GetPendingParticipantRegistrationActions, 1)
+
+	err = s.UpdateUserStatuses([]*UserStatus{{UserAddress: "user1", CandidateRegistrationLt: 10}})
+	if err != nil {
+		t.Fatalf("update statuses: %v", err)
+	}
+
+	// the flagged purchase is excluded
+	assertPending("candidate registrations", s.GetPendingCandidateRegistrationActions, 1)
+	assertPending("white tickets", pendingConditionActions(s, WhiteTicketMintedActionType), 1)
+	assertPending("black tickets", pendingConditionActions(s, BlackTicketPurchasedActionType), 1)
+
+	err = s.UpdateUserConditions([]*UserCondition{
+		{UserAddress: "user1", Slot: WhiteTicketMintedActionType, Value: 1, ProcessedLt: 20},
+		{UserAddress: "user1", Slot: BlackTicketPurchasedActionType, Value: 1, ProcessedLt: 30},
+	})
+	if err != nil {
+		t.Fatalf("update conditions: %v", err)
+	}
+
+	assertPending("candidate registrations", s.GetPendingCandidateRegistrationActions, 1)
+	assertPending("white tickets", pendingConditionActions(s, WhiteTicketMintedActionType), 0)
+	assertPending("black tickets", pendingConditionActions(s, BlackTicketPurchasedActionType), 0)
+}
+
+func pendingConditionActions(s Storage, slot string) func() ([]*UserAction, error) {
+	return func() ([]*UserAction, error) { return s.GetPendingConditionActions(slot) }
+}
+
+func testUserStatusesConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	err := s.UpdateUserStatuses([]*UserStatus{
+		{UserAddress: "user1", CandidateRegistrationLt: 10},
+		{UserAddress: "user2", CandidateRegistrationLt: 11},
+		// the same user twice in one batch, the last one wins
+		{UserAddress: "user1", CandidateRegistrationLt: 10, ParticipantRegistrationLt: 50},
+	})
+	if err != nil {
+		t.Fatalf("update statuses: %v", err)
+	}
+
+	// a single status update keeps the participant registration
+	err = s.UpdateUserStatus(&UserStatus{UserAddress: "user1", CandidateRegistrationLt: 10, ConditionsReached: true})
+	if err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+
+	userStatus, err := s.GetUserStatusByAddress("user1")
+	if err != nil {
+		t.Fatalf("get status: %v", err)
+	}
+
+	if !userStatus.ConditionsReached || userStatus.ParticipantRegistrationLt != 50 {
+		t.Errorf("unexpected upserted status %+v", userStatus)
+	}
+
+	err = s.UpdateUserConditions([]*UserCondition{
+		{UserAddress: "user1", Slot: "white", Value: 1, ProcessedLt: 20},
+		{UserAddress: "user1", Slot: "black", Value: 1, ProcessedLt: 30},
+		{UserAddress: "user2", Slot: "white", Value: 1, ProcessedLt: 21},
+		// the same slot twice in one batch, the last one wins
+		{UserAddress: "user2", Slot: "white", Value: 2, ProcessedLt: 22},
+	})
+	if err != nil {
+		t.Fatalf("update conditions: %v", err)
+	}
+
+	conditions, err := s.GetUserConditions([]string{"user2", "user3"})
+	if err != nil || len(conditions) != 1 || conditions[0].Value != 2 || conditions[0].ProcessedLt != 22 {
+		t.Errorf("expected the last condition of user2, got %+v (%v)", conditions, err)
+	}
+
+	if _, err := s.GetUserStatusByAddress("user3"); err == nil {
+		t.Errorf("expected an error for a missing status")
+	}
+
+	userStatuses, err := s.GetUserStatusesByAddresses([]string{"user1", "user2", "user3"})
+	if err != nil || len(userStatuses) != 2 {
+		t.Errorf("expected 2 statuses, got %d (%v)", len(userStatuses), err)
+	}
+
+	userStatuses, err = s.GetUserStatusesByConditionsReached()
+	if err != nil || len(userStatuses) != 1 || userStatuses[0].UserAddress != "user1" {
+		t.Errorf("expected user1 to reach the conditions, got %d (%v)", len(userStatuses), err)
+	}
+
+	statistics, err := s.GetRaffleStatistics()
+	if err != nil {
+		t.Fatalf("get statistics: %v", err)
+	}
+
+	expected := RaffleStatistics{Candidates: 2, ConditionsReached: 1, Participants: 1, Conditions: map[string]int64{"white": 2, "black": 1}}
+	if !reflect.DeepEqual(*statistics, expected) {
+		t.Errorf("expected statistics %+v, got %+v", expected, *statistics)
+	}
+}
+
+func testOutboxConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	first := &OutboxMessage{UserAddress: "user1", State: QueuedOutboxState, Conditions: []ConditionValue{{Slot: "white", Value: 1, ProcessedLt: 20}}}
+	second := &OutboxMessage{UserAddress: "user2", State: QueuedOutboxState, Conditions: []ConditionValue{{Slot: "black", Value: 1}}}
+	for _, message := range []*OutboxMessage{first, second} {
+		if err := s.CreateOutboxMessage(message); err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+	}
+
+	if first.ID == 0 || second.ID <= first.ID {
+		t.Fatalf("expected increasing ids, got %d and %d", first.ID, second.ID)
+	}
+
+	first.State = SentOutboxState
+	first.Attempts = 1
+	first.QueryID = 1 << 40
+	if err := s.UpdateOutboxMessage(first); err != nil {
+		t.Fatalf("update message: %v", err)
+	}
+
+	messages, err := s.GetOutboxMessages(QueuedOutboxState, SentOutboxState)
+	if err != nil || len(messages) != 2 || messages[0].ID != first.ID {
+		t.Fatalf("expected 2 messages ordered by id, got %d (%v)", len(messages), err)
+	}
+
+	if messages[0].State != SentOutboxState || messages[0].Attempts != 1 || messages[0].QueryID != 1<<40 || !reflect.DeepEqual(messages[0].Conditions, first.Conditions) {
+		t.Errorf("unexpected updated message %+v", messages[0])
+	}
+
+	messages, err = s.GetOutboxMessagesByUser("user2")
+	if err != nil || len(messages) != 1 || messages[0].ID != second.ID {
+		t.Errorf("expected the message of user2, got %d (%v)", len(messages), err)
+	}
+}
+
+func testTransactionConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	write := func(tx Storage, lt int64) error {
+		err := tx.UpdateUserActions([]*UserAction{
+			{ActionType: WhiteTicketMintedActionType, UserAddress: "user1", Address: "item", TransactionHash: "hash", TransactionLt: lt},
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateUserActionTouch(&UserActionTouch{ActionType: WhiteTicketMintedActionType, UserAddress: "-", TransactionLt: lt})
+	}
+
+	failure := errors.New("failure")
+	if err := s.Transaction(func(tx Storage) error { return errors.Join(write(tx, 100), failure) }); !errors.Is(err, failure) {
+		t.Fatalf("expected the transaction error, got %v", err)
+	}
+
+	actions, err := s.GetUserActions(WhiteTicketMintedActionType)
+	if err != nil || len(actions) != 0 {
+		t.Errorf("expected rolled back actions, got %d (%v)", len(actions), err)
+	}
+
+	if lt, err := s.GetUserActionTouch(WhiteTicketMintedActionType); err != nil || lt != 0 {
+		t.Errorf("expected rolled back touch, got %d (%v)", lt, err)
+	}
+
+	if err := s.Transaction(func(tx Storage) error { return write(tx, 200) }); err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+
+	actions, err = s.GetUserActions(WhiteTicketMintedActionType)
+	if err != nil || len(actions) != 1 || actions[0].RaffleAddress != raffleAddress(t) {
+		t.Errorf("expected one committed action of the raffle, got %+v (%v)", actions, err)
+	}
+
+	if lt, err := s.GetUserActionTouch(WhiteTicketMintedActionType); err != nil || lt != 200 {
+		t.Errorf("expected committed touch 200, got %d (%v)", lt, err)
+	}
+}
+
+func testCachedLookupsConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	if _, ok, err := s.GetCachedLookup("trace", "missing"); err != nil || ok {
+		t.Fatalf("expected a miss, got %v, %v", ok, err)
+	}
+
+	if err := s.UpdateCachedLookup("trace", raffleAddress(t), "first"); err != nil {
+		t.Fatalf("update cached lookup: %v", err)
+	}
+
+	if err := s.UpdateCachedLookup("trace", raffleAddress(t), "second"); err != nil {
+		t.Fatalf("update cached lookup again: %v", err)
+	}
+
+	// the cache is not scoped by raffle and keeps the first value
+	value, ok, err := open(raffleAddress(t)+"/other").GetCachedLookup("trace", raffleAddress(t))
+	if err != nil || !ok || value != "first" {
+		t.Errorf("expected the first value from another raffle, got %q, %v, %v", value, ok, err)
+	}
+
+	if _, ok, _ := s.GetCachedLookup("item_collection", raffleAddress(t)); ok {
+		t.Error("expected the lookups to be keyed by kind")
+	}
+}
+
+func testActionVerificationConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	err := s.UpdateUserActions([]*UserAction{
+		{ActionType: CandidateRegistrationActionType, UserAddress: "user1", Address: "candidate1", TransactionHash: "h1", TransactionLt: 10},
+		{ActionType: BlackTicketPurchasedActionType, UserAddress: "user1", Address: "black1", TransactionHash: "h2", TransactionLt: 20},
+		{ActionType: BlackTicketPurchasedActionType, UserAddress: "user1", Address: "black2", TransactionHash: "h3", TransactionLt: 30},
+	})
+	if err != nil {
+		t.Fatalf("update actions: %v", err)
+	}
+
+	// the actions above the final lt wait, the window is oldest first
+	actions, err := s.GetUnverifiedUserActions(25, 1)
+	if err != nil || len(actions) != 1 || actions[0].TransactionHash != "h1" {
+		t.Fatalf("expected the oldest unverified action, got %+v (%v)", actions, err)
+	}
+
+	if err := s.MarkUserActionsVerified([]int64{actions[0].ID}); err != nil {
+		t.Fatalf("mark verified: %v", err)
+	}
+
+	actions, err = s.GetUnverifiedUserActions(25, 10)
+	if err != nil || len(actions) != 1 || actions[0].TransactionHash != "h2" {
+		t.Fatalf("expected the verified action skipped, got %+v (%v)", actions, err)
+	}
+
+	if err := s.DeleteUserActions([]int64{actions[0].ID}); err != nil {
+		t.Fatalf("delete actions: %v", err)
+	}
+
+	if actions, err := s.GetUserActions(BlackTicketPurchasedActionType); err != nil || len(actions) != 1 || actions[0].Address != "black2" {
+		t.Errorf("expected the deleted purchase gone, got %+v (%v)", actions, err)
+	}
+
+	// an action collected again with the same transaction stays verified
+	err = s.UpdateUserActions([]*UserAction{
+		{ActionType: CandidateRegistrationActionType, UserAddress: "user1", Address: "candidate1", TransactionHash: "h1", TransactionLt: 10},
+	})
+	if err != nil {
+		t.Fatalf("update actions again: %v", err)
+	}
+
+	if actions, err := s.GetUnverifiedUserActions(25, 10); err != nil || len(actions) != 0 {
+		t.Errorf("expected the recollected action still verified, got %+v (%v)", actions, err)
+	}
+
+	// an action stored again with another transaction is verified again
+	err = s.UpdateUserActions([]*UserAction{
+		{ActionType: CandidateRegistrationActionType, UserAddress: "user1", Address: "candidate1", TransactionHash: "h4", TransactionLt: 11},
+	})
+	if err != nil {
+		t.Fatalf("update actions again: %v", err)
+	}
+
+	if actions, err := s.GetUnverifiedUserActions(25, 10); err != nil || len(actions) != 1 || actions[0].TransactionHash != "h4" {
+		t.Errorf("expected the updated action unverified, got %+v (%v)", actions, err)
+	}
+
+	if err := s.UpdateUserStatuses([]*UserStatus{{UserAddress: "user1", CandidateRegistrationLt: 10}}); err != nil {
+		t.Fatalf("update statuses: %v", err)
+	}
+
+	if err := s.UpdateUserConditions([]*UserCondition{{UserAddress: "user1", Slot: "black", Value: 1, ProcessedLt: 30}}); err != nil {
+		t.Fatalf("update conditions: %v", err)
+	}
+
+	if err := s.DeleteUserStatus("user1"); err != nil {
+		t.Fatalf("delete status: %v", err)
+	}
+
+	if statuses, err := s.GetUserStatusesByAddresses([]string{"user1"}); err != nil || len(statuses) != 0 {
+		t.Errorf("expected the status deleted, got %+v (%v)", statuses, err)
+	}
+
+	if conditions, err := s.GetUserConditions([]string{"user1"}); err != nil || len(conditions) != 0 {
+		t.Errorf("expected the conditions deleted with the status, got %+v (%v)", conditions, err)
+	}
+}
+
+func testWinnersConformance(t *testing.T, open scopedStorage) {
+	s := open(raffleAddress(t))
+
+	err := s.UpdateWinners([]*Winner{
+		{WinnerIndex: 0, State: DrawingWinnerState, MessageHash: "m1", SentAt: time.Unix(100, 0)},
+		{WinnerIndex: 1, State: DrawingWinnerState, MessageHash: "m1", SentAt: time.Unix(100, 0)},
+	})
+	if err != nil {
+		t.Fatalf("update winners: %v", err)
+	}
+
+	// the drawn winner replaces the drawing one of its index
+	err = s.UpdateWinners([]*Winner{
+		{WinnerIndex: 0, State: DrawnWinnerState, ParticipantIndex: 1 << 40, ParticipantAddress: "participant1", UserAddress: "user1", TransactionHash: "h1", TransactionLt: 10},
+	})
+	if err != nil {
+		t.Fatalf("update drawn winner: %v", err)
+	}
+
+	winners, err := s.GetWinners()
+	if err != nil || len(winners) != 2 {
+		t.Fatalf("expected 2 winners, got %d (%v)", len(winners), err)
+	}
+
+	if winners[0].State != DrawnWinnerState || winners[0].ParticipantIndex != 1<<40 || winners[0].UserAddress != "user1" || winners[1].State != DrawingWinnerState {
+		t.Errorf("unexpected winners %+v %+v", winners[0], winners[1])
+	}
+
+	if err := s.DeleteWinners([]int{1}); err != nil {
+		t.Fatalf("delete winners: %v", err)
+	}
+
+	if winners, err := s.GetWinners(); err != nil || len(winners) != 1 || winners[0].WinnerIndex != 0 {
+		t.Errorf("expected the drawing winner deleted, got %+v (%v)", winners, err)
+	}
+
+	if winners, err := open(raffleAddress(t) + "-other").GetWinners(); err != nil || len(winners) != 0 {
+		t.Errorf("expected no winners of another raffle, got %d (%v)", len(winners), err)
+	}
+}
@@ -0,0 +1,692 @@
+package storage
+
+import (
+	"backend/internal/logger"
+	"database/sql"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormStorage implements Storage on top of gorm, the queries are shared by the SQLite and the
+// Postgres backends. User actions, statuses and cursors are scoped by the raffle address.
+type gormStorage struct {
+	db            *gorm.DB
+	raffleAddress string
+}
+
+func (s *gormStorage) Transaction(fn func(tx Storage) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&gormStorage{db: tx, raffleAddress: s.raffleAddress})
+	})
+}
+
+func (s *gormStorage) GetRaffle(address string) (*Raffle, error) {
+
+	var raffle Raffle
+	err := s.db.Where("address = ?", address).First(&raffle).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &raffle, nil
+}
+
+func (s *gormStorage) GetRaffleDeployedLt(address string) (int64, error) {
+	logger.Debug("getting raffle deployed lt...", zap.String("address", address))
+
+	var deployedLt int64
+	err := s.db.Raw(`
+		select coalesce(max(deployed_lt), 0) as deployed_lt
+		from raffles
+		where address = ?
+	`, address).Scan(&deployedLt).Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Debug("getting raffle deployed lt... done", zap.Int64("deployedLt", deployedLt))
+	return deployedLt, nil
+}
+
+func (s *gormStorage) UpdateRaffleDeployedLt(address string, deployedLt int64) error {
+	logger.Debug("updating raffle deployed lt...", zap.String("address", address), zap.Int64("deployedLt", deployedLt))
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"deployed_lt"}),
+	}).Create(&Raffle{Address: address, DeployedLt: deployedLt}).Error
+
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("updating raffle deployed lt... done")
+	return nil
+}
+
+func (s *gormStorage) GetRaffleConditions(address string) ([]*RaffleCondition, error) {
+
+	var conditions []*RaffleCondition
+	err := s.db.Where("raffle_address = ?", address).Order("position").Find(&conditions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return conditions, nil
+}
+
+func (s *gormStorage) UpdateRaffleConditions(address string, conditions []*RaffleCondition) error {
+	logger.Debug("updating raffle conditions...", zap.String("address", address))
+
+	for _, condition := range conditions {
+		condition.RaffleAddress = address
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("raffle_address = ?", address).Delete(&RaffleCondition{}).Error; err != nil {
+			return err
+		}
+
+		if len(conditions) == 0 {
+			return nil
+		}
+
+		return tx.Create(conditions).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("updating raffle conditions... done")
+	return nil
+}
+
+func (s *gormStorage) GetRaffleStatistics() (*RaffleStatistics, error) {
+	logger.Debug("getting raffle statistics...", zap.String("address", s.raffleAddress))
+
+	var statistics RaffleStatistics
+	err := s.db.Raw(`
+		select count(*) as candidates,
+		       coalesce(sum(case when s.conditions_reached then 1 else 0 end), 0) as conditions_reached,
+		       coalesce(sum(case when s.participant_registration_lt > 0 then 1 else 0 end), 0) as participants
+		from user_statuses s
+		where s.raffle_address = ?
+	`, s.raffleAddress).Scan(&statistics).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	var slots []struct {
+		Slot  string
+		Users int64
+	}
+	err = s.db.Raw(`
+		select c.slot, count(*) as users
+		from user_conditions c
+		where c.raffle_address = ? and c.value > 0
+		group by c.slot
+	`, s.raffleAddress).Scan(&slots).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	statistics.Conditions = make(map[string]int64, len(slots))
+	for _, slot := range slots {
+		statistics.Conditions[slot.Slot] = slot.Users
+	}
+
+	logger.Debug("getting raffle statistics... done")
+	return &statistics, nil
+}
+
+func (s *gormStorage) GetUserActions(actionType ActionType) ([]*UserAction, error) {
+
+	var actions []*UserAction
+	err := s.db.Where("raffle_address = ? and action_type = ?", s.raffleAddress, actionType).Find(&actions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+func (s *gormStorage) GetUserActionsByUser(userAddress string) ([]*UserAction, error) {
+
+	var actions []*UserAction
+	err := s.db.Where("raffle_address = ? and user_address = ?", s.raffleAddress, userAddress).Order("transaction_lt").Find(&actions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+func (s *gormStorage) UpdateUserActions(actions []*UserAction) error {
+	logger.Debug("update pending user actions...")
+
+	if len(actions) == 0 {
+		logger.Debug("no pending user actions to persist")
+		return nil
+	}
+
+	actionAddresses := mapToStrings(actions, func(action *UserAction) string { return action.UserAddress })
+	logger.Info("found new pending user actions", zap.Strings("actions", actionAddresses))
+
+	for _, action := range actions {
+		action.RaffleAddress = s.raffleAddress
+	}
+
+	// postgres refuses to upsert the same row twice in one statement
+	actions = lastByKey(actions, func(action *UserAction) string {
+		return action.ActionType + "|" + action.UserAddress + "|" + action.Address
+	})
+
+	// an action collected again stays verified unless it now points at another transaction
+	doUpdates := append(clause.AssignmentColumns([]string{"transaction_lt", "transaction_hash"}), clause.Assignment{
+		Column: clause.Column{Name: "verified"},
+		Value: gorm.Expr("case when user_actions.transaction_hash = excluded.transaction_hash and user_actions.transaction_lt = excluded.transaction_lt " +
+			"then user_actions.verified else excluded.verified end"),
+	})
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "raffle_address"}, {Name: "action_type"}, {Name: "user_address"}, {Name: "address"}},
+		DoUpdates: doUpdates,
+	}).CreateInBatches(actions, 100).Error
+
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("update pending user actions...done")
+	return nil
+}
+
+func (s *gormStorage) GetUnverifiedUserActions(finalLt int64, limit int) ([]*UserAction, error) {
+
+	var actions []*UserAction
+	err := s.db.Where("raffle_address = ? and not coalesce(verified, false) and transaction_lt <= ?", s.raffleAddress, finalLt).
+		Order("transaction_lt").Limit(limit).Find(&actions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+func (s *gormStorage) MarkUserActionsVerified(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return s.db.Model(&UserAction{}).Where("raffle_address = ? and id in ?", s.raffleAddress, ids).Update("verified", true).Error
+}
+
+func (s *gormStorage) DeleteUserActions(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	logger.Info("deleting user actions", zap.Int64s("ids", ids))
+	return s.db.Where("raffle_address = ? and id in ?", s.raffleAddress, ids).Delete(&UserAction{}).Error
+}
+
+func (s *gormStorage) GetUserActionTouch(actionType ActionType) (int64, error) {
+	logger.Debug("getting last action transaction...")
+
+	var transactionLt int64
+	err := s.db.Raw(`
+		select coalesce(max(transaction_lt), 0) as transaction_lt
+		from user_action_touches
+		where raffle_address = ? and action_type = ?
+	`, s.raffleAddress, actionType).Scan(&transactionLt).Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Debug("getting last action transaction... done", zap.Int64("transactionLt", transactionLt))
+	return transactionLt, nil
+}
+
+func (s *gormStorage) GetUserActionTouchByAddress(actionType ActionType, address string) (int64, error) {
+	logger.Debug("getting last action transaction by user", zap.String("action type", actionType), zap.String("address", address))
+
+	var transactionLt int64
+	err := s.db.Raw(`
+		select coalesce(max(transaction_lt), 0) as transaction_lt
+		from user_action_touches
+		where raffle_address = ? and action_type = ? and user_address = ?
+	`, s.raffleAddress, actionType, address).Scan(&transactionLt).Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Debug("getting last action transaction by user... done")
+	return transactionLt, nil
+}
+
+func (s *gormStorage) UpdateUserActionTouch(actionTouch *UserActionTouch) error {
+	logger.Debug("updating pending action touch...")
+
+	actionTouch.RaffleAddress = s.raffleAddress
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "raffle_address"}, {Name: "action_type"}, {Name: "user_address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"transaction_lt"}),
+	}).Create(&actionTouch).Error
+
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("updating pending action touch...done")
+	return nil
+}
+
+func (s *gormStorage) GetUserActionTouchesByAddress(address string) ([]*UserActionTouch, error) {
+
+	var touches []*UserActionTouch
+	err := s.db.Where("raffle_address = ? and user_address = ?", s.raffleAddress, address).Order("action_type").Find(&touches).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return touches, nil
+}
+
+func (s *gormStorage) DeleteUserActionTouchesByAddress(address string) error {
+	logger.Info("deleting user action touches", zap.String("userAddress", address))
+
+	return s.db.Where("raffle_address = ? and user_address = ?", s.raffleAddress, address).Delete(&UserActionTouch{}).Error
+}
+
+func (s *gormStorage) GetPendingCandidateRegistrationActions() ([]*UserAction, error) {
+	logger.Debug("getting pending candidate registration actions...")
+
+	rows, err := s.db.Raw(`
+		select a.*
+		from user_actions a
+			left join user_statuses s on s.raffle_address = a.raffle_address and s.user_address = a.user_address
+		where a.raffle_address = ? and a.action_type = ? and s.user_address is null
+	`, s.raffleAddress, CandidateRegistrationActionType).Rows()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.Warn("failed to close rows", zap.Error(err))
+		}
+	}(rows)
+
+	var actions = make([]*UserAction, 0)
+	for rows.Next() {
+		var userAction UserAction
+
+		if err := s.db.ScanRows(rows, &userAction); err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, &userAction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("getting pending candidate registration actions... done")
+	return actions, nil
+}
+
+func (s *gormStorage) GetPendingParticipantRegistrationActions() ([]*UserAction, error) {
+	logger.Debug("getting pending participant registration actions...")
+
+	rows, err := s.db.Raw(`
+		select a.*
+		from user_actions a
+		where a.raffle_address = ? and a.action_type = ?
+	`, s.raffleAddress, ParticipantRegistrationActionType).Rows()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.Warn("failed to close rows", zap.Error(err))
+		}
+	}(rows)
+
+	var actions = make([]*UserAction, 0)
+	for rows.Next() {
+		var userAction UserAction
+
+		if err := s.db.ScanRows(rows, &userAction); err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, &userAction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("getting pending participant registration actions... done")
+	return actions, nil
+}
+
+// GetPendingConditionActions returns the actions of a condition slot not counted in the
+// confirmed value of their candidate yet
+func (s *gormStorage) GetPendingConditionActions(slot string) ([]*UserAction, error) {
+	logger.Debug("getting pending condition actions...", zap.String("slot", slot))
+
+	var actions = make([]*UserAction, 0)
+	err := s.db.Raw(`
+		select a.*
+		from user_actions a
+			join user_statuses s on s.raffle_address = a.raffle_address and s.user_address = a.user_address
+			left join user_conditions c on c.raffle_address = a.raffle_address and c.user_address = a.user_address and c.slot = a.action_type
+		where a.raffle_address = ? and a.action_type = ?
+		  and coalesce(a.flag_reason, '') = ''
+		  and (c.processed_lt is null or c.processed_lt < a.transaction_lt)
+		order by a.transaction_lt
+	`, s.raffleAddress, slot).Scan(&actions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("getting pending condition actions... done", zap.Int("actions", len(actions)))
+	return actions, nil
+}
+
+func (s *gormStorage) GetUserStatusesByConditionsReached() ([]*UserStatus, error) {
+	var userStatuses []*UserStatus
+	tx := s.db.Where("raffle_address = ? and conditions_reached", s.raffleAddress).Find(&userStatuses)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return userStatuses, nil
+}
+
+func (s *gormStorage) GetUserStatusByAddress(address string) (*UserStatus, error) {
+
+	var userStatus UserStatus
+	err := s.db.Where("raffle_address = ? and user_address = ?", s.raffleAddress, address).First(&userStatus).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &userStatus, nil
+}
+
+func (s *gormStorage) GetUserStatusesByAddresses(addresses []string) ([]*UserStatus, error) {
+
+	var userStatuses []*UserStatus
+	tx := s.db.Where("raffle_address = ? and user_address in ?", s.raffleAddress, addresses).Find(&userStatuses)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return userStatuses, nil
+}
+
+func (s *gormStorage) UpdateUserStatus(action *UserStatus) error {
+	logger.Debug("updating user status...", zap.String("userAddress", action.UserAddress))
+
+	action.RaffleAddress = s.raffleAddress
+
+	tx := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "raffle_address"}, {Name: "user_address"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"last_deployed_unix_time",
+			"conditions_reached",
+		}),
+	}).Create(&action)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	logger.Debug("updating user status...done")
+	return nil
+}
+
+func (s *gormStorage) UpdateUserStatuses(userStatuses []*UserStatus) error {
+	logger.Debug("update user statuses...")
+
+	if len(userStatuses) == 0 {
+		logger.Debug("no user statuses to persist")
+		return nil
+	}
+
+	for _, userStatus := range userStatuses {
+		userStatus.RaffleAddress = s.raffleAddress
+	}
+
+	userStatuses = lastByKey(userStatuses, func(userStatus *UserStatus) string { return userStatus.UserAddress })
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "raffle_address"}, {Name: "user_address"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"participant_registration_lt",
+			"last_deployed_unix_time",
+			"conditions_reached",
+		}),
+	}).CreateInBatches(userStatuses, 100).Error
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("update user statuses... done")
+	return nil
+}
+
+func (s *gormStorage) DeleteUserStatus(address string) error {
+	logger.Info("deleting user status", zap.String("userAddress", address))
+
+	if err := s.db.Where("raffle_address = ? and user_address = ?", s.raffleAddress, address).Delete(&UserCondition{}).Error; err != nil {
+		return err
+	}
+
+	return s.db.Where("raffle_address = ? and user_address = ?", s.raffleAddress, address).Delete(&UserStatus{}).Error
+}
+
+func (s *gormStorage) GetUserConditions(addresses []string) ([]*UserCondition, error) {
+
+	var conditions []*UserCondition
+	tx := s.db.Where("raffle_address = ? and user_address in ?", s.raffleAddress, addresses).Order("user_address, slot").Find(&conditions)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return conditions, nil
+}
+
+func (s *gormStorage) UpdateUserConditions(conditions []*UserCondition) error {
+	logger.Debug("update user conditions...")
+
+	if len(conditions) == 0 {
+		logger.Debug("no user conditions to persist")
+		return nil
+	}
+
+	for _, condition := range conditions {
+		condition.RaffleAddress = s.raffleAddress
+	}
+
+	conditions = lastByKey(conditions, func(condition *UserCondition) string { return condition.UserAddress + "/" + condition.Slot })
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "raffle_address"}, {Name: "user_address"}, {Name: "slot"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "processed_lt"}),
+	}).CreateInBatches(conditions, 100).Error
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("update user conditions... done")
+	return nil
+}
+
+func (s *gormStorage) GetOutboxMessages(states ...OutboxState) ([]*OutboxMessage, error) {
+
+	var messages []*OutboxMessage
+	err := s.db.Where("raffle_address = ? and state in ?", s.raffleAddress, states).Order("id").Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStorage) GetOutboxMessagesByUser(userAddress string) ([]*OutboxMessage, error) {
+
+	var messages []*OutboxMessage
+	err := s.db.Where("raffle_address = ? and user_address = ?", s.raffleAddress, userAddress).Order("id").Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStorage) CreateOutboxMessage(message *OutboxMessage) error {
+	logger.Debug("creating outbox message...", zap.String("userAddress", message.UserAddress))
+
+	message.RaffleAddress = s.raffleAddress
+	if err := s.db.Create(message).Error; err != nil {
+		return err
+	}
+
+	logger.Debug("creating outbox message... done", zap.Int64("id", message.ID))
+	return nil
+}
+
+func (s *gormStorage) UpdateOutboxMessage(message *OutboxMessage) error {
+	logger.Debug("updating outbox message...", zap.Int64("id", message.ID), zap.String("state", message.State))
+
+	message.RaffleAddress = s.raffleAddress
+	if err := s.db.Save(message).Error; err != nil {
+		return err
+	}
+
+	logger.Debug("updating outbox message... done")
+	return nil
+}
+
+func (s *gormStorage) GetWinners() ([]*Winner, error) {
+
+	var winners []*Winner
+	err := s.db.Where("raffle_address = ?", s.raffleAddress).Order("winner_index").Find(&winners).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return winners, nil
+}
+
+// UpdateWinners upserts the winners by index, a drawn winner replaces the drawing one
+func (s *gormStorage) UpdateWinners(winners []*Winner) error {
+	logger.Debug("update winners...")
+
+	if len(winners) == 0 {
+		logger.Debug("no winners to persist")
+		return nil
+	}
+
+	for _, winner := range winners {
+		winner.RaffleAddress = s.raffleAddress
+	}
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "raffle_address"}, {Name: "winner_index"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"state",
+			"participant_index",
+			"participant_address",
+			"user_address",
+			"transaction_hash",
+			"transaction_lt",
+			"message_hash",
+			"sent_at",
+			"updated_at",
+		}),
+	}).Create(winners).Error
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("update winners... done")
+	return nil
+}
+
+func (s *gormStorage) DeleteWinners(winnerIndexes []int) error {
+	if len(winnerIndexes) == 0 {
+		return nil
+	}
+
+	return s.db.Where("raffle_address = ? and winner_index in ?", s.raffleAddress, winnerIndexes).Delete(&Winner{}).Error
+}
+
+func (s *gormStorage) GetCachedLookup(kind string, key string) (string, bool, error) {
+
+	var lookup CachedLookup
+	tx := s.db.Where("kind = ? and key = ?", kind, key).Limit(1).Find(&lookup)
+	if tx.Error != nil {
+		return "", false, tx.Error
+	}
+
+	return lookup.Value, tx.RowsAffected > 0, nil
+}
+
+// UpdateCachedLookup keeps the first value of a key, a cached fact never changes
+func (s *gormStorage) UpdateCachedLookup(kind string, key string, value string) error {
+
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&CachedLookup{
+		Kind:  kind,
+		Key:   key,
+		Value: value,
+	}).Error
+}
+
+func mapToStrings[T any](slice []T, extract func(T) string) []string {
+	result := make([]string, len(slice))
+	for i, item := range slice {
+		result[i] = extract(item)
+	}
+	return result
+}
+
+// lastByKey drops the items followed by another one with the same key, keeping the order of
+// the rest, so an upsert batch behaves like the same upserts applied one by one.
+func lastByKey[T any](slice []T, key func(T) string) []T {
+	last := make(map[string]int, len(slice))
+	for i, item := range slice {
+		last[key(item)] = i
+	}
+
+	if len(last) == len(slice) {
+		return slice
+	}
+
+	result := make([]T, 0, len(last))
+	for i, item := range slice {
+		if last[key(item)] == i {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"backend/internal/logger"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+const schemaVersionTable = `create table if not exists schema_version (version integer primary key, name text not null, applied_at timestamp not null)`
+
+// migrationLockID serializes the postgres migrations of processes sharing one database
+const migrationLockID = 7_013_370_001
+
+// Migration is an up migration, the files are named <version>_<name>.sql and applied in
+// version order, each in its own transaction.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is nil for a pending migration
+	AppliedAt *time.Time
+}
+
+type SchemaVersion struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// Database is an opened database whose schema may still be behind the embedded migrations
+type Database struct {
+	db     *gorm.DB
+	driver DriverType
+	name   string
+	// singleRaffleAddress owns the rows of a database created before the multi raffle release
+	singleRaffleAddress string
+}
+
+// SetSingleRaffleAddress sets the raffle the rows of a single raffle database are migrated to
+func (d *Database) SetSingleRaffleAddress(raffleAddress string) {
+	d.singleRaffleAddress = raffleAddress
+}
+
+// Migrations lists the migrations embedded for the driver
+func Migrations(driver DriverType) ([]Migration, error) {
+	directory := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, directory)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %q: %w", driver, err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		versionString, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(versionString)
+		if !ok || err != nil || !strings.HasSuffix(entry.Name(), ".sql") {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+
+		sql, err := fs.ReadFile(migrationFiles, path.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(sql)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// MigrationStatus reports every embedded migration with the time it was applied
+func (d *Database) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := Migrations(d.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]SchemaVersion)
+	if d.db.Migrator().HasTable(&SchemaVersion{}) {
+		var versions []SchemaVersion
+		if err := d.db.Find(&versions).Error; err != nil {
+			return nil, err
+		}
+
+		for _, version := range versions {
+			applied[version.Version] = version
+		}
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = MigrationStatus{Migration: migration}
+		if version, ok := applied[migration.Version]; ok {
+			appliedAt := version.AppliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// Migrate applies the pending migrations and returns them
+func (d *Database) Migrate() ([]Migration, error) {
+	if d.db.Migrator().HasTable(&UserStatus{}) && !d.db.Migrator().HasColumn(&UserStatus{}, "RaffleAddress") {
+		if err := d.migrateSingleRaffle(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.db.Exec(schemaVersionTable).Error; err != nil {
+		return nil, err
+	}
+
+	statuses, err := d.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+
+		logger.Info("migrating database...", zap.Int("version", status.Version), zap.String("name", status.Name))
+		ok, err := d.apply(status.Migration)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", status.Version, status.Name, err)
+		}
+
+		if ok {
+			applied = append(applied, status.Migration)
+		}
+	}
+
+	return applied, nil
+}
+
+// apply runs one migration, false means another process has applied it meanwhile
+func (d *Database) apply(migration Migration) (bool, error) {
+	ok := false
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if d.driver == PostgresDriverType {
+			if err := tx.Exec("select pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+				return err
+			}
+		}
+
+		var count int64
+		if err := tx.Model(&SchemaVersion{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		for _, statement := range splitStatements(migration.SQL) {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		ok = true
+		return tx.Create(&SchemaVersion{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}).Error
+	})
+
+	return ok, err
+}
+
+// migrateSingleRaffle moves the rows of a database created before the multi raffle release to
+// the configured raffle, the embedded migrations then continue from the initial schema.
+func (d *Database) migrateSingleRaffle() error {
+	if d.singleRaffleAddress == "" {
+		return fmt.Errorf("database %s uses the single raffle schema, configure raffle.address to migrate it", d.name)
+	}
+
+	script, err := fs.ReadFile(migrationFiles, path.Join("migrations", "single_raffle", d.driver+".sql"))
+	if err != nil {
+		return fmt.Errorf("database %s uses the single raffle schema, no migration for driver %q: %w", d.name, d.driver, err)
+	}
+
+	logger.Info("migrating single raffle database...", zap.String("raffle address", d.singleRaffleAddress))
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range splitStatements(string(script)) {
+			var args []any
+			if strings.Contains(statement, "@raffle_address") {
+				args = append(args, sql.Named("raffle_address", d.singleRaffleAddress))
+			}
+
+			if err := tx.Exec(statement, args...).Error; err != nil {
+				return fmt.Errorf("single raffle migration: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (d *Database) Close() error {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
+// migrated applies the pending migrations of a database opened by a storage constructor
+func (d *Database) migrated() (*gorm.DB, error) {
+	if _, err := d.Migrate(); err != nil {
+		return nil, errors.Join(err, d.Close())
+	}
+
+	return d.db, nil
+}
+
+// splitStatements splits a migration into statements, comment lines are dropped and a
+// statement ends with a semicolon at the end of a line.
+func splitStatements(sql string) []string {
+	var statements []string
+	var statement strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		statement.WriteString(line)
+		statement.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(statement.String()))
+			statement.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(statement.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+
+	return statements
+}
@@ -0,0 +1,12 @@
+-- the schema of the multi raffle release, databases created by AutoMigrate already match it
+create table if not exists user_actions (id bigserial primary key, raffle_address text, action_type text, user_address text, address text, transaction_hash text not null, transaction_lt bigint not null, transaction_unix_time bigint not null);
+create table if not exists user_action_touches (raffle_address text, action_type text, user_address text, transaction_lt bigint not null, primary key (raffle_address, action_type, user_address));
+create table if not exists user_statuses (raffle_address text, user_address text, white_ticket_minted smallint default 0, black_ticket_purchased smallint default 0, candidate_registration_lt bigint not null, white_ticket_minted_processed_lt bigint default 0, black_ticket_purchased_processed_lt bigint default 0, participant_registration_lt bigint default 0, last_deployed_unix_time bigint default 0, primary key (raffle_address, user_address));
+create table if not exists raffles (address text, deployed_lt bigint not null, white_ticket_minted_target smallint default 0, black_ticket_purchased_target smallint default 0, primary key (address));
+create table if not exists outbox_messages (id bigserial primary key, raffle_address text, user_address text, state text not null, white_ticket_minted smallint not null, white_ticket_minted_processed_lt bigint default 0, black_ticket_purchased smallint not null, black_ticket_purchased_processed_lt bigint default 0, attempts bigint default 0, seqno bigint default 0, query_id bigint default 0, message_hash text, trace_id text, error text, sent_at timestamptz, created_at timestamptz, updated_at timestamptz);
+create index if not exists idx_outbox_messages_state on outbox_messages (state);
+create index if not exists idx_outbox_user on outbox_messages (raffle_address, user_address);
+
+-- keep the first copy of duplicated actions, so the unique index can be built on old data
+delete from user_actions where id not in (select min(id) from user_actions group by raffle_address, action_type, user_address, address);
+create unique index if not exists idx_unique_action on user_actions (raffle_address, action_type, user_address, address);
@@ -0,0 +1,33 @@
+-- the white and black counters become the first two slots of the raffle conditions
+create table if not exists raffle_conditions (raffle_address text, position bigint, slot text not null, kind text, target bigint default 0, primary key (raffle_address, position));
+create table if not exists user_conditions (raffle_address text, user_address text, slot text, value bigint default 0, processed_lt bigint default 0, primary key (raffle_address, user_address, slot));
+
+insert into raffle_conditions (raffle_address, position, slot, kind, target)
+select address, 0, 'WhiteTicketMintedActionType', 'mint', white_ticket_minted_target from raffles
+union all
+select address, 1, 'BlackTicketPurchasedActionType', 'purchase', black_ticket_purchased_target from raffles;
+
+insert into user_conditions (raffle_address, user_address, slot, value, processed_lt)
+select raffle_address, user_address, 'WhiteTicketMintedActionType', white_ticket_minted, white_ticket_minted_processed_lt from user_statuses
+where white_ticket_minted > 0 or white_ticket_minted_processed_lt > 0
+union all
+select raffle_address, user_address, 'BlackTicketPurchasedActionType', black_ticket_purchased, black_ticket_purchased_processed_lt from user_statuses
+where black_ticket_purchased > 0 or black_ticket_purchased_processed_lt > 0;
+
+alter table user_statuses add column if not exists conditions_reached boolean default false;
+update user_statuses s set conditions_reached = exists (
+	select 1 from raffles r
+	where r.address = s.raffle_address
+	  and s.white_ticket_minted >= r.white_ticket_minted_target
+	  and s.black_ticket_purchased >= r.black_ticket_purchased_target
+);
+
+alter table outbox_messages add column if not exists conditions text not null default '[]';
+update outbox_messages set conditions = json_build_array(
+	json_build_object('slot', 'WhiteTicketMintedActionType', 'value', white_ticket_minted, 'processed_lt', white_ticket_minted_processed_lt),
+	json_build_object('slot', 'BlackTicketPurchasedActionType', 'value', black_ticket_purchased, 'processed_lt', black_ticket_purchased_processed_lt)
+)::text;
+
+alter table user_statuses drop column white_ticket_minted, drop column white_ticket_minted_processed_lt, drop column black_ticket_purchased, drop column black_ticket_purchased_processed_lt;
+alter table raffles drop column white_ticket_minted_target, drop column black_ticket_purchased_target;
+alter table outbox_messages drop column white_ticket_minted, drop column white_ticket_minted_processed_lt, drop column black_ticket_purchased, drop column black_ticket_purchased_processed_lt;
@@ -0,0 +1,2 @@
+-- chain facts that cannot change once final, shared by the raffles
+create table if not exists cached_lookups (kind text, key text, value text not null, created_at timestamptz, primary key (kind, key));
@@ -0,0 +1,2 @@
+-- the seller of a purchase and the reason a wash trade is excluded from the counts
+alter table user_actions add column if not exists counterparty text default '', add column if not exists flag_reason text default '';
@@ -0,0 +1,2 @@
+-- the winners drawn by RaffleNext, a drawing row is a message sent and not seen on-chain yet
+create table if not exists winners (raffle_address text, winner_index bigint, state text not null, participant_index bigint default 0, participant_address text, user_address text, transaction_hash text, transaction_lt bigint default 0, message_hash text, sent_at timestamptz, created_at timestamptz, updated_at timestamptz, primary key (raffle_address, winner_index));
@@ -0,0 +1,23 @@
+-- the tables of a database created before the multi raffle release get the raffle address
+-- column in their keys, every row belongs to the configured raffle @raffle_address
+drop index if exists `idx_unique_action`;
+alter table `user_actions` rename to `single_raffle_user_actions`;
+alter table `user_action_touches` rename to `single_raffle_user_action_touches`;
+alter table `user_statuses` rename to `single_raffle_user_statuses`;
+
+create table `user_actions` (`id` integer primary key autoincrement, `raffle_address` text, `action_type` text, `user_address` text, `address` text, `transaction_hash` text not null, `transaction_lt` integer not null, `transaction_unix_time` integer not null);
+insert into `user_actions` (`id`, `raffle_address`, `action_type`, `user_address`, `address`, `transaction_hash`, `transaction_lt`, `transaction_unix_time`)
+select `id`, @raffle_address, `action_type`, `user_address`, `address`, `transaction_hash`, `transaction_lt`, `transaction_unix_time` from `single_raffle_user_actions`;
+create unique index `idx_unique_action` on `user_actions` (`raffle_address`, `action_type`, `user_address`, `address`);
+
+create table `user_action_touches` (`raffle_address` text, `action_type` text, `user_address` text, `transaction_lt` integer not null, primary key (`raffle_address`, `action_type`, `user_address`));
+insert into `user_action_touches` (`raffle_address`, `action_type`, `user_address`, `transaction_lt`)
+select @raffle_address, `action_type`, `user_address`, `transaction_lt` from `single_raffle_user_action_touches`;
+
+create table `user_statuses` (`raffle_address` text, `user_address` text, `white_ticket_minted` integer default 0, `black_ticket_purchased` integer default 0, `candidate_registration_lt` integer not null, `white_ticket_minted_processed_lt` integer default 0, `black_ticket_purchased_processed_lt` integer default 0, `participant_registration_lt` integer default 0, `last_deployed_unix_time` integer default 0, primary key (`raffle_address`, `user_address`));
+insert into `user_statuses` (`raffle_address`, `user_address`, `white_ticket_minted`, `black_ticket_purchased`, `candidate_registration_lt`, `white_ticket_minted_processed_lt`, `black_ticket_purchased_processed_lt`, `participant_registration_lt`, `last_deployed_unix_time`)
+select @raffle_address, `user_address`, `white_ticket_minted`, `black_ticket_purchased`, `candidate_registration_lt`, `white_ticket_minted_processed_lt`, `black_ticket_purchased_processed_lt`, `participant_registration_lt`, `last_deployed_unix_time` from `single_raffle_user_statuses`;
+
+drop table `single_raffle_user_actions`;
+drop table `single_raffle_user_action_touches`;
+drop table `single_raffle_user_statuses`;
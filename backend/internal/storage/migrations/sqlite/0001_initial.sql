@@ -0,0 +1,12 @@
+-- the schema of the multi raffle release, databases created by AutoMigrate already match it
+create table if not exists `user_actions` (`id` integer primary key autoincrement, `raffle_address` text, `action_type` text, `user_address` text, `address` text, `transaction_hash` text not null, `transaction_lt` integer not null, `transaction_unix_time` integer not null);
+create table if not exists `user_action_touches` (`raffle_address` text, `action_type` text, `user_address` text, `transaction_lt` integer not null, primary key (`raffle_address`, `action_type`, `user_address`));
+create table if not exists `user_statuses` (`raffle_address` text, `user_address` text, `white_ticket_minted` integer default 0, `black_ticket_purchased` integer default 0, `candidate_registration_lt` integer not null, `white_ticket_minted_processed_lt` integer default 0, `black_ticket_purchased_processed_lt` integer default 0, `participant_registration_lt` integer default 0, `last_deployed_unix_time` integer default 0, primary key (`raffle_address`, `user_address`));
+create table if not exists `raffles` (`address` text, `deployed_lt` integer not null, `white_ticket_minted_target` integer default 0, `black_ticket_purchased_target` integer default 0, primary key (`address`));
+create table if not exists `outbox_messages` (`id` integer primary key autoincrement, `raffle_address` text, `user_address` text, `state` text not null, `white_ticket_minted` integer not null, `white_ticket_minted_processed_lt` integer default 0, `black_ticket_purchased` integer not null, `black_ticket_purchased_processed_lt` integer default 0, `attempts` integer default 0, `seqno` integer default 0, `query_id` integer default 0, `message_hash` text, `trace_id` text, `error` text, `sent_at` datetime, `created_at` datetime, `updated_at` datetime);
+create index if not exists `idx_outbox_messages_state` on `outbox_messages` (`state`);
+create index if not exists `idx_outbox_user` on `outbox_messages` (`raffle_address`, `user_address`);
+
+-- keep the first copy of duplicated actions, so the unique index can be built on old data
+delete from `user_actions` where `id` not in (select min(`id`) from `user_actions` group by `raffle_address`, `action_type`, `user_address`, `address`);
+create unique index if not exists `idx_unique_action` on `user_actions` (`raffle_address`, `action_type`, `user_address`, `address`);
@@ -0,0 +1,3 @@
+-- the seller of a purchase and the reason a wash trade is excluded from the counts
+alter table `user_actions` add column `counterparty` text default '';
+alter table `user_actions` add column `flag_reason` text default '';
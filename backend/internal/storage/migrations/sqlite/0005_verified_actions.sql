@@ -0,0 +1,2 @@
+-- set once the transaction of an action was found again below the finality depth
+alter table `user_actions` add column `verified` numeric default false;
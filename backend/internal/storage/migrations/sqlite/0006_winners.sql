@@ -0,0 +1,2 @@
+-- the winners drawn by RaffleNext, a drawing row is a message sent and not seen on-chain yet
+create table if not exists `winners` (`raffle_address` text, `winner_index` integer, `state` text not null, `participant_index` integer default 0, `participant_address` text, `user_address` text, `transaction_hash` text, `transaction_lt` integer default 0, `message_hash` text, `sent_at` datetime, `created_at` datetime, `updated_at` datetime, primary key (`raffle_address`, `winner_index`));
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"backend/internal/logger"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMigrations(t *testing.T) {
+	for _, driver := range []DriverType{SqliteDriverType, PostgresDriverType} {
+		migrations, err := Migrations(driver)
+		if err != nil {
+			t.Fatalf("%s migrations: %v", driver, err)
+		}
+
+		if len(migrations) == 0 || migrations[0].Version != 1 {
+			t.Errorf("%s: expected migrations starting at version 1, got %d", driver, len(migrations))
+		}
+	}
+}
+
+func TestMigrateExistingDatabase(t *testing.T) {
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	database, err := OpenSqlite(filepath.Join(t.TempDir(), "existing.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer database.Close()
+
+	// user actions of an early database, before the unique index existed
+	err = database.db.Exec("create table `user_actions` (`id` integer primary key autoincrement, `raffle_address` text, `action_type` text, `user_address` text, `address` text, `transaction_hash` text not null, `transaction_lt` integer not null, `transaction_unix_time` integer not null)").Error
+	if err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	for _, hash := range []string{"hash1", "hash2"} {
+		err = database.db.Exec("insert into user_actions (raffle_address, action_type, user_address, address, transaction_hash, transaction_lt, transaction_unix_time) values ('raffle', ?, 'user', 'item', ?, 1, 1)", WhiteTicketMintedActionType, hash).Error
+		if err != nil {
+			t.Fatalf("insert action: %v", err)
+		}
+	}
+
+	statuses, err := database.MigrationStatus()
+	if err != nil || len(statuses) == 0 || statuses[0].AppliedAt != nil {
+		t.Fatalf("expected a pending initial migration, got %+v (%v)", statuses, err)
+	}
+
+	applied, err := database.Migrate()
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	if len(applied) != len(statuses) {
+		t.Errorf("expected %d applied migrations, got %d", len(statuses), len(applied))
+	}
+
+	var actions []*UserAction
+	if err := database.db.Find(&actions).Error; err != nil || len(actions) != 1 || actions[0].TransactionHash != "hash1" {
+		t.Errorf("expected the first duplicated action to be kept, got %d (%v)", len(actions), err)
+	}
+
+	if applied, err := database.Migrate(); err != nil || len(applied) != 0 {
+		t.Errorf("expected nothing to migrate twice, got %d (%v)", len(applied), err)
+	}
+
+	statuses, err = database.MigrationStatus()
+	if err != nil {
+		t.Fatalf("migration status: %v", err)
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d is still pending", status.Version)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements("-- comment\ncreate table a (\n  id integer\n);\n\ncreate index b on a (id);\n")
+	if len(statements) != 2 || statements[0] != "create table a (\n  id integer\n);" {
+		t.Errorf("unexpected statements %q", statements)
+	}
+}
+
+func TestMigrateConditionSlots(t *testing.T) {
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	database, err := OpenSqlite(filepath.Join(t.TempDir(), "counters.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer database.Close()
+
+	migrations, err := Migrations(SqliteDriverType)
+	if err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+
+	if err := database.db.Exec(schemaVersionTable).Error; err != nil {
+		t.Fatalf("create schema version: %v", err)
+	}
+
+	if _, err := database.apply(migrations[0]); err != nil {
+		t.Fatalf("apply initial migration: %v", err)
+	}
+
+	// counters of a raffle tracked before the condition slots
+	for _, statement := range []string{
+		"insert into raffles (address, deployed_lt, white_ticket_minted_target, black_ticket_purchased_target) values ('raffle', 1, 1, 1)",
+		"insert into user_statuses (raffle_address, user_address, white_ticket_minted, black_ticket_purchased, candidate_registration_lt, white_ticket_minted_processed_lt, black_ticket_purchased_processed_lt) values ('raffle', 'user1', 1, 1, 10, 20, 30), ('raffle', 'user2', 1, 0, 11, 21, 0)",
+		"insert into outbox_messages (raffle_address, user_address, state, white_ticket_minted, white_ticket_minted_processed_lt, black_ticket_purchased) values ('raffle', 'user2', 'sent', 1, 21, 0)",
+	} {
+		if err := database.db.Exec(statement).Error; err != nil {
+			t.Fatalf("insert legacy rows: %v", err)
+		}
+	}
+
+	if _, err := database.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	s := &gormStorage{db: database.db, raffleAddress: "raffle"}
+
+	raffleConditions, err := s.GetRaffleConditions("raffle")
+	if err != nil || len(raffleConditions) != 2 || raffleConditions[0].Slot != WhiteTicketMintedActionType || raffleConditions[1].Target != 1 {
+		t.Errorf("expected the white and black targets, got %+v (%v)", raffleConditions, err)
+	}
+
+	userConditions, err := s.GetUserConditions([]string{"user1", "user2"})
+	if err != nil || len(userConditions) != 3 {
+		t.Fatalf("expected 3 user conditions, got %d (%v)", len(userConditions), err)
+	}
+
+	if userConditions[0].Slot != BlackTicketPurchasedActionType || userConditions[0].ProcessedLt != 30 {
+		t.Errorf("unexpected black condition of user1 %+v", userConditions[0])
+	}
+
+	userStatuses, err := s.GetUserStatusesByConditionsReached()
+	if err != nil || len(userStatuses) != 1 || userStatuses[0].UserAddress != "user1" {
+		t.Errorf("expected user1 to reach the conditions, got %d (%v)", len(userStatuses), err)
+	}
+
+	messages, err := s.GetOutboxMessagesByUser("user2")
+	if err != nil || len(messages) != 1 || len(messages[0].Conditions) != 2 {
+		t.Fatalf("expected the converted outbox message, got %+v (%v)", messages, err)
+	}
+
+	if white := messages[0].Conditions[0]; white.Slot != WhiteTicketMintedActionType || white.Value != 1 || white.ProcessedLt != 21 {
+		t.Errorf("unexpected white condition %+v", white)
+	}
+}
+
+func TestMigrateSingleRaffleDatabase(t *testing.T) {
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	database, err := OpenSqlite(filepath.Join(t.TempDir(), "single.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer database.Close()
+
+	// the schema AutoMigrate created before the multi raffle release
+	for _, statement := range []string{
+		"create table `user_statuses` (`user_address` text, `white_ticket_minted` integer default 0, `black_ticket_purchased` integer default 0, `candidate_registration_lt` integer not null, `white_ticket_minted_processed_lt` integer default 0, `black_ticket_purchased_processed_lt` integer default 0, `participant_registration_lt` integer default 0, `last_deployed_unix_time` integer default 0, primary key (`user_address`))",
+		"create table `user_actions` (`id` integer primary key autoincrement, `action_type` text, `user_address` text, `address` text, `transaction_hash` text not null, `transaction_lt` integer not null, `transaction_unix_time` integer not null)",
+		"create unique index `idx_unique_action` on `user_actions` (`action_type`, `user_address`, `address`)",
+		"create table `user_action_touches` (`action_type` text, `user_address` text, `transaction_lt` integer not null, primary key (`action_type`, `user_address`))",
+		"insert into user_statuses (user_address, white_ticket_minted, candidate_registration_lt, white_ticket_minted_processed_lt) values ('user', 1, 10, 20)",
+		"insert into user_actions (action_type, user_address, address, transaction_hash, transaction_lt, transaction_unix_time) values ('WhiteTicketMintedActionType', 'user', 'item', 'hash', 20, 1)",
+		"insert into user_action_touches (action_type, user_address, transaction_lt) values ('WhiteTicketMintedActionType', 'user', 20)",
+	} {
+		if err := database.db.Exec(statement).Error; err != nil {
+			t.Fatalf("create single raffle schema: %v", err)
+		}
+	}
+
+	if _, err := database.Migrate(); err == nil {
+		t.Fatal("expected a single raffle database to need the raffle address")
+	}
+
+	database.SetSingleRaffleAddress("raffle")
+	if _, err := database.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	s := &gormStorage{db: database.db, raffleAddress: "raffle"}
+
+	userStatus, err := s.GetUserStatusByAddress("user")
+	if err != nil || userStatus == nil || userStatus.CandidateRegistrationLt != 10 {
+		t.Errorf("expected the user status of the raffle, got %+v (%v)", userStatus, err)
+	}
+
+	userConditions, err := s.GetUserConditions([]string{"user"})
+	if err != nil || len(userConditions) != 1 || userConditions[0].ProcessedLt != 20 {
+		t.Errorf("expected the white condition of the user, got %d (%v)", len(userConditions), err)
+	}
+
+	actions, err := s.GetUserActions(WhiteTicketMintedActionType)
+	if err != nil || len(actions) != 1 || actions[0].RaffleAddress != "raffle" {
+		t.Fatalf("expected the action of the raffle, got %d (%v)", len(actions), err)
+	}
+
+	if touch, err := s.GetUserActionTouchByAddress(WhiteTicketMintedActionType, "user"); err != nil || touch != 20 {
+		t.Errorf("expected the touch of the raffle, got %d (%v)", touch, err)
+	}
+
+	// the same action of another raffle no longer collides with the unique index
+	other := *actions[0]
+	other.ID = 0
+	other.RaffleAddress = "other"
+	if err := database.db.Create(&other).Error; err != nil {
+		t.Errorf("expected the unique index to include the raffle address: %v", err)
+	}
+}
@@ -1,28 +1,122 @@
 package storage
 
+import "time"
+
 type UserStatus struct {
-	UserAddress                     string `gorm:"primaryKey"`
-	WhiteTicketMinted               uint8  `gorm:"default:0"`
-	BlackTicketPurchased            uint8  `gorm:"default:0"`
-	CandidateRegistrationLt         int64  `gorm:"not null"`
-	WhiteTicketMintedProcessedLt    int64  `gorm:"default:0"`
-	BlackTicketPurchasedProcessedLt int64  `gorm:"default:0"`
-	ParticipantRegistrationLt       int64  `gorm:"default:0"`
-	LastDeployedUnixTime            int64  `gorm:"default:0"`
+	RaffleAddress             string `gorm:"primaryKey"`
+	UserAddress               string `gorm:"primaryKey"`
+	CandidateRegistrationLt   int64  `gorm:"not null"`
+	ParticipantRegistrationLt int64  `gorm:"default:0"`
+	LastDeployedUnixTime      int64  `gorm:"default:0"`
+	// ConditionsReached is set once the confirmed conditions match the raffle targets
+	ConditionsReached bool `gorm:"default:false"`
+}
+
+// UserCondition is the confirmed value of a condition slot of a user, the actions of the slot
+// up to ProcessedLt are counted in it.
+type UserCondition struct {
+	RaffleAddress string `gorm:"primaryKey"`
+	UserAddress   string `gorm:"primaryKey"`
+	Slot          string `gorm:"primaryKey"`
+	Value         uint64 `gorm:"default:0"`
+	ProcessedLt   int64  `gorm:"default:0"`
 }
 
 type UserAction struct {
 	ID                  int64      `gorm:"primaryKey"`
+	RaffleAddress       string     `gorm:"uniqueIndex:idx_unique_action"`
 	ActionType          ActionType `gorm:"uniqueIndex:idx_unique_action"`
 	UserAddress         string     `gorm:"uniqueIndex:idx_unique_action"`
 	Address             string     `gorm:"uniqueIndex:idx_unique_action"`
 	TransactionHash     string     `gorm:"not null"`
 	TransactionLt       int64      `gorm:"not null"`
 	TransactionUnixTime int64      `gorm:"not null"`
+	// Counterparty is the other wallet of the action, the seller of a purchase
+	Counterparty string `gorm:"default:''"`
+	// FlagReason excludes the action from the conditions, empty when it counts
+	FlagReason string `gorm:"default:''"`
+	// Verified is set once the transaction was found again below the finality depth
+	Verified bool `gorm:"default:false"`
 }
 
 type UserActionTouch struct {
+	RaffleAddress string     `gorm:"primaryKey"`
 	ActionType    ActionType `gorm:"primaryKey"`
 	UserAddress   string     `gorm:"primaryKey"`
 	TransactionLt int64      `gorm:"not null"`
 }
+
+type Raffle struct {
+	Address    string `gorm:"primaryKey"`
+	DeployedLt int64  `gorm:"not null"`
+}
+
+// RaffleCondition is a slot of the raffle conditions in payload order, with the target the
+// raffle declares for it.
+type RaffleCondition struct {
+	RaffleAddress string `gorm:"primaryKey"`
+	Position      int    `gorm:"primaryKey"`
+	Slot          string `gorm:"not null"`
+	Kind          string
+	Target        uint64 `gorm:"default:0"`
+}
+
+type RaffleStatistics struct {
+	Candidates        int64
+	ConditionsReached int64
+	Participants      int64
+	// Conditions counts the users with a non zero value by slot
+	Conditions map[string]int64 `gorm:"-"`
+}
+
+// ConditionValue is the value of a condition slot carried by an outbox message
+type ConditionValue struct {
+	Slot        string `json:"slot"`
+	Value       uint64 `json:"value"`
+	ProcessedLt int64  `json:"processed_lt"`
+}
+
+// OutboxMessage is an intended RaffleSetConditions message. The user status only advances to
+// its counts once the candidate contract reports the same conditions.
+type OutboxMessage struct {
+	ID            int64            `gorm:"primaryKey"`
+	RaffleAddress string           `gorm:"index:idx_outbox_user"`
+	UserAddress   string           `gorm:"index:idx_outbox_user"`
+	State         OutboxState      `gorm:"index;not null"`
+	Conditions    []ConditionValue `gorm:"serializer:json;not null"`
+	Attempts      int              `gorm:"default:0"`
+	Seqno         uint32           `gorm:"default:0"`
+	QueryID       uint64           `gorm:"default:0"`
+	MessageHash   string
+	TraceID       string
+	Error         string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Winner is a winner of the raffle by the index RaffleNext gave it. A drawing winner is a sent
+// RaffleNext message not seen on-chain yet, its participant is known once drawn.
+type Winner struct {
+	RaffleAddress      string      `gorm:"primaryKey"`
+	WinnerIndex        int         `gorm:"primaryKey;autoIncrement:false"`
+	State              WinnerState `gorm:"not null"`
+	ParticipantIndex   uint64      `gorm:"default:0"`
+	ParticipantAddress string
+	UserAddress        string
+	TransactionHash    string
+	TransactionLt      int64 `gorm:"default:0"`
+	MessageHash        string
+	SentAt             time.Time
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// CachedLookup is a chain fact that cannot change once final, like the body of a finished trace.
+// It is keyed by the chain only, the raffles share it.
+type CachedLookup struct {
+	Kind      string `gorm:"primaryKey"`
+	Key       string `gorm:"primaryKey"`
+	Value     string `gorm:"not null"`
+	CreatedAt time.Time
+}
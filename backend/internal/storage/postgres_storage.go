@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"backend/internal/logger"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// PoolOptions tunes the connection pool of a networked database, zero values keep the
+// database/sql defaults.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// PostgresStorage keeps the state of every raffle in a Postgres database, so the oracle and
+// API servers on other hosts can share it. Scoping works as in SqliteStorage.
+type PostgresStorage struct {
+	gormStorage
+}
+
+// OpenPostgres connects to the database without migrating it
+func OpenPostgres(dsn string, pool PoolOptions) (*Database, error) {
+
+	logger.Debug("initializing postgres database...")
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+
+	logger.Debug("initializing postgres database... done", zap.Int("max open connections", pool.MaxOpenConns))
+	return &Database{db: db, driver: PostgresDriverType, name: "postgres"}, nil
+}
+
+// NewPostgresStorage connects to the database and applies the pending migrations
+func NewPostgresStorage(dsn string, pool PoolOptions) (*PostgresStorage, error) {
+	database, err := OpenPostgres(dsn, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.migrated()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgresStorage{
+		gormStorage: gormStorage{db: db},
+	}, nil
+}
+
+// ForRaffle returns a storage sharing the same connection pool, scoped to the given raffle
+func (s *PostgresStorage) ForRaffle(raffleAddress string) *PostgresStorage {
+	return &PostgresStorage{
+		gormStorage: gormStorage{db: s.db, raffleAddress: raffleAddress},
+	}
+}
+
+// Close releases the connection pool
+func (s *PostgresStorage) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
@@ -2,363 +2,93 @@ package storage
 
 import (
 	"backend/internal/logger"
-	"database/sql"
-	"log"
+	"errors"
+	"os"
+	"strings"
 
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
+// SqliteStorage keeps the state of every raffle in one database file. User actions, statuses
+// and cursors are scoped by the raffle address set with ForRaffle.
 type SqliteStorage struct {
-	db *gorm.DB
+	gormStorage
 }
 
-func NewSqliteStorage() *SqliteStorage {
+// OpenSqlite opens the database file without migrating it
+func OpenSqlite(path string) (*Database, error) {
 
-	logger.Debug("initializing database...")
-	db, err := gorm.Open(sqlite.Open("persistent.db"), &gorm.Config{})
-	if err != nil {
-		panic(err)
+	logger.Debug("initializing database...", zap.String("path", path))
+	dsn := path
+	if !strings.Contains(dsn, "?") {
+		// raffles share the file, their transactions wait for each other instead of failing busy
+		dsn += "?_busy_timeout=5000&_txlock=immediate"
 	}
 
-	err = db.AutoMigrate(
-		&UserAction{},
-		&UserActionTouch{},
-		&UserStatus{},
-	)
-
-	if err != nil {
-		panic(err)
-	}
-
-	return &SqliteStorage{
-		db: db,
-	}
-}
-
-func (s *SqliteStorage) GetUserActions(actionType ActionType) ([]*UserAction, error) {
-
-	var actions []*UserAction
-	err := s.db.Where("action_type = ?", actionType).Find(&actions).Error
-
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
-	return actions, nil
-}
-
-func (s *SqliteStorage) UpdateUserActions(actions []*UserAction) error {
-	logger.Debug("update pending user actions...")
-
-	if len(actions) == 0 {
-		logger.Debug("no pending user actions to persist")
-		return nil
-	}
-
-	actionAddresses := mapToStrings(actions, func(action *UserAction) string { return action.UserAddress })
-	logger.Info("found new pending user actions", zap.Strings("actions", actionAddresses))
-
-	err := s.db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "action_type"}, {Name: "user_address"}, {Name: "address"}},
-		DoUpdates: clause.AssignmentColumns([]string{"transaction_lt", "transaction_hash"}),
-	}).CreateInBatches(actions, 100).Error
-
-	if err != nil {
-		return err
-	}
-
-	logger.Debug("update pending user actions...done")
-	return nil
-}
-
-func (s *SqliteStorage) GetUserActionTouch(actionType ActionType) (int64, error) {
-	logger.Debug("getting last action transaction...")
-
-	var transactionLt int64
-	err := s.db.Raw(`
-		select coalesce(max(transaction_lt), 0) as transaction_lt
-		from user_action_touches
-		where action_type = ?
-	`, actionType).Scan(&transactionLt).Error
-
-	if err != nil {
-		return 0, err
-	}
-
-	logger.Debug("getting last action transaction... done", zap.Int64("transactionLt", transactionLt))
-	return transactionLt, nil
+	return &Database{db: db, driver: SqliteDriverType, name: path}, nil
 }
 
-func (s *SqliteStorage) GetUserActionTouchByAddress(actionType ActionType, address string) (int64, error) {
-	logger.Debug("getting last action transaction by user", zap.String("action type", actionType), zap.String("address", address))
-
-	var transactionLt int64
-	err := s.db.Raw(`
-		select coalesce(max(transaction_lt), 0) as transaction_lt
-		from user_action_touches
-		where action_type = ? and user_address = ?
-	`, actionType, address).Scan(&transactionLt).Error
-
+// NewSqliteStorage opens the database file and applies the pending migrations, the rows of a
+// single raffle database are migrated to singleRaffleAddress.
+func NewSqliteStorage(path string, singleRaffleAddress string) (*SqliteStorage, error) {
+	database, err := OpenSqlite(path)
 	if err != nil {
-		return 0, err
-	}
-
-	logger.Debug("getting last action transaction by user... done")
-	return transactionLt, nil
-}
-
-func (s *SqliteStorage) UpdateUserActionTouch(actionTouch *UserActionTouch) error {
-	logger.Debug("updating pending action touch...")
-
-	err := s.db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "action_type"}, {Name: "user_address"}},
-		DoUpdates: clause.AssignmentColumns([]string{"transaction_lt"}),
-	}).Create(&actionTouch).Error
-
-	if err != nil {
-		return err
-	}
-
-	logger.Debug("updating pending action touch...done")
-	return nil
-}
-
-func (s *SqliteStorage) GetPendingCandidateRegistrationActions() ([]*UserAction, error) {
-	logger.Debug("getting pending candidate registration actions...")
-
-	rows, err := s.db.Raw(`
-		select a.*
-		from user_actions a
-			left join user_statuses s on s.user_address = a.user_address
-		where a.action_type = ? and s.user_address is null
-	`, CandidateRegistrationActionType).Rows()
-	if err != nil {
-		logger.Fatal(err.Error())
 		return nil, err
 	}
+	database.SetSingleRaffleAddress(singleRaffleAddress)
 
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			logger.Fatal(err.Error())
-		}
-	}(rows)
-
-	var actions = make([]*UserAction, 0)
-	for rows.Next() {
-		var userAction UserAction
-
-		if err := s.db.ScanRows(rows, &userAction); err != nil {
-			log.Fatal(err)
-			return nil, err
-		}
-
-		actions = append(actions, &userAction)
-	}
-
-	logger.Debug("getting pending candidate registration actions... done")
-	return actions, nil
-}
-
-func (s *SqliteStorage) GetPendingParticipantRegistrationActions() ([]*UserAction, error) {
-	logger.Debug("getting pending participant registration actions...")
-
-	rows, err := s.db.Raw(`
-		select a.*
-		from user_actions a
-		where a.action_type = ?
-	`, ParticipantRegistrationActionType).Rows()
-	if err != nil {
-		logger.Fatal(err.Error())
-	}
-
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			logger.Fatal(err.Error())
-		}
-	}(rows)
-
-	var actions = make([]*UserAction, 0)
-	for rows.Next() {
-		var userAction UserAction
-
-		if err := s.db.ScanRows(rows, &userAction); err != nil {
-			log.Fatal(err)
-			return nil, err
-		}
-
-		actions = append(actions, &userAction)
-	}
-
-	logger.Debug("getting pending participant registration actions... done")
-	return actions, nil
-}
-
-func (s *SqliteStorage) GetPendingWhiteTicketMintedActions() ([]*UserAction, error) {
-	logger.Debug("getting pending white ticket minted actions...")
-
-	rows, err := s.db.Raw(`
-		select a.*
-		from user_actions a
-				 left join user_statuses s on s.user_address = a.user_address
-		where a.action_type = ?
-		  and (s.white_ticket_minted_processed_lt < a.transaction_lt or s.white_ticket_minted_processed_lt = 0)
-	`, WhiteTicketMintedActionType).Rows()
-
-	if err != nil {
-		logger.Fatal(err.Error())
-	}
-
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			logger.Fatal(err.Error())
-		}
-	}(rows)
-
-	var actions = make([]*UserAction, 0)
-	for rows.Next() {
-		var userAction UserAction
-
-		if err := s.db.ScanRows(rows, &userAction); err != nil {
-			log.Fatal(err)
-			return nil, err
-		}
-
-		actions = append(actions, &userAction)
-	}
-
-	logger.Debug("getting pending white ticket minted actions... done")
-	return actions, nil
-}
-
-func (s *SqliteStorage) GetPendingBlackTicketPurchasedActions() ([]*UserAction, error) {
-	logger.Debug("getting pending black ticket purchased actions...")
-
-	rows, err := s.db.Raw(`
-		select a.*
-		from user_actions a
-			left join user_statuses s on s.user_address = a.user_address
-		where a.action_type = ?
-		  and (s.black_ticket_purchased_processed_lt < a.transaction_lt or s.black_ticket_purchased_processed_lt = 0)
-	`, BlackTicketPurchasedActionType).Rows()
-
+	db, err := database.migrated()
 	if err != nil {
-		logger.Fatal(err.Error())
 		return nil, err
 	}
 
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			logger.Fatal(err.Error())
-		}
-	}(rows)
-
-	var actions = make([]*UserAction, 0)
-	for rows.Next() {
-		var userAction UserAction
-
-		if err := s.db.ScanRows(rows, &userAction); err != nil {
-			log.Fatal(err)
-			return nil, err
-		}
-
-		actions = append(actions, &userAction)
-	}
-
-	logger.Debug("getting pending black ticket purchased actions... done")
-	return actions, nil
+	return &SqliteStorage{
+		gormStorage: gormStorage{db: db},
+	}, nil
 }
 
-func (s *SqliteStorage) GetUserStatusesByConditionsReached() ([]*UserStatus, error) {
-	var userStatuses []*UserStatus
-	tx := s.db.Where("white_ticket_minted = 1 and black_ticket_purchased = 1").Find(&userStatuses)
-	if tx.Error != nil {
-		return nil, tx.Error
+// ForRaffle returns a storage sharing the same database, scoped to the given raffle
+func (s *SqliteStorage) ForRaffle(raffleAddress string) *SqliteStorage {
+	return &SqliteStorage{
+		gormStorage: gormStorage{db: s.db, raffleAddress: raffleAddress},
 	}
-
-	return userStatuses, nil
 }
 
-func (s *SqliteStorage) GetUserStatusByAddress(address string) (*UserStatus, error) {
-
-	var userStatus UserStatus
-	err := s.db.Where("user_address = ?", address).First(&userStatus).Error
+// Snapshot copies the database into a temporary file and returns a storage of the copy scoped
+// like s, release closes and removes the copy. Copying only reads the database, so writers are
+// not blocked by what is done with the copy.
+func (s *SqliteStorage) Snapshot() (Storage, func() error, error) {
+	file, err := os.CreateTemp("", "oracle-snapshot-*.db")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &userStatus, nil
-}
-
-func (s *SqliteStorage) GetUserStatusesByAddresses(addresses []string) ([]*UserStatus, error) {
-
-	var userStatuses []*UserStatus
-	tx := s.db.Where("user_address in ?", addresses).Find(&userStatuses)
-	if tx.Error != nil {
-		return nil, tx.Error
+	path := file.Name()
+	if err := file.Close(); err != nil {
+		return nil, nil, errors.Join(err, os.Remove(path))
 	}
 
-	return userStatuses, nil
-}
-
-func (s *SqliteStorage) UpdateUserStatus(action *UserStatus) error {
-	logger.Debug("updating user status...", zap.String("userAddress", action.UserAddress))
-
-	tx := s.db.Clauses(clause.OnConflict{
-		Columns: []clause.Column{{Name: "user_address"}},
-		DoUpdates: clause.AssignmentColumns([]string{
-			"white_ticket_minted",
-			"white_ticket_minted_processed_lt",
-			"black_ticket_purchased",
-			"black_ticket_purchased_processed_lt",
-			"last_deployed_unix_time",
-		}),
-	}).Create(&action)
-	if tx.Error != nil {
-		logger.Fatal(tx.Error.Error())
-		return tx.Error
-	}
-
-	logger.Debug("updating user status...done")
-	return nil
-}
-
-func (s *SqliteStorage) UpdateUserStatuses(userStatuses []*UserStatus) error {
-	logger.Debug("update user statuses...")
-
-	if len(userStatuses) == 0 {
-		logger.Debug("no user statuses to persist")
-		return nil
+	if err := s.db.Exec("vacuum into ?", path).Error; err != nil {
+		return nil, nil, errors.Join(err, os.Remove(path))
 	}
 
-	err := s.db.Clauses(clause.OnConflict{
-		Columns: []clause.Column{{Name: "user_address"}},
-		DoUpdates: clause.AssignmentColumns([]string{
-			"white_ticket_minted",
-			"white_ticket_minted_processed_lt",
-			"black_ticket_purchased",
-			"black_ticket_purchased_processed_lt",
-			"last_deployed_unix_time",
-		}),
-	}).CreateInBatches(userStatuses, 100).Error
+	database, err := OpenSqlite(path)
 	if err != nil {
-		return err
+		return nil, nil, errors.Join(err, os.Remove(path))
 	}
 
-	logger.Debug("update user statuses... done")
-	return nil
-}
-
-func mapToStrings[T any](slice []T, extract func(T) string) []string {
-	result := make([]string, len(slice))
-	for i, item := range slice {
-		result[i] = extract(item)
+	release := func() error {
+		return errors.Join(database.Close(), os.Remove(path))
 	}
-	return result
+
+	return &SqliteStorage{
+		gormStorage: gormStorage{db: database.db, raffleAddress: s.raffleAddress},
+	}, release, nil
 }
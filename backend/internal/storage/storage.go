@@ -1,20 +1,38 @@
 package storage
 
 type Storage interface {
+	// Transaction runs fn on a storage bound to one database transaction, the writes made
+	// through it are committed together when fn returns nil and rolled back otherwise.
+	Transaction(fn func(tx Storage) error) error
+
+	// raffle
+	GetRaffle(address string) (*Raffle, error)
+	GetRaffleDeployedLt(address string) (int64, error)
+	UpdateRaffleDeployedLt(address string, deployedLt int64) error
+	GetRaffleConditions(address string) ([]*RaffleCondition, error)
+	UpdateRaffleConditions(address string, conditions []*RaffleCondition) error
+	GetRaffleStatistics() (*RaffleStatistics, error)
+
 	// user action
 	GetUserActions(actionType ActionType) ([]*UserAction, error)
+	GetUserActionsByUser(userAddress string) ([]*UserAction, error)
 	UpdateUserActions(actions []*UserAction) error
+	// GetUnverifiedUserActions returns at most limit unverified actions up to finalLt, oldest first
+	GetUnverifiedUserActions(finalLt int64, limit int) ([]*UserAction, error)
+	MarkUserActionsVerified(ids []int64) error
+	DeleteUserActions(ids []int64) error
 
 	// user action touch
 	GetUserActionTouch(actionType ActionType) (int64, error)
 	GetUserActionTouchByAddress(actionType ActionType, address string) (int64, error)
 	UpdateUserActionTouch(actionTouch *UserActionTouch) error
+	GetUserActionTouchesByAddress(address string) ([]*UserActionTouch, error)
+	DeleteUserActionTouchesByAddress(address string) error
 
 	// pending user action
 	GetPendingCandidateRegistrationActions() ([]*UserAction, error)
 	GetPendingParticipantRegistrationActions() ([]*UserAction, error)
-	GetPendingWhiteTicketMintedActions() ([]*UserAction, error)
-	GetPendingBlackTicketPurchasedActions() ([]*UserAction, error)
+	GetPendingConditionActions(slot string) ([]*UserAction, error)
 
 	// user action
 	GetUserStatusByAddress(address string) (*UserStatus, error)
@@ -22,8 +40,36 @@ type Storage interface {
 	GetUserStatusesByConditionsReached() ([]*UserStatus, error)
 	UpdateUserStatus(action *UserStatus) error
 	UpdateUserStatuses(action []*UserStatus) error
+	// DeleteUserStatus removes the status of a user together with its conditions
+	DeleteUserStatus(address string) error
+
+	// user condition
+	GetUserConditions(addresses []string) ([]*UserCondition, error)
+	UpdateUserConditions(conditions []*UserCondition) error
+
+	// outbox
+	GetOutboxMessages(states ...OutboxState) ([]*OutboxMessage, error)
+	GetOutboxMessagesByUser(userAddress string) ([]*OutboxMessage, error)
+	CreateOutboxMessage(message *OutboxMessage) error
+	UpdateOutboxMessage(message *OutboxMessage) error
+
+	// winner
+	GetWinners() ([]*Winner, error)
+	UpdateWinners(winners []*Winner) error
+	DeleteWinners(winnerIndexes []int) error
+
+	// cached lookup, shared by the raffles
+	GetCachedLookup(kind string, key string) (string, bool, error)
+	UpdateCachedLookup(kind string, key string, value string) error
 }
 
+type DriverType = string
+
+const (
+	SqliteDriverType   DriverType = "sqlite"
+	PostgresDriverType DriverType = "postgres"
+)
+
 type ActionType = string
 
 const (
@@ -31,4 +77,23 @@ const (
 	ParticipantRegistrationActionType ActionType = "ParticipantRegistrationActionType"
 	WhiteTicketMintedActionType       ActionType = "WhiteTicketMintedActionType"
 	BlackTicketPurchasedActionType    ActionType = "BlackTicketPurchasedActionType"
+	// WinnerNotificationActionType only keys the cursor of the winner notifications
+	WinnerNotificationActionType ActionType = "WinnerNotificationActionType"
+)
+
+type OutboxState = string
+
+const (
+	QueuedOutboxState    OutboxState = "queued"
+	SentOutboxState      OutboxState = "sent"
+	ConfirmedOutboxState OutboxState = "confirmed"
+	FailedOutboxState    OutboxState = "failed"
+	BouncedOutboxState   OutboxState = "bounced"
+)
+
+type WinnerState = string
+
+const (
+	DrawingWinnerState WinnerState = "drawing"
+	DrawnWinnerState   WinnerState = "drawn"
 )
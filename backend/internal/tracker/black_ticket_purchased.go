@@ -1,7 +1,9 @@
 package tracker
 
 import (
+	"backend/internal/conditions"
 	"backend/internal/logger"
+	"backend/internal/marketplace"
 	"backend/internal/storage"
 	"errors"
 	"math"
@@ -15,7 +17,10 @@ import (
 	"go.uber.org/zap"
 )
 
-func (t *Tracker) collectActionsBlackTicketPurchasedInternal(userAddress string, lastBlackTicketPurchasedLt int64, raffleDeployedLt int64) ([]*storage.UserAction, error) {
+// collectActionsBlackTicketPurchasedInternal walks the traces of an account, a candidate wallet
+// or a collection item, newer than its cursor. attribute maps the buyer of a purchase to the
+// candidate it counts for.
+func (t *Tracker) collectActionsBlackTicketPurchasedInternal(slot conditions.Slot, accountAddress string, lastBlackTicketPurchasedLt int64, raffleDeployedLt int64, attribute func(buyer ton.AccountID) (string, bool)) ([]*storage.UserAction, *storage.UserActionTouch, error) {
 	logger.Debug("black ticket purchased: processing collect actions")
 	var actions = make([]*storage.UserAction, 0)
 
@@ -24,45 +29,40 @@ func (t *Tracker) collectActionsBlackTicketPurchasedInternal(userAddress string,
 	var maxTransactionLt int64 = 0
 	var beforeLt int64 = 0
 
-	userAccountAddress, err := tongo.ParseAddress(userAddress)
-
-	blackTicketCollectionAccountID, err := ton.ParseAccountID(t.blackTicketCollectionAddress)
+	blackTicketCollectionAccountID, err := ton.ParseAccountID(slot.Collection)
 	if err != nil {
-		logger.Fatal("black ticket purchased: collection address is invalid", zap.Error(err))
-		return nil, errors.New("black ticket collection address is invalid")
+		return nil, nil, unrecoverableError("black ticket purchased: parse collection address", err)
 	}
 
 	for {
 		logger.Debug("raffle black ticket: collect traces... iteration", zap.Int64("current beforeLt", beforeLt))
-		accountTracesResult, err := infinityRateLimitRetry(
+		accountTracesResult, err := rateLimitRetry(t.ctx,
 			func() (*tonapi.TraceIDs, error) {
-				return t.client.GetAccountTraces(t.ctx, tonapi.GetAccountTracesParams{
-					AccountID: userAddress,
-					Limit:     tonapi.NewOptInt(GlobalLimitWindowSize),
-					BeforeLt: tonapi.OptInt64{
-						Value: beforeLt,
-						Set:   beforeLt > 0,
-					},
-				})
+				return t.source.GetAccountTraces(t.ctx, accountAddress, t.limitWindowSize, beforeLt)
 			},
 		)
 
 		if err != nil {
-			logger.Fatal("black ticket purchased: cannot get source account info, ...exiting", zap.Error(err))
-			return nil, err
+			return nil, nil, sourceError("black ticket purchased: collect traces", err)
 		}
 
 		for _, traceID := range accountTracesResult.GetTraces() {
 			logger.Debug("black ticket purchased: collect trace details... iteration", zap.String("trace id", traceID.GetID()))
-			trace, err := infinityRateLimitRetry(
+			trace, err := rateLimitRetry(t.ctx,
 				func() (*tonapi.Trace, error) {
-					return t.client.GetTrace(t.ctx, tonapi.GetTraceParams{TraceID: traceID.GetID()})
+					return t.source.GetTrace(t.ctx, traceID.GetID())
 				},
 			)
 
 			if err != nil {
-				logger.Debug("black ticket purchased: collect trace details... failed", zap.Error(err))
-				break
+				return nil, nil, sourceError("black ticket purchased: collect trace details", err)
+			}
+
+			if !t.traceFinal(trace) {
+				logger.Debug("black ticket purchased: trace is not final yet, skip", zap.String("trace id", traceID.GetID()))
+				transactionLt = trace.Transaction.Lt
+				beforeLt = transactionLt
+				continue
 			}
 
 			transactionLt = trace.Transaction.Lt
@@ -70,25 +70,31 @@ func (t *Tracker) collectActionsBlackTicketPurchasedInternal(userAddress string,
 			maxTransactionLt = max(maxTransactionLt, transactionLt)
 
 			if transactionLt <= lastBlackTicketPurchasedLt {
-				logger.Debug("black ticket purchased: last transaction logic time reached", zap.String("user address", userAddress))
+				logger.Debug("black ticket purchased: last transaction logic time reached", zap.String("account address", accountAddress))
 				break
 			}
 
 			beforeLt = walkTracesBlackTicketPurchased(trace, func(inner *tonapi.Trace) {
 				transactionLt = inner.Transaction.Lt
 				transactionUnixTime = inner.Transaction.Utime
-				transactionHash, processedTicketAddress, ok := t.processBlackTicketPurchasedTrace(inner, &blackTicketCollectionAccountID, &userAccountAddress.ID)
+				transactionHash, processedTicketAddress, buyer, seller, ok := t.processBlackTicketPurchasedTrace(inner, &blackTicketCollectionAccountID, slot.Marketplaces)
+
+				var userAddress string
+				if ok {
+					userAddress, ok = attribute(buyer)
+				}
 
 				if ok && transactionLt > lastBlackTicketPurchasedLt {
 					logger.Debug("black ticket purchased: append action", zap.String("user address", userAddress), zap.String("ticket address", processedTicketAddress))
 
 					actions = append(actions, &storage.UserAction{
-						ActionType:          storage.BlackTicketPurchasedActionType,
+						ActionType:          slot.Name,
 						UserAddress:         userAddress,
 						Address:             processedTicketAddress,
 						TransactionLt:       transactionLt,
 						TransactionHash:     transactionHash,
 						TransactionUnixTime: transactionUnixTime,
+						Counterparty:        seller.ToHuman(true, false),
 					})
 				} else {
 					logger.Debug("black ticket purchased: no need to process, skip")
@@ -103,7 +109,7 @@ func (t *Tracker) collectActionsBlackTicketPurchasedInternal(userAddress string,
 			logger.Debug("black ticket purchased: process user account trace... iteration done")
 		}
 
-		if len(accountTracesResult.GetTraces()) < GlobalLimitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastBlackTicketPurchasedLt {
+		if len(accountTracesResult.GetTraces()) < t.limitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastBlackTicketPurchasedLt {
 			logger.Debug("black ticket purchased: out of trace, finalize traces results...")
 			break
 		}
@@ -111,36 +117,36 @@ func (t *Tracker) collectActionsBlackTicketPurchasedInternal(userAddress string,
 		logger.Debug("black ticket purchased: collect user account traces... iteration done")
 	}
 
+	var touch *storage.UserActionTouch
 	if maxTransactionLt > lastBlackTicketPurchasedLt {
-		pendingUserActionTouch := &storage.UserActionTouch{
-			ActionType:    storage.BlackTicketPurchasedActionType,
-			UserAddress:   userAddress,
+		touch = &storage.UserActionTouch{
+			ActionType:    slot.Name,
+			UserAddress:   accountAddress,
 			TransactionLt: maxTransactionLt,
 		}
-		err = t.storage.UpdateUserActionTouch(pendingUserActionTouch)
-		if err != nil {
-			logger.Fatal("black ticket purchased: failed to update last action transaction state")
-			return nil, err
-		}
 	}
 
-	return actions, nil
+	t.observeActions(slot.Name, accountAddress, max(maxTransactionLt, lastBlackTicketPurchasedLt), len(actions))
+	return actions, touch, nil
 }
 
-func (t *Tracker) collectBlackTicketPurchasedActions(raffleDeployedAt int64) error {
+// collectBlackTicketPurchasedActions collects the marketplace purchases of a purchase slot
+// from the wallets of the candidates, the black tickets of the default conditions. The wallets
+// are scanned concurrently by the tracker workers.
+func (t *Tracker) collectBlackTicketPurchasedActions(slot conditions.Slot, raffleDeployedAt int64) error {
+	if slot.Indexing == conditions.CollectionIndexing {
+		return t.collectCollectionPurchaseActions(slot, raffleDeployedAt)
+	}
 
-	actions := make([]*storage.UserAction, 0)
 	candidateAddressesActions, err := t.storage.GetUserActions(storage.CandidateRegistrationActionType)
 
 	if err != nil {
-		logger.Fatal("black ticket purchased: failed to get user actions", zap.Error(err))
-		panic(err)
+		return storageError("black ticket purchased: get candidate registrations", err)
 	}
 
 	userStatusesConditionReached, err := t.storage.GetUserStatusesByConditionsReached()
 	if err != nil {
-		logger.Fatal("black ticket purchased: failed to get user statuses conditions reached", zap.Error(err))
-		panic(err)
+		return storageError("black ticket purchased: get user statuses conditions reached", err)
 	}
 
 	userStatusesConditionReachedMap := make(map[string]*storage.UserStatus)
@@ -148,6 +154,7 @@ func (t *Tracker) collectBlackTicketPurchasedActions(raffleDeployedAt int64) err
 		userStatusesConditionReachedMap[status.UserAddress] = status
 	}
 
+	var scans []purchaseScan
 	for _, candidateAddressAction := range candidateAddressesActions {
 
 		if _, exists := userStatusesConditionReachedMap[candidateAddressAction.UserAddress]; exists {
@@ -156,30 +163,27 @@ func (t *Tracker) collectBlackTicketPurchasedActions(raffleDeployedAt int64) err
 		}
 
 		logger.Debug("get latest black ticket purchased at")
-		lastBlackTicketPurchasedAt, err := t.storage.GetUserActionTouchByAddress(storage.BlackTicketPurchasedActionType, candidateAddressAction.UserAddress)
+		lastBlackTicketPurchasedAt, err := t.storage.GetUserActionTouchByAddress(slot.Name, candidateAddressAction.UserAddress)
 		if err != nil {
-			logger.Fatal("failed to get last black ticket purchased at", zap.Error(err))
-			panic(err)
+			return storageError("black ticket purchased: get last action transaction state", err)
 		}
 
-		pendingActions, err := t.collectActionsBlackTicketPurchasedInternal(candidateAddressAction.UserAddress, lastBlackTicketPurchasedAt, raffleDeployedAt)
+		userAccountID, err := ton.ParseAccountID(candidateAddressAction.UserAddress)
 		if err != nil {
-			logger.Fatal("black ticket purchased at", zap.Error(err))
-			return err
+			return malformedError("black ticket purchased: parse user address", err)
 		}
 
-		actions = append(actions, pendingActions...)
-	}
-
-	if len(actions) > 0 {
-		err = t.storage.UpdateUserActions(actions)
-		if err != nil {
-			logger.Fatal("black ticket purchased at", zap.Error(err))
-			panic(err)
-		}
+		userAddress := candidateAddressAction.UserAddress
+		scans = append(scans, purchaseScan{
+			accountAddress: userAddress,
+			lastLt:         lastBlackTicketPurchasedAt,
+			attribute: func(buyer ton.AccountID) (string, bool) {
+				return userAddress, buyer == userAccountID
+			},
+		})
 	}
 
-	return nil
+	return t.runPurchaseScans("black ticket purchased: update actions", slot, raffleDeployedAt, scans)
 }
 
 func walkTracesBlackTicketPurchased(trace *tonapi.Trace, callback func(*tonapi.Trace), lastBlackTicketPurchasedAt int64, raffleDeployedAt int64) int64 {
@@ -202,210 +206,134 @@ func walkTracesBlackTicketPurchased(trace *tonapi.Trace, callback func(*tonapi.T
 	return transactionLt
 }
 
-func (t *Tracker) processBlackTicketPurchasedTrace(trace *tonapi.Trace, blackTicketCollectionAccountID *ton.AccountID, userAccountID *ton.AccountID) (string, string, bool) {
+// processBlackTicketPurchasedTrace recognizes the transfer of a collection item by an allowed sale
+// contract to a new owner, it returns the transaction hash, the item, the buyer and the seller.
+func (t *Tracker) processBlackTicketPurchasedTrace(trace *tonapi.Trace, blackTicketCollectionAccountID *ton.AccountID, marketplaces []string) (string, string, ton.AccountID, ton.AccountID, bool) {
 	nftTransferOpCode := "0x5fcc3d14"
 
 	message, ok := trace.Transaction.GetInMsg().Get()
 	if !ok {
 		logger.Debug("black ticket purchased: missing incoming message... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	if !trace.Transaction.Success {
 		logger.Debug("black ticket purchased: ignore unsuccessful incoming messages... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	if !message.OpCode.IsSet() || message.OpCode.Value != nftTransferOpCode {
 		logger.Debug("black ticket purchased: not NFT transfer op code... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	sourceAccountID, ok := message.Source.Get()
 	if !ok {
 		logger.Debug("black ticket purchased: cannot get message source address... skip")
-		return "", "", false
-	}
-	sourceAccount, err := infinityRateLimitRetry(
-		func() (*tonapi.Account, error) {
-			return t.client.GetAccount(t.ctx, tonapi.GetAccountParams{AccountID: sourceAccountID.Address})
-		},
-	)
-	if err != nil {
-		logger.Debug("black ticket purchased: cannot get source account info... skip")
-		return "", "", false
-	}
-
-	if !slices.Contains(sourceAccount.GetMethods, "get_sale_data") && !slices.Contains(sourceAccount.GetMethods, "get_fix_price_data_v4") {
-		logger.Debug("black ticket purchased: account contract does not provide sale data method... skip")
-		return "", "", false
-	}
-
-	var saleDataResult *tonapi.MethodExecutionResult
-	if slices.Contains(sourceAccount.GetMethods, "get_sale_data") {
-		saleDataResult, err = infinityRateLimitRetry(
-			func() (*tonapi.MethodExecutionResult, error) {
-				return t.client.ExecGetMethodForBlockchainAccount(t.ctx, tonapi.ExecGetMethodForBlockchainAccountParams{AccountID: sourceAccount.Address, MethodName: "get_sale_data"})
-			},
-		)
-	} else if slices.Contains(sourceAccount.GetMethods, "get_fix_price_data_v4") {
-		saleDataResult, err = infinityRateLimitRetry(
-			func() (*tonapi.MethodExecutionResult, error) {
-				return t.client.ExecGetMethodForBlockchainAccount(t.ctx, tonapi.ExecGetMethodForBlockchainAccountParams{AccountID: sourceAccount.Address, MethodName: "get_fix_price_data_v4"})
-			},
-		)
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
-
-	if err != nil {
-		logger.Debug("black ticket purchased: cannot execute get_sale_data method... skip")
-		return "", "", false
-	}
-
-	// marketplace address
-	var saleDataMarketplaceAddressCellString string
-	if slices.Contains(sourceAccount.GetMethods, "get_sale_data") {
-		saleDataMarketplaceAddressCellString, ok = saleDataResult.GetStack()[3].GetCell().Get()
-		if !ok {
-			logger.Warn("black ticket purchased: invalid GetGems get_sale_data output... skip")
-			return "", "", false
-		}
-	} else if slices.Contains(sourceAccount.GetMethods, "get_fix_price_data_v4") {
-		saleDataMarketplaceAddressCellString, ok = saleDataResult.GetStack()[2].GetCell().Get()
-		if !ok {
-			logger.Warn("black ticket purchased: invalid GetGems get_sale_data output... skip")
-			return "", "", false
-		}
-	}
-
-	saleDataMarketplaceAddressCell, err := boc.DeserializeBocHex(saleDataMarketplaceAddressCellString)
-	if err != nil {
-		logger.Warn("black ticket purchased: failed to deserialize marketplace boc hex... skip")
-		return "", "", false
-	}
-
-	var saleDataMarketplaceAddress tlb.MsgAddress
-	err = tlb.Unmarshal(saleDataMarketplaceAddressCell[0], &saleDataMarketplaceAddress)
-	if err != nil {
-		logger.Warn("black ticket purchased: failed to read marketplace address due to invalid tlb scheme... skip")
-		return "", "", false
-	}
-
-	saleDataMarketplaceAccountID, err := tongo.AccountIDFromTlb(saleDataMarketplaceAddress)
-	if saleDataMarketplaceAccountID == nil || err != nil {
-		logger.Warn("black ticket purchased: invalid marketplace address... skip")
-		return "", "", false
-	}
-
-	if saleDataMarketplaceAccountID.ToRaw() != MarketplaceAddressRaw {
-		logger.Warn("black ticket purchased: purchase from not getgems marketplace, skip")
-		return "", "", false
-	}
-
-	// owner address
-	var saleDataOwnerAddressCellOpt tonapi.OptString
-	if slices.Contains(sourceAccount.GetMethods, "get_sale_data") {
-		saleDataOwnerAddressCellOpt = saleDataResult.GetStack()[5].GetCell()
-	} else if slices.Contains(sourceAccount.GetMethods, "get_fix_price_data_v4") {
-		saleDataOwnerAddressCellOpt = saleDataResult.GetStack()[4].GetCell()
-	}
-
-	saleDataOwnerAddressCellString, ok := saleDataOwnerAddressCellOpt.Get()
+	sale, ok := t.getSale(sourceAccountID.Address)
 	if !ok {
-		logger.Warn("black ticket purchased: invalid GetGems get_sale_data output... skip")
-		return "", "", false
-	}
-
-	saleDataOwnerAddressCell, err := boc.DeserializeBocHex(saleDataOwnerAddressCellString)
-	if err != nil {
-		logger.Warn("black ticket purchased: failed to deserialize marketplace boc hex... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
-	var saleDataOwnerAddress tlb.MsgAddress
-	err = tlb.Unmarshal(saleDataOwnerAddressCell[0], &saleDataOwnerAddress)
-	if err != nil {
-		logger.Warn("black ticket purchased: failed to read marketplace address due to invalid tlb scheme... skip")
-		return "", "", false
-	}
-
-	saleDataOwnerAccountID, err := tongo.AccountIDFromTlb(saleDataOwnerAddress)
-	if saleDataOwnerAccountID == nil || err != nil {
-		logger.Warn("black ticket purchased: invalid owner address... skip")
-		return "", "", false
+	if !slices.Contains(marketplaces, sale.Marketplace.ToRaw()) {
+		logger.Warn("black ticket purchased: purchase from a marketplace the slot does not allow, skip",
+			zap.String("marketplace", sale.Marketplace.ToRaw()), zap.String("adapter", sale.Adapter))
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	inMessageDestination, ok := message.Destination.Get()
 	if !ok {
 		logger.Warn("black ticket purchased: destination account address missing... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	inMessageDestinationAccountID, err := ton.ParseAccountID(inMessageDestination.Address)
 	if err != nil {
 		logger.Warn("black ticket purchased: failed to parse destination account address... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
+	}
+
+	if sale.Item != inMessageDestinationAccountID {
+		logger.Warn("black ticket purchased: the sale contract sells another item... skip")
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
-	itemResult, err := infinityRateLimitRetry(
-		func() (*tonapi.NftItem, error) {
-			return t.client.GetNftItemByAddress(t.ctx, tonapi.GetNftItemByAddressParams{
-				AccountID: inMessageDestination.Address,
-			})
+	itemCollection, err := rateLimitRetry(t.ctx,
+		func() (string, error) {
+			return t.lookups.GetItemCollection(t.ctx, inMessageDestination.Address)
 		},
 	)
 
 	if err != nil {
-		logger.Warn("black ticket purchased: cannot get nft item information... skip")
-		return "", "", false
-	}
-
-	collectionValue, ok := itemResult.GetCollection().Get()
-	if !ok {
-		logger.Warn("black ticket purchased: could not extract item collection value... skip")
-		return "", "", false
+		logger.Warn("black ticket purchased: cannot get nft item collection... skip")
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
-	if collectionValue.Address != blackTicketCollectionAccountID.ToRaw() {
+	if itemCollection != blackTicketCollectionAccountID.ToRaw() {
 		logger.Warn("black ticket purchased: black ticket collection address not matched... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	body, err := boc.DeserializeBocHex(message.GetRawBody().Value)
 	if err != nil {
 		logger.Warn("black ticket purchased: failed to deserialize new owner boc hex... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	bodyCell := body[0]
 	err = bodyCell.Skip(32)
 	if err != nil {
 		logger.Warn("black ticket purchased: failed to skip op code... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	err = bodyCell.Skip(64)
 	if err != nil {
 		logger.Warn("black ticket purchased: failed to skip query id... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	var newOwnerAddress tlb.MsgAddress
 	err = tlb.Unmarshal(bodyCell, &newOwnerAddress)
 	if err != nil {
 		logger.Warn("black ticket purchased: failed to read new owner address due to invalid tlb scheme... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
 	newOwnerUserAccountID, err := tongo.AccountIDFromTlb(newOwnerAddress)
-	if newOwnerUserAccountID == nil || err != nil || userAccountID.ToRaw() != newOwnerUserAccountID.ToRaw() {
+	if newOwnerUserAccountID == nil || err != nil {
 		logger.Warn("black ticket purchased: invalid new owner account address... skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
 	}
 
-	if saleDataOwnerAccountID.ToRaw() == newOwnerUserAccountID.ToRaw() {
+	if sale.Owner.ToRaw() == newOwnerUserAccountID.ToRaw() {
 		logger.Warn("black ticket purchased: it is not purchase, it is sale cancellation, skip")
-		return "", "", false
+		return "", "", ton.AccountID{}, ton.AccountID{}, false
+	}
+
+	return trace.Transaction.Hash, inMessageDestinationAccountID.ToHuman(true, false), *newOwnerUserAccountID, sale.Owner, true
+}
+
+// getSale reads the sale data of a sale contract with the first marketplace adapter that
+// understands it. The contract transferred the item, so the sale is complete and cached.
+func (t *Tracker) getSale(saleAddress string) (*marketplace.Sale, bool) {
+	sale, err := rateLimitRetry(t.ctx,
+		func() (*marketplace.Sale, error) {
+			return t.lookups.GetCompletedSale(t.ctx, saleAddress, t.marketplaces)
+		},
+	)
+
+	if errors.Is(err, marketplace.ErrUnknownSale) {
+		logger.Debug("black ticket purchased: unknown sale contract... skip", zap.String("address", saleAddress), zap.Error(err))
+		return nil, false
+	}
+
+	if err != nil {
+		logger.Debug("black ticket purchased: cannot get sale data... skip", zap.String("address", saleAddress), zap.Error(err))
+		return nil, false
 	}
 
-	return trace.Transaction.Hash, inMessageDestinationAccountID.ToHuman(true, false), true
+	return sale, true
 }
@@ -0,0 +1,58 @@
+package tracker
+
+import (
+	"backend/internal/conditions"
+	"backend/internal/logger"
+
+	"github.com/tonkeeper/tongo/ton"
+	"go.uber.org/zap"
+)
+
+// collectCollectionPurchaseActions collects the purchases of a purchase slot from the transfer
+// history of the collection items instead of the candidate wallets. Every item keeps its own
+// cursor, the buyers are matched against the candidates by address.
+func (t *Tracker) collectCollectionPurchaseActions(slot conditions.Slot, raffleDeployedLt int64) error {
+	logger.Debug("collect collection purchase actions...", zap.String("slot", slot.Name))
+
+	candidates, err := t.candidateAddresses()
+	if err != nil {
+		return storageError("collection purchases: get candidate registrations", err)
+	}
+
+	userStatusesConditionReached, err := t.storage.GetUserStatusesByConditionsReached()
+	if err != nil {
+		return storageError("collection purchases: get user statuses conditions reached", err)
+	}
+
+	conditionsReached := make(map[string]bool)
+	for _, status := range userStatusesConditionReached {
+		conditionsReached[status.UserAddress] = true
+	}
+
+	items, err := t.getCollectionItems(slot.Collection)
+	if err != nil {
+		return err
+	}
+
+	scans := make([]purchaseScan, 0, len(items))
+	for _, item := range items {
+		itemAddress := item.ToHuman(true, false)
+
+		// the cursor of an item is kept under its address in place of a user address
+		lastPurchasedLt, err := t.storage.GetUserActionTouchByAddress(slot.Name, itemAddress)
+		if err != nil {
+			return storageError("collection purchases: get last action transaction state", err)
+		}
+
+		scans = append(scans, purchaseScan{
+			accountAddress: itemAddress,
+			lastLt:         lastPurchasedLt,
+			attribute: func(buyer ton.AccountID) (string, bool) {
+				userAddress, ok := candidates[buyer]
+				return userAddress, ok && !conditionsReached[userAddress]
+			},
+		})
+	}
+
+	return t.runPurchaseScans("collection purchases: update actions", slot, raffleDeployedLt, scans)
+}
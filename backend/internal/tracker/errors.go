@@ -0,0 +1,100 @@
+package tracker
+
+import (
+	"backend/internal/blockchain"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/tonkeeper/tonapi-go"
+)
+
+// ErrorClass tells the run loop how to react to a failed cycle
+type ErrorClass = string
+
+const (
+	TransientErrorClass     ErrorClass = "transient"
+	RateLimitErrorClass     ErrorClass = "rate_limit"
+	MalformedErrorClass     ErrorClass = "malformed"
+	StorageErrorClass       ErrorClass = "storage"
+	UnrecoverableErrorClass ErrorClass = "unrecoverable"
+	CanceledErrorClass      ErrorClass = "canceled"
+)
+
+type Error struct {
+	Class     ErrorClass
+	Operation string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return e.Operation + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the class of the first classified error in the chain, unclassified errors
+// are considered transient.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return CanceledErrorClass
+	}
+
+	var trackerError *Error
+	if errors.As(err, &trackerError) {
+		return trackerError.Class
+	}
+
+	return TransientErrorClass
+}
+
+// sourceError classifies a chain source failure by its cause
+func sourceError(operation string, err error) error {
+	class := TransientErrorClass
+
+	var statusCodeError *tonapi.ErrorStatusCode
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		class = CanceledErrorClass
+	case errors.As(err, &statusCodeError) && statusCodeError.StatusCode == http.StatusTooManyRequests:
+		class = RateLimitErrorClass
+	case errors.As(err, &statusCodeError) && statusCodeError.StatusCode == http.StatusNotFound:
+		// traces and accounts are not found until the indexer has caught up with them
+		class = TransientErrorClass
+	case errors.As(err, &statusCodeError) && statusCodeError.StatusCode >= 400 && statusCodeError.StatusCode < 500:
+		class = MalformedErrorClass
+	case errors.Is(err, blockchain.ErrFixtureNotFound):
+		class = MalformedErrorClass
+	}
+
+	return &Error{Class: class, Operation: operation, Err: err}
+}
+
+// notFound tells whether a chain source failed because the object does not exist
+func notFound(err error) bool {
+	var statusCodeError *tonapi.ErrorStatusCode
+	return errors.As(err, &statusCodeError) && statusCodeError.StatusCode == http.StatusNotFound ||
+		errors.Is(err, blockchain.ErrFixtureNotFound)
+}
+
+// storageError passes a nil error through, so storage calls can be wrapped in place
+func storageError(operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: StorageErrorClass, Operation: operation, Err: err}
+}
+
+func malformedError(operation string, err error) error {
+	return &Error{Class: MalformedErrorClass, Operation: operation, Err: err}
+}
+
+func unrecoverableError(operation string, err error) error {
+	return &Error{Class: UnrecoverableErrorClass, Operation: operation, Err: err}
+}
@@ -0,0 +1,67 @@
+package tracker
+
+import (
+	"backend/internal/blockchain"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tonkeeper/tonapi-go"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{"rate limit", sourceError("op", &tonapi.ErrorStatusCode{StatusCode: 429}), RateLimitErrorClass},
+		{"bad request", sourceError("op", &tonapi.ErrorStatusCode{StatusCode: 400}), MalformedErrorClass},
+		{"not indexed yet", sourceError("op", &tonapi.ErrorStatusCode{StatusCode: 404}), TransientErrorClass},
+		{"server error", sourceError("op", &tonapi.ErrorStatusCode{StatusCode: 502}), TransientErrorClass},
+		{"missing fixture", sourceError("op", blockchain.ErrFixtureNotFound), MalformedErrorClass},
+		{"canceled", sourceError("op", context.Canceled), CanceledErrorClass},
+		{"storage", fmt.Errorf("wrapped: %w", storageError("op", errors.New("disk full"))), StorageErrorClass},
+		{"unrecoverable", unrecoverableError("op", errors.New("bad address")), UnrecoverableErrorClass},
+		{"unclassified", errors.New("connection reset"), TransientErrorClass},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if class := Classify(test.err); class != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, class)
+			}
+		})
+	}
+
+	if storageError("op", nil) != nil {
+		t.Errorf("expected nil storage error for nil error")
+	}
+}
+
+func TestRateLimitRetryDelay(t *testing.T) {
+	for attempt := 1; attempt < rateLimitRetryAttempts+4; attempt++ {
+		window := min(rateLimitRetryInitialDelay<<(attempt-1), rateLimitRetryMaxDelay)
+		for range 20 {
+			if delay := rateLimitRetryDelay(attempt); delay < window/2 || delay > window {
+				t.Fatalf("attempt %d: delay %s outside of %s..%s", attempt, delay, window/2, window)
+			}
+		}
+	}
+}
+
+func TestRateLimitRetryHonorsCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	_, err := rateLimitRetry(ctx, func() (*tonapi.Account, error) {
+		calls++
+		return nil, &tonapi.ErrorStatusCode{StatusCode: 429}
+	})
+
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("expected a canceled retry after one call, got %v after %d calls", err, calls)
+	}
+}
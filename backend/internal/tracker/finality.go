@@ -0,0 +1,254 @@
+package tracker
+
+import (
+	"backend/internal/blockchain"
+	"backend/internal/conditions"
+	"backend/internal/logger"
+	"backend/internal/metrics"
+	"backend/internal/storage"
+	"errors"
+
+	"github.com/tonkeeper/tonapi-go"
+	"go.uber.org/zap"
+)
+
+// updateFinalLt reads the last lt of the masterchain block finalityDepth blocks below the head,
+// the collectors only consider the traces up to it for the cycle
+func (t *Tracker) updateFinalLt() error {
+	head, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.BlockchainBlock, error) {
+			return t.source.GetMasterchainHead(t.ctx)
+		},
+	)
+	if err != nil {
+		return sourceError("finality: get masterchain head", err)
+	}
+
+	block := head
+	if t.finalityDepth > 0 {
+		seqno := max(head.Seqno-int32(t.finalityDepth), 0)
+		block, err = rateLimitRetry(t.ctx,
+			func() (*tonapi.BlockchainBlock, error) {
+				return t.source.GetMasterchainBlock(t.ctx, seqno)
+			},
+		)
+		if err != nil {
+			return sourceError("finality: get masterchain block", err)
+		}
+	}
+
+	t.finalLt = block.EndLt
+	logger.Debug("finality: final lt", zap.Int32("seqno", block.Seqno), zap.Int64("final lt", t.finalLt))
+	return nil
+}
+
+// traceFinal tells whether every transaction of the trace is at most the final lt, an emulated
+// trace never is
+func (t *Tracker) traceFinal(trace *tonapi.Trace) bool {
+	if trace.Emulated.Value || trace.Transaction.Lt > t.finalLt {
+		return false
+	}
+
+	for i := range trace.Children {
+		if !t.traceFinal(&trace.Children[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifyActions looks the transactions of a window of unverified final actions up again. An
+// action whose transaction no longer resolves is deleted together with what it contributed to
+// the user status, the others are not checked again.
+func (t *Tracker) verifyActions() error {
+	actions, err := t.storage.GetUnverifiedUserActions(t.finalLt, t.limitWindowSize)
+	if err != nil {
+		return storageError("finality: get unverified actions", err)
+	}
+
+	verified := make([]int64, 0, len(actions))
+	vanished := make([]*storage.UserAction, 0)
+	for _, action := range actions {
+		// a hold snapshot has no transaction
+		if action.TransactionHash == "" {
+			verified = append(verified, action.ID)
+			continue
+		}
+
+		found, err := t.transactionResolves(action.TransactionHash)
+		if errors.Is(err, blockchain.ErrLookupUnsupported) {
+			logger.Debug("finality: transaction lookups are not supported by the chain source, skip verification")
+			return nil
+		}
+
+		if err != nil {
+			return sourceError("finality: get transaction", err)
+		}
+
+		if found {
+			verified = append(verified, action.ID)
+		} else {
+			vanished = append(vanished, action)
+		}
+	}
+
+	err = t.transaction(func(t *Tracker) error {
+		if err := t.storage.MarkUserActionsVerified(verified); err != nil {
+			return storageError("finality: mark actions verified", err)
+		}
+
+		for _, action := range vanished {
+			if err := t.revertAction(action); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, action := range vanished {
+		metrics.RevertedActions.WithLabelValues(t.raffleLabel, action.ActionType).Inc()
+	}
+
+	return nil
+}
+
+// transactionResolves looks a stored hash up as a transaction hash first, then as the hash of
+// the in-message of a transaction, the registrations and mints store the latter
+func (t *Tracker) transactionResolves(hash string) (bool, error) {
+	_, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.Transaction, error) {
+			return t.source.GetTransaction(t.ctx, hash)
+		},
+	)
+	if err == nil || !notFound(err) {
+		return err == nil, err
+	}
+
+	_, err = rateLimitRetry(t.ctx,
+		func() (*tonapi.Transaction, error) {
+			return t.source.GetTransactionByMessageHash(t.ctx, hash)
+		},
+	)
+	if err == nil || !notFound(err) {
+		return err == nil, err
+	}
+
+	return false, nil
+}
+
+// revertAction deletes a vanished action: a candidate registration takes the user status with
+// it, a participant registration is cleared from the status and a counted condition action
+// lowers the confirmed value of its slot
+func (t *Tracker) revertAction(action *storage.UserAction) error {
+	logger.Warn("finality: transaction vanished, revert action",
+		zap.String("user address", action.UserAddress),
+		zap.String("action type", action.ActionType),
+		zap.String("transaction hash", action.TransactionHash),
+		zap.Int64("transaction lt", action.TransactionLt),
+	)
+
+	if err := t.storage.DeleteUserActions([]int64{action.ID}); err != nil {
+		return storageError("finality: delete action", err)
+	}
+
+	switch action.ActionType {
+	case storage.CandidateRegistrationActionType:
+		return storageError("finality: delete user status", t.storage.DeleteUserStatus(action.UserAddress))
+	case storage.ParticipantRegistrationActionType:
+		return t.revertParticipantRegistration(action)
+	}
+
+	if _, ok := t.rules.Slot(action.ActionType); !ok || action.FlagReason != "" {
+		return nil
+	}
+
+	return t.revertConditionAction(action)
+}
+
+func (t *Tracker) revertParticipantRegistration(action *storage.UserAction) error {
+	userStatuses, err := t.storage.GetUserStatusesByAddresses([]string{action.UserAddress})
+	if err != nil {
+		return storageError("finality: get user status", err)
+	}
+
+	if len(userStatuses) == 0 || userStatuses[0].ParticipantRegistrationLt != action.TransactionLt {
+		return nil
+	}
+
+	userStatuses[0].ParticipantRegistrationLt = 0
+	return storageError("finality: update user status", t.storage.UpdateUserStatuses(userStatuses))
+}
+
+// revertConditionAction recounts the slot of a counted action. The confirmed value is capped at
+// the target, so it only drops when the remaining counted actions fall below it.
+func (t *Tracker) revertConditionAction(action *storage.UserAction) error {
+	userConditions, err := t.storage.GetUserConditions([]string{action.UserAddress})
+	if err != nil {
+		return storageError("finality: get user conditions", err)
+	}
+
+	var userCondition *storage.UserCondition
+	for _, candidate := range userConditions {
+		if candidate.Slot == action.ActionType {
+			userCondition = candidate
+		}
+	}
+
+	if userCondition == nil || userCondition.ProcessedLt < action.TransactionLt {
+		return nil
+	}
+
+	userActions, err := t.storage.GetUserActionsByUser(action.UserAddress)
+	if err != nil {
+		return storageError("finality: get user actions", err)
+	}
+
+	var counted uint64
+	for _, userAction := range userActions {
+		if userAction.ActionType == action.ActionType && userAction.FlagReason == "" && userAction.TransactionLt <= userCondition.ProcessedLt {
+			counted++
+		}
+	}
+
+	if counted >= userCondition.Value {
+		return nil
+	}
+
+	userCondition.Value = counted
+	if err := t.storage.UpdateUserConditions([]*storage.UserCondition{userCondition}); err != nil {
+		return storageError("finality: update user conditions", err)
+	}
+
+	userStatuses, err := t.storage.GetUserStatusesByAddresses([]string{action.UserAddress})
+	if err != nil {
+		return storageError("finality: get user status", err)
+	}
+
+	if len(userStatuses) == 0 {
+		return nil
+	}
+
+	raffleConditions, err := t.storage.GetRaffleConditions(t.raffleLabel)
+	if err != nil {
+		return storageError("finality: get raffle conditions", err)
+	}
+
+	values := make([]uint64, len(raffleConditions))
+	targets := make([]uint64, len(raffleConditions))
+	for i, raffleCondition := range raffleConditions {
+		for _, candidate := range userConditions {
+			if candidate.Slot == raffleCondition.Slot {
+				values[i] = candidate.Value
+			}
+		}
+		targets[i] = raffleCondition.Target
+	}
+
+	userStatuses[0].ConditionsReached = len(raffleConditions) > 0 && conditions.Reached(values, targets)
+	return storageError("finality: update user status", t.storage.UpdateUserStatus(userStatuses[0]))
+}
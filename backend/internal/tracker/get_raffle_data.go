@@ -2,19 +2,27 @@ package tracker
 
 import (
 	"backend/internal/logger"
+	"backend/internal/storage"
+	"cmp"
 	"errors"
+	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/tonkeeper/tonapi-go"
 	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
 	"github.com/tonkeeper/tongo/ton"
 	"go.uber.org/zap"
 )
 
+var errMalformedRaffleData = errors.New("unexpected raffleData stack")
+var errMalformedParticipantData = errors.New("unexpected raffleParticipantData stack")
+
+// RaffleConditions are the targets the raffle declares, one per condition slot
 type RaffleConditions struct {
-	BlackTicketPurchased uint8
-	WhiteTicketMinted    uint8
+	Targets []uint64
 }
 
 type RaffleAccountData struct {
@@ -26,108 +34,315 @@ type RaffleAccountData struct {
 	CandidatesQuantity          uint64
 	ParticipantsQuantity        uint64
 	WinnersQuantity             uint8
-	Winners                     []string
+	Winners                     []*RaffleWinner
 }
 
-func (t *Tracker) GetRaffleAccountData() (*RaffleAccountData, error) {
+// RaffleWinner is a participant of the winners dictionary, with the wallet that registered it
+type RaffleWinner struct {
+	ParticipantIndex   uint64
+	ParticipantAddress string
+	UserAddress        string
+	// WinnerIndex is nil until the winner notification reached the participant contract
+	WinnerIndex *int
+}
+
+// RaffleParticipantData is what raffleParticipantData returns, UserAddress is empty and
+// WinnerIndex nil when the contract does not store them
+type RaffleParticipantData struct {
+	ParticipantIndex uint64
+	UserAddress      string
+	WinnerIndex      *int
+}
+
+// StoreRaffleConditions persists the raffle targets, so the progress of every candidate can be
+// reported against them.
+func (t *Tracker) StoreRaffleConditions(raffleConditions RaffleConditions) error {
+	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
+	if err != nil {
+		return unrecoverableError("store raffle conditions: parse raffle address", err)
+	}
+
+	// a slot expecting other targets than the raffle would never match on-chain
+	if err := t.rules.CheckTargets(raffleConditions.Targets); err != nil {
+		return unrecoverableError("store raffle conditions", err)
+	}
+
+	raffleConditionRows := make([]*storage.RaffleCondition, len(t.rules.Slots))
+	for i, slot := range t.rules.Slots {
+		raffleConditionRows[i] = &storage.RaffleCondition{
+			Position: i,
+			Slot:     slot.Name,
+			Kind:     slot.Kind,
+			Target:   raffleConditions.Targets[i],
+		}
+	}
+
+	if err := t.storage.UpdateRaffleConditions(raffleAccountID.ToRaw(), raffleConditionRows); err != nil {
+		return storageError("store raffle conditions", err)
+	}
+	return nil
+}
 
-	_, err := ton.ParseAccountID(t.raffleAddress)
+// GetRaffleAccountData reads the raffleData of the contract and resolves every winner through
+// its participant contract
+func (t *Tracker) GetRaffleAccountData() (*RaffleAccountData, error) {
+	raffleAccountData, winnerParticipantIndexes, err := t.getRaffleData()
 	if err != nil {
-		logger.Fatal("get raffle account data: failed to parse raffle address", zap.String("raffle address", t.raffleAddress), zap.Error(err))
 		return nil, err
 	}
 
-	raffleData, err := infinityRateLimitRetry(
+	for _, participantIndex := range winnerParticipantIndexes {
+		participantAddress, err := t.getParticipantAddress(participantIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		participantData, err := t.getParticipantData(participantAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		raffleAccountData.Winners = append(raffleAccountData.Winners, &RaffleWinner{
+			ParticipantIndex:   participantIndex,
+			ParticipantAddress: participantAddress,
+			UserAddress:        participantData.UserAddress,
+			WinnerIndex:        participantData.WinnerIndex,
+		})
+	}
+
+	// the drawn order first, a winner not notified yet has no index
+	slices.SortStableFunc(raffleAccountData.Winners, func(a, b *RaffleWinner) int {
+		switch {
+		case a.WinnerIndex == nil && b.WinnerIndex == nil:
+			return 0
+		case a.WinnerIndex == nil:
+			return 1
+		case b.WinnerIndex == nil:
+			return -1
+		}
+		return cmp.Compare(*a.WinnerIndex, *b.WinnerIndex)
+	})
+
+	return raffleAccountData, nil
+}
+
+// getRaffleData reads the raffleData of the contract without resolving the winners, it returns
+// the participant indexes of the winners dictionary in key order
+func (t *Tracker) getRaffleData() (*RaffleAccountData, []uint64, error) {
+	raffleData, err := rateLimitRetry(t.ctx,
 		func() (*tonapi.MethodExecutionResult, error) {
-			return t.client.ExecGetMethodForBlockchainAccount(t.ctx, tonapi.ExecGetMethodForBlockchainAccountParams{
-				AccountID:  t.raffleAddress,
-				MethodName: "raffleData",
-				Args:       make([]string, 0),
-			})
+			return t.source.ExecGetMethod(t.ctx, t.raffleAddress, "raffleData")
 		})
 
 	if err != nil {
-		logger.Fatal("get raffle account data: failed to get raffleData, invalid raffle contract")
-		return nil, err
+		return nil, nil, sourceError("get raffle account data: get raffleData", err)
 	}
 
-	minCandidateQuantityString, ok := raffleData.GetStack()[0].GetNum().Get()
-	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+	stack := raffleData.GetStack()
+	if len(stack) < 9 {
+		return nil, nil, malformedError("get raffle account data", errMalformedRaffleData)
 	}
-	minCandidateQuantity, err := strconv.ParseInt(strings.TrimLeft(minCandidateQuantityString, "0x"), 16, 32)
 
-	conditionsDurationString, ok := raffleData.GetStack()[1].GetNum().Get()
-	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+	minCandidateQuantity, err := stackUint(stack[0], 32)
+	if err != nil {
+		return nil, nil, malformedError("get raffle account data: min candidate quantity", err)
 	}
-	conditionsDuration, err := strconv.ParseInt(strings.TrimLeft(conditionsDurationString, "0x"), 16, 32)
 
-	conditionsString, ok := raffleData.GetStack()[2].GetCell().Get()
+	conditionsDuration, err := stackUint(stack[1], 32)
+	if err != nil {
+		return nil, nil, malformedError("get raffle account data: conditions duration", err)
+	}
+
+	conditionsString, ok := stack[2].GetCell().Get()
 	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+		return nil, nil, malformedError("get raffle account data", errMalformedRaffleData)
 	}
 
 	conditions, err := boc.DeserializeBocHex(conditionsString)
 	if err != nil {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+		return nil, nil, malformedError("get raffle account data", errMalformedRaffleData)
 	}
 
-	bodyCell := conditions[0]
-	blackTicketPurchased, err := bodyCell.ReadInt(8)
+	// the targets are laid out like the set conditions payload
+	targets, err := t.rules.Read(conditions[0])
 	if err != nil {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+		return nil, nil, malformedError("get raffle account data", err)
 	}
 
-	whiteTicketMinted, err := bodyCell.ReadInt(8)
+	logger.Debug("conditions", zap.Uint64s("targets", targets))
+
+	minCandidateReachedLt, err := stackUint(stack[3], 64)
 	if err != nil {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+		return nil, nil, malformedError("get raffle account data: min candidate reached lt", err)
 	}
 
-	logger.Debug("conditions", zap.Int64("blackTicketPurchased", blackTicketPurchased), zap.Int64("whiteTicketMinted", whiteTicketMinted))
-
-	minCandidateReachedLtString, ok := raffleData.GetStack()[3].GetNum().Get()
-	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+	minCandidateReachedUnixTime, err := stackUint(stack[4], 63)
+	if err != nil {
+		return nil, nil, malformedError("get raffle account data: min candidate reached unix time", err)
 	}
-	minCandidateReachedLt, err := strconv.ParseInt(strings.TrimLeft(minCandidateReachedLtString, "0x"), 16, 64)
 
-	minCandidateReachedUnixTimeString, ok := raffleData.GetStack()[4].GetNum().Get()
-	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+	candidatesQuantity, err := stackUint(stack[5], 64)
+	if err != nil {
+		return nil, nil, malformedError("get raffle account data: candidates quantity", err)
 	}
-	minCandidateReachedUnixTime, err := strconv.ParseInt(strings.TrimLeft(minCandidateReachedUnixTimeString, "0x"), 16, 64)
 
-	candidatesQuantityString, ok := raffleData.GetStack()[5].GetNum().Get()
-	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+	participantsQuantity, err := stackUint(stack[6], 64)
+	if err != nil {
+		return nil, nil, malformedError("get raffle account data: participants quantity", err)
 	}
-	candidatesQuantity, err := strconv.ParseInt(strings.TrimLeft(candidatesQuantityString, "0x"), 16, 64)
 
-	participantsQuantityString, ok := raffleData.GetStack()[6].GetNum().Get()
-	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+	winnersQuantity, err := stackUint(stack[7], 8)
+	if err != nil {
+		return nil, nil, malformedError("get raffle account data: winners quantity", err)
 	}
-	participantsQuantity, err := strconv.ParseInt(strings.TrimLeft(participantsQuantityString, "0x"), 16, 64)
 
-	winnersQuantityString, ok := raffleData.GetStack()[6].GetNum().Get()
-	if !ok {
-		return nil, errors.New("get raffle account data: failed to get raffle account data")
+	winnerParticipantIndexes, err := stackDictKeys(stack[8])
+	if err != nil {
+		return nil, nil, malformedError("get raffle account data: winners", err)
 	}
-	winnersQuantity, err := strconv.ParseInt(strings.TrimLeft(winnersQuantityString, "0x"), 16, 8)
 
 	return &RaffleAccountData{
-		MinCandidateQuantity: uint32(minCandidateQuantity),
-		ConditionsDuration:   uint32(conditionsDuration),
-		Conditions: RaffleConditions{
-			WhiteTicketMinted:    uint8(whiteTicketMinted),
-			BlackTicketPurchased: uint8(blackTicketPurchased),
-		},
-		MinCandidateReachedLt:       uint64(minCandidateReachedLt),
+		MinCandidateQuantity:        uint32(minCandidateQuantity),
+		ConditionsDuration:          uint32(conditionsDuration),
+		Conditions:                  RaffleConditions{Targets: targets},
+		MinCandidateReachedLt:       minCandidateReachedLt,
 		MinCandidateReachedUnixTime: int64(minCandidateReachedUnixTime),
-		CandidatesQuantity:          uint64(candidatesQuantity),
-		ParticipantsQuantity:        uint64(participantsQuantity),
+		CandidatesQuantity:          candidatesQuantity,
+		ParticipantsQuantity:        participantsQuantity,
 		WinnersQuantity:             uint8(winnersQuantity),
-		Winners:                     []string{},
-	}, nil
+		Winners:                     []*RaffleWinner{},
+	}, winnerParticipantIndexes, nil
+}
+
+// getParticipantAddress reads the address of the participant contract of an index
+func (t *Tracker) getParticipantAddress(participantIndex uint64) (string, error) {
+	result, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.MethodExecutionResult, error) {
+			return t.source.ExecGetMethod(t.ctx, t.raffleAddress, "raffleParticipantAddress",
+				tonapi.ExecGetMethodArg{Value: strconv.FormatUint(participantIndex, 10), Type: tonapi.ExecGetMethodArgTypeTinyint},
+			)
+		})
+	if err != nil {
+		return "", sourceError("get raffle account data: get raffleParticipantAddress", err)
+	}
+
+	if len(result.GetStack()) == 0 {
+		return "", malformedError("get raffle account data: get raffleParticipantAddress", errMalformedRaffleData)
+	}
+
+	participantAccountID, ok, err := stackAddress(result.GetStack()[0])
+	if err != nil {
+		return "", malformedError("get raffle account data: read participant address", err)
+	}
+	if !ok {
+		return "", malformedError("get raffle account data: read participant address", errMalformedRaffleData)
+	}
+
+	return participantAccountID.ToHuman(true, false), nil
+}
+
+// getParticipantData reads what the participant contract stores: its index, the user who
+// registered it and the winner index the raffle notified it of
+func (t *Tracker) getParticipantData(participantAddress string) (*RaffleParticipantData, error) {
+	participantAccountID, err := ton.ParseAccountID(participantAddress)
+	if err != nil {
+		return nil, malformedError("get participant data: parse participant address", err)
+	}
+
+	result, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.MethodExecutionResult, error) {
+			return t.source.ExecGetMethod(t.ctx, participantAccountID.ToRaw(), "raffleParticipantData")
+		})
+	if err != nil {
+		return nil, sourceError("get participant data: get raffleParticipantData", err)
+	}
+
+	stack := result.GetStack()
+	if len(stack) < 3 {
+		return nil, malformedError("get participant data", errMalformedParticipantData)
+	}
+
+	participantIndex, err := stackUint(stack[0], 64)
+	if err != nil {
+		return nil, malformedError("get participant data: participant index", err)
+	}
+
+	participantData := &RaffleParticipantData{ParticipantIndex: participantIndex}
+
+	userAccountID, ok, err := stackAddress(stack[1])
+	if err != nil {
+		return nil, malformedError("get participant data: user address", err)
+	}
+	if ok {
+		participantData.UserAddress = userAccountID.ToHuman(true, false)
+	}
+
+	if stack[2].Type != tonapi.TvmStackRecordTypeNull {
+		winnerIndex, err := stackUint(stack[2], 8)
+		if err != nil {
+			return nil, malformedError("get participant data: winner index", err)
+		}
+		index := int(winnerIndex)
+		participantData.WinnerIndex = &index
+	}
+
+	return participantData, nil
+}
+
+// stackUint reads an unsigned number of at most bitSize bits from a get-method stack
+func stackUint(record tonapi.TvmStackRecord, bitSize int) (uint64, error) {
+	num, ok := record.GetNum().Get()
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %s", record.Type)
+	}
+
+	return strconv.ParseUint(strings.TrimPrefix(num, "0x"), 16, bitSize)
+}
+
+// stackAddress reads an optional address from a get-method stack, the slice arrives as a cell
+func stackAddress(record tonapi.TvmStackRecord) (*ton.AccountID, bool, error) {
+	if record.Type == tonapi.TvmStackRecordTypeNull {
+		return nil, false, nil
+	}
+
+	cells, err := boc.DeserializeBocHex(record.GetCell().Value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var address tlb.MsgAddress
+	if err := tlb.Unmarshal(cells[0], &address); err != nil {
+		return nil, false, err
+	}
+
+	accountID, err := ton.AccountIDFromTlb(address)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return accountID, accountID != nil, nil
+}
+
+// stackDictKeys reads the uint64 keys of an optional dictionary from a get-method stack
+func stackDictKeys(record tonapi.TvmStackRecord) ([]uint64, error) {
+	if record.Type == tonapi.TvmStackRecordTypeNull {
+		return []uint64{}, nil
+	}
+
+	cells, err := boc.DeserializeBocHex(record.GetCell().Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var dict tlb.Hashmap[tlb.Uint64, tlb.Any]
+	if err := tlb.Unmarshal(cells[0], &dict); err != nil {
+		return nil, err
+	}
+
+	keys := make([]uint64, 0, len(dict.Keys()))
+	for _, key := range dict.Keys() {
+		keys = append(keys, uint64(key))
+	}
+
+	return keys, nil
 }
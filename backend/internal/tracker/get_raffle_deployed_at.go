@@ -9,42 +9,76 @@ import (
 	"go.uber.org/zap"
 )
 
+// ResolveRaffleDeployedLt returns the logical time the trackers start from. An explicit
+// override wins and replaces the stored value, otherwise the stored value is reused and only
+// the very first start walks the raffle traces back to its deployment.
+func (t *Tracker) ResolveRaffleDeployedLt(overrideLt int64) (int64, error) {
+	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
+	if err != nil {
+		return 0, unrecoverableError("resolve raffle deployed lt: parse raffle address", err)
+	}
+
+	if overrideLt > 0 {
+		logger.Info("resolve raffle deployed lt: using configured value", zap.Int64("lt", overrideLt))
+		if err := t.storage.UpdateRaffleDeployedLt(raffleAccountID.ToRaw(), overrideLt); err != nil {
+			return 0, storageError("resolve raffle deployed lt: update deployed lt", err)
+		}
+		return overrideLt, nil
+	}
+
+	deployedLt, err := t.storage.GetRaffleDeployedLt(raffleAccountID.ToRaw())
+	if err != nil {
+		return 0, storageError("resolve raffle deployed lt: get deployed lt", err)
+	}
+
+	if deployedLt > 0 {
+		logger.Info("resolve raffle deployed lt: using stored value", zap.Int64("lt", deployedLt))
+		return deployedLt, nil
+	}
+
+	logger.Info("resolve raffle deployed lt: searching raffle deployment trace...")
+	deployedLt, err = t.GetRaffleAccountDeployedLt()
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Info("resolve raffle deployed lt: searching raffle deployment trace... done", zap.Int64("lt", deployedLt))
+	if err := t.storage.UpdateRaffleDeployedLt(raffleAccountID.ToRaw(), deployedLt); err != nil {
+		return 0, storageError("resolve raffle deployed lt: update deployed lt", err)
+	}
+	return deployedLt, nil
+}
+
 func (t *Tracker) GetRaffleAccountDeployedLt() (int64, error) {
 	var lastTraceID *tonapi.TraceID = nil
 
 	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to parse raffle address", zap.String("raffle address", t.raffleAddress), zap.Error(err))
-		return 0, err
+		return 0, unrecoverableError("get raffle deployed lt: parse raffle address", err)
 	}
 
-	var beforeLt = tonapi.OptInt64{Value: 0, Set: false}
+	var beforeLt int64 = 0
 	for {
 		if lastTraceID != nil {
-			lastTrace, err := infinityRateLimitRetry(
+			lastTrace, err := rateLimitRetry(t.ctx,
 				func() (*tonapi.Trace, error) {
-					return t.client.GetTrace(t.ctx, tonapi.GetTraceParams{TraceID: lastTraceID.GetID()})
+					return t.source.GetTrace(t.ctx, lastTraceID.GetID())
 				},
 			)
 			if err != nil {
-				return 0, err
+				return 0, sourceError("get raffle deployed lt: get trace", err)
 			}
-			beforeLt = tonapi.NewOptInt64(lastTrace.Transaction.Lt)
+			beforeLt = lastTrace.Transaction.Lt
 		}
 
 		logger.Debug("verify raffle account: search first traceID")
-		accountTracesResult, err := infinityRateLimitRetry(
+		accountTracesResult, err := rateLimitRetry(t.ctx,
 			func() (*tonapi.TraceIDs, error) {
-				return t.client.GetAccountTraces(t.ctx, tonapi.GetAccountTracesParams{
-					AccountID: raffleAccountID.ToRaw(),
-					Limit:     tonapi.NewOptInt(GlobalLimitWindowSize),
-					BeforeLt:  beforeLt,
-				})
+				return t.source.GetAccountTraces(t.ctx, raffleAccountID.ToRaw(), t.limitWindowSize, beforeLt)
 			})
 
 		if err != nil {
-			logger.Fatal("verify raffle account: failed to search first traceID", zap.Error(err))
-			return 0, err
+			return 0, sourceError("get raffle deployed lt: collect traces", err)
 		}
 
 		if len(accountTracesResult.Traces) > 0 {
@@ -52,23 +86,23 @@ func (t *Tracker) GetRaffleAccountDeployedLt() (int64, error) {
 		}
 
 		logger.Debug("verify raffle account: check conditions in proper to continue", zap.Int("trace count", len(accountTracesResult.Traces)))
-		if len(accountTracesResult.Traces) < GlobalLimitWindowSize {
+		if len(accountTracesResult.Traces) < t.limitWindowSize {
 			break
 		}
 	}
 
 	if lastTraceID == nil {
-		return 0, errors.New("verify raffle account: no traces found")
+		return 0, unrecoverableError("get raffle deployed lt", errors.New("no traces found"))
 	}
 
-	lastTrace, err := infinityRateLimitRetry(
+	lastTrace, err := rateLimitRetry(t.ctx,
 		func() (*tonapi.Trace, error) {
-			return t.client.GetTrace(t.ctx, tonapi.GetTraceParams{TraceID: lastTraceID.GetID()})
+			return t.source.GetTrace(t.ctx, lastTraceID.GetID())
 		},
 	)
 
 	if err != nil {
-		return 0, err
+		return 0, sourceError("get raffle deployed lt: get trace", err)
 	}
 
 	return lastTrace.Transaction.GetLt(), nil
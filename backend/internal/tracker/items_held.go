@@ -0,0 +1,175 @@
+package tracker
+
+import (
+	"backend/internal/conditions"
+	"backend/internal/logger"
+	"backend/internal/storage"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"go.uber.org/zap"
+)
+
+var errMalformedCollectionData = errors.New("unexpected collection stack")
+
+// collectHoldActions counts the items of the collection of a hold slot held by the candidates.
+// Holdings are taken once, on the first run after the snapshot time; an item on sale counts
+// for its seller.
+func (t *Tracker) collectHoldActions(slot conditions.Slot) error {
+	logger.Debug("collect hold actions...", zap.String("slot", slot.Name))
+
+	snapshotLt, err := t.storage.GetUserActionTouch(slot.Name)
+	if err != nil {
+		return storageError("items held: get last action transaction state", err)
+	}
+
+	if snapshotLt > 0 {
+		logger.Debug("items held: snapshot already taken, skip", zap.String("slot", slot.Name))
+		return nil
+	}
+
+	if time.Now().Unix() < slot.SnapshotUnixTime {
+		logger.Debug("items held: snapshot time not reached, skip", zap.String("slot", slot.Name))
+		return nil
+	}
+
+	candidates, err := t.candidateAddresses()
+	if err != nil {
+		return storageError("items held: get candidate registration actions", err)
+	}
+
+	items, err := t.getCollectionItems(slot.Collection)
+	if err != nil {
+		return err
+	}
+
+	var actions = make([]*storage.UserAction, 0)
+	for _, itemAddress := range items {
+		item, err := rateLimitRetry(t.ctx,
+			func() (*tonapi.NftItem, error) {
+				return t.source.GetNftItem(t.ctx, itemAddress.ToRaw())
+			})
+		if err != nil {
+			return sourceError("items held: get nft item", err)
+		}
+
+		owner, ok := item.GetOwner().Get()
+		if sale, onSale := item.GetSale().Get(); onSale {
+			owner, ok = sale.GetOwner().Get()
+		}
+
+		if !ok {
+			continue
+		}
+
+		ownerAccountID, err := ton.ParseAccountID(owner.Address)
+		if err != nil {
+			logger.Debug("items held: invalid owner address... skip", zap.String("item address", itemAddress.ToRaw()))
+			continue
+		}
+
+		userAddress, ok := candidates[ownerAccountID]
+		if !ok {
+			continue
+		}
+
+		logger.Info("items held: append action",
+			zap.String("user address", userAddress),
+			zap.String("item address", itemAddress.ToHuman(true, false)),
+		)
+
+		// a snapshot has no transaction, the snapshot time orders it instead
+		actions = append(actions, &storage.UserAction{
+			ActionType:          slot.Name,
+			UserAddress:         userAddress,
+			Address:             itemAddress.ToHuman(true, false),
+			TransactionLt:       slot.SnapshotUnixTime,
+			TransactionUnixTime: slot.SnapshotUnixTime,
+		})
+	}
+
+	touch := &storage.UserActionTouch{
+		ActionType:    slot.Name,
+		UserAddress:   "-",
+		TransactionLt: slot.SnapshotUnixTime,
+	}
+
+	if err := t.commitActions("items held: update actions", actions, touch); err != nil {
+		return err
+	}
+
+	t.observeActions(slot.Name, "-", slot.SnapshotUnixTime, len(actions))
+
+	return nil
+}
+
+// getCollectionItems lists the item addresses of a collection by index
+func (t *Tracker) getCollectionItems(collectionAddress string) ([]ton.AccountID, error) {
+	collectionData, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.MethodExecutionResult, error) {
+			return t.source.ExecGetMethod(t.ctx, collectionAddress, "get_collection_data")
+		})
+	if err != nil {
+		return nil, sourceError("collection items: get collection data", err)
+	}
+
+	if len(collectionData.GetStack()) == 0 {
+		return nil, malformedError("collection items: get collection data", errMalformedCollectionData)
+	}
+
+	nextItemIndexString, ok := collectionData.GetStack()[0].GetNum().Get()
+	if !ok {
+		return nil, malformedError("collection items: get collection data", errMalformedCollectionData)
+	}
+
+	nextItemIndex, err := strconv.ParseInt(nextItemIndexString, 0, 64)
+	if err != nil {
+		return nil, malformedError("collection items: get collection data", err)
+	}
+
+	items := make([]ton.AccountID, 0, nextItemIndex)
+	for index := int64(0); index < nextItemIndex; index++ {
+		itemAddressResult, err := rateLimitRetry(t.ctx,
+			func() (*tonapi.MethodExecutionResult, error) {
+				return t.source.ExecGetMethod(t.ctx, collectionAddress, "get_nft_address_by_index",
+					tonapi.ExecGetMethodArg{Value: strconv.FormatInt(index, 10), Type: tonapi.ExecGetMethodArgTypeInt257},
+				)
+			})
+		if err != nil {
+			return nil, sourceError("collection items: get nft address by index", err)
+		}
+
+		if len(itemAddressResult.GetStack()) == 0 {
+			return nil, malformedError("collection items: get nft address by index", errMalformedCollectionData)
+		}
+
+		itemAddressCell, ok := itemAddressResult.GetStack()[0].GetCell().Get()
+		if !ok {
+			return nil, malformedError("collection items: get nft address by index", errMalformedCollectionData)
+		}
+
+		cells, err := boc.DeserializeBocHex(itemAddressCell)
+		if err != nil || len(cells) == 0 {
+			return nil, malformedError("collection items: get nft address by index", errMalformedCollectionData)
+		}
+
+		var itemAddress tlb.MsgAddress
+		if err := tlb.Unmarshal(cells[0], &itemAddress); err != nil {
+			return nil, malformedError("collection items: get nft address by index", err)
+		}
+
+		itemAccountID, err := ton.AccountIDFromTlb(itemAddress)
+		if err != nil || itemAccountID == nil {
+			return nil, malformedError("collection items: get nft address by index", errMalformedCollectionData)
+		}
+
+		items = append(items, *itemAccountID)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"backend/internal/logger"
+	"backend/internal/storage"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+var errNotCandidate = errors.New("user is not a candidate of the raffle")
+
+// ResetUserCursors deletes the cursors of the accounts scanned for the user, the next cycle
+// collects their traces again from the raffle deployment. Re-collected actions are upserted
+// and counted once. It returns the deleted cursors.
+func (t *Tracker) ResetUserCursors(userAddress string) ([]*storage.UserActionTouch, error) {
+	var touches []*storage.UserActionTouch
+	err := t.storage.Transaction(func(tx storage.Storage) error {
+		var err error
+		touches, err = tx.GetUserActionTouchesByAddress(userAddress)
+		if err != nil {
+			return err
+		}
+
+		return tx.DeleteUserActionTouchesByAddress(userAddress)
+	})
+	if err != nil {
+		return nil, storageError("resync: reset user cursors", err)
+	}
+
+	logger.Info("resync: user cursors reset", zap.String("user address", userAddress), zap.Int("cursors", len(touches)))
+	return touches, nil
+}
+
+// PushConditions enqueues the confirmed slot values of a candidate again and processes the
+// outbox, for a candidate contract that lost track of them. The other queued messages are sent
+// along.
+func (t *Tracker) PushConditions(userAddress string) ([]storage.ConditionValue, error) {
+	userStatuses, err := t.storage.GetUserStatusesByAddresses([]string{userAddress})
+	if err != nil {
+		return nil, storageError("push conditions: get user status", err)
+	}
+
+	if len(userStatuses) == 0 {
+		return nil, unrecoverableError("push conditions", errNotCandidate)
+	}
+
+	userConditions, err := t.storage.GetUserConditions([]string{userAddress})
+	if err != nil {
+		return nil, storageError("push conditions: get user conditions", err)
+	}
+
+	values := make([]storage.ConditionValue, len(t.rules.Slots))
+	for i, slot := range t.rules.Slots {
+		values[i] = storage.ConditionValue{Slot: slot.Name}
+		for _, userCondition := range userConditions {
+			if userCondition.Slot == slot.Name {
+				values[i].Value = userCondition.Value
+				values[i].ProcessedLt = userCondition.ProcessedLt
+			}
+		}
+	}
+
+	logger.Info("push conditions: enqueue set conditions", zap.String("user address", userAddress), zap.Uint64s("conditions", conditionValues(values)))
+
+	err = t.transaction(func(t *Tracker) error {
+		return t.enqueueSetConditions(userAddress, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, t.processOutbox()
+}
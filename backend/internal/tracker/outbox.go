@@ -0,0 +1,556 @@
+package tracker
+
+import (
+	"backend/internal/blockchain"
+	"backend/internal/conditions"
+	"backend/internal/logger"
+	"backend/internal/metrics"
+	"backend/internal/storage"
+	"errors"
+	"time"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/wallet"
+	"go.uber.org/zap"
+)
+
+const outboxMaxAttempts = 5
+const outboxConfirmationTimeout = 10 * time.Minute
+
+// enqueueSetConditions records the intent to push the slot values of the user on-chain. Values
+// of messages still in flight are merged in, so a later message never lowers what an earlier
+// one has set.
+func (t *Tracker) enqueueSetConditions(userAddress string, values []storage.ConditionValue) error {
+	messages, err := t.storage.GetOutboxMessagesByUser(userAddress)
+	if err != nil {
+		return storageError("outbox: get outbox messages by user", err)
+	}
+
+	next := &storage.OutboxMessage{
+		UserAddress: userAddress,
+		State:       storage.QueuedOutboxState,
+		Conditions:  append([]storage.ConditionValue(nil), values...),
+	}
+
+	var queued *storage.OutboxMessage
+	for _, message := range messages {
+		if message.State == storage.QueuedOutboxState || message.State == storage.SentOutboxState {
+			mergeOutboxMessage(next, message)
+		}
+
+		if message.State == storage.QueuedOutboxState {
+			queued = message
+		}
+	}
+
+	rejected := 0
+	for _, message := range messages {
+		switch message.State {
+		case storage.SentOutboxState:
+			if conditionsCover(message.Conditions, next.Conditions) {
+				logger.Debug("enqueue set conditions: already in flight", zap.String("user address", next.UserAddress), zap.Int64("id", message.ID))
+				return nil
+			}
+		case storage.FailedOutboxState, storage.BouncedOutboxState:
+			if conditionsCover(message.Conditions, next.Conditions) && conditionsCover(next.Conditions, message.Conditions) {
+				rejected++
+			}
+		}
+	}
+
+	if queued != nil {
+		mergeOutboxMessage(queued, next)
+		return storageError("outbox: update outbox message", t.storage.UpdateOutboxMessage(queued))
+	}
+
+	if rejected >= outboxMaxAttempts {
+		logger.Warn("enqueue set conditions: conditions were rejected too many times, skipping",
+			zap.String("user address", next.UserAddress),
+			zap.Uint64s("conditions", conditionValues(next.Conditions)),
+		)
+		return nil
+	}
+
+	return storageError("outbox: create outbox message", t.storage.CreateOutboxMessage(next))
+}
+
+func (t *Tracker) processOutbox() error {
+	queued, err := t.storage.GetOutboxMessages(storage.QueuedOutboxState)
+	if err != nil {
+		return storageError("outbox: get outbox messages", err)
+	}
+
+	batchSize := max(t.wallet.MaxMessages(), 1)
+	for start := 0; start < len(queued); start += batchSize {
+		if err := t.sendOutboxBatch(queued[start:min(start+batchSize, len(queued))]); err != nil {
+			return err
+		}
+	}
+
+	sent, err := t.storage.GetOutboxMessages(storage.SentOutboxState)
+	if err != nil {
+		return storageError("outbox: get outbox messages", err)
+	}
+
+	for _, message := range sent {
+		if err := t.confirmOutboxMessage(message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendOutboxBatch sends the outbox messages in one external message. A message that cannot be
+// built fails on its own, a failed send is retried for the whole batch.
+func (t *Tracker) sendOutboxBatch(messages []*storage.OutboxMessage) error {
+	logger.Info("outbox: sending set conditions...", zap.Int("messages", len(messages)))
+
+	batch := make([]*storage.OutboxMessage, 0, len(messages))
+	sendables := make([]wallet.Sendable, 0, len(messages))
+	for _, message := range messages {
+		logger.Debug("outbox: set conditions",
+			zap.Int64("id", message.ID),
+			zap.String("user address", message.UserAddress),
+			zap.Uint64s("conditions", conditionValues(message.Conditions)),
+		)
+
+		setConditionsMessage, err := t.setConditionsMessage(message.UserAddress, t.slotValues(message.Conditions))
+		if err != nil {
+			logger.Warn("outbox: cannot build set conditions", zap.Int64("id", message.ID), zap.Error(err))
+
+			message.State = storage.FailedOutboxState
+			message.Error = err.Error()
+			if err := t.storage.UpdateOutboxMessage(message); err != nil {
+				return storageError("outbox: update outbox message", err)
+			}
+			metrics.SetConditions.WithLabelValues(t.raffleLabel, "failed").Inc()
+			continue
+		}
+
+		batch = append(batch, message)
+		sendables = append(sendables, setConditionsMessage)
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	result, sendErr := t.wallet.Send(t.ctx, 60*time.Second, sendables...)
+	if sendErr != nil {
+		logger.Warn("outbox: cannot send set conditions to blockchain", zap.Int("messages", len(batch)), zap.Error(sendErr))
+	}
+
+	for _, message := range batch {
+		message.Attempts++
+
+		if sendErr != nil {
+			message.Error = sendErr.Error()
+			if message.Attempts >= outboxMaxAttempts {
+				message.State = storage.FailedOutboxState
+				metrics.SetConditions.WithLabelValues(t.raffleLabel, "failed").Inc()
+			}
+		} else {
+			message.State = storage.SentOutboxState
+			message.Seqno = result.Seqno
+			message.QueryID = result.QueryID
+			message.MessageHash = result.MessageHash.Hex()
+			message.Error = ""
+			message.SentAt = time.Now()
+			metrics.SetConditions.WithLabelValues(t.raffleLabel, "sent").Inc()
+		}
+
+		if err := t.storage.UpdateOutboxMessage(message); err != nil {
+			return storageError("outbox: update outbox message", err)
+		}
+	}
+
+	if sendErr == nil {
+		logger.Info("outbox: sending set conditions... done",
+			zap.Int("messages", len(batch)),
+			zap.Uint32("seqno", result.Seqno),
+			zap.Uint64("query id", result.QueryID),
+			zap.String("message hash", result.MessageHash.Hex()),
+		)
+	}
+
+	return nil
+}
+
+func (t *Tracker) confirmOutboxMessage(message *storage.OutboxMessage) error {
+	if message.TraceID == "" {
+		message.TraceID = t.findOutboxTrace(message)
+	}
+
+	if message.TraceID != "" {
+		trace, err := rateLimitRetry(t.ctx,
+			func() (*tonapi.Trace, error) {
+				return t.source.GetTrace(t.ctx, message.TraceID)
+			})
+
+		if err == nil && t.setConditionsBounced(trace, message.UserAddress) {
+			logger.Warn("outbox: set conditions bounced", zap.Int64("id", message.ID), zap.String("trace id", message.TraceID))
+
+			message.State = storage.BouncedOutboxState
+			metrics.SetConditions.WithLabelValues(t.raffleLabel, "bounced").Inc()
+			return storageError("outbox: update outbox message", t.storage.UpdateOutboxMessage(message))
+		}
+	}
+
+	values, err := t.getCandidateConditions(message.UserAddress)
+	if err != nil {
+		logger.Debug("outbox: cannot read candidate conditions yet", zap.Int64("id", message.ID), zap.Error(err))
+	}
+
+	if err == nil && slotValuesCover(values, t.slotValues(message.Conditions)) {
+		state := message.State
+		message.State = storage.ConfirmedOutboxState
+		err := t.transaction(func(t *Tracker) error {
+			if err := t.applyOutboxMessage(message); err != nil {
+				return err
+			}
+
+			return storageError("outbox: update outbox message", t.storage.UpdateOutboxMessage(message))
+		})
+		if err != nil {
+			message.State = state
+			return err
+		}
+
+		logger.Info("outbox: set conditions confirmed", zap.Int64("id", message.ID), zap.String("user address", message.UserAddress))
+		metrics.SetConditions.WithLabelValues(t.raffleLabel, "confirmed").Inc()
+		return nil
+	}
+
+	if time.Since(message.SentAt) > outboxConfirmationTimeout {
+		logger.Warn("outbox: set conditions not confirmed in time", zap.Int64("id", message.ID))
+
+		message.State = storage.FailedOutboxState
+		message.Error = "not confirmed in time"
+		metrics.SetConditions.WithLabelValues(t.raffleLabel, "failed").Inc()
+	}
+
+	return storageError("outbox: update outbox message", t.storage.UpdateOutboxMessage(message))
+}
+
+// applyOutboxMessage advances the user conditions to the confirmed values and records whether
+// they now reach the raffle targets
+func (t *Tracker) applyOutboxMessage(message *storage.OutboxMessage) error {
+	userStatus, err := t.storage.GetUserStatusByAddress(message.UserAddress)
+	if err != nil {
+		return storageError("outbox: get user status by address", err)
+	}
+
+	userConditions, err := t.storage.GetUserConditions([]string{message.UserAddress})
+	if err != nil {
+		return storageError("outbox: get user conditions", err)
+	}
+
+	userConditionsMap := make(map[string]*storage.UserCondition)
+	for _, userCondition := range userConditions {
+		userConditionsMap[userCondition.Slot] = userCondition
+	}
+
+	for _, value := range message.Conditions {
+		userCondition, ok := userConditionsMap[value.Slot]
+		if !ok {
+			userCondition = &storage.UserCondition{UserAddress: message.UserAddress, Slot: value.Slot}
+			userConditionsMap[value.Slot] = userCondition
+			userConditions = append(userConditions, userCondition)
+		}
+
+		userCondition.Value = max(userCondition.Value, value.Value)
+		userCondition.ProcessedLt = max(userCondition.ProcessedLt, value.ProcessedLt)
+	}
+
+	if err := t.storage.UpdateUserConditions(userConditions); err != nil {
+		return storageError("outbox: update user conditions", err)
+	}
+
+	raffleConditions, err := t.storage.GetRaffleConditions(t.raffleLabel)
+	if err != nil {
+		return storageError("outbox: get raffle conditions", err)
+	}
+
+	values := make([]uint64, len(raffleConditions))
+	targets := make([]uint64, len(raffleConditions))
+	for i, raffleCondition := range raffleConditions {
+		if userCondition, ok := userConditionsMap[raffleCondition.Slot]; ok {
+			values[i] = userCondition.Value
+		}
+		targets[i] = raffleCondition.Target
+	}
+
+	userStatus.ConditionsReached = len(raffleConditions) > 0 && conditions.Reached(values, targets)
+	userStatus.LastDeployedUnixTime = time.Now().Unix()
+
+	return storageError("outbox: update user status", t.storage.UpdateUserStatus(userStatus))
+}
+
+// findOutboxTrace looks for the wallet trace started by the external message of the outbox
+// message, an empty trace id means it is not indexed yet.
+func (t *Tracker) findOutboxTrace(message *storage.OutboxMessage) string {
+	walletAccountID := t.wallet.GetAddress()
+
+	accountTracesResult, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.TraceIDs, error) {
+			return t.source.GetAccountTraces(t.ctx, walletAccountID.ToRaw(), t.limitWindowSize, 0)
+		})
+
+	if err != nil {
+		logger.Debug("outbox: cannot get wallet traces", zap.Error(err))
+		return ""
+	}
+
+	for _, traceID := range accountTracesResult.GetTraces() {
+		if traceID.GetUtime() < message.SentAt.Add(-time.Minute).Unix() {
+			continue
+		}
+
+		trace, err := rateLimitRetry(t.ctx,
+			func() (*tonapi.Trace, error) {
+				return t.source.GetTrace(t.ctx, traceID.GetID())
+			})
+
+		if err != nil {
+			logger.Debug("outbox: cannot get wallet trace", zap.String("trace id", traceID.GetID()), zap.Error(err))
+			continue
+		}
+
+		if inMsg, ok := trace.Transaction.GetInMsg().Get(); ok && inMsg.GetHash() == message.MessageHash {
+			return traceID.GetID()
+		}
+	}
+
+	return ""
+}
+
+// setConditionsBounced looks up the raffle transaction handling the set conditions of the user,
+// a batch trace carries the messages of many users and only this branch matters.
+func (t *Tracker) setConditionsBounced(trace *tonapi.Trace, userAddress string) bool {
+	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
+	if err != nil {
+		return false
+	}
+
+	userAccountID, err := ton.ParseAccountID(userAddress)
+	if err != nil {
+		return false
+	}
+
+	if trace.Transaction.Account.Address == raffleAccountID.ToRaw() && isSetConditionsOf(trace, userAccountID) {
+		return traceBounced(trace)
+	}
+
+	for i := range trace.Children {
+		if t.setConditionsBounced(&trace.Children[i], userAddress) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSetConditionsOf(trace *tonapi.Trace, userAccountID ton.AccountID) bool {
+	inMsg, ok := trace.Transaction.GetInMsg().Get()
+	if !ok {
+		return false
+	}
+
+	rawBody, ok := inMsg.GetRawBody().Get()
+	if !ok {
+		return false
+	}
+
+	var setConditions blockchain.RaffleSetConditions
+	if err := blockchain.UnmarshalBody(rawBody, &setConditions); err != nil {
+		return false
+	}
+
+	accountID, err := ton.AccountIDFromTlb(setConditions.UserAddress)
+	return err == nil && accountID != nil && *accountID == userAccountID
+}
+
+func traceBounced(trace *tonapi.Trace) bool {
+	if trace.Transaction.GetAborted() {
+		return true
+	}
+
+	if inMsg, ok := trace.Transaction.GetInMsg().Get(); ok && inMsg.GetBounced() {
+		return true
+	}
+
+	for i := range trace.Children {
+		if traceBounced(&trace.Children[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getCandidateConditions reads the slot values stored by the candidate contract of the user,
+// in the layout written by setConditionsMessage.
+func (t *Tracker) getCandidateConditions(userAddress string) ([]uint64, error) {
+	userAccountID, err := ton.ParseAccountID(userAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	raffleCandidateAddressResult, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.MethodExecutionResult, error) {
+			return t.source.ExecGetMethod(t.ctx, t.raffleAddress, "raffleCandidateAddress",
+				tonapi.ExecGetMethodArg{Value: userAccountID.ToRaw(), Type: tonapi.ExecGetMethodArgTypeSlice},
+			)
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raffleCandidateAddressResult.GetStack()) == 0 {
+		return nil, errors.New("get candidate conditions: empty raffleCandidateAddress result")
+	}
+
+	cell, err := boc.DeserializeBocHex(raffleCandidateAddressResult.GetStack()[0].GetCell().Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var raffleCandidateAddress tlb.MsgAddress
+	if err := tlb.Unmarshal(cell[0], &raffleCandidateAddress); err != nil {
+		return nil, err
+	}
+
+	raffleCandidateAccountID, err := ton.AccountIDFromTlb(raffleCandidateAddress)
+	if err != nil || raffleCandidateAccountID == nil {
+		return nil, errors.New("get candidate conditions: invalid raffle candidate address")
+	}
+
+	raffleCandidateDataResult, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.MethodExecutionResult, error) {
+			return t.source.ExecGetMethod(t.ctx, raffleCandidateAccountID.ToRaw(), "raffleCandidateData")
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raffleCandidateDataResult.GetStack()) == 0 {
+		return nil, errors.New("get candidate conditions: empty raffleCandidateData result")
+	}
+
+	cell, err = boc.DeserializeBocHex(raffleCandidateDataResult.GetStack()[0].GetCell().Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.rules.Read(cell[0])
+}
+
+func (t *Tracker) setConditionsMessage(userAddress string, values []uint64) (wallet.Message, error) {
+	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
+	if err != nil {
+		return wallet.Message{}, err
+	}
+
+	userAccountID, err := ton.ParseAccountID(userAddress)
+	if err != nil {
+		return wallet.Message{}, err
+	}
+
+	conditions, err := t.rules.Bits(values)
+	if err != nil {
+		return wallet.Message{}, err
+	}
+
+	body, err := blockchain.MarshalBody(blockchain.RaffleSetConditions{
+		UserAddress: userAccountID.ToMsgAddress(),
+		Conditions:  conditions,
+	})
+	if err != nil {
+		return wallet.Message{}, err
+	}
+
+	return wallet.Message{
+		Amount:  tlb.Grams(t.setConditionsAmount),
+		Address: raffleAccountID,
+		Bounce:  true,
+		Mode:    wallet.DefaultMessageMode,
+		Body:    body,
+	}, nil
+}
+
+func mergeOutboxMessage(message *storage.OutboxMessage, other *storage.OutboxMessage) {
+	for _, value := range other.Conditions {
+		merged := false
+		for i := range message.Conditions {
+			if message.Conditions[i].Slot == value.Slot {
+				message.Conditions[i].Value = max(message.Conditions[i].Value, value.Value)
+				message.Conditions[i].ProcessedLt = max(message.Conditions[i].ProcessedLt, value.ProcessedLt)
+				merged = true
+			}
+		}
+
+		if !merged {
+			message.Conditions = append(message.Conditions, value)
+		}
+	}
+}
+
+// conditionsCover tells whether the values of message are at least those of other, slot by slot
+func conditionsCover(message []storage.ConditionValue, other []storage.ConditionValue) bool {
+	values := make(map[string]uint64)
+	for _, value := range message {
+		values[value.Slot] = value.Value
+	}
+
+	for _, value := range other {
+		if values[value.Slot] < value.Value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// slotValues lays the values of an outbox message out in the slot order, slots the message
+// does not carry are zero
+func (t *Tracker) slotValues(values []storage.ConditionValue) []uint64 {
+	result := make([]uint64, len(t.rules.Slots))
+	for _, value := range values {
+		for i, slot := range t.rules.Slots {
+			if slot.Name == value.Slot {
+				result[i] = value.Value
+			}
+		}
+	}
+
+	return result
+}
+
+func slotValuesCover(values []uint64, other []uint64) bool {
+	if len(values) != len(other) {
+		return false
+	}
+
+	for i := range values {
+		if values[i] < other[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func conditionValues(values []storage.ConditionValue) []uint64 {
+	result := make([]uint64, len(values))
+	for i, value := range values {
+		result[i] = value.Value
+	}
+
+	return result
+}
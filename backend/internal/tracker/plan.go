@@ -0,0 +1,243 @@
+package tracker
+
+import (
+	"backend/internal/blockchain"
+	"backend/internal/conditions"
+	"backend/internal/logger"
+	"backend/internal/storage"
+	"errors"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// errDryRun rolls the transaction of a dry run back
+var errDryRun = errors.New("dry run")
+
+// PlannedUser is what a cycle would change for one user
+type PlannedUser struct {
+	UserAddress string
+	// Old is nil when the cycle registers the candidate, New when a verification removed it
+	Old *storage.UserStatus
+	New *storage.UserStatus
+	// Conditions are the confirmed and the sent value of every slot, empty when no set
+	// conditions message would be sent to the user
+	Conditions []PlannedCondition
+	// ConditionsReached tells whether the sent values reach the raffle targets
+	ConditionsReached bool
+	// Body is the hex BOC of the set conditions body, Error why it cannot be built
+	Body  string
+	Error string
+}
+
+type PlannedCondition struct {
+	Slot string
+	Old  uint64
+	New  uint64
+}
+
+// snapshotStorage is a storage that can copy its database, a dry run then plans against the
+// copy instead of holding the database lock while it collects
+type snapshotStorage interface {
+	Snapshot() (storage.Storage, func() error, error)
+}
+
+// Plan runs a cycle up to the outbox on a copy of the tracker bound to a snapshot of the
+// database, or to a storage transaction that is rolled back, nothing is stored or sent. The
+// raffle targets are stored in it like before a cycle. It returns the users whose status
+// changes or who would be sent their conditions, in address order.
+func (t *Tracker) Plan(raffleDeployedLt int64, raffleConditions RaffleConditions) ([]*PlannedUser, error) {
+	if snapshotter, ok := t.storage.(snapshotStorage); ok {
+		snapshot, release, err := snapshotter.Snapshot()
+		if err != nil {
+			return nil, storageError("dry run: snapshot database", err)
+		}
+		defer func() {
+			if err := release(); err != nil {
+				logger.Warn("dry run: cannot release the database snapshot", zap.Error(err))
+			}
+		}()
+
+		plan, err := t.planOn(snapshot, raffleDeployedLt, raffleConditions)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Info("dry run: plan ready", zap.Int("users", len(plan)))
+		return plan, nil
+	}
+
+	var plan []*PlannedUser
+	err := t.storage.Transaction(func(tx storage.Storage) error {
+		var err error
+		// the transaction holds the database lock and serves one goroutine
+		plan, err = t.planOn(tx, raffleDeployedLt, raffleConditions)
+		if err != nil {
+			return err
+		}
+
+		return errDryRun
+	})
+	if !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+
+	logger.Info("dry run: plan ready", zap.Int("users", len(plan)))
+	return plan, nil
+}
+
+// planOn plans with a copy of the tracker bound to the storage, the cached lookups are written
+// into it and the accounts are scanned one at a time
+func (t *Tracker) planOn(s storage.Storage, raffleDeployedLt int64, raffleConditions RaffleConditions) ([]*PlannedUser, error) {
+	scoped := *t
+	scoped.storage = s
+	if cached, ok := t.source.(*blockchain.CachedSource); ok {
+		cachedStorage := cached.WithCache(s)
+		scoped.source = cachedStorage
+		scoped.lookups = cachedStorage
+	}
+	scoped.workers = 1
+
+	return scoped.plan(raffleDeployedLt, raffleConditions)
+}
+
+func (t *Tracker) plan(raffleDeployedLt int64, raffleConditions RaffleConditions) ([]*PlannedUser, error) {
+	if err := t.StoreRaffleConditions(raffleConditions); err != nil {
+		return nil, err
+	}
+
+	if err := t.collect(raffleDeployedLt); err != nil {
+		return nil, err
+	}
+
+	addresses, err := t.pendingAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	oldStatuses, err := t.storage.GetUserStatusesByAddresses(addresses)
+	if err != nil {
+		return nil, storageError("dry run: get user statuses by addresses", err)
+	}
+
+	if err := t.synchronizeStatuses(raffleConditions); err != nil {
+		return nil, err
+	}
+
+	newStatuses, err := t.storage.GetUserStatusesByAddresses(addresses)
+	if err != nil {
+		return nil, storageError("dry run: get user statuses by addresses", err)
+	}
+
+	userConditions, err := t.storage.GetUserConditions(addresses)
+	if err != nil {
+		return nil, storageError("dry run: get user conditions", err)
+	}
+
+	queued, err := t.storage.GetOutboxMessages(storage.QueuedOutboxState)
+	if err != nil {
+		return nil, storageError("dry run: get outbox messages", err)
+	}
+
+	plan := make([]*PlannedUser, 0)
+	for _, address := range addresses {
+		user := &PlannedUser{
+			UserAddress: address,
+			Old:         findUserStatus(oldStatuses, address),
+			New:         findUserStatus(newStatuses, address),
+			Conditions:  make([]PlannedCondition, 0),
+		}
+
+		for _, message := range queued {
+			if message.UserAddress == address {
+				t.planSetConditions(user, userConditions, message, raffleConditions)
+			}
+		}
+
+		changed := (user.Old == nil) != (user.New == nil) || user.Old != nil && *user.Old != *user.New
+		if changed || len(user.Conditions) > 0 {
+			plan = append(plan, user)
+		}
+	}
+
+	return plan, nil
+}
+
+// pendingAddresses returns the users a synchronization may change: the pending registrations,
+// the pending condition actions and the queued outbox messages, sorted
+func (t *Tracker) pendingAddresses() ([]string, error) {
+	var actions []*storage.UserAction
+
+	candidateActions, err := t.storage.GetPendingCandidateRegistrationActions()
+	if err != nil {
+		return nil, storageError("dry run: get pending candidate registration actions", err)
+	}
+	actions = append(actions, candidateActions...)
+
+	participantActions, err := t.storage.GetPendingParticipantRegistrationActions()
+	if err != nil {
+		return nil, storageError("dry run: get pending participant registration actions", err)
+	}
+	actions = append(actions, participantActions...)
+
+	for _, slot := range t.rules.Slots {
+		conditionActions, err := t.storage.GetPendingConditionActions(slot.Name)
+		if err != nil {
+			return nil, storageError("dry run: get pending condition actions", err)
+		}
+		actions = append(actions, conditionActions...)
+	}
+
+	addresses := make([]string, 0, len(actions))
+	for _, action := range actions {
+		addresses = append(addresses, action.UserAddress)
+	}
+
+	queued, err := t.storage.GetOutboxMessages(storage.QueuedOutboxState)
+	if err != nil {
+		return nil, storageError("dry run: get outbox messages", err)
+	}
+
+	for _, message := range queued {
+		addresses = append(addresses, message.UserAddress)
+	}
+
+	slices.Sort(addresses)
+	return slices.Compact(addresses), nil
+}
+
+// planSetConditions records the values and the body of the set conditions message the outbox
+// would send to the user
+func (t *Tracker) planSetConditions(user *PlannedUser, userConditions []*storage.UserCondition, message *storage.OutboxMessage, raffleConditions RaffleConditions) {
+	values := t.slotValues(message.Conditions)
+	for i, slot := range t.rules.Slots {
+		condition := PlannedCondition{Slot: slot.Name, New: values[i]}
+		for _, userCondition := range userConditions {
+			if userCondition.UserAddress == user.UserAddress && userCondition.Slot == slot.Name {
+				condition.Old = userCondition.Value
+			}
+		}
+		user.Conditions = append(user.Conditions, condition)
+	}
+
+	user.ConditionsReached = conditions.Reached(values, raffleConditions.Targets)
+
+	setConditionsMessage, err := t.setConditionsMessage(user.UserAddress, values)
+	if err == nil {
+		user.Body, err = setConditionsMessage.Body.ToBocString()
+	}
+
+	if err != nil {
+		user.Error = err.Error()
+	}
+}
+
+func findUserStatus(userStatuses []*storage.UserStatus, address string) *storage.UserStatus {
+	for _, userStatus := range userStatuses {
+		if userStatus.UserAddress == address {
+			return userStatus
+		}
+	}
+
+	return nil
+}
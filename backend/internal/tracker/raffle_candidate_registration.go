@@ -1,14 +1,14 @@
 package tracker
 
 import (
+	"backend/internal/blockchain"
 	"backend/internal/logger"
 	"backend/internal/storage"
 	"math"
 
 	"github.com/tonkeeper/tonapi-go"
 	"github.com/tonkeeper/tongo"
-	"github.com/tonkeeper/tongo/boc"
-	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
 	"go.uber.org/zap"
 )
 
@@ -19,7 +19,7 @@ func (t *Tracker) collectCandidateRegistrationActions(raffleAddress string, raff
 	var actions = make([]*storage.UserAction, 0)
 	lastCandidateRegistrationLt, err := t.storage.GetUserActionTouch(storage.CandidateRegistrationActionType)
 	if err != nil {
-		panic(err)
+		return storageError("raffle candidate registration: get last action transaction state", err)
 	}
 
 	var transactionLt int64 = 0
@@ -29,35 +29,33 @@ func (t *Tracker) collectCandidateRegistrationActions(raffleAddress string, raff
 
 	for {
 		logger.Debug("raffle candidate registration: collect traces... iteration", zap.Int64("current beforeLt", beforeLt))
-		accountTracesResult, err := infinityRateLimitRetry(
+		accountTracesResult, err := rateLimitRetry(t.ctx,
 			func() (*tonapi.TraceIDs, error) {
-				return t.client.GetAccountTraces(t.ctx, tonapi.GetAccountTracesParams{
-					AccountID: raffleAddress,
-					Limit:     tonapi.NewOptInt(GlobalLimitWindowSize),
-					BeforeLt: tonapi.OptInt64{
-						Value: beforeLt,
-						Set:   beforeLt > 0,
-					},
-				})
+				return t.source.GetAccountTraces(t.ctx, raffleAddress, t.limitWindowSize, beforeLt)
 			},
 		)
 
 		if err != nil {
-			logger.Fatal("raffle candidate registration: collect traces... failed", zap.Error(err))
-			return err
+			return sourceError("raffle candidate registration: collect traces", err)
 		}
 
 		for _, traceID := range accountTracesResult.GetTraces() {
 			logger.Debug("raffle candidate registration: collect trace details... iteration", zap.String("trace id", traceID.GetID()))
-			trace, err := infinityRateLimitRetry(
+			trace, err := rateLimitRetry(t.ctx,
 				func() (*tonapi.Trace, error) {
-					return t.client.GetTrace(t.ctx, tonapi.GetTraceParams{TraceID: traceID.GetID()})
+					return t.source.GetTrace(t.ctx, traceID.GetID())
 				},
 			)
 
 			if err != nil {
-				logger.Debug("raffle candidate registration: collect trace details... failed", zap.Error(err))
-				break
+				return sourceError("raffle candidate registration: collect trace details", err)
+			}
+
+			if !t.traceFinal(trace) {
+				logger.Debug("raffle candidate registration: trace is not final yet, skip", zap.String("trace id", traceID.GetID()))
+				transactionLt = trace.Transaction.Lt
+				beforeLt = transactionLt
+				continue
 			}
 
 			transactionLt = trace.Transaction.Lt
@@ -96,7 +94,7 @@ func (t *Tracker) collectCandidateRegistrationActions(raffleAddress string, raff
 			logger.Debug("raffle candidate registration: collect trace details... iteration done")
 		}
 
-		if len(accountTracesResult.GetTraces()) < GlobalLimitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastCandidateRegistrationLt {
+		if len(accountTracesResult.GetTraces()) < t.limitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastCandidateRegistrationLt {
 			logger.Debug("raffle candidate registration: exit condition reached, finalize traces results...")
 			break
 		}
@@ -104,28 +102,21 @@ func (t *Tracker) collectCandidateRegistrationActions(raffleAddress string, raff
 		logger.Debug("raffle candidate registration: collect raffle candidate account traces... iteration done")
 	}
 
+	var touches []*storage.UserActionTouch
 	if maxTransactionLt > lastCandidateRegistrationLt {
-
-		actionTouch := &storage.UserActionTouch{
+		touches = append(touches, &storage.UserActionTouch{
 			ActionType:    storage.CandidateRegistrationActionType,
 			UserAddress:   "-",
 			TransactionLt: maxTransactionLt,
-		}
-
-		err := t.storage.UpdateUserActionTouch(actionTouch)
-		if err != nil {
-			logger.Fatal("raffle candidate registration: failed to update last action transaction state", zap.Error(err))
-			return err
-		}
+		})
 	}
 
-	if len(actions) > 0 {
-		err := t.storage.UpdateUserActions(actions)
-		if err != nil {
-			panic(err)
-		}
+	if err := t.commitActions("raffle candidate registration: update actions", actions, touches...); err != nil {
+		return err
 	}
 
+	t.observeActions(storage.CandidateRegistrationActionType, "-", max(maxTransactionLt, lastCandidateRegistrationLt), len(actions))
+
 	return nil
 }
 
@@ -151,7 +142,7 @@ func walkTracesCandidateRegistration(trace *tonapi.Trace, callback func(*tonapi.
 
 func processRaffleCandidateRegistrationTrace(trace *tonapi.Trace) (string, string, string, bool) {
 
-	raffleCandidateInitializeOpCode := "0x13370020"
+	raffleCandidateInitializeOpCode := blockchain.OpCode(blockchain.RaffleCandidateInitializeOpCode)
 	message, ok := trace.Transaction.GetInMsg().Get()
 	if ok {
 		isTargetOpCode := message.OpCode.IsSet() && message.OpCode.Value == raffleCandidateInitializeOpCode
@@ -159,27 +150,15 @@ func processRaffleCandidateRegistrationTrace(trace *tonapi.Trace) (string, strin
 			trace.Transaction.EndStatus == tonapi.AccountStatusActive
 
 		if isTargetOpCode && isDeployed && trace.Transaction.Success {
-			body, err := boc.DeserializeBocHex(message.GetRawBody().Value)
+			// the raffle initializes the candidate with the user who registered as recipient
+			var candidateInitialize blockchain.RaffleCandidateInitialize
+			err := blockchain.UnmarshalBody(message.GetRawBody().Value, &candidateInitialize)
 			if err != nil {
-				logger.Debug("raffle candidate registration: failed to deserialize trace body")
+				logger.Debug("raffle candidate registration: trace body deserialisation failed", zap.Error(err))
 				return "", "", "", false
 			}
 
-			bodyCell := body[0]
-			err = bodyCell.Skip(32) //op-code
-			if err != nil {
-				logger.Debug("raffle candidate registration: trace body cell underflow")
-				return "", "", "", false
-			}
-
-			var userAccountAddress tlb.MsgAddress
-			err = tlb.Unmarshal(bodyCell, &userAccountAddress)
-			if err != nil {
-				logger.Debug("raffle candidate registration: user account address deserialisation failed")
-				return "", "", "", false
-			}
-
-			userAccountID, err := tongo.AccountIDFromTlb(userAccountAddress)
+			userAccountID, err := tongo.AccountIDFromTlb(candidateInitialize.RecipientAddress)
 			if userAccountID == nil || err != nil {
 				logger.Debug("raffle candidate registration: user account address is invalid", zap.Error(err))
 				return "", "", "", false
@@ -209,3 +188,21 @@ func processRaffleCandidateRegistrationTrace(trace *tonapi.Trace) (string, strin
 
 	return "", "", "", false
 }
+
+// candidateAddresses maps the account of every registered candidate to the address its actions
+// are stored under
+func (t *Tracker) candidateAddresses() (map[ton.AccountID]string, error) {
+	candidateActions, err := t.storage.GetUserActions(storage.CandidateRegistrationActionType)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[ton.AccountID]string, len(candidateActions))
+	for _, action := range candidateActions {
+		if userAccountID, err := ton.ParseAccountID(action.UserAddress); err == nil {
+			candidates[userAccountID] = action.UserAddress
+		}
+	}
+
+	return candidates, nil
+}
@@ -1,14 +1,13 @@
 package tracker
 
 import (
+	"backend/internal/blockchain"
 	"backend/internal/logger"
 	"backend/internal/storage"
 	"math"
 
 	"github.com/tonkeeper/tonapi-go"
 	"github.com/tonkeeper/tongo"
-	"github.com/tonkeeper/tongo/boc"
-	"github.com/tonkeeper/tongo/tlb"
 	"go.uber.org/zap"
 )
 
@@ -19,7 +18,7 @@ func (t *Tracker) collectParticipantRegistrationActions(raffleAddress string, ra
 	logger.Debug("raffle participant registration: get last participant registered at")
 	lastParticipantRegistrationLt, err := t.storage.GetUserActionTouch(storage.ParticipantRegistrationActionType)
 	if err != nil {
-		panic(err)
+		return storageError("raffle participant registration: get last action transaction state", err)
 	}
 
 	var transactionLt int64 = 0
@@ -29,35 +28,33 @@ func (t *Tracker) collectParticipantRegistrationActions(raffleAddress string, ra
 
 	for {
 		logger.Debug("raffle participant registration: collect traces... iteration", zap.Int64("current beforeLt", beforeLt))
-		accountTracesResult, err := infinityRateLimitRetry(
+		accountTracesResult, err := rateLimitRetry(t.ctx,
 			func() (*tonapi.TraceIDs, error) {
-				return t.client.GetAccountTraces(t.ctx, tonapi.GetAccountTracesParams{
-					AccountID: raffleAddress,
-					Limit:     tonapi.NewOptInt(GlobalLimitWindowSize),
-					BeforeLt: tonapi.OptInt64{
-						Value: beforeLt,
-						Set:   beforeLt > 0,
-					},
-				})
+				return t.source.GetAccountTraces(t.ctx, raffleAddress, t.limitWindowSize, beforeLt)
 			},
 		)
 
 		if err != nil {
-			logger.Fatal("raffle participant registration: collect traces... failed", zap.Error(err))
-			return err
+			return sourceError("raffle participant registration: collect traces", err)
 		}
 
 		for _, traceID := range accountTracesResult.GetTraces() {
 			logger.Debug("raffle participant registration: collect trace details... iteration", zap.String("trace id", traceID.GetID()))
-			trace, err := infinityRateLimitRetry(
+			trace, err := rateLimitRetry(t.ctx,
 				func() (*tonapi.Trace, error) {
-					return t.client.GetTrace(t.ctx, tonapi.GetTraceParams{TraceID: traceID.GetID()})
+					return t.source.GetTrace(t.ctx, traceID.GetID())
 				},
 			)
 
 			if err != nil {
-				logger.Debug("raffle participant registration: collect trace details... failed", zap.Error(err))
-				break
+				return sourceError("raffle participant registration: collect trace details", err)
+			}
+
+			if !t.traceFinal(trace) {
+				logger.Debug("raffle participant registration: trace is not final yet, skip", zap.String("trace id", traceID.GetID()))
+				transactionLt = trace.Transaction.Lt
+				beforeLt = transactionLt
+				continue
 			}
 
 			transactionLt = trace.Transaction.Lt
@@ -96,7 +93,7 @@ func (t *Tracker) collectParticipantRegistrationActions(raffleAddress string, ra
 			logger.Debug("raffle participant registration: collect trace details... iteration done")
 		}
 
-		if len(accountTracesResult.GetTraces()) < GlobalLimitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastParticipantRegistrationLt {
+		if len(accountTracesResult.GetTraces()) < t.limitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastParticipantRegistrationLt {
 			logger.Debug("raffle participant registration: exit condition reached, finalize traces results...")
 			break
 		}
@@ -104,28 +101,21 @@ func (t *Tracker) collectParticipantRegistrationActions(raffleAddress string, ra
 		logger.Debug("raffle participant registration: collect raffle participant account traces... iteration done")
 	}
 
+	var touches []*storage.UserActionTouch
 	if maxTransactionLt > lastParticipantRegistrationLt {
-
-		actionTouch := &storage.UserActionTouch{
+		touches = append(touches, &storage.UserActionTouch{
 			ActionType:    storage.ParticipantRegistrationActionType,
 			UserAddress:   "-",
 			TransactionLt: maxTransactionLt,
-		}
-
-		err := t.storage.UpdateUserActionTouch(actionTouch)
-		if err != nil {
-			logger.Fatal("raffle participant registration: failed to update last action transaction state")
-			return err
-		}
+		})
 	}
 
-	if len(actions) > 0 {
-		err := t.storage.UpdateUserActions(actions)
-		if err != nil {
-			panic(err)
-		}
+	if err := t.commitActions("raffle participant registration: update actions", actions, touches...); err != nil {
+		return err
 	}
 
+	t.observeActions(storage.ParticipantRegistrationActionType, "-", max(maxTransactionLt, lastParticipantRegistrationLt), len(actions))
+
 	return nil
 }
 
@@ -151,7 +141,7 @@ func walkTracesParticipantRegistration(trace *tonapi.Trace, callback func(*tonap
 
 func processRaffleParticipantRegistrationTrace(trace *tonapi.Trace) (string, string, string, bool) {
 
-	raffleParticipantInitializeOpCode := tonapi.OptString{Value: "0x13370030", Set: true}
+	raffleParticipantInitializeOpCode := tonapi.NewOptString(blockchain.OpCode(blockchain.RaffleParticipantInitializeOpCode))
 	message, ok := trace.Transaction.GetInMsg().Get()
 	if ok {
 		isTargetOpCode := message.GetOpCode() == raffleParticipantInitializeOpCode
@@ -159,26 +149,14 @@ func processRaffleParticipantRegistrationTrace(trace *tonapi.Trace) (string, str
 			trace.Transaction.EndStatus == tonapi.AccountStatusActive
 
 		if isTargetOpCode && isDeployed && trace.Transaction.Success {
-			body, err := boc.DeserializeBocHex(message.GetRawBody().Value)
-			if err != nil {
-				logger.Debug("raffle participant registration: failed to deserialize trace body")
-			}
-
-			bodyCell := body[0]
-			err = bodyCell.Skip(32) //op-code
-			if err != nil {
-				logger.Debug("raffle participant registration: trace body cell underflow")
-				return "", "", "", false
-			}
-
-			var userAccountAddress tlb.MsgAddress
-			err = tlb.Unmarshal(bodyCell, &userAccountAddress)
+			var participantInitialize blockchain.RaffleParticipantInitialize
+			err := blockchain.UnmarshalBody(message.GetRawBody().Value, &participantInitialize)
 			if err != nil {
-				logger.Debug("raffle participant registration: user account address deserialisation failed")
+				logger.Debug("raffle participant registration: trace body deserialisation failed", zap.Error(err))
 				return "", "", "", false
 			}
 
-			userAccountID, err := tongo.AccountIDFromTlb(userAccountAddress)
+			userAccountID, err := tongo.AccountIDFromTlb(participantInitialize.UserAddress)
 			if userAccountID == nil || err != nil {
 				logger.Debug("raffle participant registration: user account address is invalid")
 				return "", "", "", false
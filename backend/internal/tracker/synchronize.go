@@ -2,13 +2,9 @@ package tracker
 
 import (
 	"backend/internal/logger"
+	"backend/internal/metrics"
 	"backend/internal/storage"
-	"time"
 
-	"github.com/tonkeeper/tongo/boc"
-	"github.com/tonkeeper/tongo/tlb"
-	"github.com/tonkeeper/tongo/ton"
-	"github.com/tonkeeper/tongo/wallet"
 	"go.uber.org/zap"
 )
 
@@ -16,9 +12,11 @@ func (t *Tracker) synchronizePendingCandidateRegistrationActions() error {
 	pendingActions, err := t.storage.GetPendingCandidateRegistrationActions()
 	if err != nil {
 		logger.Debug("cannot get pending candidate registration actions, exiting...")
-		return err
+		return storageError("synchronize: get pending candidate registration actions", err)
 	}
 
+	metrics.PendingActions.WithLabelValues(t.raffleLabel, storage.CandidateRegistrationActionType).Set(float64(len(pendingActions)))
+
 	var userStatuses = make([]*storage.UserStatus, len(pendingActions))
 	for i, action := range pendingActions {
 		userStatuses[i] = &storage.UserStatus{
@@ -30,27 +28,40 @@ func (t *Tracker) synchronizePendingCandidateRegistrationActions() error {
 	err = t.storage.UpdateUserStatuses(userStatuses)
 	if err != nil {
 		logger.Debug("cannot update user statuses action, exiting...")
-		return err
+		return storageError("synchronize: update user statuses", err)
 	}
 
 	return nil
 }
 
-func (t *Tracker) synchronizePendingWhiteTicketMintedActions(maxWhiteTicketMinted uint8) error {
-	pendingActions, err := t.storage.GetPendingWhiteTicketMintedActions()
-	if err != nil {
-		logger.Debug("cannot get pending white ticket minted actions, exiting...")
-		return err
+// synchronizePendingConditionActions counts the actions of every slot the candidates have not
+// pushed yet and enqueues the conditions of those whose values increase. Values are capped at
+// the raffle targets.
+func (t *Tracker) synchronizePendingConditionActions(raffleConditions RaffleConditions) error {
+	if err := t.rules.CheckTargets(raffleConditions.Targets); err != nil {
+		return unrecoverableError("synchronize: check raffle conditions", err)
 	}
 
-	addressPendingActionMap := make(map[string]*storage.UserAction)
-	for _, action := range pendingActions {
-		addressPendingActionMap[action.UserAddress] = action
-	}
+	addressQuantityMap := make(map[string][]uint64)
+	addressProcessedLtMap := make(map[string][]int64)
+	for i, slot := range t.rules.Slots {
+		pendingActions, err := t.storage.GetPendingConditionActions(slot.Name)
+		if err != nil {
+			logger.Debug("cannot get pending condition actions, exiting...", zap.String("slot", slot.Name))
+			return storageError("synchronize: get pending condition actions", err)
+		}
+
+		metrics.PendingActions.WithLabelValues(t.raffleLabel, slot.Name).Set(float64(len(pendingActions)))
+
+		for _, action := range pendingActions {
+			if _, ok := addressQuantityMap[action.UserAddress]; !ok {
+				addressQuantityMap[action.UserAddress] = make([]uint64, len(t.rules.Slots))
+				addressProcessedLtMap[action.UserAddress] = make([]int64, len(t.rules.Slots))
+			}
 
-	addressQuantityMap := make(map[string]uint8)
-	for _, action := range pendingActions {
-		addressQuantityMap[action.UserAddress] = addressQuantityMap[action.UserAddress] + 1
+			addressQuantityMap[action.UserAddress][i]++
+			addressProcessedLtMap[action.UserAddress][i] = max(addressProcessedLtMap[action.UserAddress][i], action.TransactionLt)
+		}
 	}
 
 	addresses := make([]string, 0, len(addressQuantityMap))
@@ -58,74 +69,49 @@ func (t *Tracker) synchronizePendingWhiteTicketMintedActions(maxWhiteTicketMinte
 		addresses = append(addresses, address)
 	}
 
-	userStatuses, err := t.storage.GetUserStatusesByAddresses(addresses)
+	userConditions, err := t.storage.GetUserConditions(addresses)
 	if err != nil {
-		return err
+		return storageError("synchronize: get user conditions", err)
 	}
 
-	for _, userStatus := range userStatuses {
-		userStatusNext := &storage.UserStatus{
-			UserAddress:                     userStatus.UserAddress,
-			WhiteTicketMinted:               min(userStatus.WhiteTicketMinted+addressQuantityMap[userStatus.UserAddress], maxWhiteTicketMinted),
-			WhiteTicketMintedProcessedLt:    addressPendingActionMap[userStatus.UserAddress].TransactionLt,
-			CandidateRegistrationLt:         userStatus.CandidateRegistrationLt,
-			BlackTicketPurchasedProcessedLt: userStatus.BlackTicketPurchasedProcessedLt,
-			ParticipantRegistrationLt:       userStatus.ParticipantRegistrationLt,
-			LastDeployedUnixTime:            userStatus.LastDeployedUnixTime,
-		}
-
-		err = t.invalidateConditions(userStatus, userStatusNext)
-		if err != nil {
-			logger.Debug("cannot invalidate conditions, exiting...")
-			return err
-		}
+	userConditionsMap := make(map[string]*storage.UserCondition)
+	for _, userCondition := range userConditions {
+		userConditionsMap[userCondition.UserAddress+"/"+userCondition.Slot] = userCondition
 	}
 
-	return nil
-}
-
-func (t *Tracker) synchronizePendingBlackTicketPurchasedActions(maxBlackTicketMinted uint8) error {
-	pendingActions, err := t.storage.GetPendingBlackTicketPurchasedActions()
+	userStatuses, err := t.storage.GetUserStatusesByAddresses(addresses)
 	if err != nil {
-		logger.Debug("cannot get pending black ticket purchased actions, exiting...")
-		return err
-	}
-
-	addressPendingActionMap := make(map[string]*storage.UserAction)
-	for _, action := range pendingActions {
-		addressPendingActionMap[action.UserAddress] = action
+		return storageError("synchronize: get user statuses by addresses", err)
 	}
 
-	addressQuantityMap := make(map[string]uint8)
-	for _, action := range pendingActions {
-		addressQuantityMap[action.UserAddress] = addressQuantityMap[action.UserAddress] + 1
-	}
+	for _, userStatus := range userStatuses {
+		values := make([]storage.ConditionValue, len(t.rules.Slots))
+		increased := false
+		for i, slot := range t.rules.Slots {
+			value := storage.ConditionValue{Slot: slot.Name}
+			if userCondition, ok := userConditionsMap[userStatus.UserAddress+"/"+slot.Name]; ok {
+				value.Value = userCondition.Value
+				value.ProcessedLt = userCondition.ProcessedLt
+			}
 
-	addresses := make([]string, 0, len(addressQuantityMap))
-	for address := range addressQuantityMap {
-		addresses = append(addresses, address)
-	}
+			if quantity := addressQuantityMap[userStatus.UserAddress][i]; quantity > 0 {
+				next := min(value.Value+quantity, raffleConditions.Targets[i])
+				increased = increased || next > value.Value
+				value.Value = next
+				value.ProcessedLt = addressProcessedLtMap[userStatus.UserAddress][i]
+			}
 
-	userStatuses, err := t.storage.GetUserStatusesByAddresses(addresses)
-	if err != nil {
-		return err
-	}
+			values[i] = value
+		}
 
-	for _, userStatus := range userStatuses {
-		userStatusNext := &storage.UserStatus{
-			UserAddress:                     userStatus.UserAddress,
-			CandidateRegistrationLt:         userStatus.CandidateRegistrationLt,
-			WhiteTicketMinted:               userStatus.WhiteTicketMinted,
-			WhiteTicketMintedProcessedLt:    userStatus.WhiteTicketMintedProcessedLt,
-			BlackTicketPurchased:            min(userStatus.BlackTicketPurchased+addressQuantityMap[userStatus.UserAddress], maxBlackTicketMinted),
-			BlackTicketPurchasedProcessedLt: addressPendingActionMap[userStatus.UserAddress].TransactionLt,
-			ParticipantRegistrationLt:       userStatus.ParticipantRegistrationLt,
-			LastDeployedUnixTime:            userStatus.LastDeployedUnixTime,
+		if !increased {
+			continue
 		}
 
-		err := t.invalidateConditions(userStatus, userStatusNext)
-		if err != nil {
-			logger.Debug("synchronize black ticket purchased: cannot invalidate conditions, exiting...")
+		logger.Info("set candidate conditions", zap.String("user address", userStatus.UserAddress), zap.Any("conditions", values))
+
+		if err := t.enqueueSetConditions(userStatus.UserAddress, values); err != nil {
+			logger.Debug("synchronize conditions: cannot enqueue set conditions, exiting...")
 			return err
 		}
 	}
@@ -137,135 +123,75 @@ func (t *Tracker) synchronizePendingParticipantRegistrationActions() error {
 	pendingActions, err := t.storage.GetPendingParticipantRegistrationActions()
 	if err != nil {
 		logger.Debug("cannot get pending participant registration actions, exiting...")
-		return err
+		return storageError("synchronize: get pending participant registration actions", err)
 	}
 
-	var userStatuses = make([]*storage.UserStatus, len(pendingActions))
-	for i, action := range pendingActions {
-		userStatuses[i] = &storage.UserStatus{
-			UserAddress:               action.UserAddress,
-			ParticipantRegistrationLt: action.TransactionLt,
-		}
-	}
+	metrics.PendingActions.WithLabelValues(t.raffleLabel, storage.ParticipantRegistrationActionType).Set(float64(len(pendingActions)))
 
-	err = t.storage.UpdateUserStatuses(userStatuses)
-	if err != nil {
-		logger.Debug("cannot update user statuses action, exiting...")
-		return err
+	addresses := make([]string, len(pendingActions))
+	for i, action := range pendingActions {
+		addresses[i] = action.UserAddress
 	}
 
-	return nil
-}
-
-func (t *Tracker) invalidateConditions(status *storage.UserStatus, statusNext *storage.UserStatus) error {
-
-	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
+	existingUserStatuses, err := t.storage.GetUserStatusesByAddresses(addresses)
 	if err != nil {
-		logger.Debug("invalidate conditions: cannot parse raffle account id, exiting...")
-		return err
+		logger.Debug("cannot get user statuses, exiting...")
+		return storageError("synchronize: get user statuses by addresses", err)
 	}
 
-	userAccountID, err := ton.ParseAccountID(status.UserAddress)
-	if err != nil {
-		logger.Debug("invalidate conditions: cannot parse user account id, exiting...")
-		return err
+	existingUserStatusesMap := make(map[string]*storage.UserStatus)
+	for _, userStatus := range existingUserStatuses {
+		existingUserStatusesMap[userStatus.UserAddress] = userStatus
 	}
 
-	if status.WhiteTicketMinted < statusNext.WhiteTicketMinted || status.BlackTicketPurchased < statusNext.BlackTicketPurchased {
-
-		logger.Info(
-			"set candidate conditions",
-			zap.Uint8("status.WhiteTicketMinted", status.WhiteTicketMinted),
-			zap.Uint8("statusNext.WhiteTicketMinted", statusNext.WhiteTicketMinted),
-			zap.Uint8("status.BlackTicketPurchased", status.BlackTicketPurchased),
-			zap.Uint8("statusNext.BlackTicketPurchased", statusNext.BlackTicketPurchased),
-		)
-
-		for i := 0; i < 5; i++ {
-			err := t.sendSetConditions(raffleAccountID, userAccountID, statusNext.WhiteTicketMinted, statusNext.BlackTicketPurchased)
-
-			if err == nil {
-				break
-			}
-
-			logger.Warn("invalidate conditions: cannot send set conditions to blockchain, retrying...")
-		}
-
-		status.LastDeployedUnixTime = time.Now().Unix()
-		err = t.storage.UpdateUserStatus(statusNext)
-		if err != nil {
-			logger.Debug("invalidate conditions: cannot update user status, exiting...")
-			return err
+	var userStatuses = make([]*storage.UserStatus, len(pendingActions))
+	for i, action := range pendingActions {
+		userStatus, exists := existingUserStatusesMap[action.UserAddress]
+		if !exists {
+			userStatus = &storage.UserStatus{UserAddress: action.UserAddress}
 		}
-	}
 
-	return nil
-}
-
-func (t *Tracker) sendSetConditions(raffleAccountID ton.AccountID, userAccountID ton.AccountID, whiteTicketMinted uint8, blackTicketPurchased uint8) error {
-	logger.Debug("sending setting conditions to blockchain...")
-
-	cell := boc.NewCell()
-
-	if err := cell.WriteUint(0x13370011, 32); err != nil {
-
-		return err
+		userStatus.ParticipantRegistrationLt = action.TransactionLt
+		userStatuses[i] = userStatus
 	}
 
-	if err := tlb.Marshal(cell, userAccountID.ToMsgAddress()); err != nil {
-		return err
-	}
-
-	if err := cell.WriteUint(uint64(whiteTicketMinted), 8); err != nil {
-		return err
-	}
-
-	if err := cell.WriteUint(uint64(blackTicketPurchased), 8); err != nil {
-		return err
-	}
-
-	if err := cell.WriteUint(0, 240); err != nil {
-		return err
-	}
-
-	message := wallet.Message{
-		Amount:  5_000_000_0,
-		Address: raffleAccountID,
-		Bounce:  true,
-		Mode:    wallet.DefaultMessageMode,
-		Body:    cell,
-	}
-
-	_, err := t.wallet.SendV2(t.ctx, 60*time.Second, message)
-
+	err = t.storage.UpdateUserStatuses(userStatuses)
 	if err != nil {
-		return err
+		logger.Debug("cannot update user statuses action, exiting...")
+		return storageError("synchronize: update user statuses", err)
 	}
 
 	return nil
 }
 
-func (t *Tracker) synchronize(maxWhiteTicketMinted uint8, maxBlackTicketMinted uint8) error {
-
-	err := t.synchronizePendingCandidateRegistrationActions()
-	if err != nil {
+// synchronize folds the pending actions into the user statuses and sends the outbox
+func (t *Tracker) synchronize(raffleConditions RaffleConditions) error {
+	if err := t.synchronizeStatuses(raffleConditions); err != nil {
 		return err
 	}
 
-	err = t.synchronizePendingParticipantRegistrationActions()
-	if err != nil {
-		return err
-	}
+	return t.processOutbox()
+}
+
+// synchronizeStatuses folds the pending actions into the user statuses, each phase commits its
+// statuses together with the outbox messages it enqueues.
+func (t *Tracker) synchronizeStatuses(raffleConditions RaffleConditions) error {
 
-	err = t.synchronizePendingWhiteTicketMintedActions(maxWhiteTicketMinted)
+	err := t.transaction(func(t *Tracker) error {
+		return t.synchronizePendingCandidateRegistrationActions()
+	})
 	if err != nil {
 		return err
 	}
 
-	err = t.synchronizePendingBlackTicketPurchasedActions(maxBlackTicketMinted)
+	err = t.transaction(func(t *Tracker) error {
+		return t.synchronizePendingParticipantRegistrationActions()
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return t.transaction(func(t *Tracker) error {
+		return t.synchronizePendingConditionActions(raffleConditions)
+	})
 }
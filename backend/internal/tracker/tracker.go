@@ -1,42 +1,103 @@
 package tracker
 
 import (
+	"backend/internal/blockchain"
+	"backend/internal/conditions"
+	"backend/internal/config"
 	"backend/internal/logger"
+	"backend/internal/marketplace"
+	"backend/internal/metrics"
 	"backend/internal/storage"
 	"context"
 	"errors"
+	"fmt"
 	"log"
-	"os"
+	"math/rand/v2"
+	"net/http"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/tonkeeper/tonapi-go"
 	"github.com/tonkeeper/tongo/liteapi"
+	"github.com/tonkeeper/tongo/ton"
 	"github.com/tonkeeper/tongo/wallet"
 	"go.uber.org/zap"
 )
 
 type Tracker struct {
-	ctx                          context.Context
-	storage                      storage.Storage
-	client                       *tonapi.Client
-	wallet                       *wallet.Wallet
-	blackTicketCollectionAddress string
-	whiteTicketCollectionAddress string
-	raffleAddress                string
+	ctx                 context.Context
+	storage             storage.Storage
+	source              blockchain.ChainSource
+	lookups             blockchain.Lookups
+	wallet              blockchain.MessageSender
+	raffleAddress       string
+	raffleLabel         string
+	rules               conditions.Rules
+	marketplaces        *marketplace.Registry
+	limitWindowSize     int
+	workers             int
+	finalityDepth       int
+	finalLt             int64
+	setConditionsAmount uint64
+	winnersQuantity     int
+	drawAmount          uint64
+	forwardAmount       uint64
+	forwardPayload      string
+}
+
+type Options struct {
+	Storage                      storage.Storage
+	Source                       blockchain.ChainSource
+	Wallet                       blockchain.MessageSender
+	RaffleAddress                string
+	BlackTicketCollectionAddress string
+	WhiteTicketCollectionAddress string
+	MarketplaceAddress           string
+	// Conditions are the slots of the raffle conditions, empty means the white and black
+	// ticket slots of the collections above
+	Conditions []conditions.Slot
+	// Marketplaces reads the sale contracts of purchases, the default registry when nil
+	Marketplaces    *marketplace.Registry
+	LimitWindowSize int
+	// Workers bounds the accounts scanned at once by a collector
+	Workers             int
+	SetConditionsAmount uint64
+	// FinalityDepth is the number of masterchain blocks below the head a trace has to be in
+	FinalityDepth int
+	// WinnersQuantity is the number of winners drawn with RaffleNext, zero draws none. The
+	// forward amount and payload are sent on to the winner wallet.
+	WinnersQuantity      int
+	DrawAmount           uint64
+	WinnerForwardAmount  uint64
+	WinnerForwardPayload string
 }
 
 type Func[T any] func() (T, error)
 
-func infinityRateLimitRetry[T any](
+const (
+	rateLimitRetryInitialDelay = 500 * time.Millisecond
+	rateLimitRetryMaxDelay     = 30 * time.Second
+	rateLimitRetryAttempts     = 8
+)
+
+// rateLimitRetry repeats a rate limited call a bounded number of times with a jittered exponential
+// backoff, a persistent rate limit is left to the run loop backoff.
+func rateLimitRetry[T any](
+	ctx context.Context,
 	fn Func[T],
 ) (T, error) {
-	for {
+	for attempt := 1; ; attempt++ {
 		result, err := fn()
 		if err != nil {
 			var e *tonapi.ErrorStatusCode
-			if errors.As(errors.Unwrap(err), &e) && e.StatusCode == 429 {
-				time.Sleep(500 * time.Millisecond)
+			if errors.As(err, &e) && e.StatusCode == http.StatusTooManyRequests && attempt < rateLimitRetryAttempts {
+				metrics.RateLimitRetries.Inc()
+				timer := time.NewTimer(rateLimitRetryDelay(attempt))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return result, ctx.Err()
+				case <-timer.C:
+				}
 				continue
 			}
 		}
@@ -45,89 +106,328 @@ func infinityRateLimitRetry[T any](
 	}
 }
 
-func NewTracker(ctx context.Context) *Tracker {
-
-	if err := godotenv.Load(); err != nil {
-		panic("no .env file found")
+// rateLimitRetryDelay draws the delay after the failed attempt from the doubled window of the
+// previous one, so that concurrent workers do not retry in lockstep
+func rateLimitRetryDelay(attempt int) time.Duration {
+	window := rateLimitRetryInitialDelay
+	for i := 1; i < attempt && window < rateLimitRetryMaxDelay; i++ {
+		window *= 2
 	}
+	window = min(window, rateLimitRetryMaxDelay)
+
+	return window/2 + rand.N(window/2+1)
+}
 
-	walletMnemonic := os.Getenv("WALLET_MNEMONIC")
-	walletVersion := os.Getenv("WALLET_VERSION")
-	logger.Debug("tracker initialization: .env provided data", zap.String("wallet version", walletVersion), zap.Bool("wallet mnemonic", walletMnemonic != ""))
+// NewTrackers builds one tracker per configured raffle. The trackers share the database,
+// the chain source and the oracle wallet, their state is scoped by the raffle address.
+func NewTrackers(ctx context.Context, configuration *config.Configuration) ([]*Tracker, error) {
 
-	sqliteStorage := storage.NewSqliteStorage()
+	logger.Debug("tracker initialization: configuration", zap.String("wallet version", configuration.Wallet.Version), zap.String("chain source", configuration.Chain.Source))
 
-	logger.Debug("tracker initialization: tonapi client...\n")
-	client, err := tonapi.NewClient(tonapi.TonApiURL, tonapi.WithToken("AF64UYO7BZZBSYIAAAAGMH67OZFW62PFAP6HGNCLST5YRXESM6FBPBYPEVZDGI3RDCSEUYY"))
+	forRaffle, err := OpenStorage(configuration)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	logger.Debug("tracker initialization:  wallet...\n")
+	logger.Debug("tracker initialization:  liteapi client...\n")
 	clientLite, err := liteapi.NewClientWithDefaultMainnet()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	var source blockchain.ChainSource
+	switch configuration.Chain.Source {
+	case blockchain.TonapiChainSourceType:
+		client, err := tonapi.NewClient(tonapi.TonApiURL, tonapi.WithToken(configuration.Chain.TonapiToken))
+		if err != nil {
+			return nil, err
+		}
+		source = blockchain.NewTonapiSource(client)
+	case blockchain.LiteapiChainSourceType:
+		source = blockchain.NewLiteapiSource(clientLite)
+		logger.Warn("tracker initialization: the liteapi chain source cannot look transactions up by hash, final actions are not verified")
 	}
+	source = blockchain.NewInstrumentedSource(source)
+	// the budget is shared by the trackers of all raffles, it is spent before the call is counted
+	source = blockchain.NewRateLimitedSource(source, configuration.Chain.RequestsPerSecond, configuration.Chain.Burst)
+	// the cache is not scoped by raffle, cached lookups are served without spending the budget
+	source = blockchain.NewCachedSource(source, forRaffle(""))
 
-	pk, err := wallet.SeedToPrivateKey(walletMnemonic)
+	logger.Debug("tracker initialization:  wallet...\n")
+
+	pk, err := wallet.SeedToPrivateKey(configuration.Wallet.Mnemonic)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	version := blockchain.WalletMap[configuration.Wallet.Version]
+
+	logger.Debug("tracker initialization: wallet info", zap.String("version", configuration.Wallet.Version), zap.Int("version index", version))
+
+	var sender blockchain.MessageSender
+	if wallet.Version(version) == wallet.HighLoadV2R2 {
+		sender, err = blockchain.NewHighloadSender(pk, clientLite, configuration.Wallet.MaxBatchSize)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		oracleWallet, err := wallet.New(pk, wallet.Version(version), clientLite)
+		if err != nil {
+			return nil, err
+		}
+		sender = blockchain.NewWalletSender(&oracleWallet, clientLite)
+	}
+
+	logger.Debug("tracker initialization: wallet address", zap.String("address", sender.GetAddress().ToHuman(true, false)), zap.Int("max messages", sender.MaxMessages()))
+
+	var trackers []*Tracker
+	for _, raffle := range configuration.RaffleConfigurations() {
+		raffleAccountID, err := ton.ParseAccountID(raffle.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Debug("tracker initialization: raffle", zap.String("raffle address", raffleAccountID.ToRaw()))
+		rules, err := conditions.NewRules(raffle.ConditionSlots())
+		if err != nil {
+			return nil, err
+		}
+
+		trackers = append(trackers, NewTrackerWithOptions(ctx, Options{
+			Storage:                      forRaffle(raffleAccountID.ToRaw()),
+			Source:                       source,
+			Wallet:                       sender,
+			RaffleAddress:                raffle.Address,
+			BlackTicketCollectionAddress: raffle.BlackTicketCollectionAddress,
+			WhiteTicketCollectionAddress: raffle.WhiteTicketCollectionAddress,
+			MarketplaceAddress:           raffle.MarketplaceAddress,
+			Conditions:                   rules.Slots,
+			LimitWindowSize:              configuration.Chain.LimitWindowSize,
+			Workers:                      configuration.Chain.Workers,
+			FinalityDepth:                configuration.Chain.FinalityDepth,
+			SetConditionsAmount:          raffle.SetConditionsAmount,
+			WinnersQuantity:              raffle.WinnersQuantity,
+			DrawAmount:                   raffle.DrawAmount,
+			WinnerForwardAmount:          raffle.WinnerForwardAmount,
+			WinnerForwardPayload:         raffle.WinnerForwardPayload,
+		}))
 	}
 
-	logger.Debug("tracker initialization:", zap.Bool("private key is not empty", pk != nil))
-	version := WalletMap[walletVersion]
+	logger.Debug("tracker initialization: initializing trackers... done", zap.Int("count", len(trackers)))
+	return trackers, nil
+}
+
+// OpenStorage opens the configured database and returns the constructor of raffle scoped storages.
+// A single raffle database is migrated to the raffle configured by the environment.
+func OpenStorage(configuration *config.Configuration) (func(raffleAddress string) storage.Storage, error) {
+	storageConfiguration := configuration.Storage
+	switch storageConfiguration.Driver {
+	case storage.PostgresDriverType:
+		postgresStorage, err := storage.NewPostgresStorage(storageConfiguration.DSN, storage.PoolOptions{
+			MaxOpenConns:    storageConfiguration.MaxOpenConns,
+			MaxIdleConns:    storageConfiguration.MaxIdleConns,
+			ConnMaxLifetime: storageConfiguration.ConnMaxLifetime,
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	logger.Debug("tracker initialization: wallet info", zap.String("version", walletVersion), zap.Int("version index", version), zap.Bool("private key is empty", pk == nil))
-	oracleWallet, err := wallet.New(pk, wallet.Version(version), clientLite)
+		return func(raffleAddress string) storage.Storage { return postgresStorage.ForRaffle(raffleAddress) }, nil
+	default:
+		// storages are scoped by the raw raffle address
+		singleRaffleAddress := ""
+		if raffleAccountID, err := ton.ParseAccountID(configuration.Raffle.Address); err == nil {
+			singleRaffleAddress = raffleAccountID.ToRaw()
+		}
+
+		sqliteStorage, err := storage.NewSqliteStorage(storageConfiguration.Path, singleRaffleAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(raffleAddress string) storage.Storage { return sqliteStorage.ForRaffle(raffleAddress) }, nil
+	}
+}
+
+func NewTrackerWithOptions(ctx context.Context, options Options) *Tracker {
+	marketplaceAddress := config.DefaultMarketplaceAddress
+	if options.MarketplaceAddress != "" {
+		marketplaceAddress = options.MarketplaceAddress
+	}
+
+	slots := options.Conditions
+	if len(slots) == 0 {
+		slots = conditions.DefaultSlots(options.WhiteTicketCollectionAddress, options.BlackTicketCollectionAddress, marketplaceAddress)
+	}
 
+	rules, err := conditions.NewRules(slots)
 	if err != nil {
-		panic(err)
+		logger.Warn("tracker initialization: invalid conditions", zap.String("raffle address", options.RaffleAddress), zap.Error(err))
+	}
+
+	// a caching source serves the immutable lookups itself
+	lookups, ok := options.Source.(blockchain.Lookups)
+	if !ok {
+		lookups = blockchain.NewDirectLookups(options.Source)
+	}
+
+	marketplaces := options.Marketplaces
+	if marketplaces == nil {
+		marketplaces = marketplace.DefaultRegistry()
+	}
+
+	limitWindowSize := options.LimitWindowSize
+	if limitWindowSize <= 0 {
+		limitWindowSize = config.DefaultLimitWindowSize
+	}
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = config.DefaultWorkers
+	}
+
+	setConditionsAmount := options.SetConditionsAmount
+	if setConditionsAmount == 0 {
+		setConditionsAmount = config.DefaultSetConditionsAmount
+	}
+
+	drawAmount := options.DrawAmount
+	if drawAmount == 0 {
+		drawAmount = config.DefaultDrawAmount
+	}
+
+	raffleLabel := options.RaffleAddress
+	if raffleAccountID, err := ton.ParseAccountID(options.RaffleAddress); err == nil {
+		raffleLabel = raffleAccountID.ToRaw()
 	}
 
-	logger.Debug("tracker initialization: initializing tracker... done")
 	return &Tracker{
-		ctx:                          ctx,
-		storage:                      sqliteStorage,
-		client:                       client,
-		wallet:                       &oracleWallet,
-		raffleAddress:                os.Getenv("RAFFLE_ADDRESS"),
-		blackTicketCollectionAddress: os.Getenv("BLACK_TICKET_COLLECTION_ADDRESS"),
-		whiteTicketCollectionAddress: os.Getenv("WHITE_TICKET_COLLECTION_ADDRESS"),
+		ctx:                 ctx,
+		storage:             options.Storage,
+		source:              options.Source,
+		lookups:             lookups,
+		wallet:              options.Wallet,
+		raffleAddress:       options.RaffleAddress,
+		raffleLabel:         raffleLabel,
+		rules:               rules,
+		marketplaces:        marketplaces,
+		limitWindowSize:     limitWindowSize,
+		workers:             workers,
+		finalityDepth:       max(options.FinalityDepth, 0),
+		setConditionsAmount: setConditionsAmount,
+		winnersQuantity:     max(options.WinnersQuantity, 0),
+		drawAmount:          drawAmount,
+		forwardAmount:       options.WinnerForwardAmount,
+		forwardPayload:      options.WinnerForwardPayload,
 	}
 }
 
-func (t *Tracker) Run(raffleDeployedLt int64, targetWhiteTicketMinted uint8, targetBlackTicketMinted uint8) {
+var errNoConditions = errors.New("no condition slots")
 
-	logger.Debug("\n\n GATHERING CANDIDATE REGISTRATIONS \n\n")
+// Run performs a single collection and synchronization cycle. Failures are returned as classified
+// errors, the caller decides whether to back off or to stop.
+func (t *Tracker) Run(raffleDeployedLt int64, raffleConditions RaffleConditions) error {
+	if err := t.collect(raffleDeployedLt); err != nil {
+		return err
+	}
 
-	err := t.collectCandidateRegistrationActions(t.raffleAddress, raffleDeployedLt)
-	if err != nil {
-		panic(err)
+	logger.Debug("\n\n BLOCKCHAIN SYNCHRONIZATION \n\n")
+	return t.timed("synchronization", func() error {
+		return t.synchronize(raffleConditions)
+	})
+}
+
+// collect runs the collectors and verifies the final actions, the first phase of a cycle
+func (t *Tracker) collect(raffleDeployedLt int64) error {
+	if len(t.rules.Slots) == 0 {
+		return unrecoverableError("run", errNoConditions)
 	}
 
-	logger.Debug("\n\n GATHERING WHITE TICKET MINTED \n\n")
-	err = t.collectWhiteTicketMintedActions(raffleDeployedLt)
-	if err != nil {
-		panic(err)
+	if err := t.updateFinalLt(); err != nil {
+		return err
 	}
 
-	logger.Debug("\n\n GATHERING BLACK TICKET PURCHASES \n\n")
-	err = t.collectBlackTicketPurchasedActions(raffleDeployedLt)
-	if err != nil {
-		panic(err)
+	logger.Debug("\n\n GATHERING CANDIDATE REGISTRATIONS \n\n")
+	if err := t.timed("candidate_registration", func() error {
+		return t.collectCandidateRegistrationActions(t.raffleAddress, raffleDeployedLt)
+	}); err != nil {
+		return err
+	}
+
+	for _, slot := range t.rules.Slots {
+		logger.Debug("\n\n GATHERING CONDITION ACTIONS \n\n", zap.String("slot", slot.Name), zap.String("kind", slot.Kind))
+		if err := t.timed(slot.Name, func() error {
+			return t.collectConditionActions(slot, raffleDeployedLt)
+		}); err != nil {
+			return err
+		}
 	}
 
 	logger.Debug("\n\n GATHERING PARTICIPANT REGISTRATIONS \n\n")
-	err = t.collectParticipantRegistrationActions(t.raffleAddress, raffleDeployedLt)
-	if err != nil {
-		panic(err)
+	if err := t.timed("participant_registration", func() error {
+		return t.collectParticipantRegistrationActions(t.raffleAddress, raffleDeployedLt)
+	}); err != nil {
+		return err
 	}
 
-	logger.Debug("\n\n BLOCKCHAIN SYNCHRONIZATION \n\n")
-	err = t.synchronize(targetWhiteTicketMinted, targetBlackTicketMinted)
+	logger.Debug("\n\n VERIFYING FINAL ACTIONS \n\n")
+	return t.timed("verification", t.verifyActions)
+}
+
+// collectConditionActions runs the collector of the slot kind
+func (t *Tracker) collectConditionActions(slot conditions.Slot, raffleDeployedLt int64) error {
+	switch slot.Kind {
+	case conditions.MintKind:
+		return t.collectWhiteTicketMintedActions(slot, raffleDeployedLt)
+	case conditions.PurchaseKind:
+		return t.collectBlackTicketPurchasedActions(slot, raffleDeployedLt)
+	case conditions.HoldKind:
+		return t.collectHoldActions(slot)
+	}
+
+	return unrecoverableError("collect condition actions", fmt.Errorf("unknown slot kind %q", slot.Kind))
+}
+
+// timed records the duration of a collector cycle, failed cycles included
+func (t *Tracker) timed(collector string, fn func() error) error {
+	start := time.Now()
+	defer func() {
+		metrics.CollectorCycleDuration.WithLabelValues(t.raffleLabel, collector).Observe(time.Since(start).Seconds())
+	}()
+
+	return fn()
+}
+
+// ReportWalletBalance refreshes the oracle wallet balance metric
+func (t *Tracker) ReportWalletBalance() error {
+	walletAccountID := t.wallet.GetAddress()
+
+	account, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.Account, error) {
+			return t.source.GetAccount(t.ctx, walletAccountID.ToRaw())
+		})
 	if err != nil {
-		panic(err)
+		return sourceError("report wallet balance", err)
 	}
+
+	metrics.WalletBalance.WithLabelValues(walletAccountID.ToHuman(true, false)).Set(float64(account.GetBalance()))
+	return nil
+}
+
+// observeActions counts the discovered actions and the cursor reached by a collector
+func (t *Tracker) observeActions(actionType string, userAddress string, cursorLt int64, actions int) {
+	metrics.ActionsDiscovered.WithLabelValues(t.raffleLabel, actionType).Add(float64(actions))
+	if cursorLt > 0 {
+		metrics.CursorLt.WithLabelValues(t.raffleLabel, actionType, userAddress).Set(float64(cursorLt))
+	}
+}
+
+func (t *Tracker) RaffleAddress() string {
+	return t.raffleAddress
+}
+
+func (t *Tracker) Storage() storage.Storage {
+	return t.storage
 }
 
 func (t *Tracker) Finalize() {
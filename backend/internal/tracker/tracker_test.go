@@ -0,0 +1,978 @@
+package tracker
+
+import (
+	"backend/internal/abuse"
+	"backend/internal/blockchain"
+	"backend/internal/conditions"
+	"backend/internal/logger"
+	"backend/internal/metrics"
+	"backend/internal/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/wallet"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	fixtureRaffleAddress                = "EQAREREREREREREREREREREREREREREREREREREREREREeYT"
+	fixtureWhiteTicketCollectionAddress = "EQAhISEhISEhISEhISEhISEhISEhISEhISEhISEhISEhIZoD"
+	fixtureBlackTicketCollectionAddress = "EQAiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIiIp3C"
+	fixtureHoldCollectionAddress        = "EQAjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjI599"
+
+	fixtureUser1Address       = "EQAxMTExMTExMTExMTExMTExMTExMTExMTExMTExMTExMbHz"
+	fixtureUser2Address       = "EQAyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMrYy"
+	fixtureCandidate1Address  = "EQBBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQWIj"
+	fixtureCandidate2Address  = "EQBCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQmXi"
+	fixtureParticipantAddress = "EQBRUVFRUVFRUVFRUVFRUVFRUVFRUVFRUVFRUVFRUVFRUUnT"
+	fixtureWhiteItemAddress   = "EQBhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYTXD"
+	fixtureBlackItemAddress   = "EQBiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYjIC"
+
+	fixtureOracleAddress    = "0:1212121212121212121212121212121212121212121212121212121212121212"
+	fixtureRaffleDeployedLt = 900
+)
+
+// fixtureRaffleConditions asks for one white and one black ticket
+var fixtureRaffleConditions = RaffleConditions{Targets: []uint64{1, 1}}
+
+type recordingSender struct {
+	mutex    sync.Mutex
+	messages []wallet.Message
+	batches  int
+	// err fails the sends, the messages are recorded unless it tells they were not sent
+	err error
+}
+
+func (s *recordingSender) Send(_ context.Context, _ time.Duration, messages ...wallet.Sendable) (*blockchain.SendResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if errors.Is(s.err, blockchain.ErrNotSent) {
+		return nil, s.err
+	}
+
+	seqno := uint32(s.batches)
+	s.batches++
+	for _, message := range messages {
+		if m, ok := message.(wallet.Message); ok {
+			s.messages = append(s.messages, m)
+		}
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return &blockchain.SendResult{Seqno: seqno}, nil
+}
+
+func (s *recordingSender) MaxMessages() int {
+	return 4
+}
+
+func (s *recordingSender) GetAddress() ton.AccountID {
+	return ton.MustParseAccountID(fixtureOracleAddress)
+}
+
+func (s *recordingSender) sent() []wallet.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]wallet.Message(nil), s.messages...)
+}
+
+func newFixtureTracker(t *testing.T, fixtures ...string) (*Tracker, *storage.SqliteStorage, *recordingSender) {
+	t.Helper()
+
+	logger.Initialize(logger.Configuration{Level: zapcore.ErrorLevel})
+
+	paths := make([]string, len(fixtures))
+	for i, fixture := range fixtures {
+		paths[i] = filepath.Join("testdata", fixture)
+	}
+
+	source, err := blockchain.LoadFixtureSource(paths...)
+	if err != nil {
+		t.Fatalf("load fixtures: %v", err)
+	}
+
+	sqliteStorage, err := storage.NewSqliteStorage(filepath.Join(t.TempDir(), "tracker.db"), "")
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	raffleStorage := sqliteStorage.ForRaffle(ton.MustParseAccountID(fixtureRaffleAddress).ToRaw())
+	sender := &recordingSender{}
+
+	trackerInstance := NewTrackerWithOptions(context.Background(), Options{
+		Storage:                      raffleStorage,
+		Source:                       source,
+		Wallet:                       sender,
+		RaffleAddress:                fixtureRaffleAddress,
+		BlackTicketCollectionAddress: fixtureBlackTicketCollectionAddress,
+		WhiteTicketCollectionAddress: fixtureWhiteTicketCollectionAddress,
+	})
+
+	return trackerInstance, raffleStorage, sender
+}
+
+func assertUserActions(t *testing.T, s storage.Storage, actionType storage.ActionType, expected map[string]string) {
+	t.Helper()
+
+	actions, err := s.GetUserActions(actionType)
+	if err != nil {
+		t.Fatalf("get %s actions: %v", actionType, err)
+	}
+
+	if len(actions) != len(expected) {
+		t.Fatalf("%s: expected %d actions, got %d", actionType, len(expected), len(actions))
+	}
+
+	for _, action := range actions {
+		address, ok := expected[action.UserAddress]
+		if !ok {
+			t.Errorf("%s: unexpected action of user %s", actionType, action.UserAddress)
+			continue
+		}
+
+		if action.Address != address {
+			t.Errorf("%s: user %s expected address %s, got %s", actionType, action.UserAddress, address, action.Address)
+		}
+
+		if action.TransactionHash == "" || action.TransactionLt == 0 {
+			t.Errorf("%s: user %s action has no transaction reference", actionType, action.UserAddress)
+		}
+	}
+}
+
+func assertUserStatus(t *testing.T, s storage.Storage, address string, whiteTicketMinted uint64, blackTicketPurchased uint64, isParticipant bool) {
+	t.Helper()
+
+	userStatus, err := s.GetUserStatusByAddress(address)
+	if err != nil {
+		t.Fatalf("get user status %s: %v", address, err)
+	}
+
+	userConditions, err := s.GetUserConditions([]string{address})
+	if err != nil {
+		t.Fatalf("get user conditions %s: %v", address, err)
+	}
+
+	values := make(map[string]uint64)
+	for _, userCondition := range userConditions {
+		values[userCondition.Slot] = userCondition.Value
+	}
+
+	if values[storage.WhiteTicketMintedActionType] != whiteTicketMinted || values[storage.BlackTicketPurchasedActionType] != blackTicketPurchased {
+		t.Errorf("user %s: expected white/black %d/%d, got %d/%d", address, whiteTicketMinted, blackTicketPurchased,
+			values[storage.WhiteTicketMintedActionType], values[storage.BlackTicketPurchasedActionType])
+	}
+
+	if userStatus.ConditionsReached != (whiteTicketMinted == 1 && blackTicketPurchased == 1) {
+		t.Errorf("user %s: expected conditions reached %v", address, !userStatus.ConditionsReached)
+	}
+
+	if userStatus.CandidateRegistrationLt == 0 {
+		t.Errorf("user %s: candidate registration lt is not set", address)
+	}
+
+	if (userStatus.ParticipantRegistrationLt != 0) != isParticipant {
+		t.Errorf("user %s: expected participant %v, got registration lt %d", address, isParticipant, userStatus.ParticipantRegistrationLt)
+	}
+}
+
+func TestTrackerRun(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	runTracker(t, trackerInstance)
+
+	assertUserActions(t, s, storage.CandidateRegistrationActionType, map[string]string{
+		fixtureUser1Address: fixtureCandidate1Address,
+		fixtureUser2Address: fixtureCandidate2Address,
+	})
+
+	assertUserActions(t, s, storage.WhiteTicketMintedActionType, map[string]string{
+		fixtureUser1Address: fixtureWhiteItemAddress,
+	})
+
+	// user 2 cancelled their own sale, it must not count as a purchase
+	assertUserActions(t, s, storage.BlackTicketPurchasedActionType, map[string]string{
+		fixtureUser1Address: fixtureBlackItemAddress,
+	})
+
+	assertUserActions(t, s, storage.ParticipantRegistrationActionType, map[string]string{
+		fixtureUser1Address: fixtureParticipantAddress,
+	})
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+	assertUserStatus(t, s, fixtureUser2Address, 0, 0, false)
+
+	// white and black counts of user 1 are merged into a single outbox message
+	raffleAccountID := ton.MustParseAccountID(fixtureRaffleAddress)
+	messages := sender.sent()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 set conditions message, got %d", len(messages))
+	}
+
+	message := messages[0]
+	if message.Address != raffleAccountID {
+		t.Errorf("set conditions sent to %s instead of raffle", message.Address.ToRaw())
+	}
+
+	opCode, err := message.Body.ReadUint(32)
+	if err != nil || opCode != 0x13370011 {
+		t.Errorf("expected set conditions op code, got 0x%08x (%v)", opCode, err)
+	}
+	message.Body.ResetCounters()
+
+	outboxMessages, err := s.GetOutboxMessages(storage.ConfirmedOutboxState)
+	if err != nil {
+		t.Fatalf("get outbox messages: %v", err)
+	}
+
+	if len(outboxMessages) != 1 || !reflect.DeepEqual(conditionValues(outboxMessages[0].Conditions), []uint64{1, 1}) {
+		t.Errorf("expected one confirmed 1/1 outbox message, got %+v", outboxMessages)
+	}
+}
+
+func TestTrackerRunReportsMetrics(t *testing.T) {
+	trackerInstance, _, _ := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	raffleLabel := ton.MustParseAccountID(fixtureRaffleAddress).ToRaw()
+	discovered := metrics.ActionsDiscovered.WithLabelValues(raffleLabel, storage.CandidateRegistrationActionType)
+	confirmed := metrics.SetConditions.WithLabelValues(raffleLabel, "confirmed")
+	discoveredBefore, confirmedBefore := testutil.ToFloat64(discovered), testutil.ToFloat64(confirmed)
+
+	runTracker(t, trackerInstance)
+
+	if delta := testutil.ToFloat64(discovered) - discoveredBefore; delta != 2 {
+		t.Errorf("expected 2 discovered candidate registrations, got %v", delta)
+	}
+
+	if delta := testutil.ToFloat64(confirmed) - confirmedBefore; delta != 1 {
+		t.Errorf("expected 1 confirmed set conditions, got %v", delta)
+	}
+
+	if cursorLt := testutil.ToFloat64(metrics.CursorLt.WithLabelValues(raffleLabel, storage.CandidateRegistrationActionType, "-")); cursorLt <= fixtureRaffleDeployedLt {
+		t.Errorf("expected candidate registration cursor past the deployment, got %v", cursorLt)
+	}
+
+	if pending := testutil.ToFloat64(metrics.PendingActions.WithLabelValues(raffleLabel, storage.CandidateRegistrationActionType)); pending != 2 {
+		t.Errorf("expected 2 pending candidate registrations, got %v", pending)
+	}
+}
+
+func TestTrackerRunWaitsForConfirmation(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+	)
+
+	// the candidate contract of user 1 has not received the conditions yet
+	err := trackerInstance.source.(*blockchain.FixtureSource).Load(&blockchain.Fixture{
+		GetMethods: map[string]map[string]json.RawMessage{
+			fixtureCandidate1Address: {
+				"raffleCandidateData": json.RawMessage(`{"success":true,"exit_code":0,"stack":[{"type":"cell","cell":"b5ee9c720101010100220000400000000000000000000000000000000000000000000000000000000000000000"}]}`),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("load fixture: %v", err)
+	}
+
+	runTracker(t, trackerInstance)
+	runTracker(t, trackerInstance)
+
+	if sent := len(sender.sent()); sent != 1 {
+		t.Errorf("expected 1 set conditions message, got %d", sent)
+	}
+
+	assertUserStatus(t, s, fixtureUser1Address, 0, 0, false)
+
+	outboxMessages, err := s.GetOutboxMessages(storage.SentOutboxState)
+	if err != nil || len(outboxMessages) != 1 {
+		t.Fatalf("expected 1 sent outbox message, got %d (%v)", len(outboxMessages), err)
+	}
+}
+
+func TestTrackerRunIsIdempotent(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	runTracker(t, trackerInstance)
+	sentAfterFirstRun := len(sender.sent())
+
+	runTracker(t, trackerInstance)
+
+	if sent := len(sender.sent()); sent != sentAfterFirstRun {
+		t.Errorf("second run sent %d additional messages", sent-sentAfterFirstRun)
+	}
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+	assertUserStatus(t, s, fixtureUser2Address, 0, 0, false)
+}
+
+func TestTrackerRunServesCachedLookups(t *testing.T) {
+	trackerInstance, s, _ := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	cachedSource := blockchain.NewCachedSource(trackerInstance.source, s)
+	trackerInstance.source = cachedSource
+	trackerInstance.lookups = cachedSource
+
+	traceHits := metrics.ChainCacheLookups.WithLabelValues("trace", "hit")
+	saleMisses := metrics.ChainCacheLookups.WithLabelValues("completed_sale", "miss")
+	hitsBefore, missesBefore := testutil.ToFloat64(traceHits), testutil.ToFloat64(saleMisses)
+
+	runTracker(t, trackerInstance)
+	missesAfterFirstRun := testutil.ToFloat64(saleMisses)
+	if missesAfterFirstRun == missesBefore {
+		t.Error("expected the sale of the purchase to be read from the source")
+	}
+
+	// the second run reads the newest traces again, up to the cursors
+	runTracker(t, trackerInstance)
+
+	if hits := testutil.ToFloat64(traceHits) - hitsBefore; hits == 0 {
+		t.Error("expected the second run to hit the trace cache")
+	}
+
+	collection, ok, err := s.GetCachedLookup("item_collection", ton.MustParseAccountID(fixtureBlackItemAddress).ToRaw())
+	if err != nil || !ok || !strings.Contains(collection, ton.MustParseAccountID(fixtureBlackTicketCollectionAddress).ToRaw()) {
+		t.Errorf("expected the collection of the purchased item to be cached, got %q (%v)", collection, err)
+	}
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+}
+
+func TestTrackerRunFlagsWashTrades(t *testing.T) {
+	// user 1 funded the seller of the black ticket before buying it
+	trackerInstance, s, _ := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"wash_trades.json",
+	)
+
+	runTracker(t, trackerInstance)
+
+	actions, err := s.GetUserActions(storage.BlackTicketPurchasedActionType)
+	if err != nil || len(actions) != 1 {
+		t.Fatalf("expected 1 black ticket purchase, got %d (%v)", len(actions), err)
+	}
+
+	if actions[0].FlagReason != abuse.SellerFundedReason || actions[0].Counterparty == "" {
+		t.Errorf("expected the purchase flagged with its seller, got %+v", actions[0])
+	}
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 0, false)
+}
+
+func TestTrackerRunWaitsForFinality(t *testing.T) {
+	// the masterchain head is at 100, three blocks below it the chain is final up to lt 1900
+	trackerInstance, s, _ := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+		"finality.json",
+	)
+	trackerInstance.finalityDepth = 3
+
+	runTracker(t, trackerInstance)
+
+	// the purchase and the participant registration are not final yet
+	assertUserActions(t, s, storage.BlackTicketPurchasedActionType, map[string]string{})
+	assertUserActions(t, s, storage.ParticipantRegistrationActionType, map[string]string{})
+	assertUserStatus(t, s, fixtureUser1Address, 1, 0, false)
+
+	if lt, err := s.GetUserActionTouchByAddress(storage.BlackTicketPurchasedActionType, fixtureUser1Address); err != nil || lt > 1900 {
+		t.Errorf("expected the purchase cursor not to pass the final lt, got %d (%v)", lt, err)
+	}
+
+	source := trackerInstance.source.(*blockchain.FixtureSource)
+	if err := source.Load(&blockchain.Fixture{MasterchainHead: 103}); err != nil {
+		t.Fatalf("advance masterchain head: %v", err)
+	}
+
+	runTracker(t, trackerInstance)
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+}
+
+func TestTrackerRunRevertsVanishedActions(t *testing.T) {
+	trackerInstance, s, _ := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	runTracker(t, trackerInstance)
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+
+	// the purchase and the participant registration of user 1 were rolled back by the chain
+	vanished := make([]*storage.UserAction, 0)
+	for _, actionType := range []storage.ActionType{storage.BlackTicketPurchasedActionType, storage.ParticipantRegistrationActionType} {
+		actions, err := s.GetUserActions(actionType)
+		if err != nil || len(actions) != 1 {
+			t.Fatalf("expected 1 %s action, got %d (%v)", actionType, len(actions), err)
+		}
+
+		if !actions[0].Verified {
+			t.Errorf("expected the %s action verified", actionType)
+		}
+
+		actions[0].TransactionHash = strings.Repeat("ff", 32)
+		actions[0].Verified = false
+		vanished = append(vanished, actions[0])
+	}
+
+	if err := s.UpdateUserActions(vanished); err != nil {
+		t.Fatalf("update actions: %v", err)
+	}
+
+	runTracker(t, trackerInstance)
+
+	assertUserActions(t, s, storage.BlackTicketPurchasedActionType, map[string]string{})
+	assertUserActions(t, s, storage.ParticipantRegistrationActionType, map[string]string{})
+	assertUserStatus(t, s, fixtureUser1Address, 1, 0, false)
+	assertUserStatus(t, s, fixtureUser2Address, 0, 0, false)
+}
+
+func TestTrackerResetUserCursors(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	runTracker(t, trackerInstance)
+
+	user2 := ton.MustParseAccountID(fixtureUser2Address).ToHuman(true, false)
+	touches, err := trackerInstance.ResetUserCursors(user2)
+	if err != nil || len(touches) != 1 || touches[0].ActionType != storage.BlackTicketPurchasedActionType {
+		t.Fatalf("expected the purchase cursor of user 2 reset, got %+v (%v)", touches, err)
+	}
+
+	if lt, err := s.GetUserActionTouchByAddress(storage.BlackTicketPurchasedActionType, user2); err != nil || lt != 0 {
+		t.Errorf("expected no purchase cursor, got %d (%v)", lt, err)
+	}
+
+	// the traces are collected again without counting anything twice
+	runTracker(t, trackerInstance)
+
+	if lt, err := s.GetUserActionTouchByAddress(storage.BlackTicketPurchasedActionType, user2); err != nil || lt != touches[0].TransactionLt {
+		t.Errorf("expected the purchase cursor back at %d, got %d (%v)", touches[0].TransactionLt, lt, err)
+	}
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+	assertUserStatus(t, s, fixtureUser2Address, 0, 0, false)
+
+	if sent := len(sender.sent()); sent != 1 {
+		t.Errorf("expected 1 set conditions message, got %d", sent)
+	}
+}
+
+func TestTrackerPushConditions(t *testing.T) {
+	trackerInstance, _, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+	)
+
+	runTracker(t, trackerInstance)
+
+	user1 := ton.MustParseAccountID(fixtureUser1Address).ToHuman(true, false)
+	values, err := trackerInstance.PushConditions(user1)
+	if err != nil {
+		t.Fatalf("push conditions: %v", err)
+	}
+
+	if !reflect.DeepEqual(conditionValues(values), []uint64{1, 1}) {
+		t.Errorf("expected the confirmed 1/1 conditions pushed, got %v", conditionValues(values))
+	}
+
+	if sent := len(sender.sent()); sent != 2 {
+		t.Errorf("expected the conditions sent again, got %d messages", sent)
+	}
+
+	_, err = trackerInstance.PushConditions(ton.MustParseAccountID(fixtureParticipantAddress).ToHuman(true, false))
+	if Classify(err) != UnrecoverableErrorClass {
+		t.Errorf("expected an unknown user to be refused, got %v", err)
+	}
+}
+
+func TestTrackerPlan(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"participant_registration.json",
+	)
+
+	cachedSource := blockchain.NewCachedSource(trackerInstance.source, s)
+	trackerInstance.source = cachedSource
+	trackerInstance.lookups = cachedSource
+
+	plan, err := trackerInstance.Plan(fixtureRaffleDeployedLt, fixtureRaffleConditions)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+
+	user1 := ton.MustParseAccountID(fixtureUser1Address).ToHuman(true, false)
+	user2 := ton.MustParseAccountID(fixtureUser2Address).ToHuman(true, false)
+	if len(plan) != 2 || plan[0].UserAddress != user1 || plan[1].UserAddress != user2 {
+		t.Fatalf("expected users 1 and 2 planned, got %+v", plan)
+	}
+
+	planned := plan[0]
+	if planned.Old != nil || planned.New == nil || planned.New.CandidateRegistrationLt == 0 || planned.New.ParticipantRegistrationLt == 0 {
+		t.Errorf("expected user 1 registered as candidate and participant, got %+v -> %+v", planned.Old, planned.New)
+	}
+
+	expected := []PlannedCondition{
+		{Slot: storage.WhiteTicketMintedActionType, Old: 0, New: 1},
+		{Slot: storage.BlackTicketPurchasedActionType, Old: 0, New: 1},
+	}
+	if !reflect.DeepEqual(planned.Conditions, expected) || !planned.ConditionsReached {
+		t.Errorf("expected 0/0 -> 1/1 reaching the targets, got %+v (%v)", planned.Conditions, planned.ConditionsReached)
+	}
+
+	message, err := trackerInstance.setConditionsMessage(user1, []uint64{1, 1})
+	if err != nil {
+		t.Fatalf("set conditions message: %v", err)
+	}
+
+	if body, err := message.Body.ToBocString(); err != nil || planned.Body != body {
+		t.Errorf("expected body %s, got %s (%v)", body, planned.Body, err)
+	}
+
+	if len(plan[1].Conditions) != 0 || plan[1].Body != "" {
+		t.Errorf("expected no set conditions for user 2, got %+v", plan[1])
+	}
+
+	if sent := len(sender.sent()); sent != 0 {
+		t.Errorf("expected nothing sent, got %d messages", sent)
+	}
+
+	// the storage is left as it was, the cycle then does what was planned
+	actions, err := s.GetUserActions(storage.CandidateRegistrationActionType)
+	if err != nil || len(actions) != 0 {
+		t.Errorf("expected no stored actions, got %d (%v)", len(actions), err)
+	}
+
+	if _, ok, err := s.GetCachedLookup("item_collection", ton.MustParseAccountID(fixtureBlackItemAddress).ToRaw()); err != nil || ok {
+		t.Errorf("expected no cached lookups, got %v (%v)", ok, err)
+	}
+
+	runTracker(t, trackerInstance)
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+	if sent := len(sender.sent()); sent != 1 {
+		t.Errorf("expected the planned message sent by the cycle, got %d messages", sent)
+	}
+}
+
+func TestTrackerRunWithoutPurchases(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+	)
+
+	runTracker(t, trackerInstance)
+
+	assertUserActions(t, s, storage.BlackTicketPurchasedActionType, map[string]string{})
+	assertUserStatus(t, s, fixtureUser1Address, 1, 0, false)
+
+	if sent := len(sender.sent()); sent != 1 {
+		t.Errorf("expected 1 set conditions message, got %d", sent)
+	}
+}
+
+// failingStorage fails to store the actions of one type, inside transactions as well
+type failingStorage struct {
+	storage.Storage
+	actionType storage.ActionType
+}
+
+func (s *failingStorage) UpdateUserActions(actions []*storage.UserAction) error {
+	for _, action := range actions {
+		if action.ActionType == s.actionType {
+			return errors.New("disk full")
+		}
+	}
+
+	return s.Storage.UpdateUserActions(actions)
+}
+
+func (s *failingStorage) Transaction(fn func(tx storage.Storage) error) error {
+	return s.Storage.Transaction(func(tx storage.Storage) error {
+		return fn(&failingStorage{Storage: tx, actionType: s.actionType})
+	})
+}
+
+func TestTrackerRunKeepsCursorWithActions(t *testing.T) {
+	trackerInstance, s, _ := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+	)
+	trackerInstance.storage = &failingStorage{Storage: s, actionType: storage.WhiteTicketMintedActionType}
+
+	err := trackerInstance.Run(fixtureRaffleDeployedLt, fixtureRaffleConditions)
+	if Classify(err) != StorageErrorClass {
+		t.Fatalf("expected a storage error, got %v", err)
+	}
+
+	if lt, err := s.GetUserActionTouch(storage.WhiteTicketMintedActionType); err != nil || lt != 0 {
+		t.Errorf("expected the cursor to stay with the failed actions, got %d (%v)", lt, err)
+	}
+
+	// the next cycle collects the same traces again
+	trackerInstance.storage = s
+	runTracker(t, trackerInstance)
+
+	assertUserActions(t, s, storage.WhiteTicketMintedActionType, map[string]string{
+		fixtureUser1Address: fixtureWhiteItemAddress,
+	})
+	assertUserStatus(t, s, fixtureUser1Address, 1, 0, false)
+}
+
+func TestTrackerRunIndexesCollectionPurchases(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"black_ticket_purchased.json",
+		"collection_purchases.json",
+		"participant_registration.json",
+	)
+
+	slots := trackerInstance.rules.Slots
+	slots[1].Indexing = conditions.CollectionIndexing
+
+	runTracker(t, trackerInstance)
+
+	// the same purchase as the wallet scan, the cancelled sale of user 2 is skipped as well
+	assertUserActions(t, s, storage.BlackTicketPurchasedActionType, map[string]string{
+		fixtureUser1Address: fixtureBlackItemAddress,
+	})
+
+	if lt, err := s.GetUserActionTouchByAddress(storage.BlackTicketPurchasedActionType, fixtureUser1Address); err != nil || lt != 0 {
+		t.Errorf("expected no wallet cursor, got %d (%v)", lt, err)
+	}
+
+	if lt, err := s.GetUserActionTouchByAddress(storage.BlackTicketPurchasedActionType, fixtureBlackItemAddress); err != nil || lt == 0 {
+		t.Errorf("expected an item cursor, got %d (%v)", lt, err)
+	}
+
+	assertUserStatus(t, s, fixtureUser1Address, 1, 1, true)
+
+	if sent := len(sender.sent()); sent != 1 {
+		t.Errorf("expected 1 set conditions message, got %d", sent)
+	}
+}
+
+func TestTrackerRunCountsHeldItems(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+		"items_held.json",
+	)
+
+	rules, err := conditions.NewRules([]conditions.Slot{
+		{Name: "minted", Kind: conditions.MintKind, Collection: fixtureWhiteTicketCollectionAddress},
+		{Name: "held", Kind: conditions.HoldKind, Collection: fixtureHoldCollectionAddress, SnapshotUnixTime: 1},
+	})
+	if err != nil {
+		t.Fatalf("new rules: %v", err)
+	}
+	trackerInstance.rules = rules
+
+	runTracker(t, trackerInstance)
+	runTracker(t, trackerInstance)
+
+	// the item on sale counts for its seller, the item of a non candidate is ignored
+	actions, err := s.GetUserActions("held")
+	if err != nil {
+		t.Fatalf("get held actions: %v", err)
+	}
+
+	held := make(map[string]string)
+	for _, action := range actions {
+		held[action.UserAddress] = action.Address
+	}
+
+	expected := map[string]string{
+		fixtureUser1Address: "EQBkZGRkZGRkZGRkZGRkZGRkZGRkZGRkZGRkZGRkZGRkZD2A",
+		fixtureUser2Address: "EQBlZWVlZWVlZWVlZWVlZWVlZWVlZWVlZWVlZWVlZWVlZT8_",
+	}
+	if !reflect.DeepEqual(held, expected) {
+		t.Errorf("expected held items %v, got %v", expected, held)
+	}
+
+	if sent := len(sender.sent()); sent != 2 {
+		t.Errorf("expected 2 set conditions messages, got %d", sent)
+	}
+
+	outboxMessages, err := s.GetOutboxMessagesByUser(fixtureUser1Address)
+	if err != nil || len(outboxMessages) != 1 {
+		t.Fatalf("expected 1 outbox message of user 1, got %d (%v)", len(outboxMessages), err)
+	}
+
+	if message := outboxMessages[0]; message.State != storage.ConfirmedOutboxState || !reflect.DeepEqual(conditionValues(message.Conditions), []uint64{1, 1}) {
+		t.Errorf("expected a confirmed 1/1 outbox message, got %+v", message)
+	}
+}
+
+func TestResolveRaffleDeployedLt(t *testing.T) {
+	trackerInstance, s, _ := newFixtureTracker(t, "candidate_registration.json")
+
+	deployedLt, err := trackerInstance.ResolveRaffleDeployedLt(0)
+	if err != nil {
+		t.Fatalf("resolve deployed lt: %v", err)
+	}
+
+	// the earliest raffle trace of the fixture is the first candidate registration
+	if deployedLt != 1000 {
+		t.Errorf("expected discovered lt 1000, got %d", deployedLt)
+	}
+
+	// on restart the stored value is reused without touching the chain
+	restarted := NewTrackerWithOptions(context.Background(), Options{
+		Storage:       s,
+		Source:        blockchain.NewFixtureSource(),
+		RaffleAddress: fixtureRaffleAddress,
+	})
+
+	if deployedLt, err = restarted.ResolveRaffleDeployedLt(0); err != nil || deployedLt != 1000 {
+		t.Errorf("expected stored lt 1000, got %d (%v)", deployedLt, err)
+	}
+
+	if deployedLt, err = restarted.ResolveRaffleDeployedLt(fixtureRaffleDeployedLt); err != nil || deployedLt != fixtureRaffleDeployedLt {
+		t.Errorf("expected override lt %d, got %d (%v)", fixtureRaffleDeployedLt, deployedLt, err)
+	}
+
+	if deployedLt, err = restarted.ResolveRaffleDeployedLt(0); err != nil || deployedLt != fixtureRaffleDeployedLt {
+		t.Errorf("expected override to be stored, got %d (%v)", deployedLt, err)
+	}
+}
+
+func TestTrackerStateIsScopedByRaffle(t *testing.T) {
+	trackerInstance, s, _ := newFixtureTracker(t,
+		"candidate_registration.json",
+		"white_ticket_minted.json",
+	)
+
+	runTracker(t, trackerInstance)
+
+	otherStorage := s.ForRaffle(ton.MustParseAccountID(fixtureBlackTicketCollectionAddress).ToRaw())
+
+	actions, err := otherStorage.GetUserActions(storage.CandidateRegistrationActionType)
+	if err != nil || len(actions) != 0 {
+		t.Errorf("expected no candidates of another raffle, got %d (%v)", len(actions), err)
+	}
+
+	if _, err := otherStorage.GetUserStatusByAddress(fixtureUser1Address); err == nil {
+		t.Error("expected no user status of another raffle")
+	}
+}
+
+func TestTrackerGetRaffleAccountData(t *testing.T) {
+	trackerInstance, _, _ := newFixtureTracker(t, "winners.json")
+
+	data, err := trackerInstance.GetRaffleAccountData()
+	if err != nil {
+		t.Fatalf("get raffle account data: %v", err)
+	}
+
+	if data.ParticipantsQuantity != 3 || data.WinnersQuantity != 1 || data.ConditionsDuration != 60 {
+		t.Errorf("unexpected raffle account data %+v", data)
+	}
+
+	// the winner of the dictionary is resolved through its participant contract
+	if len(data.Winners) != 1 {
+		t.Fatalf("expected 1 winner, got %d", len(data.Winners))
+	}
+
+	winner := data.Winners[0]
+	if winner.ParticipantIndex != 0 || winner.ParticipantAddress != fixtureParticipantAddress ||
+		winner.UserAddress != fixtureUser1Address || winner.WinnerIndex == nil || *winner.WinnerIndex != 0 {
+		t.Errorf("unexpected winner %+v", winner)
+	}
+}
+
+func TestTrackerDrawWinners(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t, "winners.json")
+	trackerInstance.winnersQuantity = 2
+
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	// winner 0 is read from the notification, winner 1 is drawn by a RaffleNext
+	winners, err := s.GetWinners()
+	if err != nil || len(winners) != 2 {
+		t.Fatalf("expected 2 winners, got %d (%v)", len(winners), err)
+	}
+
+	drawn := winners[0]
+	if drawn.State != storage.DrawnWinnerState || drawn.ParticipantIndex != 0 ||
+		drawn.ParticipantAddress != fixtureParticipantAddress || drawn.UserAddress != fixtureUser1Address {
+		t.Errorf("unexpected drawn winner %+v", drawn)
+	}
+
+	if winners[1].State != storage.DrawingWinnerState || winners[1].WinnerIndex != 1 {
+		t.Errorf("expected winner 1 to be drawing, got %+v", winners[1])
+	}
+
+	messages := sender.sent()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 raffle next message, got %d", len(messages))
+	}
+
+	if messages[0].Address != ton.MustParseAccountID(fixtureRaffleAddress) {
+		t.Errorf("raffle next sent to %s instead of raffle", messages[0].Address.ToRaw())
+	}
+
+	opCode, err := messages[0].Body.ReadUint(32)
+	if err != nil || opCode != 0x13370013 {
+		t.Errorf("expected raffle next op code, got 0x%08x (%v)", opCode, err)
+	}
+	messages[0].Body.ResetCounters()
+
+	// a pending draw is not sent again
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	if messages := sender.sent(); len(messages) != 1 {
+		t.Fatalf("expected no new raffle next message, got %d", len(messages))
+	}
+
+	// an expired draw is sent again
+	winners[1].SentAt = time.Now().Add(-2 * drawConfirmationTimeout)
+	if err := s.UpdateWinners(winners[1:]); err != nil {
+		t.Fatalf("update winners: %v", err)
+	}
+
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	if messages := sender.sent(); len(messages) != 2 {
+		t.Fatalf("expected the expired draw to be sent again, got %d messages", len(messages))
+	}
+}
+
+func TestTrackerDrawWinnersCountedOnChain(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t, "winners.json")
+	trackerInstance.winnersQuantity = 2
+
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	// the raffle counted winner 0, an old draw of it only waits for the notification
+	if err := s.DeleteWinners([]int{0, 1}); err != nil {
+		t.Fatalf("delete winners: %v", err)
+	}
+
+	err := s.UpdateWinners([]*storage.Winner{{WinnerIndex: 0, State: storage.DrawingWinnerState, SentAt: time.Now().Add(-2 * drawConfirmationTimeout)}})
+	if err != nil {
+		t.Fatalf("update winners: %v", err)
+	}
+
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	winners, err := s.GetWinners()
+	if err != nil || len(winners) != 2 || winners[0].State != storage.DrawingWinnerState || winners[1].WinnerIndex != 1 {
+		t.Fatalf("expected the counted draw kept and winner 1 drawn, got %+v (%v)", winners, err)
+	}
+
+	// one winner more than the participants allow is never drawn
+	trackerInstance.winnersQuantity = 3
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	if messages := sender.sent(); len(messages) != 2 {
+		t.Errorf("expected only the 2 draws the participants allow, got %d messages", len(messages))
+	}
+}
+
+func TestTrackerDrawWinnersSendFailure(t *testing.T) {
+	trackerInstance, s, sender := newFixtureTracker(t, "winners.json")
+	trackerInstance.winnersQuantity = 2
+
+	// a draw that never left the wallet is deleted and drawn again by the next cycle
+	sender.err = fmt.Errorf("%w: get seqno", blockchain.ErrNotSent)
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+
+	if winners, err := s.GetWinners(); err != nil || len(winners) != 1 {
+		t.Fatalf("expected only the drawn winner kept, got %+v (%v)", winners, err)
+	}
+
+	// a draw that may have been sent is kept, so the next cycles do not send it twice
+	sender.err = errors.New("confirmation timeout")
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+
+	sender.err = nil
+	if err := trackerInstance.DrawWinners(fixtureRaffleDeployedLt); err != nil {
+		t.Fatalf("draw winners: %v", err)
+	}
+
+	winners, err := s.GetWinners()
+	if err != nil || len(winners) != 2 || winners[1].State != storage.DrawingWinnerState {
+		t.Fatalf("expected the unconfirmed draw kept, got %+v (%v)", winners, err)
+	}
+
+	if messages := sender.sent(); len(messages) != 1 {
+		t.Errorf("expected the unconfirmed draw sent once, got %d messages", len(messages))
+	}
+}
+
+func runTracker(t *testing.T, trackerInstance *Tracker) {
+	t.Helper()
+
+	if err := trackerInstance.StoreRaffleConditions(fixtureRaffleConditions); err != nil {
+		t.Fatalf("store raffle conditions: %v", err)
+	}
+
+	if err := trackerInstance.Run(fixtureRaffleDeployedLt, fixtureRaffleConditions); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
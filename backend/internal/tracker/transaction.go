@@ -0,0 +1,34 @@
+package tracker
+
+import (
+	"backend/internal/storage"
+)
+
+// transaction runs fn on a copy of the tracker bound to one storage transaction
+func (t *Tracker) transaction(fn func(t *Tracker) error) error {
+	return t.storage.Transaction(func(tx storage.Storage) error {
+		scoped := *t
+		scoped.storage = tx
+		return fn(&scoped)
+	})
+}
+
+// commitActions persists the discovered actions together with the advanced cursors. A failure
+// leaves both untouched, so the next cycle collects the same traces again.
+func (t *Tracker) commitActions(operation string, actions []*storage.UserAction, touches ...*storage.UserActionTouch) error {
+	return storageError(operation, t.storage.Transaction(func(tx storage.Storage) error {
+		if len(actions) > 0 {
+			if err := tx.UpdateUserActions(actions); err != nil {
+				return err
+			}
+		}
+
+		for _, touch := range touches {
+			if err := tx.UpdateUserActionTouch(touch); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+}
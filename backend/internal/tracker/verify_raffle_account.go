@@ -16,128 +16,89 @@ func (t *Tracker) VerifyRaffleAccount() error {
 
 	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to parse raffle address", zap.String("raffle address", t.raffleAddress), zap.Error(err))
-		return err
+		return unrecoverableError("verify raffle account: parse raffle address", err)
 	}
 
 	logger.Debug("verify raffle account: validating raffle address", zap.String("raffle address", t.raffleAddress))
 
-	raffleAccount, err := infinityRateLimitRetry(
+	raffleAccount, err := rateLimitRetry(t.ctx,
 		func() (*tonapi.Account, error) {
-			return t.client.GetAccount(t.ctx, tonapi.GetAccountParams{
-				AccountID: t.raffleAddress,
-			})
+			return t.source.GetAccount(t.ctx, t.raffleAddress)
 		})
 
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to get raffle account state")
-		return err
+		return sourceError("verify raffle account: get raffle account state", err)
 	}
 
 	logger.Debug("verify raffle account: raffle contract info:", zap.Int64("balance", raffleAccount.GetBalance()))
 
-	raffleData, err := infinityRateLimitRetry(
+	raffleData, err := rateLimitRetry(t.ctx,
 		func() (*tonapi.MethodExecutionResult, error) {
-			return t.client.ExecGetMethodForBlockchainAccount(t.ctx, tonapi.ExecGetMethodForBlockchainAccountParams{
-				AccountID:  t.raffleAddress,
-				MethodName: "raffleData",
-				Args:       make([]string, 0),
-			})
+			return t.source.ExecGetMethod(t.ctx, t.raffleAddress, "raffleData")
 		})
 
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to get raffleData, invalid raffle contract")
-		return err
+		return sourceError("verify raffle account: get raffleData", err)
 	}
 
 	logger.Debug("verify raffle account: raffleData", zap.Bool("success", raffleData.GetSuccess()))
 
-	raffleCandidateAddressResult, err := infinityRateLimitRetry(
+	raffleCandidateAddressResult, err := rateLimitRetry(t.ctx,
 		func() (*tonapi.MethodExecutionResult, error) {
-			return t.client.ExecGetMethodWithBodyForBlockchainAccount(t.ctx,
-				tonapi.OptExecGetMethodWithBodyForBlockchainAccountReq{
-					Value: tonapi.ExecGetMethodWithBodyForBlockchainAccountReq{
-						Args: []tonapi.ExecGetMethodArg{
-							{Value: raffleAccountID.ToRaw(), Type: "slice"},
-						},
-					},
-					Set: true,
-				},
-				tonapi.ExecGetMethodWithBodyForBlockchainAccountParams{
-					AccountID:  t.raffleAddress,
-					MethodName: "raffleCandidateAddress",
-				},
+			return t.source.ExecGetMethod(t.ctx, t.raffleAddress, "raffleCandidateAddress",
+				tonapi.ExecGetMethodArg{Value: raffleAccountID.ToRaw(), Type: tonapi.ExecGetMethodArgTypeSlice},
 			)
 		})
 
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to get raffle candidate address, invalid raffle contract")
-		return err
+		return sourceError("verify raffle account: get raffle candidate address", err)
 	}
 
 	raffleCandidateAccountAddressSliceOpt := raffleCandidateAddressResult.GetStack()[0].GetCell()
 	cell, err := boc.DeserializeBocHex(raffleCandidateAccountAddressSliceOpt.Value)
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to deserialize raffle candidate address, invalid raffle contract")
-		return err
+		return malformedError("verify raffle account: deserialize raffle candidate address", err)
 	}
 
 	var raffleCandidateAccountAddress tlb.MsgAddress
 	err = tlb.Unmarshal(cell[0], &raffleCandidateAccountAddress)
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to extract raffle candidate address from boc, invalid raffle contract")
-		return err
+		return malformedError("verify raffle account: extract raffle candidate address from boc", err)
 	}
 
 	raffleCandidateAccountID, err := tongo.AccountIDFromTlb(raffleCandidateAccountAddress)
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to read raffle candidate address due to address tlb scheme, invalid raffle contract")
-		return err
+		return malformedError("verify raffle account: read raffle candidate address due to address tlb scheme", err)
 	}
 
 	logger.Debug("verify raffle account:", zap.String("raffle candidate address", raffleCandidateAccountID.ToHuman(true, false)))
 
-	raffleParticipantAddressResult, err := infinityRateLimitRetry(
+	raffleParticipantAddressResult, err := rateLimitRetry(t.ctx,
 		func() (*tonapi.MethodExecutionResult, error) {
-			return t.client.ExecGetMethodWithBodyForBlockchainAccount(t.ctx,
-				tonapi.OptExecGetMethodWithBodyForBlockchainAccountReq{
-					Value: tonapi.ExecGetMethodWithBodyForBlockchainAccountReq{
-						Args: []tonapi.ExecGetMethodArg{
-							{Value: "1", Type: "tinyint"},
-						},
-					},
-					Set: true,
-				},
-				tonapi.ExecGetMethodWithBodyForBlockchainAccountParams{
-					AccountID:  t.raffleAddress,
-					MethodName: "raffleParticipantAddress",
-				},
+			return t.source.ExecGetMethod(t.ctx, t.raffleAddress, "raffleParticipantAddress",
+				tonapi.ExecGetMethodArg{Value: "1", Type: tonapi.ExecGetMethodArgTypeTinyint},
 			)
 		})
 
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to get raffle participant address from raffle contract, invalid raffle contract")
-		return err
+		return sourceError("verify raffle account: get raffle participant address from raffle contract", err)
 	}
 
 	raffleParticipantAccountAddressSliceOpt := raffleParticipantAddressResult.GetStack()[0].GetCell()
 	cell, err = boc.DeserializeBocHex(raffleParticipantAccountAddressSliceOpt.Value)
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to extract raffle participant address from boc, invalid raffle contract")
-		return err
+		return malformedError("verify raffle account: extract raffle participant address from boc", err)
 	}
 
 	var raffleParticipantAccountAddress tlb.MsgAddress
 	err = tlb.Unmarshal(cell[0], &raffleParticipantAccountAddress)
 	if err != nil {
-		logger.Fatal("verify raffle account: failed to read raffle participant address due to address tlb scheme, invalid raffle contract")
-		return err
+		return malformedError("verify raffle account: read raffle participant address due to address tlb scheme", err)
 	}
 
 	raffleParticipantAccountID, err := tongo.AccountIDFromTlb(raffleParticipantAccountAddress)
 	if err != nil {
-		logger.Fatal("verify raffle account: invalid raffle participant address, invalid raffle contract")
-		return err
+		return malformedError("verify raffle account: invalid raffle participant address", err)
 	}
 	logger.Debug("raffleParticipant account", zap.String("address", raffleParticipantAccountID.ToHuman(true, false)))
 	logger.Debug("Verifying raffle address... done")
@@ -0,0 +1,144 @@
+package tracker
+
+import (
+	"backend/internal/abuse"
+	"backend/internal/conditions"
+	"backend/internal/logger"
+	"backend/internal/metrics"
+	"backend/internal/storage"
+	"cmp"
+	"slices"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/ton"
+	"go.uber.org/zap"
+)
+
+// flagWashTrades checks the new purchases of a slot against the stored ones in lt order. A wash
+// trade is stored with the reason it was flagged for and never counts for the conditions.
+func (t *Tracker) flagWashTrades(slot conditions.Slot, actions []*storage.UserAction) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	storedActions, err := t.storage.GetUserActions(slot.Name)
+	if err != nil {
+		return storageError("wash trades: get stored purchases", err)
+	}
+
+	stored := make(map[string]bool, len(storedActions))
+	history := make([]abuse.Transfer, 0, len(storedActions))
+	for _, action := range storedActions {
+		stored[action.UserAddress+"/"+action.Address] = true
+		history = append(history, purchaseTransfer(action))
+	}
+
+	detector := abuse.NewDetector(slot.ItemCounting, history)
+
+	actions = slices.Clone(actions)
+	slices.SortStableFunc(actions, func(a, b *storage.UserAction) int { return cmp.Compare(a.TransactionLt, b.TransactionLt) })
+	for _, action := range actions {
+		// a purchase seen again keeps the flag it was stored with
+		if stored[action.UserAddress+"/"+action.Address] {
+			continue
+		}
+		stored[action.UserAddress+"/"+action.Address] = true
+
+		transfer := purchaseTransfer(action)
+		reason := detector.Reason(transfer)
+		if reason == "" && transfer.Seller != "" {
+			funded, err := t.sellerFundedByBuyer(transfer.Seller, transfer.Buyer, action.TransactionLt)
+			if err != nil {
+				return err
+			}
+
+			if funded {
+				reason = abuse.SellerFundedReason
+			}
+		}
+
+		detector.Record(transfer, reason)
+		if reason == "" {
+			continue
+		}
+
+		logger.Warn("wash trades: purchase flagged",
+			zap.String("user address", action.UserAddress),
+			zap.String("item address", action.Address),
+			zap.String("seller address", action.Counterparty),
+			zap.String("reason", reason),
+		)
+
+		action.FlagReason = reason
+		metrics.FlaggedActions.WithLabelValues(t.raffleLabel, reason).Inc()
+	}
+
+	return nil
+}
+
+// sellerFundedByBuyer looks for a transfer of the buyer to the seller before the purchase, in the
+// latest window of the seller traces
+func (t *Tracker) sellerFundedByBuyer(seller string, buyer string, purchaseLt int64) (bool, error) {
+	traces, err := rateLimitRetry(t.ctx,
+		func() (*tonapi.TraceIDs, error) {
+			return t.source.GetAccountTraces(t.ctx, seller, t.limitWindowSize, purchaseLt)
+		})
+	if err != nil {
+		return false, sourceError("wash trades: collect seller traces", err)
+	}
+
+	for _, traceID := range traces.GetTraces() {
+		trace, err := rateLimitRetry(t.ctx,
+			func() (*tonapi.Trace, error) {
+				return t.source.GetTrace(t.ctx, traceID.GetID())
+			})
+		if err != nil {
+			return false, sourceError("wash trades: collect seller trace details", err)
+		}
+
+		if traceFundsAccount(trace, seller, buyer) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// traceFundsAccount tells whether a transaction of the trace credits the account with a value
+// sent by the funder
+func traceFundsAccount(trace *tonapi.Trace, account string, funder string) bool {
+	if message, ok := trace.Transaction.GetInMsg().Get(); ok && message.Value > 0 && rawAddress(trace.Transaction.Account.Address) == account {
+		if source, ok := message.Source.Get(); ok && rawAddress(source.Address) == funder {
+			return true
+		}
+	}
+
+	for i := range trace.Children {
+		if traceFundsAccount(&trace.Children[i], account, funder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// purchaseTransfer is the transfer of a purchase action, with raw addresses
+func purchaseTransfer(action *storage.UserAction) abuse.Transfer {
+	return abuse.Transfer{
+		Item:   rawAddress(action.Address),
+		Seller: rawAddress(action.Counterparty),
+		Buyer:  rawAddress(action.UserAddress),
+		Lt:     action.TransactionLt,
+		Reason: action.FlagReason,
+	}
+}
+
+// rawAddress normalizes an address to its raw form, an invalid one is kept as is
+func rawAddress(address string) string {
+	accountID, err := ton.ParseAccountID(address)
+	if err != nil {
+		return address
+	}
+
+	return accountID.ToRaw()
+}
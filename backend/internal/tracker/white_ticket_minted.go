@@ -1,6 +1,7 @@
 package tracker
 
 import (
+	"backend/internal/conditions"
 	"backend/internal/logger"
 	"backend/internal/storage"
 	"math"
@@ -13,14 +14,16 @@ import (
 	"go.uber.org/zap"
 )
 
-func (t *Tracker) collectWhiteTicketMintedActions(raffleDeployedLt int64) error {
-	logger.Debug("collect white ticket minted actions...")
+// collectWhiteTicketMintedActions collects the items minted by the collection of a mint slot,
+// the white tickets of the default conditions.
+func (t *Tracker) collectWhiteTicketMintedActions(slot conditions.Slot, raffleDeployedLt int64) error {
+	logger.Debug("collect white ticket minted actions...", zap.String("slot", slot.Name))
 	var actions = make([]*storage.UserAction, 0)
 
 	logger.Debug("get latest white ticket minted at")
-	lastWhiteTicketMintedLt, err := t.storage.GetUserActionTouch(storage.WhiteTicketMintedActionType)
+	lastWhiteTicketMintedLt, err := t.storage.GetUserActionTouch(slot.Name)
 	if err != nil {
-		panic(err)
+		return storageError("white ticket minted: get last action transaction state", err)
 	}
 
 	var transactionLt int64 = 0
@@ -30,34 +33,32 @@ func (t *Tracker) collectWhiteTicketMintedActions(raffleDeployedLt int64) error
 
 	for {
 		logger.Debug("white ticket minted: collect traces... iteration", zap.Int64("current beforeLt", beforeLt))
-		accountTracesResult, err := infinityRateLimitRetry(
+		accountTracesResult, err := rateLimitRetry(t.ctx,
 			func() (*tonapi.TraceIDs, error) {
-				return t.client.GetAccountTraces(t.ctx, tonapi.GetAccountTracesParams{
-					AccountID: t.whiteTicketCollectionAddress,
-					Limit:     tonapi.OptInt{Value: GlobalLimitWindowSize, Set: true},
-					BeforeLt: tonapi.OptInt64{
-						Value: beforeLt,
-						Set:   beforeLt > 0,
-					},
-				})
+				return t.source.GetAccountTraces(t.ctx, slot.Collection, t.limitWindowSize, beforeLt)
 			})
 
 		if err != nil {
-			logger.Fatal("white ticket minted: collect traces... failed", zap.Error(err))
-			return err
+			return sourceError("white ticket minted: collect traces", err)
 		}
 
 		for _, traceID := range accountTracesResult.GetTraces() {
 			logger.Debug("white ticket minted: collect trace details... iteration", zap.String("trace id", traceID.GetID()))
-			trace, err := infinityRateLimitRetry(
+			trace, err := rateLimitRetry(t.ctx,
 				func() (*tonapi.Trace, error) {
-					return t.client.GetTrace(t.ctx, tonapi.GetTraceParams{TraceID: traceID.GetID()})
+					return t.source.GetTrace(t.ctx, traceID.GetID())
 				},
 			)
 
 			if err != nil {
-				logger.Fatal("white ticket minted: cannot get white ticket collection account trace", zap.Error(err))
-				break
+				return sourceError("white ticket minted: collect trace details", err)
+			}
+
+			if !t.traceFinal(trace) {
+				logger.Debug("white ticket minted: trace is not final yet, skip", zap.String("trace id", traceID.GetID()))
+				transactionLt = trace.Transaction.Lt
+				beforeLt = transactionLt
+				continue
 			}
 
 			transactionLt = trace.Transaction.Lt
@@ -81,7 +82,7 @@ func (t *Tracker) collectWhiteTicketMintedActions(raffleDeployedLt int64) error
 					)
 
 					actions = append(actions, &storage.UserAction{
-						ActionType:          storage.WhiteTicketMintedActionType,
+						ActionType:          slot.Name,
 						UserAddress:         processedUserAddress,
 						Address:             processedTicketAddress,
 						TransactionLt:       transactionLt,
@@ -101,7 +102,7 @@ func (t *Tracker) collectWhiteTicketMintedActions(raffleDeployedLt int64) error
 			logger.Debug("white ticket minted: process account trace... iteration done", zap.Int64("transaction lt", transactionUnixTime))
 		}
 
-		if len(accountTracesResult.GetTraces()) < GlobalLimitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastWhiteTicketMintedLt {
+		if len(accountTracesResult.GetTraces()) < t.limitWindowSize || beforeLt < raffleDeployedLt || transactionLt <= lastWhiteTicketMintedLt {
 			logger.Debug("white ticket minted: exit condition reached, finalize traces results...", zap.Int64("transaction lt", transactionUnixTime))
 			break
 		}
@@ -109,26 +110,21 @@ func (t *Tracker) collectWhiteTicketMintedActions(raffleDeployedLt int64) error
 		logger.Debug("white ticket minted: collect nft collection account traces... iteration done")
 	}
 
+	var touches []*storage.UserActionTouch
 	if maxTransactionLt > lastWhiteTicketMintedLt {
-		actionTouch := &storage.UserActionTouch{
-			ActionType:    storage.WhiteTicketMintedActionType,
+		touches = append(touches, &storage.UserActionTouch{
+			ActionType:    slot.Name,
 			UserAddress:   "-",
 			TransactionLt: maxTransactionLt,
-		}
-		err := t.storage.UpdateUserActionTouch(actionTouch)
-		if err != nil {
-			logger.Fatal("white ticket minted: failed to update last action transaction state")
-			return err
-		}
+		})
 	}
 
-	if len(actions) > 0 {
-		err := t.storage.UpdateUserActions(actions)
-		if err != nil {
-			panic("failed to update pending white ticket minted actions: " + err.Error())
-		}
+	if err := t.commitActions("white ticket minted: update actions", actions, touches...); err != nil {
+		return err
 	}
 
+	t.observeActions(slot.Name, "-", max(maxTransactionLt, lastWhiteTicketMintedLt), len(actions))
+
 	return nil
 }
 
@@ -0,0 +1,384 @@
+package tracker
+
+import (
+	"backend/internal/blockchain"
+	"backend/internal/logger"
+	"backend/internal/metrics"
+	"backend/internal/storage"
+	"errors"
+	"time"
+
+	"github.com/tonkeeper/tonapi-go"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/tonkeeper/tongo/wallet"
+	"go.uber.org/zap"
+)
+
+// drawConfirmationTimeout is how long a draw may take to reach the raffle. It outlasts the
+// validity of the wallet external messages, a draw older than it that the raffle has not
+// counted was never delivered.
+const drawConfirmationTimeout = 10 * time.Minute
+
+// DrawWinners draws the configured winners once the conditions duration after the minimum of
+// participants elapsed. The winner notifications are collected first, then the RaffleNext
+// messages still missing are sent; a draw the raffle has not counted in time is sent again.
+func (t *Tracker) DrawWinners(raffleDeployedLt int64) error {
+	if t.winnersQuantity == 0 {
+		return nil
+	}
+
+	// the winners themselves are read from the notifications, not resolved here
+	raffleAccountData, _, err := t.getRaffleData()
+	if err != nil {
+		return err
+	}
+
+	if raffleAccountData.MinCandidateReachedUnixTime == 0 {
+		logger.Debug("winners: minimum of participants not reached yet")
+		return nil
+	}
+
+	endsAt := time.Unix(raffleAccountData.MinCandidateReachedUnixTime+int64(raffleAccountData.ConditionsDuration), 0)
+	if time.Now().Before(endsAt) {
+		logger.Debug("winners: conditions duration not elapsed yet", zap.Time("ends at", endsAt))
+		return nil
+	}
+
+	if err := t.updateFinalLt(); err != nil {
+		return err
+	}
+
+	if err := t.collectWinners(raffleDeployedLt); err != nil {
+		return err
+	}
+
+	winners, err := t.storage.GetWinners()
+	if err != nil {
+		return storageError("winners: get winners", err)
+	}
+
+	// the raffle numbers its winners in draw order, the draws below its winners quantity have
+	// been counted and only wait for their notification
+	drawnOnChain := int(raffleAccountData.WinnersQuantity)
+	taken := make(map[int]bool)
+	expired := make([]int, 0)
+	inFlight := 0
+	for _, winner := range winners {
+		if winner.State == storage.DrawingWinnerState && winner.WinnerIndex >= drawnOnChain {
+			if time.Since(winner.SentAt) > drawConfirmationTimeout {
+				logger.Warn("winners: draw not counted by the raffle in time, drawing again", zap.Int("winner index", winner.WinnerIndex), zap.String("message hash", winner.MessageHash))
+				expired = append(expired, winner.WinnerIndex)
+				continue
+			}
+
+			inFlight++
+		}
+
+		taken[winner.WinnerIndex] = true
+	}
+
+	if err := t.storage.DeleteWinners(expired); err != nil {
+		return storageError("winners: delete expired draws", err)
+	}
+	metrics.WinnerDraws.WithLabelValues(t.raffleLabel, "expired").Add(float64(len(expired)))
+
+	missing := t.winnersQuantity - drawnOnChain - inFlight
+	// RaffleNext needs more participants than the winners plus one
+	if available := int(raffleAccountData.ParticipantsQuantity) - 1 - drawnOnChain - inFlight; missing > available {
+		logger.Debug("winners: not enough participants for the winners quantity",
+			zap.Int("winners quantity", t.winnersQuantity),
+			zap.Uint64("participants", raffleAccountData.ParticipantsQuantity),
+		)
+		missing = available
+	}
+
+	if missing <= 0 {
+		return nil
+	}
+
+	draws := make([]*storage.Winner, 0, missing)
+	for index := drawnOnChain; len(draws) < missing; index++ {
+		if !taken[index] {
+			draws = append(draws, &storage.Winner{WinnerIndex: index, State: storage.DrawingWinnerState, SentAt: time.Now()})
+		}
+	}
+
+	return t.sendRaffleNext(draws)
+}
+
+// sendRaffleNext stores the draws before sending them, a draw lost with the process or with
+// an unconfirmed send is only sent again once it expired. The draws that provably never left
+// the wallet are deleted for the next cycle.
+func (t *Tracker) sendRaffleNext(draws []*storage.Winner) error {
+	if err := t.storage.UpdateWinners(draws); err != nil {
+		return storageError("winners: update winners", err)
+	}
+
+	batchSize := max(t.wallet.MaxMessages(), 1)
+	for start := 0; start < len(draws); start += batchSize {
+		batch := draws[start:min(start+batchSize, len(draws))]
+
+		sendables := make([]wallet.Sendable, len(batch))
+		for i := range batch {
+			message, err := t.raffleNextMessage()
+			if err != nil {
+				return unrecoverableError("winners: build raffle next", err)
+			}
+			sendables[i] = message
+		}
+
+		logger.Info("winners: sending raffle next...", zap.Int("draws", len(batch)))
+		result, err := t.wallet.Send(t.ctx, 60*time.Second, sendables...)
+		if err != nil {
+			unsent := draws[start+len(batch):]
+			if errors.Is(err, blockchain.ErrNotSent) {
+				unsent = draws[start:]
+			} else {
+				logger.Warn("winners: raffle next may have been sent, keeping the draws until they expire", zap.Ints("winner indexes", winnerIndexes(batch)), zap.Error(err))
+			}
+
+			if err := t.storage.DeleteWinners(winnerIndexes(unsent)); err != nil {
+				return storageError("winners: delete unsent draws", err)
+			}
+			return sourceError("winners: send raffle next", err)
+		}
+
+		for _, draw := range batch {
+			draw.MessageHash = result.MessageHash.Hex()
+			draw.SentAt = time.Now()
+		}
+
+		if err := t.storage.UpdateWinners(batch); err != nil {
+			return storageError("winners: update winners", err)
+		}
+
+		metrics.WinnerDraws.WithLabelValues(t.raffleLabel, "sent").Add(float64(len(batch)))
+		logger.Info("winners: sending raffle next... done", zap.Int("draws", len(batch)), zap.Uint32("seqno", result.Seqno), zap.String("message hash", result.MessageHash.Hex()))
+	}
+
+	return nil
+}
+
+func (t *Tracker) raffleNextMessage() (wallet.Message, error) {
+	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
+	if err != nil {
+		return wallet.Message{}, err
+	}
+
+	// the payload is forwarded to the winner as is, a text comment in the snake layout
+	body, err := blockchain.MarshalBody(blockchain.RaffleNext{
+		ForwardAmount:  tlb.Grams(t.forwardAmount),
+		ForwardPayload: tlb.Text(t.forwardPayload),
+	})
+	if err != nil {
+		return wallet.Message{}, err
+	}
+
+	return wallet.Message{
+		Amount:  tlb.Grams(t.drawAmount),
+		Address: raffleAccountID,
+		Bounce:  true,
+		Mode:    wallet.DefaultMessageMode,
+		Body:    body,
+	}, nil
+}
+
+// collectWinners reads the winner notifications the raffle sent to the participant contracts
+// since the cursor, a drawn winner replaces the draw of its index
+func (t *Tracker) collectWinners(raffleDeployedLt int64) error {
+	raffleAccountID, err := ton.ParseAccountID(t.raffleAddress)
+	if err != nil {
+		return unrecoverableError("winners: parse raffle address", err)
+	}
+
+	lastNotificationLt, err := t.storage.GetUserActionTouch(storage.WinnerNotificationActionType)
+	if err != nil {
+		return storageError("winners: get last notification transaction state", err)
+	}
+
+	notifications := make([]*tonapi.Trace, 0)
+	var maxTransactionLt int64 = 0
+	var beforeLt int64 = 0
+
+	for {
+		accountTracesResult, err := rateLimitRetry(t.ctx,
+			func() (*tonapi.TraceIDs, error) {
+				return t.source.GetAccountTraces(t.ctx, raffleAccountID.ToRaw(), t.limitWindowSize, beforeLt)
+			},
+		)
+		if err != nil {
+			return sourceError("winners: collect traces", err)
+		}
+
+		reached := false
+		for _, traceID := range accountTracesResult.GetTraces() {
+			trace, err := rateLimitRetry(t.ctx,
+				func() (*tonapi.Trace, error) {
+					return t.source.GetTrace(t.ctx, traceID.GetID())
+				},
+			)
+			if err != nil {
+				return sourceError("winners: collect trace details", err)
+			}
+
+			beforeLt = trace.Transaction.Lt
+			if trace.Transaction.Lt <= lastNotificationLt || trace.Transaction.Lt < raffleDeployedLt {
+				reached = true
+				break
+			}
+
+			if !t.traceFinal(trace) {
+				logger.Debug("winners: trace is not final yet, skip", zap.String("trace id", traceID.GetID()))
+				continue
+			}
+
+			maxTransactionLt = max(maxTransactionLt, trace.Transaction.Lt)
+			walkWinnerNotifications(trace, raffleAccountID.ToRaw(), func(notification *tonapi.Trace) {
+				notifications = append(notifications, notification)
+			})
+		}
+
+		if reached || len(accountTracesResult.GetTraces()) < t.limitWindowSize {
+			break
+		}
+	}
+
+	winners, err := t.storage.GetWinners()
+	if err != nil {
+		return storageError("winners: get winners", err)
+	}
+
+	stored := make(map[int]*storage.Winner)
+	for _, winner := range winners {
+		stored[winner.WinnerIndex] = winner
+	}
+
+	drawn := make([]*storage.Winner, 0, len(notifications))
+	for _, notification := range notifications {
+		winner, ok := t.processWinnerNotification(notification)
+		if !ok {
+			logger.Debug("winners: notification cannot be processed, skip", zap.String("transaction hash", notification.Transaction.Hash))
+			continue
+		}
+
+		participantData, err := t.getParticipantData(winner.ParticipantAddress)
+		if err != nil {
+			return err
+		}
+
+		winner.ParticipantIndex = participantData.ParticipantIndex
+		if winner.UserAddress == "" {
+			winner.UserAddress = participantData.UserAddress
+		}
+
+		if draw, ok := stored[winner.WinnerIndex]; ok {
+			winner.MessageHash = draw.MessageHash
+			winner.SentAt = draw.SentAt
+		}
+
+		logger.Info("winners: winner drawn",
+			zap.Int("winner index", winner.WinnerIndex),
+			zap.Uint64("participant index", winner.ParticipantIndex),
+			zap.String("user address", winner.UserAddress),
+		)
+		drawn = append(drawn, winner)
+	}
+
+	err = t.storage.Transaction(func(tx storage.Storage) error {
+		if err := tx.UpdateWinners(drawn); err != nil {
+			return err
+		}
+
+		if maxTransactionLt > lastNotificationLt {
+			return tx.UpdateUserActionTouch(&storage.UserActionTouch{
+				ActionType:    storage.WinnerNotificationActionType,
+				UserAddress:   "-",
+				TransactionLt: maxTransactionLt,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return storageError("winners: update winners", err)
+	}
+
+	metrics.WinnerDraws.WithLabelValues(t.raffleLabel, "drawn").Add(float64(len(drawn)))
+	return nil
+}
+
+// walkWinnerNotifications calls back with the transactions of the participant contracts
+// notified by a RaffleNext the raffle handled
+func walkWinnerNotifications(trace *tonapi.Trace, raffleAddress string, callback func(*tonapi.Trace)) {
+	raffleNextOpCode := tonapi.NewOptString(blockchain.OpCode(blockchain.RaffleNextOpCode))
+	winnerNotificationOpCode := tonapi.NewOptString(blockchain.OpCode(blockchain.RaffleParticipantWinnerNotificationOpCode))
+
+	if inMsg, ok := trace.Transaction.GetInMsg().Get(); ok && inMsg.GetOpCode() == raffleNextOpCode &&
+		trace.Transaction.Account.Address == raffleAddress && trace.Transaction.Success {
+		for i := range trace.Children {
+			if childInMsg, ok := trace.Children[i].Transaction.GetInMsg().Get(); ok && childInMsg.GetOpCode() == winnerNotificationOpCode {
+				callback(&trace.Children[i])
+			}
+		}
+	}
+
+	for i := range trace.Children {
+		walkWinnerNotifications(&trace.Children[i], raffleAddress, callback)
+	}
+}
+
+// processWinnerNotification decodes the winner index of a notification. The user is the
+// destination of the message the participant forwards, it is left to the participant contract
+// data when the notification failed after the raffle counted the winner.
+func (t *Tracker) processWinnerNotification(trace *tonapi.Trace) (*storage.Winner, bool) {
+	inMsg, ok := trace.Transaction.GetInMsg().Get()
+	if !ok {
+		return nil, false
+	}
+
+	var notification blockchain.RaffleParticipantWinnerNotification
+	if err := blockchain.UnmarshalBody(inMsg.GetRawBody().Value, &notification); err != nil {
+		return nil, false
+	}
+
+	participantAccountID, err := ton.ParseAccountID(trace.Transaction.Account.Address)
+	if err != nil {
+		return nil, false
+	}
+
+	winner := &storage.Winner{
+		WinnerIndex:        int(notification.WinnerIndex),
+		State:              storage.DrawnWinnerState,
+		ParticipantAddress: participantAccountID.ToHuman(true, false),
+		TransactionHash:    inMsg.GetHash(),
+		TransactionLt:      trace.Transaction.Lt,
+	}
+
+	for _, outMsg := range trace.Transaction.OutMsgs {
+		destination, ok := outMsg.Destination.Get()
+		if !ok {
+			continue
+		}
+
+		if inSource, ok := inMsg.Source.Get(); ok && destination.Address == inSource.Address {
+			continue
+		}
+
+		if userAccountID, err := ton.ParseAccountID(destination.Address); err == nil {
+			winner.UserAddress = userAccountID.ToHuman(true, false)
+			return winner, true
+		}
+	}
+
+	return winner, true
+}
+
+func winnerIndexes(winners []*storage.Winner) []int {
+	result := make([]int, len(winners))
+	for i, winner := range winners {
+		result[i] = winner.WinnerIndex
+	}
+
+	return result
+}
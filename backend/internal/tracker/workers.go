@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"backend/internal/conditions"
+	"backend/internal/storage"
+
+	"github.com/tonkeeper/tongo/ton"
+	"golang.org/x/sync/errgroup"
+)
+
+// purchaseScan is the trace scan of one account of a purchase slot from its cursor
+type purchaseScan struct {
+	accountAddress string
+	lastLt         int64
+	attribute      func(buyer ton.AccountID) (string, bool)
+}
+
+// runPurchaseScans runs the scans on at most t.workers goroutines and commits their actions, wash
+// trades flagged, and their cursors in one transaction once all of them succeed. The cursors are
+// read by the caller, so the workers only talk to the chain source; the first failure skips the
+// scans not started yet.
+func (t *Tracker) runPurchaseScans(op string, slot conditions.Slot, raffleDeployedLt int64, scans []purchaseScan) error {
+	type result struct {
+		actions []*storage.UserAction
+		touch   *storage.UserActionTouch
+	}
+
+	results := make([]result, len(scans))
+	group, ctx := errgroup.WithContext(t.ctx)
+	group.SetLimit(t.workers)
+	for i, scan := range scans {
+		group.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return sourceError(op, err)
+			}
+
+			actions, touch, err := t.collectActionsBlackTicketPurchasedInternal(slot, scan.accountAddress, scan.lastLt, raffleDeployedLt, scan.attribute)
+			if err != nil {
+				return err
+			}
+
+			results[i] = result{actions: actions, touch: touch}
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	// results keep the order of the scans, the commit does not depend on the worker schedule
+	actions := make([]*storage.UserAction, 0)
+	touches := make([]*storage.UserActionTouch, 0)
+	for _, result := range results {
+		actions = append(actions, result.actions...)
+		if result.touch != nil {
+			touches = append(touches, result.touch)
+		}
+	}
+
+	if err := t.flagWashTrades(slot, actions); err != nil {
+		return err
+	}
+
+	return t.commitActions(op, actions, touches...)
+}
@@ -0,0 +1,72 @@
+import {Address, Cell, ContractProvider, Sender} from '@ton/core';
+import {writeFileSync} from 'fs';
+import * as path from 'path';
+
+import {Raffle} from '../wrappers/Raffle';
+
+// Writes the bodies the Raffle wrapper sends into the backend testdata, the backend decodes
+// them with its own codecs and encodes them back into the same BOC. Run it again whenever a
+// wrapper changes a message layout:
+//
+//   npx ts-node scripts/messageBodies.ts
+//   (or: npx blueprint run messageBodies)
+
+const fixturePath = path.join(__dirname, '../../backend/internal/blockchain/testdata/wrapper_messages.json');
+
+const raffleAddress = Address.parse('0:1111111111111111111111111111111111111111111111111111111111111111');
+const userAddress = Address.parse('0:3131313131313131313131313131313131313131313131313131313131313131');
+
+// captures the body of the internal message a wrapper sends instead of sending it
+async function body(send: (provider: ContractProvider, via: Sender) => Promise<void>): Promise<string> {
+  let captured: Cell | undefined;
+  const provider = {
+    internal: async (_via: Sender, args: { body?: Cell | string | null }) => {
+      if (!(args.body instanceof Cell)) {
+        throw new Error('the wrapper did not send a cell body');
+      }
+      captured = args.body;
+    },
+  } as unknown as ContractProvider;
+
+  await send(provider, {send: async () => {}} as Sender);
+  if (!captured) {
+    throw new Error('the wrapper sent nothing');
+  }
+
+  // the backend serializes its BOCs without index and checksum
+  return captured.toBoc({idx: false, crc32: false}).toString('hex');
+}
+
+async function writeFixtures() {
+  const raffle = Raffle.createFromAddress(raffleAddress);
+
+  const messages = {
+    'register candidate': await body((provider, via) =>
+        raffle.sendRegisterCandidate(provider, via, {value: 0n, recipientAddress: userAddress, telegramID: 1001n})),
+    'set conditions': await body((provider, via) =>
+        raffle.sendConditions(provider, via, {
+          value: 0n,
+          userAddress,
+          // distinct values, so a swapped slot order shows
+          conditions: {whiteTicketMinted: 1n, blackTicketPurchased: 2n},
+        })),
+    'next': await body((provider, via) =>
+        raffle.sendRaffleNext(provider, via, {value: 0n, forwardAmount: 0n, message: ''})),
+    'next with a forward payload': await body((provider, via) =>
+        raffle.sendRaffleNext(provider, via, {value: 0n, forwardAmount: 1_000_000_000n, message: 'congratulations'})),
+  };
+
+  writeFileSync(fixturePath, JSON.stringify({origin: 'onchain/scripts/messageBodies.ts', messages}, null, 2) + '\n');
+  console.log(`wrote ${Object.keys(messages).length} message bodies to ${fixturePath}`);
+}
+
+export async function run() {
+  await writeFixtures();
+}
+
+if (require.main === module) {
+  writeFixtures().catch((error) => {
+    console.error(error);
+    process.exit(1);
+  });
+}